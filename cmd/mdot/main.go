@@ -140,7 +140,7 @@ func makeDot(name string, w io.Writer) error {
 	if err != nil {
 		return err
 	}
-	n, err = checker.Check(n, 0, 0)
+	n, err = checker.Check(n, 0, 0, 0, true)
 	if err != nil {
 		return err
 	}
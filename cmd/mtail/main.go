@@ -35,46 +35,102 @@ func (f *seqStringFlag) Set(value string) error {
 	return nil
 }
 
-var logs seqStringFlag
+// repeatedStringFlag collects each value of a flag that may be repeated,
+// without splitting on commas, as the values may be regular expressions.
+type repeatedStringFlag []string
+
+func (f *repeatedStringFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *repeatedStringFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+var (
+	logs  seqStringFlag
+	progs seqStringFlag
+
+	multilineStarts repeatedStringFlag
+
+	exportAllows repeatedStringFlag
+	exportDenies repeatedStringFlag
+)
 
 var (
 	port               = flag.String("port", "3903", "HTTP port to listen on.")
 	address            = flag.String("address", "", "Host or IP address on which to bind HTTP listener")
 	unixSocket         = flag.String("unix_socket", "", "UNIX Socket to listen on")
-	progs              = flag.String("progs", "", "Name of the directory containing mtail programs")
+	tlsCert            = flag.String("tls_cert", "", "If set with -tls_key, serve HTTP over TLS with the PEM encoded certificate in this file.  The certificate is reloaded when the file changes.")
+	tlsKey             = flag.String("tls_key", "", "The PEM encoded private key of the -tls_cert certificate.")
+	basicAuthUser      = flag.String("http_basic_auth_user", "", "If set with -http_basic_auth_password, require HTTP requests to present this user with basic authentication, and respond 401 Unauthorized to those that don't.")
+	basicAuthPassword  = flag.String("http_basic_auth_password", "", "The password HTTP requests must present with -http_basic_auth_user.  As command lines can be read by other users of the machine, prefer -http_basic_auth_password_file.")
+	basicAuthPassFile  = flag.String("http_basic_auth_password_file", "", "Read the password for -http_basic_auth_user from this file, ignoring a trailing newline.")
+	progsManifest      = flag.String("progs_manifest", "", "Name of a file listing the mtail programs to load, one per line, instead of the -progs directory.")
+	progsRecursive     = flag.Bool("progs_recursive", false, "Also load mtail programs from subdirectories of the -progs directory.")
 	ignoreRegexPattern = flag.String("ignore_filename_regex_pattern", "", "")
+	syslogAddr         = flag.String("syslog_addr", "", "If set, listen for syslog messages on this host:port over UDP and TCP, as if given to -logs as syslog://host:port.")
 
 	version = flag.Bool("version", false, "Print mtail version information.")
 
 	// Compiler behaviour flags.
-	oneShot       = flag.Bool("one_shot", false, "Compile the programs, then read the contents of the provided logs from start until EOF, print the values of the metrics store in the given format and exit. This is a debugging flag only, not for production use.")
-	oneShotFormat = flag.String("one_shot_format", "json", "Format to use with -one_shot. This is a debugging flag only, not for production use. Supported formats: json, prometheus.")
-	compileOnly   = flag.Bool("compile_only", false, "Compile programs only, do not load the virtual machine.")
-	dumpAst       = flag.Bool("dump_ast", false, "Dump AST of programs after parse (to INFO log).")
-	dumpAstTypes  = flag.Bool("dump_ast_types", false, "Dump AST of programs with type annotation after typecheck (to INFO log).")
-	dumpBytecode  = flag.Bool("dump_bytecode", false, "Dump bytecode of programs (to INFO log).")
+	oneShot         = flag.Bool("one_shot", false, "Compile the programs, then read the contents of the provided logs from start until EOF, print the values of the metrics store in the given format and exit. This is a debugging flag only, not for production use.")
+	oneShotFormat   = flag.String("one_shot_format", "json", "Format to use with -one_shot. This is a debugging flag only, not for production use. Supported formats: json, prometheus.")
+	compileOnly     = flag.Bool("compile_only", false, "Compile programs only, do not load the virtual machine.")
+	lint            = flag.Bool("lint", false, "Check programs for likely mistakes, such as unused capture groups, metrics that are never changed, and patterns that can never match, and print each one found with its severity and position, without running them.  Exits non-zero if any program has a compile error or an error severity finding.")
+	dumpAst         = flag.Bool("dump_ast", false, "Print the AST of each program after parsing to standard output, showing its patterns, their action blocks, and the metric declarations with their dimensions, then exit without running them.")
+	dumpAstTypes    = flag.Bool("dump_ast_types", false, "Dump AST of programs with type annotation after typecheck (to INFO log).")
+	dumpBytecode    = flag.Bool("dump_bytecode", false, "Dump bytecode of programs (to INFO log).")
+	traceProgs      = flag.Bool("trace", false, "Print each instruction executed by the programs, the stack, and the patterns matched, for every line read. Lines are read from standard input unless -logs is given. This is a debugging flag only, not for production use.")
+	optimize        = flag.Bool("optimize", false, "Run the experimental peephole optimiser on the bytecode of programs.")
+	dumpBytecodeDir = flag.String("dump_bytecode_dir", "", "Write the bytecode of each program to <name>.bytecode in this directory, replacing it on reload.")
 
 	// VM Runtime behaviour flags.
 	syslogUseCurrentYear = flag.Bool("syslog_use_current_year", true, "Patch yearless timestamps with the present year.")
-	overrideTimezone     = flag.String("override_timezone", "", "If set, use the provided timezone in timestamp conversion, instead of UTC.")
+	defaultTimezone      = flag.String("default_timezone", "", "If set, the timezone of log timestamps that give no zone offset or abbreviation, instead of UTC.  Local is the machine's timezone.")
+	overrideTimezone     = flag.String("override_timezone", "", "Deprecated: use --default_timezone.")
 	emitProgLabel        = flag.Bool("emit_prog_label", true, "Emit the 'prog' label in variable exports.")
+	addHostnameLabel     = flag.Bool("add_hostname_label", false, "Add a label holding the hostname, named by -hostname_label_name, to every exported metric.")
+	hostnameLabelName    = flag.String("hostname_label_name", "hostname", "The name of the label added by -add_hostname_label.")
+	hostname             = flag.String("hostname", "", "The hostname to export metrics as, instead of the machine's hostname.  Useful in containers, where the machine's hostname is often meaningless.")
+	prefixWithProgram    = flag.Bool("prefix_with_program", false, "Prefix the name of each exported metric with the name of the program that defines it, e.g. errors in nginx.mtail is exported as nginx_errors.")
+	resetOnReload        = flag.Bool("reset_on_reload", false, "Reset the metrics of a program to zero when it is reloaded.  If disabled (the default) the values of metrics declared with the same name, kind, type and dimensions are carried over to the new version of the program.")
 	emitMetricTimestamp  = flag.Bool("emit_metric_timestamp", false, "Emit the recorded timestamp of a metric.  If disabled (the default) no explicit timestamp is sent to a collector.")
 	logRuntimeErrors     = flag.Bool("vm_logs_runtime_errors", true, "Enables logging of runtime errors to the standard log.  Set to false to only have the errors printed to the HTTP console.")
 
 	// Ops flags.
 	pollInterval                = flag.Duration("poll_interval", 250*time.Millisecond, "Set the interval to poll each log file for data; must be positive, or zero to disable polling.  With polling mode, only the files found at mtail startup will be polled.")
 	pollLogInterval             = flag.Duration("poll_log_interval", 250*time.Millisecond, "Set the interval to find all matched log files for polling; must be positive, or zero to disable polling.  With polling mode, only the files found at mtail startup will be polled.")
+	logDiscoveryInterval        = flag.Duration("log_discovery_interval", 0, "Set the interval to re-evaluate the -logs glob patterns, tailing newly matched files from their start and retiring the tailers of removed files.  Overrides -poll_log_interval when set.")
+	multilineMaxBytes           = flag.Int("multiline_max_bytes", 64*1024, "The maximum size in bytes of a record joined from multiple lines with -multiline_start.  A continuation line that would make the record longer starts a new record.")
+	multilineTimeout            = flag.Duration("multiline_timeout", time.Second, "Send a partial record joined with -multiline_start once no lines have been read from its log for this long.")
+	stateFile                   = flag.String("state_file", "", "If set, record how far each log file has been read in this file, and resume reading from there on startup.")
+	readFromStart               = flag.Bool("read_from_start", false, "Read the log files found at startup from their start, rather than only the lines written after mtail starts.  Logs with a position in the -state_file are read from that position instead.  Log files created after startup are always read from their start.")
+	stateCheckpointInterval     = flag.Duration("state_checkpoint_interval", 10*time.Second, "Interval between writes of the -state_file, or zero to only write it on shutdown.")
 	expiredMetricGcTickInterval = flag.Duration("expired_metrics_gc_interval", time.Hour, "interval between expired metric garbage collection runs")
 	staleLogGcTickInterval      = flag.Duration("stale_log_gc_interval", time.Hour, "interval between stale log garbage collection runs")
 	metricPushInterval          = flag.Duration("metric_push_interval", time.Minute, "interval between metric pushes to passive collectors")
+	staleAfter                  = flag.Duration("stale_after", 0, "If set, leave the series of metrics that haven't been updated for this long out of the exports, until they are updated again.  Unlike an expiry, the series are kept in the metric store.")
 	maxRegexpLength             = flag.Int("max_regexp_length", 1024, "The maximum length a mtail regexp expression can have. Excessively long patterns are likely to cause compilation and runtime performance problems.")
+	maxCaptures                 = flag.Int("max_captures", 100, "The maximum number of capture groups a mtail regexp expression can have.  Each capture group slows down matching, so groups the program doesn't refer to should be non-capturing.")
+	allowRiskyRegex             = flag.Bool("allow_risky_regex", false, "Load programs with patterns that have a nested unbounded repetition, such as `(a+)+`, logging a warning, rather than refusing to compile them.")
 	maxRecursionDepth           = flag.Int("max_recursion_depth", 100, "The maximum length a mtail statement can be, as measured by parsed tokens. Excessively long mtail expressions are likely to cause compilation and runtime performance problems.")
+	maxDimensionsPerMetric      = flag.Int("max_dimensions_per_metric", 0, "The maximum number of label values a dimensioned metric can hold, unless the metric declares a limit.  Once a metric is at the limit, the least recently updated label value is removed to make room for a new one.  If zero (the default) there is no limit.")
+	nomatchWarnAfter            = flag.Int64("nomatch_warn_after", 0, "Log a warning naming each program pattern that has not matched any of the first this many lines processed by the program, to catch mistyped patterns and changed log formats.  If zero (the default) no warning is logged.")
+	vmWorkers                   = flag.Int("vm_workers", 0, "The maximum number of programs that process log lines at the same time.  Each program processes lines in order on its own goroutine.  If zero (the default) all programs run concurrently; set to 1 to run one program at a time.")
+	vmExecutionTimeout          = flag.Duration("vm_execution_timeout", 0, "Abandon the processing of a log line by a program once it has taken longer than this, counting it in vm_timeouts_total, so that the following lines are still processed.  If zero (the default) there is no timeout.")
+	readBufferSize              = flag.Int("read_buffer_size", 4096, "Size in bytes of the buffer each log is read into with every read.  Larger buffers need fewer reads to keep up with busy logs, at the cost of one buffer per tailed log.  Lines longer than the buffer are still read whole.")
+	logEncoding                 = flag.String("log_encoding", "", "Character encoding of the logs, by IANA name such as ISO-8859-1 or Shift_JIS.  Lines are transcoded to UTF-8 before they are matched, and byte sequences invalid in the encoding are replaced with U+FFFD.  If empty (the default) the logs are read as UTF-8.")
+	lineBufferSize              = flag.Int("line_buffer_size", 1000, "The number of log lines buffered for each program while it is busy.")
+	lineOverflowPolicy          = flag.String("line_overflow_policy", "block", "What to do with a log line when a program's line buffer is full: \"block\" waits for the program, which stops logs being read until it catches up; \"drop\" drops the line for that program, counting it in lines_dropped_total.")
 
 	// Debugging flags.
 	blockProfileRate     = flag.Int("block_profile_rate", 0, "Nanoseconds of block time before goroutine blocking events reported. 0 turns off.  See https://golang.org/pkg/runtime/#SetBlockProfileRate")
 	mutexProfileFraction = flag.Int("mutex_profile_fraction", 0, "Fraction of mutex contention events reported.  0 turns off.  See http://golang.org/pkg/runtime/#SetMutexProfileFraction")
 	httpDebugEndpoints   = flag.Bool("http_debugging_endpoint", true, "Enable debugging endpoints (/debug/*).")
 	httpInfoEndpoints    = flag.Bool("http_info_endpoint", true, "Enable info endpoints (/progz,/varz).")
+	reloadEndpoint       = flag.Bool("enable_reload_endpoint", false, "Enable the /reload endpoint, which reloads all programs on a POST request.")
 
 	// Tracing.
 	jaegerEndpoint    = flag.String("jaeger_endpoint", "", "If set, collector endpoint URL of jaeger thrift service")
@@ -85,8 +141,21 @@ var (
 	_ = flag.Int("metric_push_interval_seconds", 0, "DEPRECATED: use --metric_push_interval instead")
 )
 
+// exportFilterSpec splits an -export_allow or -export_deny value into the
+// backend it applies to, or "" for every backend, and its regular expression.
+func exportFilterSpec(s string) (backend, pattern string) {
+	if b, p, ok := strings.Cut(s, "="); ok && exporter.IsBackend(b) {
+		return b, p
+	}
+	return "", s
+}
+
 func init() {
-	flag.Var(&logs, "logs", "List of log files to monitor, separated by commas.  This flag may be specified multiple times.")
+	flag.Var(&logs, "logs", "List of log files to monitor, separated by commas.  This flag may be specified multiple times.  Use - to read from stdin.  A directory tails every file in it, including the files created in it later.")
+	flag.Var(&multilineStarts, "multiline_start", "Join the lines of the logs matching a glob into records, as GLOB=REGEXP: each line matching REGEXP starts a new record, and the lines that don't match are appended to the current record.  Each record is processed by the programs as one line, with the lines separated by newlines.  This flag may be specified multiple times; the first matching glob is used.")
+	flag.Var(&exportAllows, "export_allow", "Only export the metrics whose name matches a regular expression, given as REGEXP for every export, or BACKEND=REGEXP for one of collectd, graphite, influxdb, json, opentsdb, prometheus, statsd or varz.  This flag may be specified multiple times; a metric matching any of the expressions for an export is exported.  Hidden metrics are only exported where one of these names them.")
+	flag.Var(&exportDenies, "export_deny", "Don't export the metrics whose name matches a regular expression, given as REGEXP for every export, or BACKEND=REGEXP for one backend as with -export_allow.  This flag may be specified multiple times, and takes precedence over -export_allow.")
+	flag.Var(&progs, "progs", "Name of the directory containing mtail programs.  This flag may be specified multiple times, or the directories separated by commas; programs in different directories must have different names.")
 }
 
 var (
@@ -121,9 +190,18 @@ func main() {
 	if len(flag.Args()) > 0 {
 		glog.Exitf("Too many extra arguments specified: %q\n(the logs flag can be repeated, or the filenames separated by commas.)", flag.Args())
 	}
-	loc, err := time.LoadLocation(*overrideTimezone)
+	timezone := *defaultTimezone
+	if *overrideTimezone != "" {
+		glog.Warning("--override_timezone is deprecated, use --default_timezone instead.")
+		if timezone != "" && timezone != *overrideTimezone {
+			fmt.Fprintf(os.Stderr, "--override_timezone %q conflicts with --default_timezone %q", *overrideTimezone, timezone)
+			os.Exit(1)
+		}
+		timezone = *overrideTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't parse timezone %q: %s", *overrideTimezone, err)
+		fmt.Fprintf(os.Stderr, "Couldn't parse timezone %q: %s", timezone, err)
 		os.Exit(1)
 	}
 	if *blockProfileRate > 0 {
@@ -134,10 +212,17 @@ func main() {
 		glog.Infof("Setting mutex profile fraction to %d", *mutexProfileFraction)
 		runtime.SetMutexProfileFraction(*mutexProfileFraction)
 	}
-	if *progs == "" {
-		glog.Exitf("mtail requires programs that instruct it how to extract metrics from logs; please use the flag -progs to specify the directory containing the programs.")
+	if len(progs) == 0 && *progsManifest == "" {
+		glog.Exitf("mtail requires programs that instruct it how to extract metrics from logs; please use the flag -progs to specify the directory containing the programs, or -progs_manifest to list them.")
 	}
-	if !(*dumpBytecode || *dumpAst || *dumpAstTypes || *compileOnly) {
+	if *syslogAddr != "" {
+		logs = append(logs, "syslog://"+*syslogAddr)
+	}
+	if *traceProgs && len(logs) == 0 {
+		// Read the sample input from standard input.
+		logs = append(logs, "-")
+	}
+	if !(*dumpBytecode || *dumpAst || *dumpAstTypes || *compileOnly || *lint) {
 		if len(logs) == 0 {
 			glog.Exitf("mtail requires the names of logs to follow in order to extract logs from them; please use the flag -logs one or more times to specify glob patterns describing these logs.")
 		}
@@ -150,6 +235,9 @@ func main() {
 		glog.Infof("no poll log data interval specified; defaulting to 250ms poll")
 		*pollInterval = time.Millisecond * 250
 	}
+	if *logDiscoveryInterval > 0 {
+		*pollLogInterval = *logDiscoveryInterval
+	}
 	if *pollLogInterval == 0 {
 		glog.Infof("no poll log pattern interval specified; defaulting to 250ms poll")
 		*pollLogInterval = time.Millisecond * 250
@@ -167,14 +255,28 @@ func main() {
 	}()
 
 	opts := []mtail.Option{
-		mtail.ProgramPath(*progs),
 		mtail.LogPathPatterns(logs...),
 		mtail.IgnoreRegexPattern(*ignoreRegexPattern),
+		mtail.ReadBufferSize(*readBufferSize),
+		mtail.LogEncoding(*logEncoding),
 		mtail.SetBuildInfo(buildInfo),
 		mtail.OverrideLocation(loc),
 		mtail.MetricPushInterval(*metricPushInterval),
 		mtail.MaxRegexpLength(*maxRegexpLength),
 		mtail.MaxRecursionDepth(*maxRecursionDepth),
+		mtail.MaxCaptures(*maxCaptures),
+		mtail.MaxDimensionsPerMetric(*maxDimensionsPerMetric),
+		mtail.NomatchWarnAfter(*nomatchWarnAfter),
+		mtail.VMWorkers(*vmWorkers),
+		mtail.VMExecutionTimeout(*vmExecutionTimeout),
+		mtail.LineBufferSize(*lineBufferSize),
+	}
+	switch *lineOverflowPolicy {
+	case "block":
+	case "drop":
+		opts = append(opts, mtail.DropLinesWhenFull)
+	default:
+		glog.Exitf("-line_overflow_policy must be \"block\" or \"drop\", not %q", *lineOverflowPolicy)
 	}
 	eOpts := []exporter.Option{}
 	if *logRuntimeErrors {
@@ -189,19 +291,72 @@ func main() {
 		logPatternPollWaker := waker.NewTimed(ctx, *pollLogInterval)
 		opts = append(opts, mtail.LogPatternPollWaker(logPatternPollWaker), mtail.LogstreamPollWaker(logStreamPollWaker))
 	}
+	for _, m := range multilineStarts {
+		glob, start, ok := strings.Cut(m, "=")
+		if !ok {
+			glog.Exitf("-multiline_start %q is not of the form GLOB=REGEXP", m)
+		}
+		opts = append(opts, mtail.MultilineRecords(glob, start, *multilineMaxBytes, *multilineTimeout))
+	}
+	if *stateFile != "" {
+		var stateCheckpointWaker waker.Waker
+		if *stateCheckpointInterval > 0 {
+			stateCheckpointWaker = waker.NewTimed(ctx, *stateCheckpointInterval)
+		}
+		opts = append(opts, mtail.StateFile(*stateFile, stateCheckpointWaker))
+	}
+	if *readFromStart {
+		opts = append(opts, mtail.ReadFromStart)
+	}
 	if *unixSocket == "" {
 		opts = append(opts, mtail.BindAddress(*address, *port))
 	} else {
 		opts = append(opts, mtail.BindUnixSocket(*unixSocket))
 	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		glog.Exit("-tls_cert and -tls_key must be given together")
+	}
+	if *tlsCert != "" {
+		opts = append(opts, mtail.TLSCertificate(*tlsCert, *tlsKey))
+	}
+	if *basicAuthPassFile != "" {
+		if *basicAuthPassword != "" {
+			glog.Exit("-http_basic_auth_password and -http_basic_auth_password_file can't both be given")
+		}
+		b, err := os.ReadFile(*basicAuthPassFile)
+		if err != nil {
+			glog.Exit(err)
+		}
+		*basicAuthPassword = strings.TrimRight(string(b), "\r\n")
+	}
+	if (*basicAuthUser == "") != (*basicAuthPassword == "") {
+		glog.Exit("-http_basic_auth_user and a password must be given together")
+	}
+	if *basicAuthUser != "" {
+		opts = append(opts, mtail.BasicAuth(*basicAuthUser, *basicAuthPassword))
+	}
 	if *oneShot {
 		opts = append(opts, mtail.OneShot)
 	}
+	for _, p := range progs {
+		if p != "" {
+			opts = append(opts, mtail.ProgramPath(p))
+		}
+	}
+	if *progsManifest != "" {
+		opts = append(opts, mtail.ProgramManifest(*progsManifest), mtail.ManifestPollWaker(waker.NewTimed(ctx, *pollLogInterval)))
+	}
+	if *progsRecursive {
+		opts = append(opts, mtail.RecursivePrograms)
+	}
 	if *compileOnly {
 		opts = append(opts, mtail.CompileOnly)
 	}
+	if *lint {
+		opts = append(opts, mtail.LintTo(os.Stdout))
+	}
 	if *dumpAst {
-		opts = append(opts, mtail.DumpAst)
+		opts = append(opts, mtail.DumpAstTo(os.Stdout))
 	}
 	if *dumpAstTypes {
 		opts = append(opts, mtail.DumpAstTypes)
@@ -209,23 +364,69 @@ func main() {
 	if *dumpBytecode {
 		opts = append(opts, mtail.DumpBytecode)
 	}
+	if *traceProgs {
+		opts = append(opts, mtail.TraceExecutionTo(os.Stdout))
+	}
+	if *optimize {
+		opts = append(opts, mtail.OptimiseBytecode)
+	}
+	if *dumpBytecodeDir != "" {
+		opts = append(opts, mtail.DumpBytecodeDir(*dumpBytecodeDir))
+	}
 	if *httpDebugEndpoints {
 		opts = append(opts, mtail.HTTPDebugEndpoints)
 	}
 	if *httpInfoEndpoints {
 		opts = append(opts, mtail.HTTPInfoEndpoints)
 	}
+	if *reloadEndpoint {
+		opts = append(opts, mtail.ReloadEndpoint)
+	}
 	if *syslogUseCurrentYear {
 		opts = append(opts, mtail.SyslogUseCurrentYear)
 	}
+	if *allowRiskyRegex {
+		opts = append(opts, mtail.AllowRiskyRegex)
+	}
 	if !*emitProgLabel {
 		opts = append(opts, mtail.OmitProgLabel)
 		eOpts = append(eOpts, exporter.OmitProgLabel())
 	}
+	if *hostname != "" {
+		opts = append(opts, mtail.Hostname(*hostname))
+		eOpts = append(eOpts, exporter.Hostname(*hostname))
+	}
+	if *addHostnameLabel {
+		if *hostnameLabelName == "" {
+			glog.Exit("-hostname_label_name must not be empty with -add_hostname_label")
+		}
+		opts = append(opts, mtail.HostnameLabel(*hostnameLabelName))
+		eOpts = append(eOpts, exporter.HostnameLabel(*hostnameLabelName))
+	}
+	for _, a := range exportAllows {
+		backend, pattern := exportFilterSpec(a)
+		opts = append(opts, mtail.ExportAllow(backend, pattern))
+		eOpts = append(eOpts, exporter.ExportAllow(backend, pattern))
+	}
+	for _, d := range exportDenies {
+		backend, pattern := exportFilterSpec(d)
+		opts = append(opts, mtail.ExportDeny(backend, pattern))
+		eOpts = append(eOpts, exporter.ExportDeny(backend, pattern))
+	}
+	if *prefixWithProgram {
+		opts = append(opts, mtail.PrefixMetricsWithProgram)
+	}
+	if *resetOnReload {
+		opts = append(opts, mtail.ResetMetricsOnReload)
+	}
 	if *emitMetricTimestamp {
 		opts = append(opts, mtail.EmitMetricTimestamp)
 		eOpts = append(eOpts, exporter.EmitTimestamp())
 	}
+	if *staleAfter > 0 {
+		opts = append(opts, mtail.StaleAfter(*staleAfter))
+		eOpts = append(eOpts, exporter.StaleAfter(*staleAfter))
+	}
 	if *jaegerEndpoint != "" {
 		opts = append(opts, mtail.JaegerReporter(*jaegerEndpoint))
 	}
@@ -233,6 +434,7 @@ func main() {
 	if *expiredMetricGcTickInterval > 0 {
 		store.StartGcLoop(ctx, *expiredMetricGcTickInterval)
 	}
+	store.StartRateLoop(ctx)
 	m, err := mtail.New(ctx, store, opts...)
 	if err != nil {
 		glog.Error(err)
@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	contrib.go.opencensus.io/exporter/jaeger v0.2.1
+	github.com/beorn7/perks v1.0.1
 	github.com/golang/glog v1.1.1
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da
 	github.com/google/go-cmp v0.5.9
@@ -12,10 +13,10 @@ require (
 	github.com/prometheus/common v0.44.0
 	go.opencensus.io v0.24.0
 	golang.org/x/sys v0.10.0
+	golang.org/x/text v0.9.0
 )
 
 require (
-	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
@@ -4,13 +4,16 @@
 package exporter
 
 import (
+	"bufio"
 	"expvar"
 	"flag"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
 	"github.com/google/mtail/internal/metrics"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -23,9 +26,12 @@ var (
 		"Path to collectd unixsock to write metrics to.")
 	collectdPrefix = flag.String("collectd_prefix", "",
 		"Prefix to use for collectd metrics.")
+	collectdPushInterval = flag.Duration("collectd_push_interval", 0,
+		"Interval between pushes to collectd.  If zero, --metric_push_interval is used.")
 
 	collectdExportTotal   = expvar.NewInt("collectd_export_total")
 	collectdExportSuccess = expvar.NewInt("collectd_export_success")
+	collectdExportErrors  = expvar.NewInt("collectd_export_errors")
 )
 
 // metricToCollectd encodes the metric data in the collectd text protocol format.  The
@@ -35,7 +41,7 @@ func metricToCollectd(hostname string, m *metrics.Metric, l *metrics.LabelSet, i
 		hostname,
 		*collectdPrefix,
 		m.Program,
-		kindToCollectdType(m.Kind),
+		kindToCollectdType(m.ExportKind()),
 		formatLabels(m.Name, l.Labels, "-", "-", "_"),
 		int64(interval.Seconds()),
 		l.Datum.TimeString(),
@@ -48,3 +54,38 @@ func kindToCollectdType(kind metrics.Kind) string {
 	}
 	return "gauge"
 }
+
+// collectdWriter writes PUTVAL commands to the collectd unixsock plugin, and
+// reads the status reply to each one so that rejected values are reported.
+type collectdWriter struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newCollectdWriter(c net.Conn) pushWriter {
+	return &collectdWriter{conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *collectdWriter) Write(p []byte) (int, error) {
+	n, err := c.conn.Write(p)
+	if err != nil {
+		collectdExportErrors.Add(1)
+		return n, err
+	}
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		collectdExportErrors.Add(1)
+		return n, errors.Wrap(err, "reading collectd reply")
+	}
+	// Replies start with a status, which is negative on error.
+	if strings.HasPrefix(reply, "-") {
+		collectdExportErrors.Add(1)
+		return n, rejectedError{errors.Errorf("collectd rejected %q: %s", strings.TrimSpace(string(p)), strings.TrimSpace(reply))}
+	}
+	return n, nil
+}
+
+// Flush implements pushWriter; each command is sent as it is written.
+func (c *collectdWriter) Flush() error {
+	return nil
+}
@@ -6,12 +6,17 @@
 package exporter
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"expvar"
 	"flag"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"sort"
 	"strings"
@@ -26,8 +31,27 @@ import (
 // Commandline Flags.
 var (
 	writeDeadline = flag.Duration("metric_push_write_deadline", 10*time.Second, "Time to wait for a push to succeed before exiting with an error.")
+	pushRetries   = flag.Int("metric_push_retries", 3, "Number of times to retry a failed push before dropping its metrics.")
+	pushRetryWait = flag.Duration("metric_push_retry_delay", time.Second, "Time to wait before the first retry of a failed push.  The wait doubles for each retry after it.")
+	pushBatchSize = flag.Int("metric_push_batch_bytes", 16384, "Number of bytes of metrics to buffer before writing them to a graphite or OpenTSDB connection.")
 )
 
+// pushErrors counts the number of failed pushes to each service address.
+var pushErrors = expvar.NewMap("metric_push_errors_total")
+
+// pushConnections counts the connections opened to each service address.
+var pushConnections = expvar.NewMap("metric_push_connections_total")
+
+// pushRetryBacklog counts the pushes waiting to be retried.
+var pushRetryBacklog = expvar.NewInt("metric_push_retry_backlog")
+
+// pushDropped counts the number of pushes to each service address dropped after
+// running out of retries, or without being retried.
+var pushDropped = expvar.NewMap("metric_push_dropped_total")
+
+// pushDurations holds the time taken by the last push to each backend, in seconds.
+var pushDurations = expvar.NewMap("metric_push_duration_seconds")
+
 // Exporter manages the export of metrics to passive and active collectors.
 type Exporter struct {
 	ctx           context.Context
@@ -35,9 +59,14 @@ type Exporter struct {
 	store         *metrics.Store
 	pushInterval  time.Duration
 	hostname      string
+	hostnameLabel string // If not empty, the name of a label holding the hostname on every exported metric.
 	omitProgLabel bool
 	emitTimestamp bool
+	staleAfter    time.Duration // If positive, series not updated for this long are left out of the exports.
 	pushTargets   []pushOptions
+	filters       map[string]*exportFilter // Filters of the metrics exported, by backend name, or "" for those of every backend.
+	connsMu       sync.Mutex
+	conns         map[string]net.Conn // Connections kept open between pushes, by network and address.
 	initDone      chan struct{}
 }
 
@@ -52,6 +81,16 @@ func Hostname(hostname string) Option {
 	}
 }
 
+// HostnameLabel sets the Exporter to add a label called name to every
+// exported metric, with the hostname as its value.  The label is added at
+// export time, and not stored with the metric.
+func HostnameLabel(name string) Option {
+	return func(e *Exporter) error {
+		e.hostnameLabel = name
+		return nil
+	}
+}
+
 // OmitProgLabel sets the Exporter to not put program names in metric labels.
 func OmitProgLabel() Option {
 	return func(e *Exporter) error {
@@ -99,18 +138,37 @@ func New(ctx context.Context, wg *sync.WaitGroup, store *metrics.Store, options
 	}
 
 	if *collectdSocketPath != "" {
-		o := pushOptions{"unix", *collectdSocketPath, metricToCollectd, collectdExportTotal, collectdExportSuccess}
+		o := pushOptions{"collectd", "unix", *collectdSocketPath, metricToCollectd, collectdExportTotal, collectdExportSuccess, *collectdPushInterval, newCollectdWriter, nil}
 		e.RegisterPushExport(o)
 	}
 	if *graphiteHostPort != "" {
-		o := pushOptions{"tcp", *graphiteHostPort, metricToGraphite, graphiteExportTotal, graphiteExportSuccess}
+		o, err := graphitePushOptions()
+		if err != nil {
+			return nil, err
+		}
+		e.RegisterPushExport(o)
+	}
+	if *opentsdbAddr != "" {
+		o := pushOptions{"opentsdb", "tcp", *opentsdbAddr, metricToOpentsdb, opentsdbExportTotal, opentsdbExportSuccess, *opentsdbPushInterval, newBufferedWriter, nil}
+		e.RegisterPushExport(o)
+	}
+	if *influxdbURL != "" {
+		u, err := influxdbWriteURL(*influxdbURL, *influxdbDatabase)
+		if err != nil {
+			return nil, err
+		}
+		o := pushOptions{"influxdb", "http", u, metricToInfluxdb, influxdbExportTotal, influxdbExportSuccess, *influxdbPushInterval, nil, nil}
 		e.RegisterPushExport(o)
 	}
 	if *statsdHostPort != "" {
-		o := pushOptions{"udp", *statsdHostPort, metricToStatsd, statsdExportTotal, statsdExportSuccess}
+		o, err := statsdPushOptions()
+		if err != nil {
+			return nil, err
+		}
 		e.RegisterPushExport(o)
 	}
 	e.StartMetricPush()
+	e.startStaleCount()
 
 	// This routine manages shutdown of the Exporter.  TODO(jaq): This doesn't
 	// happen before mtail returns because of how context cancellation is set
@@ -120,6 +178,7 @@ func New(ctx context.Context, wg *sync.WaitGroup, store *metrics.Store, options
 		<-e.initDone
 		<-e.ctx.Done()
 		e.wg.Wait()
+		e.closeConns()
 	}()
 	return e, nil
 }
@@ -157,90 +216,450 @@ func formatLabels(name string, m map[string]string, ksep, sep, rep string) strin
 	return r
 }
 
+// addHostnameLabel adds the hostname label to l, if the Exporter has one and
+// the metric doesn't already have a label of that name.
+func (e *Exporter) addHostnameLabel(l *metrics.LabelSet) {
+	if e.hostnameLabel == "" {
+		return
+	}
+	if _, ok := l.Labels[e.hostnameLabel]; !ok {
+		l.Labels[e.hostnameLabel] = e.hostname
+	}
+}
+
 // Format a LabelSet into a string to be written to one of the timeseries
 // sockets.
 type formatter func(string, *metrics.Metric, *metrics.LabelSet, time.Duration) string
 
-func (e *Exporter) writeSocketMetrics(c io.Writer, f formatter, exportTotal *expvar.Int, exportSuccess *expvar.Int) error {
-	return e.store.Range(func(m *metrics.Metric) error {
+// pushBatch holds the metrics of one push, formatted for its target.  Each
+// line is written to the target separately, so that the target's writer can
+// pack them into datagrams, or read the reply to each one.
+type pushBatch struct {
+	lines     []string
+	delivered func() // If set, called once the batch has been delivered.
+}
+
+// formatPush formats the metrics in the store for each of targets in a single
+// pass over the store, so that every target is sent the same values, and the
+// store is read once however many targets there are.  Each metric is locked
+// while it's formatted for all the targets, and not while the batches are
+// written, so a slow collector doesn't hold up the programs updating it.
+func (e *Exporter) formatPush(targets []pushOptions) []*pushBatch {
+	batches := make([]*pushBatch, len(targets))
+	for i := range batches {
+		batches[i] = &pushBatch{}
+	}
+	now := time.Now()
+	for _, m := range e.store.Snapshot() {
 		m.RLock()
 		// Don't try to send text metrics to any push service.
 		if m.Kind == metrics.Text {
 			m.RUnlock()
-			return nil
+			continue
 		}
-		exportTotal.Add(1)
+		var labelSets []*metrics.LabelSet
+		var stale []bool
 		lc := make(chan *metrics.LabelSet)
 		go m.EmitLabelSets(lc)
 		for l := range lc {
-			line := f(e.hostname, m, l, e.pushInterval)
-			n, err := fmt.Fprint(c, line)
-			glog.V(2).Infof("Sent %d bytes\n", n)
-			if err == nil {
-				exportSuccess.Add(1)
-			} else {
-				return errors.Errorf("write error: %s", err)
+			e.addHostnameLabel(l)
+			labelSets = append(labelSets, l)
+			stale = append(stale, e.stale(l, now))
+		}
+		for i, target := range targets {
+			if !e.exported(target.name, m) {
+				continue
+			}
+			target.total.Add(1)
+			for j, l := range labelSets {
+				// Stale series are still formatted, so that a formatter
+				// that remembers the series it has seen, such as statsd's,
+				// doesn't forget them, but aren't sent.
+				line := target.f(e.hostname, m, l, e.interval(target))
+				if line == "" || stale[j] {
+					continue
+				}
+				batches[i].lines = append(batches[i].lines, line)
 			}
 		}
 		m.RUnlock()
-		return nil
-	})
+	}
+	for i, target := range targets {
+		if target.formatted != nil {
+			batches[i].delivered = target.formatted()
+		}
+	}
+	return batches
 }
 
-// PushMetrics sends metrics to each of the configured services.
-func (e *Exporter) PushMetrics() {
-	for _, target := range e.pushTargets {
-		glog.V(2).Infof("pushing to %s", target.addr)
-		conn, err := net.DialTimeout(target.net, target.addr, *writeDeadline)
+// writeBatch writes the formatted metrics in batch to c.  The caller counts
+// the lines as sent once the whole batch has been delivered.
+func writeBatch(c io.Writer, batch *pushBatch) error {
+	for _, line := range batch.lines {
+		n, err := io.WriteString(c, line)
+		glog.V(2).Infof("Sent %d bytes\n", n)
 		if err != nil {
-			glog.Infof("pusher dial error: %s", err)
-			continue
+			return errors.Wrap(err, "write error")
 		}
-		err = conn.SetDeadline(time.Now().Add(*writeDeadline))
-		if err != nil {
-			glog.Infof("Couldn't set deadline on connection: %s", err)
+	}
+	return nil
+}
+
+// writeSocketMetrics formats the metrics exported to backend with f, and writes them to c.
+func (e *Exporter) writeSocketMetrics(c io.Writer, backend string, f formatter, interval time.Duration, exportTotal *expvar.Int, exportSuccess *expvar.Int) error {
+	target := pushOptions{name: backend, f: f, total: exportTotal, success: exportSuccess, interval: interval}
+	batch := e.formatPush([]pushOptions{target})[0]
+	if err := writeBatch(c, batch); err != nil {
+		return err
+	}
+	exportSuccess.Add(int64(len(batch.lines)))
+	if batch.delivered != nil {
+		batch.delivered()
+	}
+	return nil
+}
+
+// interval returns the interval between pushes to target.
+func (e *Exporter) interval(target pushOptions) time.Duration {
+	if target.interval > 0 {
+		return target.interval
+	}
+	return e.pushInterval
+}
+
+// PushMetrics sends metrics to each of the configured services.  Once the
+// Exporter has been shut down, as it is for the final push before mtail
+// exits, the push and its retries are given metric_push_write_deadline to
+// finish instead of being abandoned.
+func (e *Exporter) PushMetrics() {
+	ctx := e.ctx
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), *writeDeadline)
+		defer cancel()
+	}
+	e.pushTo(ctx, e.pushTargets)
+}
+
+// pushTo formats the metrics for all of targets at once, then sends each
+// target its batch concurrently, recording how long each takes.  It returns
+// once every target has been sent to, or ctx is done.
+func (e *Exporter) pushTo(ctx context.Context, targets []pushOptions) {
+	batches := e.formatPush(targets)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(target pushOptions, batch *pushBatch) {
+			defer wg.Done()
+			start := time.Now()
+			e.pushWithRetry(ctx, target, batch)
+			d := new(expvar.Float)
+			d.Set(time.Since(start).Seconds())
+			pushDurations.Set(target.name, d)
+		}(target, batches[i])
+	}
+	wg.Wait()
+}
+
+// rejectedError is a push error from a collector refusing the metrics it was
+// sent, rather than from failing to receive them, so the push isn't retried.
+type rejectedError struct{ error }
+
+// partialError is a push error after some of the batch had already been
+// written to statsd.  The push isn't retried, as sending the batch again
+// would count the increments received twice.  Other collectors are sent
+// values rather than increments, so their partial pushes are retried.
+type partialError struct{ error }
+
+// retryable returns true if the push that failed with err can be sent again.
+func retryable(err error) bool {
+	return !errors.As(err, &rejectedError{}) && !errors.As(err, &partialError{})
+}
+
+// pushWithRetry sends batch to target, and if that fails tries again up to
+// metric_push_retries times, waiting twice as long before each retry as the
+// one before it.  The batch is dropped if every try fails, or if ctx is done
+// while waiting.  Pushes that the collector rejects, or statsd pushes that
+// failed part way through, aren't retried, and are dropped straight away.
+// Only the batch being retried is kept, as the next push to the target waits
+// for this one to finish.
+func (e *Exporter) pushWithRetry(ctx context.Context, target pushOptions, batch *pushBatch) {
+	err := e.pushMetrics(ctx, target, batch)
+	for retry := 0; err != nil && retryable(err) && retry < *pushRetries; retry++ {
+		wait := retryDelay(*pushRetryWait, retry)
+		glog.V(1).Infof("retrying push to %s in %s", target.addr, wait)
+		pushRetryBacklog.Add(1)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			pushRetryBacklog.Add(-1)
+			pushDropped.Add(target.addr, 1)
+			glog.Infof("dropped push to %s while waiting to retry: %s", target.addr, err)
+			return
+		case <-t.C:
 		}
-		err = e.writeSocketMetrics(conn, target.f, target.total, target.success)
-		if err != nil {
-			glog.Infof("pusher write error: %s", err)
+		pushRetryBacklog.Add(-1)
+		err = e.pushMetrics(ctx, target, batch)
+	}
+	if err != nil {
+		pushDropped.Add(target.addr, 1)
+		if retryable(err) {
+			glog.Infof("dropped push to %s after %d retries: %s", target.addr, *pushRetries, err)
+		} else {
+			glog.Infof("dropped push to %s without retrying: %s", target.addr, err)
 		}
-		err = conn.Close()
+	}
+	// A batch written in part isn't sent again, so it's taken as delivered.
+	if (err == nil || errors.As(err, &partialError{})) && batch.delivered != nil {
+		batch.delivered()
+	}
+}
+
+// retryDelay returns the time to wait before the retry numbered retry, counting
+// from zero: base doubled for each retry before it, with up to half of it taken
+// off at random so that retries after an outage don't all arrive at once.
+func retryDelay(base time.Duration, retry int) time.Duration {
+	if retry > 30 {
+		retry = 30
+	}
+	d := base << uint(retry)
+	if d <= 1 {
+		return d
+	}
+	// #nosec G404 -- jitter doesn't need a cryptographic random source.
+	return d - time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// pushMetrics sends batch to the service described by target.  Errors are
+// counted and logged, and returned so that the push can be retried.
+func (e *Exporter) pushMetrics(ctx context.Context, target pushOptions, batch *pushBatch) error {
+	glog.V(2).Infof("pushing to %s", target.addr)
+	if target.net == "http" {
+		err := e.postMetrics(ctx, target, batch)
 		if err != nil {
-			glog.Infof("connection close failed: %s", err)
+			pushErrors.Add(target.addr, 1)
+			glog.Infof("pusher post error: %s", err)
+			return err
 		}
+		target.success.Add(int64(len(batch.lines)))
+		return nil
+	}
+	conn, err := e.dial(target)
+	if err != nil {
+		pushErrors.Add(target.addr, 1)
+		glog.Infof("pusher dial error: %s", err)
+		return err
 	}
+	err = conn.SetDeadline(time.Now().Add(*writeDeadline))
+	if err != nil {
+		glog.Infof("Couldn't set deadline on connection: %s", err)
+	}
+	sc := &sentConn{Conn: conn}
+	if target.writer != nil {
+		w := target.writer(sc)
+		err = writeBatch(w, batch)
+		if err == nil {
+			err = w.Flush()
+		}
+	} else {
+		err = writeBatch(sc, batch)
+	}
+	if err != nil {
+		pushErrors.Add(target.addr, 1)
+		glog.Infof("pusher write error: %s", err)
+		closeConn(conn)
+		if sc.sent > 0 && target.name == "statsd" && !errors.As(err, &rejectedError{}) {
+			return partialError{err}
+		}
+		return err
+	}
+	target.success.Add(int64(len(batch.lines)))
+	e.release(target, conn)
+	return nil
 }
 
-// StartMetricPush pushes metrics to the configured services each interval.
-func (e *Exporter) StartMetricPush() {
-	if len(e.pushTargets) == 0 {
-		return
+// sentConn counts the bytes written to a connection, to tell whether a push
+// that failed had already sent part of its batch.
+type sentConn struct {
+	net.Conn
+	sent int64
+}
+
+func (c *sentConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent += int64(n)
+	return n, err
+}
+
+// dial returns the connection to target kept open by an earlier push, or
+// opens a new one.  The caller has the connection to itself until it's
+// released.
+func (e *Exporter) dial(target pushOptions) (net.Conn, error) {
+	key := target.net + ":" + target.addr
+	e.connsMu.Lock()
+	conn, ok := e.conns[key]
+	delete(e.conns, key)
+	e.connsMu.Unlock()
+	if ok {
+		if alive(conn) {
+			return conn, nil
+		}
+		glog.V(1).Infof("reconnecting to %s", target.addr)
+		closeConn(conn)
+	}
+	conn, err := net.DialTimeout(target.net, target.addr, *writeDeadline)
+	if err != nil {
+		return nil, err
 	}
-	if e.pushInterval <= 0 {
+	pushConnections.Add(target.addr, 1)
+	return conn, nil
+}
+
+// release keeps conn open for the next push to target.  Connections are
+// closed instead once the Exporter is shut down, as there won't be another
+// push, or if another push has already kept a connection to target.
+func (e *Exporter) release(target pushOptions, conn net.Conn) {
+	key := target.net + ":" + target.addr
+	e.connsMu.Lock()
+	_, ok := e.conns[key]
+	if !ok && e.ctx.Err() == nil {
+		if e.conns == nil {
+			e.conns = make(map[string]net.Conn)
+		}
+		e.conns[key] = conn
+		e.connsMu.Unlock()
 		return
 	}
-	e.wg.Add(1)
-	go func() {
-		defer e.wg.Done()
-		<-e.initDone
-		glog.Info("Started metric push.")
-		ticker := time.NewTicker(e.pushInterval)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-e.ctx.Done():
-				return
-			case <-ticker.C:
-				e.PushMetrics()
-			}
+	e.connsMu.Unlock()
+	closeConn(conn)
+}
+
+// alive returns false if the other end has closed conn since it was last
+// used.  Collectors don't send anything unasked, so a short read should time
+// out; otherwise the connection would only be found to be closed after the
+// next push had been written to it and lost.
+func alive(conn net.Conn) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// closeConns closes the connections kept open between pushes.
+func (e *Exporter) closeConns() {
+	e.connsMu.Lock()
+	defer e.connsMu.Unlock()
+	for key, conn := range e.conns {
+		closeConn(conn)
+		delete(e.conns, key)
+	}
+}
+
+func closeConn(conn net.Conn) {
+	if err := conn.Close(); err != nil {
+		glog.Infof("connection close failed: %s", err)
+	}
+}
+
+// newBufferedWriter buffers the lines of a push so that they're written to
+// the connection in as few writes as possible, of up to metric_push_batch_bytes
+// each.
+func newBufferedWriter(c net.Conn) pushWriter {
+	return bufio.NewWriterSize(c, *pushBatchSize)
+}
+
+// postMetrics sends all the metrics in batch to the URL target.addr in a
+// single gzip compressed POST request.
+func (e *Exporter) postMetrics(ctx context.Context, target pushOptions, batch *pushBatch) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := writeBatch(zw, batch); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.addr, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	client := &http.Client{Timeout: *writeDeadline}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		err := errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		if resp.StatusCode/100 == 4 {
+			return rejectedError{err}
 		}
-	}()
+		return err
+	}
+	return nil
+}
+
+// StartMetricPush pushes metrics to the configured services each interval.
+// Services without their own push interval use the Exporter's.  Services
+// with the same interval are pushed to together, from one pass over the
+// store each interval.
+func (e *Exporter) StartMetricPush() {
+	var intervals []time.Duration
+	groups := make(map[time.Duration][]pushOptions)
+	for _, target := range e.pushTargets {
+		interval := e.interval(target)
+		if interval <= 0 {
+			continue
+		}
+		if _, ok := groups[interval]; !ok {
+			intervals = append(intervals, interval)
+		}
+		groups[interval] = append(groups[interval], target)
+	}
+	for _, interval := range intervals {
+		e.wg.Add(1)
+		go func(targets []pushOptions, interval time.Duration) {
+			defer e.wg.Done()
+			<-e.initDone
+			for _, target := range targets {
+				glog.Infof("Started metric push to %s every %s.", target.addr, interval)
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-e.ctx.Done():
+					return
+				case <-ticker.C:
+					e.pushTo(e.ctx, targets)
+				}
+			}
+		}(groups[interval], interval)
+	}
 }
 
 type pushOptions struct {
-	net, addr      string
+	name           string // The backend's name, for its export filters.
+	net, addr      string // If net is "http", metrics are posted to the URL addr.
 	f              formatter
 	total, success *expvar.Int
+	interval       time.Duration             // If zero, the Exporter's push interval is used.
+	writer         func(net.Conn) pushWriter // If set, wraps the connection for protocols that need more than a stream of lines.
+	formatted      func() func()             // If set, called once each push to the target has been formatted, returning the batch's delivered func.
+}
+
+// pushWriter writes formatted metrics to a push connection.  Flush is called
+// after all the metrics have been written.
+type pushWriter interface {
+	io.Writer
+	Flush() error
 }
 
 // RegisterPushExport adds a push export connection to the Exporter.  Items in
@@ -4,12 +4,21 @@
 package exporter
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -163,6 +172,58 @@ func TestMetricToGraphite(t *testing.T) {
 		"prefixprog.bar.host.snuh_teevee 37 1343124840\n",
 	}
 	testutil.ExpectNoDiff(t, expected, r)
+
+	*graphitePrefix = "infra.logs.{hostname}."
+	r = FakeSocketWrite(metricToGraphite, scalarMetric)
+	expected = []string{"infra.logs.gunstar.prog.foo 37 1343124840\n"}
+	testutil.ExpectNoDiff(t, expected, r)
+	r = FakeSocketWrite(metricToGraphite, dimensionedMetric)
+	expected = []string{
+		"infra.logs.gunstar.prog.bar.host.quux_com 37 1343124840\n",
+		"infra.logs.gunstar.prog.bar.host.snuh_teevee 37 1343124840\n",
+	}
+	testutil.ExpectNoDiff(t, expected, r)
+	*graphitePrefix = ""
+}
+
+func TestMetricToGraphiteTags(t *testing.T) {
+	*graphitePrefix = ""
+	*graphiteUseTags = true
+	defer func() { *graphiteUseTags = false }()
+	ts, terr := time.Parse("2006/01/02 15:04:05", "2012/07/24 10:14:00")
+	if terr != nil {
+		t.Errorf("time parse error: %s", terr)
+	}
+
+	scalarMetric := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := scalarMetric.GetDatum()
+	datum.SetInt(d, 37, ts)
+	r := FakeSocketWrite(metricToGraphite, scalarMetric)
+	testutil.ExpectNoDiff(t, []string{"prog.foo 37 1343124840\n"}, r)
+
+	multiLabelMetric := metrics.NewMetric("bar", "prog", metrics.Gauge, metrics.Int, "host", "code", "path", "empty")
+	d, _ = multiLabelMetric.GetDatum("quux.com", "200", "~/a b;c", "")
+	datum.SetInt(d, 37, ts)
+	r = FakeSocketWrite(metricToGraphite, multiLabelMetric)
+	testutil.ExpectNoDiff(t, []string{"prog.bar;code=200;host=quux.com;path=_/a_b_c 37 1343124840\n"}, r)
+
+	histogramMetric := metrics.NewMetric("hist", "prog", metrics.Histogram, metrics.Buckets, "xxx")
+	lv := &metrics.LabelValue{Labels: []string{"bar"}, Value: datum.MakeBuckets([]datum.Range{{0, 10}, {10, 20}}, time.Unix(0, 0))}
+	histogramMetric.AppendLabelValue(lv)
+	d, _ = histogramMetric.GetDatum("bar")
+	datum.SetFloat(d, 1, ts)
+	datum.SetFloat(d, 15, ts)
+	r = FakeSocketWrite(metricToGraphite, histogramMetric)
+	r = strings.Split(strings.TrimSuffix(r[0], "\n"), "\n")
+	sort.Strings(r)
+	expected := []string{
+		"prog.hist.bin_10;xxx=bar 1 1343124840",
+		"prog.hist.bin_20;xxx=bar 1 1343124840",
+		"prog.hist.bin_inf;xxx=bar 0 1343124840",
+		"prog.hist.count;xxx=bar 2 1343124840",
+		"prog.hist;xxx=bar 16 1343124840",
+	}
+	testutil.ExpectNoDiff(t, expected, r)
 }
 
 func TestMetricToStatsd(t *testing.T) {
@@ -175,7 +236,7 @@ func TestMetricToStatsd(t *testing.T) {
 	scalarMetric := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
 	d, _ := scalarMetric.GetDatum()
 	datum.SetInt(d, 37, ts)
-	r := FakeSocketWrite(metricToStatsd, scalarMetric)
+	r := FakeSocketWrite(newStatsdEncoder().metricToStatsd, scalarMetric)
 	expected := []string{"prog.foo:37|c"}
 	if !reflect.DeepEqual(expected, r) {
 		t.Errorf("String didn't match:\n\texpected: %v\n\treceived: %v", expected, r)
@@ -186,7 +247,7 @@ func TestMetricToStatsd(t *testing.T) {
 	datum.SetInt(d, 37, ts)
 	d, _ = dimensionedMetric.GetDatum("snuh")
 	datum.SetInt(d, 42, ts)
-	r = FakeSocketWrite(metricToStatsd, dimensionedMetric)
+	r = FakeSocketWrite(newStatsdEncoder().metricToStatsd, dimensionedMetric)
 	expected = []string{
 		"prog.bar.l.quux:37|g",
 		"prog.bar.l.snuh:42|g",
@@ -198,7 +259,7 @@ func TestMetricToStatsd(t *testing.T) {
 	multiLabelMetric := metrics.NewMetric("bar", "prog", metrics.Gauge, metrics.Int, "c", "a", "b")
 	d, _ = multiLabelMetric.GetDatum("x", "z", "y")
 	datum.SetInt(d, 37, ts)
-	r = FakeSocketWrite(metricToStatsd, multiLabelMetric)
+	r = FakeSocketWrite(newStatsdEncoder().metricToStatsd, multiLabelMetric)
 	expected = []string{"prog.bar.a.z.b.y.c.x:37|g"}
 	if !reflect.DeepEqual(expected, r) {
 		t.Errorf("String didn't match:\n\texpected: %v\n\treceived: %v", expected, r)
@@ -207,16 +268,805 @@ func TestMetricToStatsd(t *testing.T) {
 	timingMetric := metrics.NewMetric("foo", "prog", metrics.Timer, metrics.Int)
 	d, _ = timingMetric.GetDatum()
 	datum.SetInt(d, 37, ts)
-	r = FakeSocketWrite(metricToStatsd, timingMetric)
+	r = FakeSocketWrite(newStatsdEncoder().metricToStatsd, timingMetric)
 	expected = []string{"prog.foo:37|ms"}
 	if !reflect.DeepEqual(expected, r) {
 		t.Errorf("String didn't match:\n\texpected: %v\n\treceived: %v", expected, r)
 	}
 
 	*statsdPrefix = prefix
-	r = FakeSocketWrite(metricToStatsd, timingMetric)
+	r = FakeSocketWrite(newStatsdEncoder().metricToStatsd, timingMetric)
 	expected = []string{"prefixprog.foo:37|ms"}
 	if !reflect.DeepEqual(expected, r) {
 		t.Errorf("prefixed string didn't match:\n\texpected: %v\n\treceived: %v", expected, r)
 	}
 }
+
+func TestMetricToStatsdCounterDeltas(t *testing.T) {
+	*statsdPrefix = ""
+	ts := time.Unix(0, 0)
+	s := newStatsdEncoder()
+
+	counter := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := counter.GetDatum()
+	datum.SetInt(d, 37, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:37|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+
+	datum.SetInt(d, 40, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:3|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+
+	// Unchanged counters aren't sent.
+	testutil.ExpectNoDiff(t, []string{""}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+
+	// A counter set to a smaller value was reset, so all of it is sent.
+	datum.SetInt(d, 4, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:4|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+	datum.SetInt(d, 40, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:36|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+
+	// A counter reset between pushes sends the increases either side of it.
+	datum.SetInt(d, 100, ts)
+	datum.SetInt(d, 3, ts)
+	datum.SetInt(d, 50, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:110|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+
+	// The collector scales up the increment by the sample rate sent with it.
+	*statsdSampleRate = 0.5
+	defer func() { *statsdSampleRate = 1 }()
+	datum.SetInt(d, 60, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:10|c|@0.5"}, FakeSocketWrite(s.metricToStatsd, counter))
+}
+
+func TestStatsdEncoderResendsUndeliveredIncrements(t *testing.T) {
+	*statsdPrefix = ""
+	ts := time.Unix(0, 0)
+	s := newStatsdEncoder()
+
+	counter := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := counter.GetDatum()
+	datum.SetInt(d, 37, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:37|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+
+	// The push of the next increment is dropped, so it's sent again with
+	// the one after.
+	datum.SetInt(d, 40, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:3|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()
+	datum.SetInt(d, 42, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:5|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+}
+
+func TestStatsdEncoderForgetsRemovedCounters(t *testing.T) {
+	*statsdPrefix = ""
+	ts := time.Unix(0, 0)
+	s := newStatsdEncoder()
+
+	counter := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := counter.GetDatum()
+	datum.SetInt(d, 37, ts)
+	testutil.ExpectNoDiff(t, []string{"prog.foo:37|c"}, FakeSocketWrite(s.metricToStatsd, counter))
+	s.formatted()()
+	testutil.ExpectNoDiff(t, 1, len(s.last))
+
+	// A push without the counter forgets it.
+	s.formatted()()
+	testutil.ExpectNoDiff(t, 0, len(s.last))
+}
+
+func TestDatagramWriter(t *testing.T) {
+	var datagrams []string
+	w := writerFunc(func(p []byte) (int, error) {
+		datagrams = append(datagrams, string(p))
+		return len(p), nil
+	})
+	d := &datagramWriter{w: w, size: 10}
+	for _, line := range []string{"a:1|c", "b:2|c", "c:3|c"} {
+		_, err := d.Write([]byte(line))
+		testutil.FatalIfErr(t, err)
+	}
+	testutil.FatalIfErr(t, d.Flush())
+	testutil.ExpectNoDiff(t, []string{"a:1|c", "b:2|c", "c:3|c"}, datagrams)
+
+	datagrams = nil
+	d.size = 20
+	for _, line := range []string{"a:1|c", "b:2|c", "c:3|c"} {
+		_, err := d.Write([]byte(line))
+		testutil.FatalIfErr(t, err)
+	}
+	testutil.FatalIfErr(t, d.Flush())
+	testutil.ExpectNoDiff(t, []string{"a:1|c\nb:2|c\nc:3|c"}, datagrams)
+}
+
+func TestDatagramWriterTerminatedLines(t *testing.T) {
+	var datagrams []string
+	w := writerFunc(func(p []byte) (int, error) {
+		datagrams = append(datagrams, string(p))
+		return len(p), nil
+	})
+	d := &datagramWriter{w: w, size: 12, terminated: true}
+	for _, line := range []string{"a 1 1\n", "b 2 1\n", "c 3 1\n"} {
+		_, err := d.Write([]byte(line))
+		testutil.FatalIfErr(t, err)
+	}
+	testutil.FatalIfErr(t, d.Flush())
+	testutil.ExpectNoDiff(t, []string{"a 1 1\nb 2 1\n", "c 3 1\n"}, datagrams)
+
+	// The lines of one write, like the buckets of a histogram, are split
+	// between datagrams.
+	datagrams = nil
+	_, err := d.Write([]byte("a 1 1\nb 2 1\nc 3 1\n"))
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, d.Flush())
+	testutil.ExpectNoDiff(t, []string{"a 1 1\nb 2 1\n", "c 3 1\n"}, datagrams)
+}
+
+func TestStatsdPushTCP(t *testing.T) {
+	*statsdPrefix = ""
+	*statsdProtocol = "tcp"
+	defer func() { *statsdProtocol = "udp" }()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer ln.Close()
+	received := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			line, _ := r.ReadString('\n')
+			received <- line
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	for _, name := range []string{"foo", "bar"} {
+		m := metrics.NewMetric(name, "prog", metrics.Gauge, metrics.Int)
+		d, _ := m.GetDatum()
+		datum.SetInt(d, 37, time.Unix(1343124840, 0))
+		testutil.FatalIfErr(t, ms.Add(m))
+	}
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	*statsdHostPort = ln.Addr().String()
+	o, err := statsdPushOptions()
+	*statsdHostPort = ""
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(o)
+
+	// Each line is ended by a newline on a stream.
+	e.PushMetrics()
+	got := []string{<-received, <-received}
+	sort.Strings(got)
+	testutil.ExpectNoDiff(t, []string{"prog.bar:37|g\n", "prog.foo:37|g\n"}, got)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestPushProtocolFlags(t *testing.T) {
+	*statsdProtocol = "sctp"
+	defer func() { *statsdProtocol = "udp" }()
+	if _, err := statsdPushOptions(); err == nil {
+		t.Error("no error for unknown statsd protocol")
+	}
+	*graphiteProtocol = "sctp"
+	defer func() { *graphiteProtocol = "tcp" }()
+	if _, err := graphitePushOptions(); err == nil {
+		t.Error("no error for unknown graphite protocol")
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestCollectdPush(t *testing.T) {
+	*collectdPrefix = ""
+	socketPath := filepath.Join(testutil.TestTempDir(t), "collectd.sock")
+	ln, err := net.Listen("unix", socketPath)
+	testutil.FatalIfErr(t, err)
+	defer ln.Close()
+
+	// A fake collectd that accepts the first value of each connection and rejects the rest.
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			for i := 0; ; i++ {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				received <- line
+				reply := "0 Success: 1 value has been dispatched.\n"
+				if i > 0 {
+					reply = "-1 Only one value per connection.\n"
+				}
+				if _, err := conn.Write([]byte(reply)); err != nil {
+					break
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	m := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := m.GetDatum()
+	datum.SetInt(d, 37, time.Unix(1343124840, 0))
+	testutil.FatalIfErr(t, ms.Add(m))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"), PushInterval(time.Minute))
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(pushOptions{"collectd", "unix", socketPath, metricToCollectd, collectdExportTotal, collectdExportSuccess, 0, newCollectdWriter, nil})
+
+	successCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "collectd_export_success", 1)
+	e.PushMetrics()
+	successCheck()
+	testutil.ExpectNoDiff(t, "PUTVAL \"gunstar/mtail-prog/counter-foo\" interval=60 1343124840:37\n", <-received)
+
+	m2 := metrics.NewMetric("bar", "prog", metrics.Counter, metrics.Int)
+	d, _ = m2.GetDatum()
+	datum.SetInt(d, 1, time.Unix(1343124840, 0))
+	testutil.FatalIfErr(t, ms.Add(m2))
+	// The rejected push isn't retried, and is counted as dropped.
+	errorsCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "collectd_export_errors", 1)
+	droppedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_dropped_total", socketPath, 1)
+	e.PushMetrics()
+	errorsCheck()
+	droppedCheck()
+
+	cancel()
+	wg.Wait()
+}
+
+func TestPushWritesEachLine(t *testing.T) {
+	*statsdPrefix = ""
+	*collectdPrefix = ""
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	m := metrics.NewMetric("c", "a.mtail", metrics.Counter, metrics.Int, "k")
+	for _, k := range []string{"bar", "baz", "foo"} {
+		d, _ := m.GetDatum(k)
+		datum.SetInt(d, 1, time.Unix(1343124840, 0))
+	}
+	testutil.FatalIfErr(t, ms.Add(m))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+
+	// The statsd lines of a dimensioned metric are separated in the datagram.
+	batch := e.formatPush([]pushOptions{{name: "statsd", f: newStatsdEncoder().metricToStatsd, total: statsdExportTotal}})[0]
+	var datagrams []string
+	w := &datagramWriter{w: writerFunc(func(p []byte) (int, error) {
+		datagrams = append(datagrams, string(p))
+		return len(p), nil
+	}), size: 1432}
+	testutil.FatalIfErr(t, writeBatch(w, batch))
+	testutil.FatalIfErr(t, w.Flush())
+	testutil.ExpectNoDiff(t, 1, len(datagrams))
+	got := strings.Split(datagrams[0], "\n")
+	sort.Strings(got)
+	testutil.ExpectNoDiff(t, []string{"a.mtail.c.k.bar:1|c", "a.mtail.c.k.baz:1|c", "a.mtail.c.k.foo:1|c"}, got)
+
+	// collectd is sent each PUTVAL on its own, and replies to each one.
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			if _, err := server.Write([]byte("0 Success: 1 value has been dispatched.\n")); err != nil {
+				return
+			}
+		}
+	}()
+	batch = e.formatPush([]pushOptions{{name: "collectd", f: metricToCollectd, total: collectdExportTotal}})[0]
+	testutil.ExpectNoDiff(t, 3, len(batch.lines))
+	testutil.FatalIfErr(t, writeBatch(newCollectdWriter(client), batch))
+}
+
+func TestPushIntervalPerTarget(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer ln.Close()
+	received := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			received <- line
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	m := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	_, err = m.GetDatum()
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, ms.Add(m))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"), PushInterval(time.Hour))
+	testutil.FatalIfErr(t, err)
+	// The formatter reports the interval it's given, which is the target's own.
+	f := func(_ string, _ *metrics.Metric, _ *metrics.LabelSet, interval time.Duration) string {
+		return interval.String() + "\n"
+	}
+	e.RegisterPushExport(pushOptions{"graphite", "tcp", ln.Addr().String(), f, graphiteExportTotal, graphiteExportSuccess, 10 * time.Millisecond, nil, nil})
+	e.StartMetricPush()
+
+	// Two pushes arriving shows the target is on its own ticker, as the
+	// Exporter's interval is an hour.
+	for i := 0; i < 2; i++ {
+		select {
+		case line := <-received:
+			testutil.ExpectNoDiff(t, "10ms\n", line)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("push %d not received", i)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestPushFanOut(t *testing.T) {
+	received := make(chan string, 100)
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		testutil.FatalIfErr(t, err)
+		defer ln.Close()
+		addrs = append(addrs, ln.Addr().String())
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				received <- line
+				conn.Close()
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	m := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := m.GetDatum()
+	datum.SetInt(d, 37, time.Unix(1343124840, 0))
+	testutil.FatalIfErr(t, ms.Add(m))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	// Each target formats the metric its own way, from the same pass over the store.
+	e.RegisterPushExport(pushOptions{"graphite", "tcp", addrs[0], func(hostname string, m *metrics.Metric, l *metrics.LabelSet, interval time.Duration) string {
+		return "graphite " + l.Datum.ValueString() + "\n"
+	}, graphiteExportTotal, graphiteExportSuccess, 0, nil, nil})
+	e.RegisterPushExport(pushOptions{"opentsdb", "tcp", addrs[1], func(hostname string, m *metrics.Metric, l *metrics.LabelSet, interval time.Duration) string {
+		return "opentsdb " + l.Datum.ValueString() + "\n"
+	}, opentsdbExportTotal, opentsdbExportSuccess, 0, nil, nil})
+
+	e.PushMetrics()
+	got := []string{<-received, <-received}
+	sort.Strings(got)
+	testutil.ExpectNoDiff(t, []string{"graphite 37\n", "opentsdb 37\n"}, got)
+	for _, backend := range []string{"graphite", "opentsdb"} {
+		if pushDurations.Get(backend) == nil {
+			t.Errorf("no push duration for %s", backend)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestPushRetry(t *testing.T) {
+	*pushRetryWait = time.Millisecond
+	defer func() { *pushRetryWait = time.Second }()
+	var failures int32
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	m := metrics.NewMetric("foo", "prog", metrics.Counter, metrics.Int)
+	d, _ := m.GetDatum()
+	datum.SetInt(d, 37, time.Unix(1343124840, 0))
+	testutil.FatalIfErr(t, ms.Add(m))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(pushOptions{"influxdb", "http", srv.URL, metricToInfluxdb, influxdbExportTotal, influxdbExportSuccess, 0, nil, nil})
+
+	// A push that fails fewer times than the retry limit is sent.
+	atomic.StoreInt32(&failures, 2)
+	droppedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_dropped_total", srv.URL, 0)
+	e.PushMetrics()
+	droppedCheck()
+	testutil.ExpectNoDiff(t, int32(3), atomic.LoadInt32(&requests))
+
+	// A push that fails every retry is dropped.
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 10)
+	droppedCheck = testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_dropped_total", srv.URL, 1)
+	e.PushMetrics()
+	droppedCheck()
+	testutil.ExpectNoDiff(t, int32(1+*pushRetries), atomic.LoadInt32(&requests))
+	testutil.ExpectNoDiff(t, int64(0), pushRetryBacklog.Value())
+
+	cancel()
+	wg.Wait()
+}
+
+// failingWriter writes its first line to the connection, and fails after.
+type failingWriter struct {
+	c     net.Conn
+	lines int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.lines++
+	if w.lines > 1 {
+		return 0, errors.New("connection broke")
+	}
+	return w.c.Write(p)
+}
+
+func (w *failingWriter) Flush() error { return nil }
+
+func TestPushAfterPartialWrite(t *testing.T) {
+	*statsdPrefix = ""
+	*pushRetryWait = time.Millisecond
+	defer func() { *pushRetryWait = time.Second }()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	for _, name := range []string{"foo", "bar"} {
+		m := metrics.NewMetric(name, "prog", metrics.Counter, metrics.Int)
+		d, _ := m.GetDatum()
+		datum.SetInt(d, 37, time.Unix(1343124840, 0))
+		testutil.FatalIfErr(t, ms.Add(m))
+	}
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	addr := ln.Addr().String()
+	failing := func(c net.Conn) pushWriter {
+		return &failingWriter{c: c}
+	}
+
+	// Graphite is sent values, so the batch is sent again, and dropped once
+	// every retry has failed.
+	graphite := pushOptions{"graphite", "tcp", addr, metricToGraphite, graphiteExportTotal, graphiteExportSuccess, 0, failing, nil}
+	successCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "graphite_export_success", 0)
+	droppedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_dropped_total", addr, 1)
+	connsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_connections_total", addr, int64(1+*pushRetries))
+	e.pushTo(ctx, []pushOptions{graphite})
+	successCheck()
+	droppedCheck()
+	connsCheck()
+
+	// The first statsd increment reached the collector, so the batch isn't
+	// sent again, and none of it is counted as sent.
+	s := newStatsdEncoder()
+	statsd := pushOptions{"statsd", "tcp", addr, s.metricToStatsd, statsdExportTotal, statsdExportSuccess, 0, failing, s.formatted}
+	successCheck = testutil.ExpectExpvarDeltaWithDeadline(t, "statsd_export_success", 0)
+	droppedCheck = testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_dropped_total", addr, 1)
+	connsCheck = testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_connections_total", addr, 1)
+	e.pushTo(ctx, []pushOptions{statsd})
+	successCheck()
+	droppedCheck()
+	connsCheck()
+
+	cancel()
+	wg.Wait()
+}
+
+// countingConn counts the writes to a connection.
+type countingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Conn.Write(p)
+}
+
+func TestPushKeepsConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer ln.Close()
+	addr := ln.Addr().String()
+	lines := make(chan string, 1000)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- line
+				}
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	for i := 0; i < 100; i++ {
+		m := metrics.NewMetric(fmt.Sprintf("metric%d", i), "prog", metrics.Counter, metrics.Int)
+		d, _ := m.GetDatum()
+		datum.SetInt(d, 37, time.Unix(1343124840, 0))
+		testutil.FatalIfErr(t, ms.Add(m))
+	}
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(pushOptions{"graphite", "tcp", addr, metricToGraphite, graphiteExportTotal, graphiteExportSuccess, 0, newBufferedWriter, nil})
+
+	// Every push is sent over the connection opened by the first.
+	connectionsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_connections_total", addr, 1)
+	for i := 0; i < 3; i++ {
+		e.PushMetrics()
+		for j := 0; j < 100; j++ {
+			<-lines
+		}
+	}
+	connectionsCheck()
+
+	cancel()
+	wg.Wait()
+}
+
+func TestBufferedWriterBatchesWrites(t *testing.T) {
+	client, server := net.Pipe()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+	defer client.Close()
+	c := &countingConn{Conn: client}
+	batch := &pushBatch{}
+	for i := 0; i < 1000; i++ {
+		batch.lines = append(batch.lines, fmt.Sprintf("prog.metric%d 37 1343124840\n", i))
+	}
+	var size int
+	for _, line := range batch.lines {
+		size += len(line)
+	}
+
+	w := newBufferedWriter(c)
+	testutil.FatalIfErr(t, writeBatch(w, batch))
+	testutil.FatalIfErr(t, w.Flush())
+	if want := (size + *pushBatchSize - 1) / *pushBatchSize; c.writes > want {
+		t.Errorf("%d bytes of metrics written in %d writes, want at most %d", size, c.writes, want)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry := 0; retry < 5; retry++ {
+		limit := time.Second << uint(retry)
+		for i := 0; i < 10; i++ {
+			d := retryDelay(time.Second, retry)
+			if d < limit/2 || d > limit {
+				t.Errorf("retryDelay(1s, %d) = %s, want between %s and %s", retry, d, limit/2, limit)
+			}
+		}
+	}
+}
+
+func TestHostnameLabel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+		Name:        "foo",
+		Program:     "test",
+		Kind:        metrics.Counter,
+		Keys:        []string{"code"},
+		LabelValues: []*metrics.LabelValue{{Labels: []string{"200"}, Value: datum.MakeInt(1, time.Unix(1, 0))}},
+	}))
+	// A metric's own label of the same name is kept.
+	testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+		Name:        "bar",
+		Program:     "test",
+		Kind:        metrics.Counter,
+		Keys:        []string{"host"},
+		LabelValues: []*metrics.LabelValue{{Labels: []string{"other"}, Value: datum.MakeInt(2, time.Unix(1, 0))}},
+	}))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"), HostnameLabel("host"), OmitProgLabel())
+	testutil.FatalIfErr(t, err)
+
+	var prom strings.Builder
+	testutil.FatalIfErr(t, e.Write(&prom))
+	for _, want := range []string{`foo{code="200",host="gunstar"} 1`, `bar{host="other"} 2`} {
+		if !strings.Contains(prom.String(), want) {
+			t.Errorf("prometheus output missing %q:\n%s", want, prom.String())
+		}
+	}
+
+	var push strings.Builder
+	testutil.FatalIfErr(t, e.writeSocketMetrics(&push, "influxdb", metricToInfluxdb, 0, influxdbExportTotal, influxdbExportSuccess))
+	got := strings.Split(strings.TrimSpace(push.String()), "\n")
+	sort.Strings(got)
+	testutil.ExpectNoDiff(t, []string{
+		"bar,host=other,prog=test value=2i 1000000000",
+		"foo,code=200,host=gunstar,prog=test value=1i 1000000000",
+	}, got)
+
+	response := httptest.NewRecorder()
+	e.HandleJSON(response, httptest.NewRequest("GET", "/json", nil))
+	var exported []*metrics.Metric
+	testutil.FatalIfErr(t, json.Unmarshal(response.Body.Bytes(), &exported))
+	gotLabels := make(map[string][]string)
+	for _, m := range exported {
+		gotLabels[m.Name] = append(m.Keys, m.LabelValues[0].Labels...)
+	}
+	testutil.ExpectNoDiff(t, map[string][]string{
+		"foo": {"code", "host", "200", "gunstar"},
+		"bar": {"host", "other"},
+	}, gotLabels)
+
+	// The label is not added to the metrics in the store.
+	testutil.ExpectNoDiff(t, []string{"code"}, ms.FindMetricOrNil("foo", "test").Keys)
+}
+
+func TestExportFilters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	for _, name := range []string{"foo", "foo_internal", "bar", "foo_hidden", "bar_hidden"} {
+		testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+			Name:        name,
+			Program:     "test",
+			Kind:        metrics.Counter,
+			Hidden:      strings.HasSuffix(name, "_hidden"),
+			LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(1, 0))}},
+		}))
+	}
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"), ExportDeny("", "_internal$"), ExportAllow("influxdb", "^foo"))
+	testutil.FatalIfErr(t, err)
+
+	var push strings.Builder
+	testutil.FatalIfErr(t, e.writeSocketMetrics(&push, "influxdb", metricToInfluxdb, 0, influxdbExportTotal, influxdbExportSuccess))
+	got := strings.Split(strings.TrimSpace(push.String()), "\n")
+	sort.Strings(got)
+	// The hidden metric allowed by name is exported, but not the other.
+	testutil.ExpectNoDiff(t, []string{"foo,prog=test value=1i 1000000000", "foo_hidden,prog=test value=1i 1000000000"}, got)
+
+	push.Reset()
+	testutil.FatalIfErr(t, e.writeSocketMetrics(&push, "graphite", metricToInfluxdb, 0, graphiteExportTotal, graphiteExportSuccess))
+	got = strings.Split(strings.TrimSpace(push.String()), "\n")
+	sort.Strings(got)
+	// Without an allow pattern naming them, hidden metrics aren't exported.
+	testutil.ExpectNoDiff(t, []string{"bar,prog=test value=1i 1000000000", "foo,prog=test value=1i 1000000000"}, got)
+
+	// The store keeps every metric.
+	if ms.FindMetricOrNil("foo_internal", "test") == nil {
+		t.Error("denied metric removed from the store")
+	}
+
+	if _, err := New(ctx, &wg, ms, ExportAllow("carrier-pigeon", "foo")); err == nil {
+		t.Error("no error for an unknown backend")
+	}
+}
+
+func TestStaleAfter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	// Updated from an old log, so its timestamp is old, but it isn't stale.
+	live := &metrics.LabelValue{Labels: []string{"live"}, Value: datum.MakeInt(2, time.Now().Add(-24*time.Hour))}
+	live.Touch(time.Now())
+	old := datum.MakeInt(1, time.Now())
+	gone := &metrics.LabelValue{Labels: []string{"gone"}, Value: old}
+	gone.Touch(time.Now().Add(-time.Hour))
+	testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+		Name:    "requests",
+		Program: "test",
+		Kind:    metrics.Counter,
+		Keys:    []string{"backend"},
+		LabelValues: []*metrics.LabelValue{
+			live,
+			gone,
+			// Never updated, so it has no time to be stale by.
+			{Labels: []string{"unset"}, Value: datum.NewInt()},
+		},
+	}))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"), StaleAfter(time.Minute))
+	testutil.FatalIfErr(t, err)
+
+	var prom strings.Builder
+	testutil.FatalIfErr(t, e.Write(&prom))
+	if !strings.Contains(prom.String(), `requests{backend="live",prog="test"} 2`) {
+		t.Errorf("fresh series not exported:\n%s", prom.String())
+	}
+	if strings.Contains(prom.String(), `backend="gone"`) {
+		t.Errorf("stale series exported:\n%s", prom.String())
+	}
+
+	var push strings.Builder
+	testutil.FatalIfErr(t, e.writeSocketMetrics(&push, "graphite", metricToGraphite, 0, graphiteExportTotal, graphiteExportSuccess))
+	if strings.Contains(push.String(), "gone") {
+		t.Errorf("stale series pushed:\n%s", push.String())
+	}
+	if !strings.Contains(push.String(), "unset") {
+		t.Errorf("unset series not pushed:\n%s", push.String())
+	}
+	testutil.ExpectNoDiff(t, int64(1), e.countStale(time.Now()))
+	*statsdPrefix = ""
+	s := newStatsdEncoder()
+	statsd := pushOptions{name: "statsd", f: s.metricToStatsd, total: statsdExportTotal, formatted: s.formatted}
+	e.formatPush([]pushOptions{statsd})[0].delivered()
+
+	// A stale series that is updated again comes back, and was kept in the
+	// store all along.
+	datum.IncIntBy(old, 1, time.Now())
+	gone.Touch(time.Now())
+	// statsd is only sent its increment, as the encoder still remembered it.
+	batch := e.formatPush([]pushOptions{statsd})[0]
+	testutil.ExpectNoDiff(t, []string{"test.requests.backend.gone:1|c"}, batch.lines)
+	prom.Reset()
+	testutil.FatalIfErr(t, e.Write(&prom))
+	if !strings.Contains(prom.String(), `requests{backend="gone",prog="test"} 2`) {
+		t.Errorf("updated series not exported:\n%s", prom.String())
+	}
+	testutil.ExpectNoDiff(t, int64(0), e.countStale(time.Now()))
+}
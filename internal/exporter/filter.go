@@ -0,0 +1,128 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package exporter
+
+import (
+	"regexp"
+
+	"github.com/google/mtail/internal/metrics"
+	"github.com/pkg/errors"
+)
+
+// backends are the names of the exports that can be given their own filters.
+var backends = map[string]struct{}{
+	"collectd":   {},
+	"graphite":   {},
+	"influxdb":   {},
+	"json":       {},
+	"opentsdb":   {},
+	"prometheus": {},
+	"statsd":     {},
+	"varz":       {},
+}
+
+// IsBackend returns true if name is the name of an export backend.
+func IsBackend(name string) bool {
+	_, ok := backends[name]
+	return ok
+}
+
+// exportFilter chooses the metrics sent to an export by their name.
+type exportFilter struct {
+	allow []*regexp.Regexp // If not empty, only metrics matching one of these are exported.
+	deny  []*regexp.Regexp // Metrics matching any of these are not exported.
+}
+
+// exports returns true if the filter lets the metric called name through.  A
+// nil filter lets every metric through.
+func (f *exportFilter) exports(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, re := range f.deny {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	return f.allows(name)
+}
+
+// allows returns true if one of the filter's allow patterns matches the
+// metric called name.  A nil filter allows none.
+func (f *exportFilter) allows(name string) bool {
+	if f == nil {
+		return false
+	}
+	for _, re := range f.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// exported returns true if the metric m is sent to the export backend, by
+// both the filters of every export and the backend's own.  A hidden metric is
+// only sent if one of those filters explicitly allows it.
+func (e *Exporter) exported(backend string, m *metrics.Metric) bool {
+	if m.Hidden && !e.filters[""].allows(m.Name) && !e.filters[backend].allows(m.Name) {
+		return false
+	}
+	return e.filters[""].exports(m.Name) && e.filters[backend].exports(m.Name)
+}
+
+// addFilter compiles pattern, and returns it with the filter for backend, or
+// for every export if backend is empty.
+func (e *Exporter) addFilter(backend, pattern string) (*exportFilter, *regexp.Regexp, error) {
+	if backend != "" && !IsBackend(backend) {
+		return nil, nil, errors.Errorf("unknown export backend %q", backend)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "export filter %q", pattern)
+	}
+	if e.filters == nil {
+		e.filters = make(map[string]*exportFilter)
+	}
+	f, ok := e.filters[backend]
+	if !ok {
+		f = &exportFilter{}
+		e.filters[backend] = f
+	}
+	return f, re, nil
+}
+
+// ExportAllow limits the metrics exported to backend to those whose name
+// matches the regular expression pattern, or one of the other patterns
+// allowed for it.  An empty backend applies to every export.  Metrics are
+// filtered as they are exported, so the store keeps every metric.  Hidden
+// metrics are only exported to backend if a pattern allowed for it, or for
+// every export, matches them.
+func ExportAllow(backend, pattern string) Option {
+	return func(e *Exporter) error {
+		f, re, err := e.addFilter(backend, pattern)
+		if err != nil {
+			return err
+		}
+		f.allow = append(f.allow, re)
+		return nil
+	}
+}
+
+// ExportDeny stops metrics whose name matches the regular expression pattern
+// from being exported to backend, even if they are allowed.  An empty backend
+// applies to every export.
+func ExportDeny(backend, pattern string) Option {
+	return func(e *Exporter) error {
+		f, re, err := e.addFilter(backend, pattern)
+		if err != nil {
+			return err
+		}
+		f.deny = append(f.deny, re)
+		return nil
+	}
+}
@@ -8,19 +8,30 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"net"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/pkg/errors"
 )
 
 var (
 	graphiteHostPort = flag.String("graphite_host_port", "",
 		"Host:port to graphite carbon server to write metrics to.")
 	graphitePrefix = flag.String("graphite_prefix", "",
-		"Prefix to use for graphite metrics.")
+		"Prefix to use for graphite metrics, e.g. infra.logs.{hostname}.  Any {hostname} in the prefix is replaced by the hostname, with its dots replaced by underscores.")
+	graphitePushInterval = flag.Duration("graphite_push_interval", 0,
+		"Interval between pushes to graphite.  If zero, --metric_push_interval is used.")
+	graphiteUseTags = flag.Bool("graphite_use_tags", false,
+		"Export the dimensions of metrics to graphite as tags, as in metric;key=value, rather than as levels of the metric path.  Needs graphite 1.1 or later.")
+	graphiteProtocol = flag.String("graphite_protocol", "tcp",
+		"Protocol to send metrics to graphite with, tcp or udp.")
+	graphiteMaxDatagramSize = flag.Int("graphite_max_datagram_size", 1432,
+		"Largest UDP datagram to send to graphite, in bytes, if --graphite_protocol is udp.")
 
 	graphiteExportTotal   = expvar.NewInt("graphite_export_total")
 	graphiteExportSuccess = expvar.NewInt("graphite_export_success")
@@ -29,17 +40,25 @@ var (
 func (e *Exporter) HandleGraphite(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-type", "text/plain")
 
+	now := time.Now()
 	err := e.store.Range(func(m *metrics.Metric) error {
 		select {
 		case <-r.Context().Done():
 			return r.Context().Err()
 		default:
 		}
+		if !e.exported("graphite", m) {
+			return nil
+		}
 		m.RLock()
 		graphiteExportTotal.Add(1)
 		lc := make(chan *metrics.LabelSet)
 		go m.EmitLabelSets(lc)
 		for l := range lc {
+			if e.stale(l, now) {
+				continue
+			}
+			e.addHostnameLabel(l)
 			line := metricToGraphite(e.hostname, m, l, 0)
 			fmt.Fprint(w, line)
 		}
@@ -51,9 +70,57 @@ func (e *Exporter) HandleGraphite(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// graphitePathPrefix returns the graphite_prefix to put before each metric
+// path, with the hostname filled in.  The dots in the hostname would add
+// levels to the graphite tree, so they are replaced with underscores.
+func graphitePathPrefix(hostname string) string {
+	return strings.ReplaceAll(*graphitePrefix, "{hostname}", strings.ReplaceAll(hostname, ".", "_"))
+}
+
+var (
+	// Graphite tag names can't contain any of ";!^=", and neither can contain
+	// the whitespace that separates the fields of the protocol.
+	graphiteTagNameEscaper  = strings.NewReplacer(";", "_", "!", "_", "^", "_", "=", "_", " ", "_", "\t", "_", "\n", "_")
+	graphiteTagValueEscaper = strings.NewReplacer(";", "_", " ", "_", "\t", "_", "\n", "_")
+)
+
+// graphiteTagged returns the name of a metric with the labels as graphite tags,
+// in the order of their names.  Graphite rejects empty tag values, so those
+// labels are left out.
+func graphiteTagged(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(graphiteTagValueEscaper.Replace(name))
+	for _, k := range keys {
+		v := labels[k]
+		if v == "" {
+			continue
+		}
+		v = graphiteTagValueEscaper.Replace(v)
+		if strings.HasPrefix(v, "~") {
+			// A leading tilde is reserved.
+			v = "_" + v[1:]
+		}
+		fmt.Fprintf(&b, ";%s=%s", graphiteTagNameEscaper.Replace(k), v)
+	}
+	return b.String()
+}
+
 // metricToGraphite encodes a metric in the graphite text protocol format.  The
 // metric lock is held before entering this function.
 func metricToGraphite(hostname string, m *metrics.Metric, l *metrics.LabelSet, _ time.Duration) string {
+	prefix := graphitePathPrefix(hostname)
+	// path returns the metric's path with suffix, followed by its dimensions.
+	path := func(suffix string) string {
+		if *graphiteUseTags {
+			return graphiteTagged(prefix+m.Program+"."+m.Name+suffix, l.Labels)
+		}
+		return prefix + m.Program + "." + formatLabels(m.Name, l.Labels, ".", ".", "_") + suffix
+	}
 	var b strings.Builder
 	if m.Kind == metrics.Histogram && m.Type == metrics.Buckets {
 		d := m.LabelValues[0].Value
@@ -65,26 +132,37 @@ func metricToGraphite(hostname string, m *metrics.Metric, l *metrics.LabelSet, _
 			} else {
 				binName = fmt.Sprintf("%v", r.Max)
 			}
-			fmt.Fprintf(&b, "%s%s.%s.bin_%s %v %v\n",
-				*graphitePrefix,
-				m.Program,
-				formatLabels(m.Name, l.Labels, ".", ".", "_"),
-				binName,
+			fmt.Fprintf(&b, "%s %v %v\n",
+				path(".bin_"+binName),
 				c,
 				l.Datum.TimeString())
 		}
-		fmt.Fprintf(&b, "%s%s.%s.count %v %v\n",
-			*graphitePrefix,
-			m.Program,
-			formatLabels(m.Name, l.Labels, ".", ".", "_"),
+		fmt.Fprintf(&b, "%s %v %v\n",
+			path(".count"),
 			buckets.GetCount(),
 			l.Datum.TimeString())
 	}
-	fmt.Fprintf(&b, "%s%s.%s %v %v\n",
-		*graphitePrefix,
-		m.Program,
-		formatLabels(m.Name, l.Labels, ".", ".", "_"),
+	fmt.Fprintf(&b, "%s %v %v\n",
+		path(""),
 		l.Datum.ValueString(),
 		l.Datum.TimeString())
 	return b.String()
 }
+
+// graphitePushOptions returns the push target for graphite, sent over
+// graphite_protocol.  Lines are buffered on a TCP connection, and packed into
+// datagrams over UDP.
+func graphitePushOptions() (pushOptions, error) {
+	o := pushOptions{"graphite", "tcp", *graphiteHostPort, metricToGraphite, graphiteExportTotal, graphiteExportSuccess, *graphitePushInterval, newBufferedWriter, nil}
+	switch *graphiteProtocol {
+	case "tcp":
+	case "udp":
+		o.net = "udp"
+		o.writer = func(c net.Conn) pushWriter {
+			return &datagramWriter{w: c, size: *graphiteMaxDatagramSize, terminated: true}
+		}
+	default:
+		return o, errors.Errorf("unknown graphite protocol %q, want tcp or udp", *graphiteProtocol)
+	}
+	return o, nil
+}
@@ -4,10 +4,14 @@
 package exporter
 
 import (
+	"bufio"
 	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -39,6 +43,18 @@ var handleGraphiteTests = []struct {
 		},
 		"foobar.test.foo 1 0\n",
 	},
+	{
+		"log timestamp",
+		[]*metrics.Metric{
+			{
+				Name:        "foo",
+				Program:     "test",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Date(2012, 1, 18, 6, 25, 0, 750000000, time.UTC))}},
+			},
+		},
+		"foobar.test.foo 1 1326867900\n",
+	},
 }
 
 func TestHandleGraphite(t *testing.T) {
@@ -69,3 +85,145 @@ func TestHandleGraphite(t *testing.T) {
 		})
 	}
 }
+
+func TestGraphitePush(t *testing.T) {
+	*graphitePrefix = "foobar."
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	addr := ln.Addr().String()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		// The connection is kept open for the next push, so read the line of this one.
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+		Name:        "foo",
+		Program:     "test",
+		Kind:        metrics.Counter,
+		Keys:        []string{"code"},
+		LabelValues: []*metrics.LabelValue{{Labels: []string{"200"}, Value: datum.MakeInt(3, time.Unix(1, 0))}},
+	}))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(pushOptions{"graphite", "tcp", addr, metricToGraphite, graphiteExportTotal, graphiteExportSuccess, 0, nil, nil})
+
+	e.PushMetrics()
+	testutil.ExpectNoDiff(t, "foobar.test.foo.code.200 3 1\n", <-received)
+
+	// Nothing is listening now, so the push fails and is counted.
+	*pushRetries = 0
+	defer func() { *pushRetries = 3 }()
+	testutil.FatalIfErr(t, ln.Close())
+	pushErrorsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_errors_total", addr, 1)
+	e.PushMetrics()
+	pushErrorsCheck()
+
+	cancel()
+	wg.Wait()
+}
+
+func TestGraphitePushUDP(t *testing.T) {
+	*graphitePrefix = ""
+	*graphiteProtocol = "udp"
+	defer func() { *graphiteProtocol = "tcp" }()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+		Name:        "foo",
+		Program:     "test",
+		Kind:        metrics.Counter,
+		LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(3, time.Unix(1, 0))}},
+	}))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	*graphiteHostPort = pc.LocalAddr().String()
+	o, err := graphitePushOptions()
+	*graphiteHostPort = ""
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(o)
+
+	e.PushMetrics()
+	b := make([]byte, 1500)
+	testutil.FatalIfErr(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := pc.ReadFrom(b)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "test.foo 3 1\n", string(b[:n]))
+
+	cancel()
+	wg.Wait()
+}
+
+func TestGraphitePushUDPDatagramSize(t *testing.T) {
+	*graphitePrefix = ""
+	*graphiteProtocol = "udp"
+	defer func() { *graphiteProtocol = "tcp" }()
+	*graphiteMaxDatagramSize = 40
+	defer func() { *graphiteMaxDatagramSize = 1432 }()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	m := metrics.NewMetric("foo", "test", metrics.Counter, metrics.Int, "code")
+	var want []string
+	for _, code := range []string{"200", "301", "404", "500"} {
+		d, _ := m.GetDatum(code)
+		datum.SetInt(d, 3, time.Unix(1, 0))
+		want = append(want, "test.foo.code."+code+" 3 1")
+	}
+	testutil.FatalIfErr(t, ms.Add(m))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	*graphiteHostPort = pc.LocalAddr().String()
+	o, err := graphitePushOptions()
+	*graphiteHostPort = ""
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(o)
+
+	// The lines of the metric are split between datagrams of at most the
+	// maximum size.
+	e.PushMetrics()
+	var got []string
+	b := make([]byte, 1500)
+	for len(got) < len(want) {
+		testutil.FatalIfErr(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := pc.ReadFrom(b)
+		testutil.FatalIfErr(t, err)
+		if n > *graphiteMaxDatagramSize {
+			t.Errorf("datagram of %d bytes, more than %d: %q", n, *graphiteMaxDatagramSize, b[:n])
+		}
+		got = append(got, strings.Split(strings.TrimSuffix(string(b[:n]), "\n"), "\n")...)
+	}
+	sort.Strings(got)
+	testutil.ExpectNoDiff(t, want, got)
+
+	cancel()
+	wg.Wait()
+}
+
+func TestGraphitePathPrefix(t *testing.T) {
+	defer func(p string) { *graphitePrefix = p }(*graphitePrefix)
+	*graphitePrefix = "infra.logs.{hostname}."
+	testutil.ExpectNoDiff(t, "infra.logs.web1_example_com.", graphitePathPrefix("web1.example.com"))
+	*graphitePrefix = "static."
+	testutil.ExpectNoDiff(t, "static.", graphitePathPrefix("web1.example.com"))
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package exporter
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/pkg/errors"
+)
+
+var (
+	influxdbURL = flag.String("influxdb_url", "",
+		"Base URL of an InfluxDB server to write metrics to, e.g. http://localhost:8086.")
+	influxdbDatabase = flag.String("influxdb_database", "mtail",
+		"InfluxDB database to write metrics to.")
+	influxdbPushInterval = flag.Duration("influxdb_push_interval", 0,
+		"Interval between pushes to InfluxDB.  If zero, --metric_push_interval is used.")
+
+	influxdbExportTotal   = expvar.NewInt("influxdb_export_total")
+	influxdbExportSuccess = expvar.NewInt("influxdb_export_success")
+)
+
+var (
+	influxdbMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxdbTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxdbStringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// influxdbWriteURL returns the URL of the write endpoint for database on the
+// InfluxDB server at base.
+func influxdbWriteURL(base, database string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing InfluxDB URL")
+	}
+	u.Path = path.Join(u.Path, "write")
+	q := u.Query()
+	q.Set("db", database)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// metricToInfluxdb encodes a metric in the InfluxDB line protocol, with the
+// metric's dimensions as tags and its value in the `value` field.  The metric
+// lock is held before entering this function.
+func metricToInfluxdb(_ string, m *metrics.Metric, l *metrics.LabelSet, _ time.Duration) string {
+	tags := map[string]string{"prog": m.Program}
+	for k, v := range l.Labels {
+		tags[k] = v
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(influxdbMeasurementEscaper.Replace(m.Name))
+	for _, k := range keys {
+		if tags[k] == "" {
+			// InfluxDB rejects empty tag values.
+			continue
+		}
+		fmt.Fprintf(&b, ",%s=%s", influxdbTagEscaper.Replace(k), influxdbTagEscaper.Replace(tags[k]))
+	}
+	b.WriteString(" ")
+	switch d := l.Datum.(type) {
+	case *datum.Int:
+		fmt.Fprintf(&b, "value=%di", d.Get())
+	case *datum.Buckets:
+		cum := datum.GetBucketsCumByMax(d)
+		maxes := make([]float64, 0, len(cum))
+		for max := range cum {
+			maxes = append(maxes, max)
+		}
+		sort.Float64s(maxes)
+		fmt.Fprintf(&b, "count=%di,sum=%s", d.GetCount(), d.ValueString())
+		for _, max := range maxes {
+			le := "inf"
+			if !math.IsInf(max, 1) {
+				le = fmt.Sprintf("%v", max)
+			}
+			fmt.Fprintf(&b, ",le_%s=%di", le, cum[max])
+		}
+	case *datum.String:
+		fmt.Fprintf(&b, `value="%s"`, influxdbStringEscaper.Replace(d.Get()))
+	default:
+		fmt.Fprintf(&b, "value=%s", l.Datum.ValueString())
+	}
+	fmt.Fprintf(&b, " %d\n", l.Datum.TimeUTC().UnixNano())
+	return b.String()
+}
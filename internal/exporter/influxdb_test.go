@@ -0,0 +1,135 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package exporter
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/google/mtail/internal/testutil"
+)
+
+var metricToInfluxdbTests = []struct {
+	name     string
+	metric   *metrics.Metric
+	expected string
+}{
+	{
+		"int",
+		&metrics.Metric{
+			Name:        "foo",
+			Program:     "test",
+			Kind:        metrics.Counter,
+			LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(1326867900, 750000000))}},
+		},
+		"foo,prog=test value=1i 1326867900750000000\n",
+	},
+	{
+		"float with dimensions",
+		&metrics.Metric{
+			Name:        "bar",
+			Program:     "test",
+			Kind:        metrics.Gauge,
+			Keys:        []string{"code", "user agent"},
+			LabelValues: []*metrics.LabelValue{{Labels: []string{"200", "curl,7"}, Value: datum.MakeFloat(0.25, time.Unix(2, 0))}},
+		},
+		"bar,code=200,prog=test,user\\ agent=curl\\,7 value=0.25 2000000000\n",
+	},
+	{
+		"histogram",
+		&metrics.Metric{
+			Name:        "latency",
+			Program:     "test",
+			Kind:        metrics.Histogram,
+			Type:        metrics.Buckets,
+			LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: makeOpentsdbBuckets(0.5, 2)}},
+		},
+		"latency,prog=test count=2i,sum=2.5,le_1=1i,le_inf=2i 4000000000\n",
+	},
+}
+
+func TestMetricToInfluxdb(t *testing.T) {
+	for _, tc := range metricToInfluxdbTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			lc := make(chan *metrics.LabelSet)
+			go tc.metric.EmitLabelSets(lc)
+			l := <-lc
+			testutil.ExpectNoDiff(t, tc.expected, metricToInfluxdb("gunstar", tc.metric, l, 0))
+		})
+	}
+}
+
+func TestInfluxdbWriteURL(t *testing.T) {
+	u, err := influxdbWriteURL("http://localhost:8086", "mtail")
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "http://localhost:8086/write?db=mtail&precision=ns", u)
+}
+
+func TestInfluxdbPush(t *testing.T) {
+	received := make(chan string, 1)
+	var status int32 = http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/write" || r.URL.Query().Get("db") != "mtail" {
+			t.Errorf("unexpected request URL %s", r.URL)
+		}
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("request body not gzipped")
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+		} else {
+			b, _ := io.ReadAll(zr)
+			received <- string(b)
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+		Name:        "foo",
+		Program:     "test",
+		Kind:        metrics.Counter,
+		LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(3, time.Unix(1, 0))}},
+	}))
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	u, err := influxdbWriteURL(srv.URL, "mtail")
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(pushOptions{"influxdb", "http", u, metricToInfluxdb, influxdbExportTotal, influxdbExportSuccess, 0, nil, nil})
+
+	e.PushMetrics()
+	testutil.ExpectNoDiff(t, "foo,prog=test value=3i 1000000000\n", <-received)
+
+	// A server error is counted, not fatal.
+	*pushRetries = 0
+	defer func() { *pushRetries = 3 }()
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	pushErrorsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "metric_push_errors_total", u, 1)
+	e.PushMetrics()
+	<-received
+	pushErrorsCheck()
+
+	// The final push after shutdown is still sent.
+	atomic.StoreInt32(&status, http.StatusNoContent)
+	cancel()
+	wg.Wait()
+	successCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "influxdb_export_success", 1)
+	e.PushMetrics()
+	testutil.ExpectNoDiff(t, "foo,prog=test value=3i 1000000000\n", <-received)
+	successCheck()
+}
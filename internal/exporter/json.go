@@ -9,13 +9,29 @@ import (
 	"net/http"
 
 	"github.com/golang/glog"
+	"github.com/google/mtail/internal/metrics"
 )
 
 var exportJSONErrors = expvar.NewInt("exporter_json_errors")
 
 // HandleJSON exports the metrics in JSON format via HTTP.
 func (e *Exporter) HandleJSON(w http.ResponseWriter, r *http.Request) {
-	b, err := json.MarshalIndent(e.store, "", "  ")
+	var v interface{} = e.store
+	if e.filters != nil || e.hostnameLabel != "" {
+		// The store's own encoding is kept for the metrics that are exported.
+		ms := metrics.NewStore()
+		for _, m := range e.store.Snapshot() {
+			if !e.exported("json", m) {
+				continue
+			}
+			if e.hostnameLabel != "" {
+				m = e.hostnameLabelled(m)
+			}
+			ms.Metrics[m.Name] = append(ms.Metrics[m.Name], m)
+		}
+		v = ms
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		exportJSONErrors.Add(1)
 		glog.Info("error marshalling metrics into json:", err.Error())
@@ -28,3 +44,33 @@ func (e *Exporter) HandleJSON(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// hostnameLabelled returns a copy of m with the hostname label added to each
+// of its LabelValues, so that the metric in the store is left unchanged.
+func (e *Exporter) hostnameLabelled(m *metrics.Metric) *metrics.Metric {
+	m.RLock()
+	defer m.RUnlock()
+	keys := m.Keys
+	for _, k := range m.Keys {
+		if k == e.hostnameLabel {
+			return m
+		}
+	}
+	keys = append(keys[:len(keys):len(keys)], e.hostnameLabel)
+	c := metrics.NewMetric(m.Name, m.Program, m.Kind, m.Type, keys...)
+	c.Hidden, c.Source, c.Buckets, c.Quantiles = m.Hidden, m.Source, m.Buckets, m.Quantiles
+	c.Limit, c.Expiry, c.Help, c.Window = m.Limit, m.Expiry, m.Help, m.Window
+	for _, lv := range m.LabelValues {
+		l := &metrics.LabelSet{Labels: make(map[string]string, len(keys))}
+		for i, k := range m.Keys {
+			l.Labels[k] = lv.Labels[i]
+		}
+		e.addHostnameLabel(l)
+		labels := make([]string, len(keys))
+		for i, k := range keys {
+			labels[i] = l.Labels[k]
+		}
+		c.LabelValues = append(c.LabelValues, &metrics.LabelValue{Labels: labels, Value: lv.Value, Expiry: lv.Expiry})
+	}
+	return c
+}
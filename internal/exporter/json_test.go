@@ -113,6 +113,12 @@ var handleJSONTests = []struct {
       "a",
       "b"
     ],
+    "Buckets": [
+      {
+        "Min": "0",
+        "Max": "+Inf"
+      }
+    ],
     "LabelValues": [
       {
         "Labels": [
@@ -124,11 +130,71 @@ var handleJSONTests = []struct {
           "Time": 0
         }
       }
+    ]
+  }
+]`,
+	},
+	{
+		"sorted",
+		[]*metrics.Metric{
+			{
+				Name:    "foo",
+				Program: "test",
+				Kind:    metrics.Counter,
+				Keys:    []string{"a"},
+				LabelValues: []*metrics.LabelValue{
+					{Labels: []string{"2"}, Value: datum.MakeInt(2, time.Unix(0, 0))},
+					{Labels: []string{"1"}, Value: datum.MakeInt(1, time.Unix(0, 0))},
+				},
+			},
+			{
+				Name:        "bar",
+				Program:     "test",
+				Kind:        metrics.Gauge,
+				LabelValues: []*metrics.LabelValue{{Value: datum.MakeInt(3, time.Unix(0, 0))}},
+			},
+		},
+		`[
+  {
+    "Name": "bar",
+    "Program": "test",
+    "Kind": 2,
+    "Type": 0,
+    "LabelValues": [
+      {
+        "Value": {
+          "Value": 3,
+          "Time": 0
+        }
+      }
+    ]
+  },
+  {
+    "Name": "foo",
+    "Program": "test",
+    "Kind": 1,
+    "Type": 0,
+    "Keys": [
+      "a"
     ],
-    "Buckets": [
+    "LabelValues": [
       {
-        "Min": "0",
-        "Max": "+Inf"
+        "Labels": [
+          "1"
+        ],
+        "Value": {
+          "Value": 1,
+          "Time": 0
+        }
+      },
+      {
+        "Labels": [
+          "2"
+        ],
+        "Value": {
+          "Value": 2,
+          "Time": 0
+        }
       }
     ]
   }
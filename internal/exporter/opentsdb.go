@@ -0,0 +1,108 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package exporter
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+)
+
+var (
+	opentsdbAddr = flag.String("opentsdb_addr", "",
+		"Host:port of an OpenTSDB server to write metrics to with the telnet protocol.")
+	opentsdbPushInterval = flag.Duration("opentsdb_push_interval", 0,
+		"Interval between pushes to OpenTSDB.  If zero, --metric_push_interval is used.")
+
+	opentsdbExportTotal   = expvar.NewInt("opentsdb_export_total")
+	opentsdbExportSuccess = expvar.NewInt("opentsdb_export_success")
+	// opentsdbSanitized counts the metric names, tag keys and tag values that
+	// had characters not allowed by OpenTSDB replaced.
+	opentsdbSanitized = expvar.NewInt("opentsdb_sanitized_total")
+)
+
+// metricToOpentsdb encodes a metric as OpenTSDB telnet protocol put
+// commands, with the metric's dimensions as tags.  The metric lock is held
+// before entering this function.
+func metricToOpentsdb(hostname string, m *metrics.Metric, l *metrics.LabelSet, _ time.Duration) string {
+	tags := map[string]string{"host": hostname, "prog": m.Program}
+	for k, v := range l.Labels {
+		tags[k] = v
+	}
+	name := sanitizeOpentsdb(m.Name)
+	ts := l.Datum.TimeString()
+	var b strings.Builder
+	if m.Kind == metrics.Histogram && m.Type == metrics.Buckets {
+		buckets := datum.GetBuckets(l.Datum)
+		cum := datum.GetBucketsCumByMax(l.Datum)
+		maxes := make([]float64, 0, len(cum))
+		for max := range cum {
+			maxes = append(maxes, max)
+		}
+		sort.Float64s(maxes)
+		for _, max := range maxes {
+			c := cum[max]
+			le := "inf"
+			if !math.IsInf(max, 1) {
+				le = fmt.Sprintf("%v", max)
+			}
+			bucketTags := map[string]string{"le": le}
+			for k, v := range tags {
+				bucketTags[k] = v
+			}
+			fmt.Fprintf(&b, "put %s.bucket %s %d%s\n", name, ts, c, formatOpentsdbTags(bucketTags))
+		}
+		fmt.Fprintf(&b, "put %s.count %s %d%s\n", name, ts, buckets.GetCount(), formatOpentsdbTags(tags))
+		fmt.Fprintf(&b, "put %s.sum %s %s%s\n", name, ts, l.Datum.ValueString(), formatOpentsdbTags(tags))
+		return b.String()
+	}
+	fmt.Fprintf(&b, "put %s %s %s%s\n", name, ts, l.Datum.ValueString(), formatOpentsdbTags(tags))
+	return b.String()
+}
+
+// formatOpentsdbTags returns the tags as space separated key=value pairs,
+// sorted by key, with a leading space.
+func formatOpentsdbTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%s", sanitizeOpentsdb(k), sanitizeOpentsdb(tags[k]))
+	}
+	return b.String()
+}
+
+// sanitizeOpentsdb replaces the characters in s that OpenTSDB doesn't allow
+// in metric names and tags with an underscore.  Letters, digits, and `-`,
+// `_`, `.` and `/` are allowed.  An empty string is replaced by a single
+// underscore, as OpenTSDB doesn't accept empty tag values either.
+func sanitizeOpentsdb(s string) string {
+	if s == "" {
+		opentsdbSanitized.Add(1)
+		return "_"
+	}
+	sanitized := false
+	r := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./", r) {
+			return r
+		}
+		sanitized = true
+		return '_'
+	}, s)
+	if sanitized {
+		opentsdbSanitized.Add(1)
+	}
+	return r
+}
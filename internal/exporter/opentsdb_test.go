@@ -0,0 +1,154 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package exporter
+
+import (
+	"bufio"
+	"context"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/google/mtail/internal/testutil"
+)
+
+var metricToOpentsdbTests = []struct {
+	name     string
+	metric   *metrics.Metric
+	expected string
+}{
+	{
+		"scalar",
+		&metrics.Metric{
+			Name:        "foo",
+			Program:     "test",
+			Kind:        metrics.Counter,
+			LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(1326867900, 0))}},
+		},
+		"put foo 1326867900 1 host=gunstar prog=test\n",
+	},
+	{
+		"dimensions",
+		&metrics.Metric{
+			Name:        "bar",
+			Program:     "test",
+			Kind:        metrics.Gauge,
+			Keys:        []string{"code", "path"},
+			LabelValues: []*metrics.LabelValue{{Labels: []string{"200", "/index.html"}, Value: datum.MakeFloat(0.25, time.Unix(2, 0))}},
+		},
+		"put bar 2 0.25 code=200 host=gunstar path=/index.html prog=test\n",
+	},
+	{
+		"sanitized",
+		&metrics.Metric{
+			Name:        "baz",
+			Program:     "test",
+			Kind:        metrics.Counter,
+			Keys:        []string{"user agent"},
+			LabelValues: []*metrics.LabelValue{{Labels: []string{"curl 7.0 (linux)"}, Value: datum.MakeInt(3, time.Unix(3, 0))}},
+		},
+		"put baz 3 3 host=gunstar prog=test user_agent=curl_7.0__linux_\n",
+	},
+	{
+		"histogram",
+		&metrics.Metric{
+			Name:        "latency",
+			Program:     "test",
+			Kind:        metrics.Histogram,
+			Type:        metrics.Buckets,
+			Buckets:     []datum.Range{{Min: 0, Max: 1}, {Min: 1, Max: math.Inf(1)}},
+			LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: makeOpentsdbBuckets(0.5, 2)}},
+		},
+		"put latency.bucket 4 1 host=gunstar le=1 prog=test\n" +
+			"put latency.bucket 4 2 host=gunstar le=inf prog=test\n" +
+			"put latency.count 4 2 host=gunstar prog=test\n" +
+			"put latency.sum 4 2.5 host=gunstar prog=test\n",
+	},
+}
+
+func makeOpentsdbBuckets(observations ...float64) datum.Datum {
+	d := datum.MakeBuckets([]datum.Range{{Min: 0, Max: 1}, {Min: 1, Max: math.Inf(1)}}, time.Unix(0, 0))
+	for _, v := range observations {
+		datum.Observe(d, v, time.Unix(4, 0))
+	}
+	return d
+}
+
+func TestMetricToOpentsdb(t *testing.T) {
+	for _, tc := range metricToOpentsdbTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			lc := make(chan *metrics.LabelSet)
+			go tc.metric.EmitLabelSets(lc)
+			l := <-lc
+			testutil.ExpectNoDiff(t, tc.expected, metricToOpentsdb("gunstar", tc.metric, l, 0))
+		})
+	}
+}
+
+func TestSanitizeOpentsdb(t *testing.T) {
+	sanitizedCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "opentsdb_sanitized_total", 1)
+	testutil.ExpectNoDiff(t, "a.b-c/d_e", sanitizeOpentsdb("a.b-c/d_e"))
+	testutil.ExpectNoDiff(t, "a_b", sanitizeOpentsdb("a:b"))
+	sanitizedCheck()
+}
+
+func TestOpentsdbPush(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.FatalIfErr(t, err)
+	defer ln.Close()
+	addr := ln.Addr().String()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		// The connection is kept open for the next push, so read the lines of this one.
+		r := bufio.NewReader(conn)
+		var b strings.Builder
+		for i := 0; i < 2; i++ {
+			line, _ := r.ReadString('\n')
+			b.WriteString(line)
+		}
+		received <- b.String()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	ms := metrics.NewStore()
+	for _, name := range []string{"foo", "bar"} {
+		testutil.FatalIfErr(t, ms.Add(&metrics.Metric{
+			Name:        name,
+			Program:     "test",
+			Kind:        metrics.Counter,
+			LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(3, time.Unix(1, 0))}},
+		}))
+	}
+	e, err := New(ctx, &wg, ms, Hostname("gunstar"))
+	testutil.FatalIfErr(t, err)
+	e.RegisterPushExport(pushOptions{"opentsdb", "tcp", addr, metricToOpentsdb, opentsdbExportTotal, opentsdbExportSuccess, 0, newBufferedWriter, nil})
+
+	e.PushMetrics()
+	got := <-received
+	for _, want := range []string{
+		"put foo 1 3 host=gunstar prog=test\n",
+		"put bar 1 3 host=gunstar prog=test\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("push %q doesn't contain %q", got, want)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
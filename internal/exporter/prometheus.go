@@ -5,9 +5,9 @@ package exporter
 
 import (
 	"expvar"
-	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/metrics"
@@ -16,10 +16,41 @@ import (
 	"github.com/prometheus/common/expfmt"
 )
 
-var metricExportTotal = expvar.NewInt("metric_export_total")
+var (
+	metricExportTotal = expvar.NewInt("metric_export_total")
+	// metricExportErrors counts the series that couldn't be exported to
+	// Prometheus, and were left out of the exposition.
+	metricExportErrors = expvar.NewInt("metric_export_errors_total")
+)
+
+// promName converts s into a valid Prometheus metric name, matching
+// `[a-zA-Z_:][a-zA-Z0-9_:]*`, by replacing invalid characters with
+// underscores.
+func promName(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '_' || r == ':' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z'):
+			b.WriteRune(r)
+		case '0' <= r && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
 
-func noHyphens(s string) string {
-	return strings.ReplaceAll(s, "-", "_")
+// promHelp returns the help text of the metric for Prometheus: the help given
+// in its declaration, else its name.
+func promHelp(m *metrics.Metric) string {
+	if m.Help != "" {
+		return m.Help
+	}
+	return m.Name
 }
 
 // Describe implements the prometheus.Collector interface.
@@ -30,10 +61,14 @@ func (e *Exporter) Describe(c chan<- *prometheus.Desc) {
 // Collect implements the prometheus.Collector interface.
 func (e *Exporter) Collect(c chan<- prometheus.Metric) {
 	lastMetric := ""
-	lastSource := ""
+	lastHelp := ""
+	now := time.Now()
 
 	/* #nosec G104 always retursn nil */
 	e.store.Range(func(m *metrics.Metric) error {
+		if !e.exported("prometheus", m) {
+			return nil
+		}
 		m.RLock()
 		// We don't have a way of converting text metrics to prometheus format.
 		if m.Kind == metrics.Text {
@@ -45,9 +80,14 @@ func (e *Exporter) Collect(c chan<- prometheus.Metric) {
 		lsc := make(chan *metrics.LabelSet)
 		go m.EmitLabelSets(lsc)
 		for ls := range lsc {
+			if e.stale(ls, now) {
+				continue
+			}
+			e.addHostnameLabel(ls)
 			if lastMetric != m.Name {
-				glog.V(2).Infof("setting source to %s", m.Source)
-				lastSource = m.Source
+				// Every metric of the same name must have the same help text.
+				lastHelp = promHelp(m)
+				glog.V(2).Infof("setting help to %s", lastHelp)
 				lastMetric = m.Name
 			}
 			var keys []string
@@ -58,29 +98,40 @@ func (e *Exporter) Collect(c chan<- prometheus.Metric) {
 			}
 			for k, v := range ls.Labels {
 				keys = append(keys, k)
-				vals = append(vals, v)
+				// Prometheus rejects label values that aren't UTF-8.  The
+				// exposition format escapes quotes, backslashes and newlines.
+				vals = append(vals, strings.ToValidUTF8(v, "\uFFFD"))
 			}
 			var pM prometheus.Metric
 			var err error
 			if m.Kind == metrics.Histogram {
 				pM, err = prometheus.NewConstHistogram(
-					prometheus.NewDesc(noHyphens(m.Name),
-						fmt.Sprintf("defined at %s", lastSource), keys, nil),
+					prometheus.NewDesc(promName(m.Name), lastHelp, keys, nil),
 					datum.GetBucketsCount(ls.Datum),
 					datum.GetBucketsSum(ls.Datum),
 					datum.GetBucketsCumByMax(ls.Datum),
 					vals...)
+			} else if m.Kind == metrics.Summary {
+				q := datum.GetQuantiles(ls.Datum)
+				pM, err = prometheus.NewConstSummary(
+					prometheus.NewDesc(promName(m.Name), lastHelp, keys, nil),
+					q.GetCount(),
+					q.GetSum(),
+					q.GetQuantiles(),
+					vals...)
 			} else {
 				pM, err = prometheus.NewConstMetric(
-					prometheus.NewDesc(noHyphens(m.Name),
-						fmt.Sprintf("defined at %s", lastSource), keys, nil),
-					promTypeForKind(m.Kind),
+					prometheus.NewDesc(promName(m.Name), lastHelp, keys, nil),
+					promTypeForKind(m.ExportKind()),
 					promValueForDatum(ls.Datum),
 					vals...)
 			}
 			if err != nil {
-				glog.Warning(err)
-				return nil
+				// Leave out just this series, so the rest of the metrics can
+				// still be scraped.
+				metricExportErrors.Add(1)
+				glog.Warningf("Not exporting %s of %s to Prometheus: %s", m.Name, m.Program, err)
+				continue
 			}
 			// By default no timestamp is emitted to Prometheus. Setting a
 			// timestamp is not recommended. It can lead to unexpected results
@@ -107,7 +158,8 @@ func (e *Exporter) Write(w io.Writer) error {
 	}
 	mfs, err := reg.Gather()
 	if err != nil {
-		return err
+		// The metric families that could be gathered are still written.
+		glog.Warningf("Error gathering metrics for Prometheus: %s", err)
 	}
 	enc := expfmt.NewEncoder(w, expfmt.FmtText)
 	for _, mf := range mfs {
@@ -18,6 +18,16 @@ import (
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
 )
 
+// makeSummary returns a summary datum estimating quantiles that has observed
+// values.
+func makeSummary(quantiles []float64, values ...float64) datum.Datum {
+	d := datum.MakeQuantiles(quantiles, time.Unix(0, 0))
+	for _, v := range values {
+		datum.Observe(d, v, time.Unix(0, 0))
+	}
+	return d
+}
+
 var handlePrometheusTests = []struct {
 	name      string
 	progLabel bool
@@ -41,9 +51,28 @@ var handlePrometheusTests = []struct {
 				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo counter
 foo{} 1
+`,
+	},
+	{
+		"rate",
+		false,
+		[]*metrics.Metric{
+			{
+				Name:        "foo",
+				Program:     "test",
+				Kind:        metrics.Counter,
+				Window:      time.Minute,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
+			},
+		},
+		// The total of 1 isn't exported, but its rate of 0 since the window
+		// started.
+		`# HELP foo foo
+# TYPE foo gauge
+foo{} 0
 `,
 	},
 	{
@@ -57,7 +86,7 @@ foo{} 1
 				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo counter
 foo{prog="test"} 1
 `,
@@ -74,9 +103,25 @@ foo{prog="test"} 1
 				LabelValues: []*metrics.LabelValue{{Labels: []string{"1", "2"}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo counter
 foo{a="1",b="2"} 1
+`,
+	},
+	{
+		"invalid name characters",
+		false,
+		[]*metrics.Metric{
+			{
+				Name:        "1st.request-count/ok",
+				Program:     "test",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
+			},
+		},
+		`# HELP _1st_request_count_ok 1st.request-count/ok
+# TYPE _1st_request_count_ok counter
+_1st_request_count_ok{} 1
 `,
 	},
 	{
@@ -90,7 +135,7 @@ foo{a="1",b="2"} 1
 				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo gauge
 foo{} 1
 `,
@@ -106,7 +151,7 @@ foo{} 1
 				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo gauge
 foo{} 1
 `,
@@ -136,7 +181,7 @@ foo{} 1
 				LabelValues: []*metrics.LabelValue{{Labels: []string{"str\"bang\"blah"}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo counter
 foo{a="str\"bang\"blah"} 1
 `,
@@ -153,7 +198,25 @@ foo{a="str\"bang\"blah"} 1
 				Source:      "location.mtail:37",
 			},
 		},
-		`# HELP foo defined at location.mtail:37
+		`# HELP foo foo
+# TYPE foo counter
+foo{} 1
+`,
+	},
+	{
+		"declared help",
+		false,
+		[]*metrics.Metric{
+			{
+				Name:        "foo",
+				Program:     "test",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
+				Source:      "location.mtail:37",
+				Help:        "Total foos seen",
+			},
+		},
+		`# HELP foo Total foos seen
 # TYPE foo counter
 foo{} 1
 `,
@@ -177,7 +240,7 @@ foo{} 1
 				Source:      "different.mtail:37",
 			},
 		},
-		`# HELP foo defined at location.mtail:37
+		`# HELP foo foo
 # TYPE foo counter
 foo{prog="test2"} 1
 foo{prog="test1"} 1
@@ -196,13 +259,34 @@ foo{prog="test1"} 1
 				Source:      "location.mtail:37",
 			},
 		},
-		`# HELP foo defined at location.mtail:37
+		`# HELP foo foo
 # TYPE foo histogram
 foo_bucket{a="bar",prog="test",le="1"} 0
 foo_bucket{a="bar",prog="test",le="2"} 0
 foo_bucket{a="bar",prog="test",le="+Inf"} 0
 foo_sum{a="bar",prog="test"} 0
 foo_count{a="bar",prog="test"} 0
+`,
+	},
+	{
+		"summary",
+		true,
+		[]*metrics.Metric{
+			{
+				Name:        "foo",
+				Program:     "test",
+				Kind:        metrics.Summary,
+				Keys:        []string{"a"},
+				LabelValues: []*metrics.LabelValue{{Labels: []string{"bar"}, Value: makeSummary([]float64{0.5, 0.9}, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)}},
+				Source:      "location.mtail:37",
+			},
+		},
+		`# HELP foo foo
+# TYPE foo summary
+foo{a="bar",prog="test",quantile="0.5"} 5
+foo{a="bar",prog="test",quantile="0.9"} 9
+foo_sum{a="bar",prog="test"} 55
+foo_count{a="bar",prog="test"} 10
 `,
 	},
 	{
@@ -240,7 +324,7 @@ foo_count{a="bar",prog="test"} 0
 				Source: "location.mtail:37",
 			},
 		},
-		`# HELP foo defined at location.mtail:37
+		`# HELP foo foo
 # TYPE foo histogram
 foo_bucket{a="bar",prog="test",le="1"} 1
 foo_bucket{a="bar",prog="test",le="2"} 2
@@ -299,7 +383,7 @@ var writePrometheusTests = []struct {
 				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP foo defined at 
+		`# HELP foo foo
 # TYPE foo counter
 foo 1
 `,
@@ -320,10 +404,83 @@ foo 1
 				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(2, time.Unix(0, 0))}},
 			},
 		},
-		`# HELP bar defined at 
+		`# HELP bar bar
+# TYPE bar counter
+bar 2
+# HELP foo foo
+# TYPE foo counter
+foo 1
+`,
+	},
+	{
+		"pathological label values",
+		[]*metrics.Metric{
+			{
+				Name:    "foo",
+				Program: "test",
+				Kind:    metrics.Counter,
+				Keys:    []string{"a"},
+				LabelValues: []*metrics.LabelValue{
+					{Labels: []string{"quote\"back\\slash\nnewline"}, Value: datum.MakeInt(1, time.Unix(0, 0))},
+					{Labels: []string{"bad\xffutf8"}, Value: datum.MakeInt(2, time.Unix(0, 0))},
+					{Labels: []string{"} 3\n# injected"}, Value: datum.MakeInt(3, time.Unix(0, 0))},
+				},
+			},
+		},
+		`# HELP foo foo
+# TYPE foo counter
+foo{a="bad�utf8"} 2
+foo{a="quote\"back\\slash\nnewline"} 1
+foo{a="} 3\n# injected"} 3
+`,
+	},
+	{
+		"invalid name skipped",
+		[]*metrics.Metric{
+			{
+				Name:        "",
+				Program:     "test",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
+			},
+			{
+				Name:        "bar",
+				Program:     "test",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(2, time.Unix(0, 0))}},
+			},
+		},
+		`# HELP bar bar
+# TYPE bar counter
+bar 2
+`,
+	},
+	{
+		"duplicate series don't fail the rest",
+		[]*metrics.Metric{
+			{
+				Name:        "foo",
+				Program:     "a",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
+			},
+			{
+				Name:        "foo",
+				Program:     "b",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(1, time.Unix(0, 0))}},
+			},
+			{
+				Name:        "bar",
+				Program:     "a",
+				Kind:        metrics.Counter,
+				LabelValues: []*metrics.LabelValue{{Labels: []string{}, Value: datum.MakeInt(2, time.Unix(0, 0))}},
+			},
+		},
+		`# HELP bar bar
 # TYPE bar counter
 bar 2
-# HELP foo defined at 
+# HELP foo foo
 # TYPE foo counter
 foo 1
 `,
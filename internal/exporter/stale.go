@@ -0,0 +1,85 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package exporter
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/metrics"
+)
+
+// staleCountInterval is how often the stale series in the store are counted.
+const staleCountInterval = 10 * time.Second
+
+// staleSeries holds the number of series in the store that are left out of
+// the exports because they haven't been updated for longer than -stale_after.
+var staleSeries = expvar.NewInt("metric_stale_series")
+
+// StaleAfter sets the Exporter to leave out the series of a metric that
+// haven't been updated for longer than d, until they are updated again.
+// Unlike an expiry set with `del ... after`, the series are kept in the store.
+func StaleAfter(d time.Duration) Option {
+	return func(e *Exporter) error {
+		e.staleAfter = d
+		return nil
+	}
+}
+
+// stale returns true if the series l was last updated more than the Exporter's
+// staleAfter before now.
+func (e *Exporter) stale(l *metrics.LabelSet, now time.Time) bool {
+	return e.staleAfter > 0 && e.staleUpdate(l.Updated, now)
+}
+
+// staleUpdate returns true if updated, the wall-clock time that a program
+// last updated a series, is more than the Exporter's staleAfter before now.
+// The datum's own timestamp isn't used, as it comes from the log, so a
+// series updated from an old log would be stale as soon as it's updated.  A
+// series that has never been updated isn't stale.
+func (e *Exporter) staleUpdate(updated time.Time, now time.Time) bool {
+	return !updated.IsZero() && now.Sub(updated) > e.staleAfter
+}
+
+// countStale returns the number of series in the store that are stale at now.
+func (e *Exporter) countStale(now time.Time) int64 {
+	var n int64
+	/* #nosec G104 always returns nil */
+	e.store.Range(func(m *metrics.Metric) error {
+		m.RLock()
+		defer m.RUnlock()
+		for _, lv := range m.LabelValues {
+			if e.staleUpdate(lv.Updated(), now) {
+				n++
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// startStaleCount counts the stale series in the store every
+// staleCountInterval, if the Exporter has a staleAfter.
+func (e *Exporter) startStaleCount() {
+	if e.staleAfter <= 0 {
+		return
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		<-e.initDone
+		glog.Infof("Leaving series not updated for %s out of the exports.", e.staleAfter)
+		ticker := time.NewTicker(staleCountInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				staleSeries.Set(e.countStale(time.Now()))
+			}
+		}
+	}()
+}
@@ -4,12 +4,19 @@
 package exporter
 
 import (
+	"bytes"
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -17,26 +24,182 @@ var (
 		"Host:port to statsd server to write metrics to.")
 	statsdPrefix = flag.String("statsd_prefix", "",
 		"Prefix to use for statsd metrics.")
+	statsdPushInterval = flag.Duration("statsd_push_interval", 0,
+		"Interval between pushes to statsd.  If zero, --metric_push_interval is used.")
+	statsdSampleRate = flag.Float64("statsd_sample_rate", 1,
+		"Sample rate to report for statsd counters, between 0 and 1.  Counter increments are sent unscaled with the rate, which the collector scales them up by.")
+	// The default fits a datagram in a typical ethernet MTU after IP and UDP headers.
+	statsdMaxDatagramSize = flag.Int("statsd_max_datagram_size", 1432,
+		"Largest UDP datagram to send to statsd, in bytes.  Metrics are packed into datagrams of up to this size.")
+	statsdProtocol = flag.String("statsd_protocol", "udp",
+		"Protocol to send metrics to statsd with, udp or tcp.")
 
 	statsdExportTotal   = expvar.NewInt("statsd_export_total")
 	statsdExportSuccess = expvar.NewInt("statsd_export_success")
 )
 
+// statsdEncoder encodes metrics in the statsd text protocol format.  StatsD
+// counters are increments, so the encoder remembers the increase last
+// delivered for each counter and sends the difference.
+type statsdEncoder struct {
+	mu      sync.Mutex
+	last    map[string]float64 // Last increase delivered, by metric path.
+	pending map[string]float64 // Increases encoded since the last push was formatted.
+}
+
+func newStatsdEncoder() *statsdEncoder {
+	return &statsdEncoder{last: make(map[string]float64), pending: make(map[string]float64)}
+}
+
+// formatted returns a func to call once the push just formatted has been
+// delivered, which remembers the values in it as sent.  If the push is
+// dropped, the next one sends the increments again along with its own.  Only
+// the counters in the push are remembered, so that the encoder forgets
+// deleted label values and the metrics of unloaded programs rather than keep
+// every counter it has ever sent.
+func (s *statsdEncoder) formatted() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	s.pending = make(map[string]float64, len(pending))
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.last = pending
+	}
+}
+
 // metricToStatsd encodes a metric in the statsd text protocol format.  The
-// metric lock is held before entering this function.
-func metricToStatsd(hostname string, m *metrics.Metric, l *metrics.LabelSet, _ time.Duration) string {
-	var t string
-	switch m.Kind {
+// metric lock is held before entering this function.  An empty string is
+// returned for counters that have not changed since the last push.
+func (s *statsdEncoder) metricToStatsd(hostname string, m *metrics.Metric, l *metrics.LabelSet, _ time.Duration) string {
+	path := fmt.Sprintf("%s%s.%s",
+		*statsdPrefix,
+		m.Program,
+		formatLabels(m.Name, l.Labels, ".", ".", "_"))
+	switch m.ExportKind() {
 	case metrics.Counter:
-		t = "c" // StatsD Counter
+		// The datum counts the counter's resets as it's set, so the
+		// increase is whole even if it was reset since the last push.
+		v := datum.GetIncrease(l.Datum)
+		s.mu.Lock()
+		last, ok := s.last[path]
+		s.pending[path] = v
+		s.mu.Unlock()
+		delta := v
+		if ok {
+			delta = v - last
+		}
+		if delta == 0 {
+			return ""
+		}
+		if *statsdSampleRate > 0 && *statsdSampleRate < 1 {
+			return fmt.Sprintf("%s:%s|c|@%s", path,
+				strconv.FormatFloat(delta, 'f', -1, 64),
+				strconv.FormatFloat(*statsdSampleRate, 'f', -1, 64))
+		}
+		return fmt.Sprintf("%s:%s|c", path, strconv.FormatFloat(delta, 'f', -1, 64))
 	case metrics.Gauge:
-		t = "g" // StatsD Gauge
+		return fmt.Sprintf("%s:%s|g", path, l.Datum.ValueString()) // StatsD Gauge
 	case metrics.Timer:
-		t = "ms" // StatsD Timer
+		return fmt.Sprintf("%s:%s|ms", path, l.Datum.ValueString()) // StatsD Timer
 	}
-	return fmt.Sprintf("%s%s.%s:%s|%s",
-		*statsdPrefix,
-		m.Program,
-		formatLabels(m.Name, l.Labels, ".", ".", "_"),
-		l.Datum.ValueString(), t)
+	return fmt.Sprintf("%s:%s|", path, l.Datum.ValueString())
+}
+
+// statsdPushOptions returns the push target for statsd, sent over
+// statsd_protocol.  Lines are packed into datagrams over UDP, and buffered on
+// a TCP connection, each ended by a newline.
+func statsdPushOptions() (pushOptions, error) {
+	s := newStatsdEncoder()
+	o := pushOptions{"statsd", "udp", *statsdHostPort, s.metricToStatsd, statsdExportTotal, statsdExportSuccess, *statsdPushInterval, newStatsdWriter, s.formatted}
+	switch *statsdProtocol {
+	case "udp":
+	case "tcp":
+		o.net = "tcp"
+		o.writer = func(c net.Conn) pushWriter {
+			return &lineWriter{newBufferedWriter(c)}
+		}
+	default:
+		return o, errors.Errorf("unknown statsd protocol %q, want udp or tcp", *statsdProtocol)
+	}
+	return o, nil
+}
+
+func newStatsdWriter(c net.Conn) pushWriter {
+	return &datagramWriter{w: c, size: *statsdMaxDatagramSize}
+}
+
+// datagramWriter packs lines written to it into datagrams of at most size
+// bytes, separated by newlines, unless the lines are already terminated by
+// them.  A write of several lines, such as the buckets of a histogram, is split
+// between datagrams on line boundaries.  Flush must be called to send the last
+// one.
+type datagramWriter struct {
+	w          io.Writer
+	size       int
+	terminated bool // If set, lines end in a newline, so none is added between them.
+	buf        []byte
+}
+
+func (d *datagramWriter) Write(p []byte) (int, error) {
+	var lines [][]byte
+	if d.terminated {
+		lines = bytes.SplitAfter(p, []byte{'\n'})
+	} else {
+		lines = bytes.Split(p, []byte{'\n'})
+	}
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if err := d.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeLine adds one line to the datagram, sending the datagram first if the
+// line doesn't fit in it.
+func (d *datagramWriter) writeLine(p []byte) error {
+	sep := 1
+	if d.terminated {
+		sep = 0
+	}
+	if len(d.buf) > 0 && len(d.buf)+sep+len(p) > d.size {
+		if err := d.Flush(); err != nil {
+			return err
+		}
+	}
+	if len(d.buf) > 0 && !d.terminated {
+		d.buf = append(d.buf, '\n')
+	}
+	d.buf = append(d.buf, p...)
+	return nil
+}
+
+// Flush sends any buffered lines.
+func (d *datagramWriter) Flush() error {
+	if len(d.buf) == 0 {
+		return nil
+	}
+	_, err := d.w.Write(d.buf)
+	d.buf = d.buf[:0]
+	return err
+}
+
+// lineWriter ends each line written to it with a newline, for sending statsd
+// lines over a stream.
+type lineWriter struct {
+	pushWriter
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n, err := l.pushWriter.Write(p)
+	if err != nil {
+		return n, err
+	}
+	_, err = l.pushWriter.Write([]byte{'\n'})
+	return n, err
 }
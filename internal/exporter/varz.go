@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/mtail/internal/metrics"
 )
@@ -21,17 +22,25 @@ const varzFormat = "%s{%s} %s\n"
 func (e *Exporter) HandleVarz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-type", "text/plain")
 
+	now := time.Now()
 	err := e.store.Range(func(m *metrics.Metric) error {
 		select {
 		case <-r.Context().Done():
 			return r.Context().Err()
 		default:
 		}
+		if !e.exported("varz", m) {
+			return nil
+		}
 		m.RLock()
 		exportVarzTotal.Add(1)
 		lc := make(chan *metrics.LabelSet)
 		go m.EmitLabelSets(lc)
 		for l := range lc {
+			if e.stale(l, now) {
+				continue
+			}
+			e.addHostnameLabel(l)
 			line := metricToVarz(m, l, e.omitProgLabel, e.hostname)
 			fmt.Fprint(w, line)
 		}
@@ -17,3 +17,22 @@ type LogLine struct {
 func New(ctx context.Context, filename string, line string) *LogLine {
 	return &LogLine{ctx, filename, line}
 }
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx carrying the structured fields of a log
+// line that were parsed out by its source, such as the severity of a syslog
+// message.
+func WithFields(ctx context.Context, fields map[string]string) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// Field returns the value of the structured field name of the line, or the
+// empty string if the line's source does not provide the field.
+func (l *LogLine) Field(name string) string {
+	if l.Context == nil {
+		return ""
+	}
+	fields, _ := l.Context.Value(fieldsKey{}).(map[string]string)
+	return fields[name]
+}
@@ -35,8 +35,9 @@ type Buckets struct {
 	Sum     float64
 }
 
+// ValueString returns the sum of the observations, formatted as Float's ValueString is.
 func (d *Buckets) ValueString() string {
-	return fmt.Sprintf("%g", d.GetSum())
+	return strconv.FormatFloat(d.GetSum(), 'f', -1, 64)
 }
 
 func (d *Buckets) Observe(v float64, ts time.Time) {
@@ -9,6 +9,8 @@ import (
 	"sort"
 	"sync/atomic"
 	"time"
+
+	"github.com/beorn7/perks/quantile"
 )
 
 // Datum is an interface for metric datums, with a type, value and timestamp to be exported.
@@ -71,6 +73,11 @@ func NewBuckets(buckets []Range) Datum {
 	return MakeBuckets(buckets, zeroTime)
 }
 
+// NewQuantiles creates a new quantiles datum with no observations.
+func NewQuantiles(quantiles []float64) Datum {
+	return MakeQuantiles(quantiles, zeroTime)
+}
+
 // MakeInt creates a new integer datum with the provided value and timestamp.
 func MakeInt(v int64, ts time.Time) Datum {
 	d := &Int{}
@@ -112,6 +119,18 @@ func MakeBuckets(buckets []Range, ts time.Time) Datum {
 	return d
 }
 
+// MakeQuantiles creates a new quantiles datum estimating the provided
+// quantiles, each between 0 and 1, and timestamp.
+func MakeQuantiles(quantiles []float64, ts time.Time) Datum {
+	targets := make(map[float64]float64, len(quantiles))
+	for _, q := range quantiles {
+		targets[q] = quantileError(q)
+	}
+	d := &Quantiles{Quantiles: quantiles, stream: quantile.NewTargeted(targets)}
+	d.stamp(ts)
+	return d
+}
+
 // GetInt returns the integer value of a datum, or error.
 func GetInt(d Datum) int64 {
 	switch d := d.(type) {
@@ -132,6 +151,19 @@ func GetFloat(d Datum) float64 {
 	}
 }
 
+// GetIncrease returns the sum of the increases in the value of a counter
+// datum since it was created, or panics if the Datum is not an Int or Float.
+func GetIncrease(d Datum) float64 {
+	switch d := d.(type) {
+	case *Int:
+		return float64(d.Increase())
+	case *Float:
+		return d.Increase()
+	default:
+		panic(fmt.Sprintf("datum %v is not an Int or Float", d))
+	}
+}
+
 // GetString returns the string of a datum, or error.
 func GetString(d Datum) string {
 	switch d := d.(type) {
@@ -149,6 +181,8 @@ func SetInt(d Datum, v int64, ts time.Time) {
 		d.Set(v, ts)
 	case *Buckets:
 		d.Observe(float64(v), ts)
+	case *Quantiles:
+		d.Observe(float64(v), ts)
 	default:
 		panic(fmt.Sprintf("datum %v is not an Int", d))
 	}
@@ -161,6 +195,8 @@ func SetFloat(d Datum, v float64, ts time.Time) {
 		d.Set(v, ts)
 	case *Buckets:
 		d.Observe(v, ts)
+	case *Quantiles:
+		d.Observe(v, ts)
 	default:
 		panic(fmt.Sprintf("datum %v is not a Float", d))
 	}
@@ -176,21 +212,25 @@ func SetString(d Datum, v string, ts time.Time) {
 	}
 }
 
-// IncIntBy increments an integer Datum by the provided value, at time ts, or panics if the Datum is not an IntDatum.
+// IncIntBy increments an integer or floating-point Datum by the provided value, at time ts, or panics if the Datum is neither.
 func IncIntBy(d Datum, v int64, ts time.Time) {
 	switch d := d.(type) {
 	case *Int:
 		d.IncBy(v, ts)
+	case *Float:
+		d.IncBy(float64(v), ts)
 	default:
 		panic(fmt.Sprintf("datum %v is not an Int", d))
 	}
 }
 
-// DecIntBy increments an integer Datum by the provided value, at time ts, or panics if the Datum is not an IntDatum.
+// DecIntBy decrements an integer or floating-point Datum by the provided value, at time ts, or panics if the Datum is neither.
 func DecIntBy(d Datum, v int64, ts time.Time) {
 	switch d := d.(type) {
 	case *Int:
 		d.DecBy(v, ts)
+	case *Float:
+		d.IncBy(-float64(v), ts)
 	default:
 		panic(fmt.Sprintf("datum %v is not an Int", d))
 	}
@@ -205,11 +245,23 @@ func GetBuckets(d Datum) *Buckets {
 	}
 }
 
-// Observe records an observation v at time ts in d, or panics if d is not a BucketsDatum.
+// GetQuantiles returns d as a Quantiles, or panics if d is not a Quantiles.
+func GetQuantiles(d Datum) *Quantiles {
+	switch d := d.(type) {
+	case *Quantiles:
+		return d
+	default:
+		panic(fmt.Sprintf("datum %v is not a Quantiles", d))
+	}
+}
+
+// Observe records an observation v at time ts in d, or panics if d is not a BucketsDatum or QuantilesDatum.
 func Observe(d Datum, v float64, ts time.Time) {
 	switch d := d.(type) {
 	case *Buckets:
 		d.Observe(v, ts)
+	case *Quantiles:
+		d.Observe(v, ts)
 	default:
 		panic(fmt.Sprintf("datum %v is not a Buckets", d))
 	}
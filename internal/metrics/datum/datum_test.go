@@ -34,6 +34,33 @@ func TestDatumSetAndValue(t *testing.T) {
 	}
 }
 
+func TestFloatValueStringNoExponent(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		expected string
+	}{
+		{1000002.25, "1000002.25"},
+		{1e21, "1000000000000000000000"},
+		{1e-7, "0.0000001"},
+	} {
+		d := MakeFloat(tc.value, time.Unix(0, 0))
+		if r := d.ValueString(); r != tc.expected {
+			t.Errorf("%v value string: got %q, want %q", tc.value, r, tc.expected)
+		}
+	}
+}
+
+func TestIncFloat(t *testing.T) {
+	d := MakeFloat(0.25, time.Unix(0, 0))
+	IncIntBy(d, 2, time.Unix(1, 0))
+	if r := GetFloat(d); r != 2.25 {
+		t.Errorf("d didn't return 2.25, got %v", r)
+	}
+	if r := d.TimeString(); r != "1" {
+		t.Errorf("d Time not correct, got %v", r)
+	}
+}
+
 var datumJSONTests = []struct {
 	datum    Datum
 	expected string
@@ -5,8 +5,8 @@ package datum
 
 import (
 	"encoding/json"
-	"fmt"
 	"math"
+	"strconv"
 	"sync/atomic"
 	"time"
 )
@@ -14,25 +14,60 @@ import (
 // Float describes a floating point value at a given timestamp.
 type Float struct {
 	BaseDatum
-	Valuebits uint64
+	Valuebits    uint64
+	increasebits uint64 // Sum of the increases in the value, see Increase.
 }
 
-// ValueString returns the value of the Float as a string.
+// ValueString returns the value of the Float as a string.  The value is
+// written in decimal without an exponent, as some collectors can't parse
+// scientific notation, and with as many digits as are needed to be read back
+// exactly.
 func (d *Float) ValueString() string {
-	return fmt.Sprintf("%g", d.Get())
+	return strconv.FormatFloat(d.Get(), 'f', -1, 64)
 }
 
 // Set sets value of the Float at the timestamp ts.
 func (d *Float) Set(v float64, ts time.Time) {
-	atomic.StoreUint64(&d.Valuebits, math.Float64bits(v))
+	old := math.Float64frombits(atomic.SwapUint64(&d.Valuebits, math.Float64bits(v)))
+	if v >= old {
+		addFloat(&d.increasebits, v-old)
+	} else if v > 0 {
+		// A counter set to a smaller value was reset, so all of it is new.
+		addFloat(&d.increasebits, v)
+	}
 	d.stamp(ts)
 }
 
+// IncBy increments the value of the Float by delta at the timestamp ts.
+func (d *Float) IncBy(delta float64, ts time.Time) {
+	addFloat(&d.Valuebits, delta)
+	if delta > 0 {
+		addFloat(&d.increasebits, delta)
+	}
+	d.stamp(ts)
+}
+
+// addFloat atomically adds delta to the float64 whose bits are at addr.
+func addFloat(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			break
+		}
+	}
+}
+
 // Get returns the floating-point value.
 func (d *Float) Get() float64 {
 	return math.Float64frombits(atomic.LoadUint64(&d.Valuebits))
 }
 
+// Increase returns the sum of the increases in the Float's value since it was
+// created, counting a set to a smaller value as a reset to zero before it.
+func (d *Float) Increase() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&d.increasebits))
+}
+
 // MarshalJSON returns a JSON encoding of the Float.
 func (d *Float) MarshalJSON() ([]byte, error) {
 	j := struct {
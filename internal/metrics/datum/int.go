@@ -13,18 +13,28 @@ import (
 // Int describes an integer value at a given timestamp.
 type Int struct {
 	BaseDatum
-	Value int64
+	Value    int64
+	increase int64 // Sum of the increases in Value, see Increase.
 }
 
 // Set sets the value of the Int to the value at timestamp.
 func (d *Int) Set(value int64, timestamp time.Time) {
-	atomic.StoreInt64(&d.Value, value)
+	old := atomic.SwapInt64(&d.Value, value)
+	if value >= old {
+		atomic.AddInt64(&d.increase, value-old)
+	} else if value > 0 {
+		// A counter set to a smaller value was reset, so all of it is new.
+		atomic.AddInt64(&d.increase, value)
+	}
 	d.stamp(timestamp)
 }
 
 // IncBy increments the Int's value by the value provided, at timestamp.
 func (d *Int) IncBy(delta int64, timestamp time.Time) {
 	atomic.AddInt64(&d.Value, delta)
+	if delta > 0 {
+		atomic.AddInt64(&d.increase, delta)
+	}
 	d.stamp(timestamp)
 }
 
@@ -39,6 +49,12 @@ func (d *Int) Get() int64 {
 	return atomic.LoadInt64(&d.Value)
 }
 
+// Increase returns the sum of the increases in the Int's value since it was
+// created, counting a set to a smaller value as a reset to zero before it.
+func (d *Int) Increase() int64 {
+	return atomic.LoadInt64(&d.increase)
+}
+
 // ValueString returns the value of the Int as a string.
 func (d *Int) ValueString() string {
 	return fmt.Sprintf("%d", atomic.LoadInt64(&d.Value))
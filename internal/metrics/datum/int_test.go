@@ -38,3 +38,16 @@ func TestDecrementScalarInt(t *testing.T) {
 		t.Errorf("expected 0, got %d", r)
 	}
 }
+
+func TestIntIncreaseCountsResets(t *testing.T) {
+	d := &Int{}
+	ts := time.Now().UTC()
+	d.Set(100, ts)
+	// Set to a smaller value, the counter was reset, so 3 is also an increase.
+	d.Set(3, ts)
+	d.Set(150, ts)
+	d.IncBy(2, ts)
+	if r := d.Increase(); r != 252 {
+		t.Errorf("expected 252, got %d", r)
+	}
+}
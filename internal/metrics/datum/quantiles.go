@@ -0,0 +1,106 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package datum
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/beorn7/perks/quantile"
+)
+
+// Quantiles describes the estimated quantiles of a stream of floating point
+// observations, and their count and sum, at a given timestamp.  The quantiles
+// are estimated with the CKMS algorithm, so the observations themselves
+// needn't be kept.
+type Quantiles struct {
+	BaseDatum
+	sync.Mutex
+	Quantiles []float64 // The quantiles to estimate, in increasing order.
+	Count     uint64
+	Sum       float64
+
+	stream *quantile.Stream
+}
+
+// quantileError returns the error allowed in the estimate of quantile q,
+// which is tighter the closer q is to 0 or 1.
+func quantileError(q float64) float64 {
+	return math.Min(q, 1-q) / 10
+}
+
+// ValueString returns the sum of the observations, formatted as Float's ValueString is.
+func (d *Quantiles) ValueString() string {
+	return strconv.FormatFloat(d.GetSum(), 'f', -1, 64)
+}
+
+func (d *Quantiles) Observe(v float64, ts time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.stream.Insert(v)
+	d.Count++
+	d.Sum += v
+
+	d.stamp(ts)
+}
+
+func (d *Quantiles) GetCount() uint64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.Count
+}
+
+func (d *Quantiles) GetSum() float64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.Sum
+}
+
+// GetQuantiles returns the estimated value of each quantile.  The values are
+// NaN if there have been no observations.
+func (d *Quantiles) GetQuantiles() map[float64]float64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.quantiles()
+}
+
+func (d *Quantiles) quantiles() map[float64]float64 {
+	qs := make(map[float64]float64, len(d.Quantiles))
+	for _, q := range d.Quantiles {
+		if d.Count == 0 {
+			qs[q] = math.NaN()
+		} else {
+			qs[q] = d.stream.Query(q)
+		}
+	}
+	return qs
+}
+
+func (d *Quantiles) MarshalJSON() ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	qs := make(map[string]float64)
+	for q, v := range d.quantiles() {
+		if math.IsNaN(v) {
+			// JSON has no NaN, so quantiles without an estimate are left out.
+			continue
+		}
+		qs[strconv.FormatFloat(q, 'g', -1, 64)] = v
+	}
+
+	j := struct {
+		Quantiles map[string]float64
+		Count     uint64
+		Sum       float64
+		Time      int64
+	}{qs, d.Count, d.Sum, atomic.LoadInt64(&d.Time)}
+
+	return json.Marshal(j)
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package datum_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestMakeQuantiles(t *testing.T) {
+	d := datum.MakeQuantiles([]float64{0.5, 0.9, 0.99}, time.Unix(37, 42))
+	q := datum.GetQuantiles(d)
+	for _, v := range q.GetQuantiles() {
+		if !math.IsNaN(v) {
+			t.Errorf("quantile with no observations not NaN, got %v", v)
+		}
+	}
+
+	ts := time.Unix(37, 31)
+	for i := 1; i <= 1000; i++ {
+		datum.SetInt(d, int64(i), ts)
+	}
+	if r := q.GetCount(); r != 1000 {
+		t.Errorf("count not 1000, got %v", r)
+	}
+	if r := q.GetSum(); r != 500500 {
+		t.Errorf("sum not 500500, got %v", r)
+	}
+	for quantile, v := range q.GetQuantiles() {
+		// Each estimate is within the allowed error of its exact rank.
+		allowed := math.Min(quantile, 1-quantile) / 10 * 1000
+		if math.Abs(v-quantile*1000) > allowed+1 {
+			t.Errorf("quantile %v estimated as %v, more than %v from %v", quantile, v, allowed, quantile*1000)
+		}
+	}
+	testutil.ExpectNoDiff(t, time.Unix(37, 31), d.TimeUTC())
+}
+
+func TestQuantilesMarshalJSON(t *testing.T) {
+	d := datum.MakeQuantiles([]float64{0.5}, time.Unix(1, 0))
+	b, err := json.Marshal(d)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, `{"Quantiles":{},"Count":0,"Sum":0,"Time":1000000000}`, string(b))
+
+	datum.SetFloat(d, 0.25, time.Unix(2, 0))
+	b, err = json.Marshal(d)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, `{"Quantiles":{"0.5":0.25},"Count":1,"Sum":0.25,"Time":2000000000}`, string(b))
+}
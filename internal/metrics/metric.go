@@ -7,11 +7,14 @@ package metrics
 
 import (
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"math/rand"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -19,6 +22,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// cardinalityLimitHits counts the new label values that caused a metric over
+// its limit to evict its oldest label value.
+var cardinalityLimitHits = expvar.NewInt("cardinality_limit_hits_total")
+
 // Kind enumerates the types of metrics supported.
 type Kind int
 
@@ -44,6 +51,10 @@ const (
 	// in a bucket.
 	Histogram
 
+	// Summary is a Kind that observes a value and estimates quantiles of
+	// the values observed.
+	Summary
+
 	endKind // end of enumeration for testing
 )
 
@@ -59,6 +70,8 @@ func (m Kind) String() string {
 		return "Text"
 	case Histogram:
 		return "Histogram"
+	case Summary:
+		return "Summary"
 	}
 	return "Unknown"
 }
@@ -75,6 +88,25 @@ type LabelValue struct {
 	Value  datum.Datum
 	// After this time of inactivity, the LabelValue is removed from the metric.
 	Expiry time.Duration `json:",omitempty"`
+	// updated is the wall-clock time in nanoseconds since the epoch that a
+	// program last updated Value, or zero if none has.  Unlike the datum's
+	// timestamp, it isn't taken from the log.
+	updated int64
+}
+
+// Touch records that a program updated the LabelValue's Value at now.
+func (lv *LabelValue) Touch(now time.Time) {
+	atomic.StoreInt64(&lv.updated, now.UnixNano())
+}
+
+// Updated returns the wall-clock time that a program last updated the
+// LabelValue's Value, or the zero time if none has.
+func (lv *LabelValue) Updated() time.Time {
+	n := atomic.LoadInt64(&lv.updated)
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
 }
 
 // Metric is an object that describes a metric, with its name, the creator and
@@ -93,7 +125,36 @@ type Metric struct {
 	labelValuesMap map[string]*LabelValue
 	Source         string        `json:",omitempty"`
 	Buckets        []datum.Range `json:",omitempty"`
+	Quantiles      []float64     `json:",omitempty"` // Quantiles estimated by a summary.
 	Limit          int           `json:",omitempty"`
+	// Expiry is the default Expiry of each new LabelValue.
+	Expiry time.Duration `json:",omitempty"`
+	Help   string        `json:",omitempty"` // Description of the metric given in its declaration.
+	// Window is the period over which the rate of a counter is exported,
+	// instead of its total, if not zero.
+	Window time.Duration `json:",omitempty"`
+
+	windows map[*LabelValue]*rateWindow // Samples of each LabelValue for its rate.
+}
+
+// MarshalJSON returns a JSON representation of the Metric, taken while
+// holding its lock so that it is consistent.  The LabelValues are ordered by
+// their labels so that the output is deterministic.
+func (m *Metric) MarshalJSON() ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	lvs := make([]*LabelValue, len(m.LabelValues))
+	copy(lvs, m.LabelValues)
+	sort.Slice(lvs, func(i, j int) bool {
+		return buildLabelValueKey(lvs[i].Labels) < buildLabelValueKey(lvs[j].Labels)
+	})
+	// alias has the fields of Metric but not this method, so it marshals in
+	// the default way, with the sorted LabelValues shadowing its own.
+	type alias Metric
+	return json.Marshal(struct {
+		*alias
+		LabelValues []*LabelValue `json:",omitempty"`
+	}{(*alias)(m), lvs})
 }
 
 // NewMetric returns a new empty metric of dimension len(keys).
@@ -149,15 +210,32 @@ func (m *Metric) FindLabelValueOrNil(labelvalues []string) *LabelValue {
 // GetDatum returns the datum named by a sequence of string label values from a
 // Metric.  If the sequence of label values does not yet exist, it is created.
 func (m *Metric) GetDatum(labelvalues ...string) (d datum.Datum, err error) {
+	lv, err := m.GetLabelValue(labelvalues...)
+	if err != nil {
+		return nil, err
+	}
+	return lv.Value, nil
+}
+
+// GetLabelValue returns the LabelValue named by a sequence of string label
+// values from a Metric, creating it as GetDatum does if it doesn't yet exist.
+func (m *Metric) GetLabelValue(labelvalues ...string) (*LabelValue, error) {
 	if len(labelvalues) != len(m.Keys) {
 		return nil, errors.Errorf("Label values requested (%q) not same length as keys for metric %v", labelvalues, m)
 	}
 	m.Lock()
 	defer m.Unlock()
-	if lv := m.FindLabelValueOrNil(labelvalues); lv != nil {
-		d = lv.Value
-	} else {
-		// TODO Check m.Limit and expire old data
+	lv := m.FindLabelValueOrNil(labelvalues)
+	if lv == nil {
+		var d datum.Datum
+		if m.Limit > 0 && len(m.LabelValues) >= m.Limit {
+			// Make room for the new label values by evicting the least
+			// recently updated.
+			cardinalityLimitHits.Add(1)
+			for len(m.LabelValues) >= m.Limit {
+				m.removeOldestDatum()
+			}
+		}
 		switch m.Type {
 		case Int:
 			d = datum.NewInt()
@@ -171,17 +249,33 @@ func (m *Metric) GetDatum(labelvalues ...string) (d datum.Datum, err error) {
 				buckets = make([]datum.Range, 0)
 			}
 			d = datum.NewBuckets(buckets)
+		case Quantiles:
+			d = datum.NewQuantiles(m.Quantiles)
+		}
+		lv = &LabelValue{Labels: labelvalues, Value: d, Expiry: m.Expiry}
+		if m.Window >= time.Second {
+			if m.windows == nil {
+				m.windows = make(map[*LabelValue]*rateWindow)
+			}
+			m.windows[lv] = newRateWindow(m.Window, 0)
 		}
-		lv := &LabelValue{Labels: labelvalues, Value: d}
 		if err := m.AppendLabelValue(lv); err != nil {
 			return nil, err
 		}
 	}
-	return d, nil
+	return lv, nil
 }
 
 // RemoveOldestDatum scans the Metric's LabelValues for the Datum with the oldest timestamp, and removes it.
 func (m *Metric) RemoveOldestDatum() {
+	m.Lock()
+	defer m.Unlock()
+	m.removeOldestDatum()
+}
+
+// removeOldestDatum removes the Datum with the oldest timestamp.  The caller
+// must hold the metric's lock.
+func (m *Metric) removeOldestDatum() {
 	var oldestLV *LabelValue
 	for _, lv := range m.LabelValues {
 		if oldestLV == nil || lv.Value.TimeUTC().Before(oldestLV.Value.TimeUTC()) {
@@ -190,10 +284,7 @@ func (m *Metric) RemoveOldestDatum() {
 	}
 	if oldestLV != nil {
 		glog.V(1).Infof("removeOldest: removing oldest LV: %v", oldestLV)
-		err := m.RemoveDatum(oldestLV.Labels...)
-		if err != nil {
-			glog.Warning(err)
-		}
+		m.removeDatum(oldestLV.Labels)
 	}
 }
 
@@ -204,6 +295,13 @@ func (m *Metric) RemoveDatum(labelvalues ...string) error {
 	}
 	m.Lock()
 	defer m.Unlock()
+	m.removeDatum(labelvalues)
+	return nil
+}
+
+// removeDatum removes the Datum described by labelvalues.  The caller must
+// hold the metric's lock.
+func (m *Metric) removeDatum(labelvalues []string) {
 	k := buildLabelValueKey(labelvalues)
 	olv, ok := m.labelValuesMap[k]
 	if ok {
@@ -217,7 +315,14 @@ func (m *Metric) RemoveDatum(labelvalues ...string) error {
 			}
 		}
 	}
-	return nil
+}
+
+// RemoveAllDatums removes every Datum from the Metric m.
+func (m *Metric) RemoveAllDatums() {
+	m.Lock()
+	defer m.Unlock()
+	m.LabelValues = make([]*LabelValue, 0)
+	m.labelValuesMap = make(map[string]*LabelValue)
 }
 
 func (m *Metric) ExpireDatum(expiry time.Duration, labelvalues ...string) error {
@@ -236,8 +341,9 @@ func (m *Metric) ExpireDatum(expiry time.Duration, labelvalues ...string) error
 // LabelSet is an object that maps the keys of a Metric to the labels naming a
 // Datum, for use when enumerating Datums from a Metric.
 type LabelSet struct {
-	Labels map[string]string
-	Datum  datum.Datum
+	Labels  map[string]string
+	Datum   datum.Datum
+	Updated time.Time // When a program last updated the Datum, from LabelValue.Updated.
 }
 
 func zip(keys []string, values []string) map[string]string {
@@ -250,10 +356,15 @@ func zip(keys []string, values []string) map[string]string {
 
 // EmitLabelSets enumerates the LabelSets corresponding to the LabelValues of a
 // Metric.  It emits them onto the provided channel, then closes the channel to
-// signal completion.
+// signal completion.  The Datum of each LabelSet of a Metric with a Window is
+// the Float rate of the LabelValue over the window.
 func (m *Metric) EmitLabelSets(c chan *LabelSet) {
 	for _, lv := range m.LabelValues {
-		ls := &LabelSet{zip(m.Keys, lv.Labels), lv.Value}
+		d := lv.Value
+		if m.Window >= time.Second {
+			d = datum.MakeFloat(m.rate(lv), lv.Value.TimeUTC())
+		}
+		ls := &LabelSet{zip(m.Keys, lv.Labels), d, lv.Updated()}
 		c <- ls
 	}
 	close(c)
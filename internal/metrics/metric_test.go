@@ -155,7 +155,7 @@ func TestAppendLabelValue(t *testing.T) {
 	if err != nil {
 		t.Errorf("Bad datum %v: %v\n", d1, err)
 	}
-	testutil.ExpectNoDiff(t, d0, d1)
+	testutil.ExpectNoDiff(t, d0, d1, testutil.IgnoreUnexported(datum.Int{}))
 }
 
 func timeGenerator(rand *rand.Rand) time.Time {
@@ -207,7 +207,7 @@ func TestMetricJSONRoundTrip(t *testing.T) {
 			return false
 		}
 
-		return testutil.ExpectNoDiff(t, m, r, testutil.IgnoreUnexported(sync.RWMutex{}, Metric{}))
+		return testutil.ExpectNoDiff(t, m, r, testutil.IgnoreUnexported(sync.RWMutex{}, Metric{}, LabelValue{}, datum.Int{}, datum.Float{}))
 	}
 	if err := quick.Check(f, nil); err != nil {
 		t.Error(err)
@@ -217,7 +217,7 @@ func TestMetricJSONRoundTrip(t *testing.T) {
 func TestTimer(t *testing.T) {
 	m := NewMetric("test", "prog", Timer, Int)
 	n := NewMetric("test", "prog", Timer, Int)
-	testutil.ExpectNoDiff(t, m, n, testutil.IgnoreUnexported(sync.RWMutex{}, Metric{}))
+	testutil.ExpectNoDiff(t, m, n, testutil.IgnoreUnexported(sync.RWMutex{}, Metric{}, LabelValue{}, datum.Int{}, datum.Float{}))
 	d, _ := m.GetDatum()
 	datum.IncIntBy(d, 1, time.Now().UTC())
 	lv := m.FindLabelValueOrNil([]string{})
@@ -233,6 +233,30 @@ func TestTimer(t *testing.T) {
 	}
 }
 
+func TestGetDatumOverLimitEvictsOldest(t *testing.T) {
+	m := NewMetric("test", "prog", Counter, Int, "path")
+	m.Limit = 2
+	for i, path := range []string{"/a", "/b"} {
+		d, err := m.GetDatum(path)
+		testutil.FatalIfErr(t, err)
+		datum.SetInt(d, 1, time.Unix(int64(2-i), 0))
+	}
+	limitHitsCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "cardinality_limit_hits_total", 1)
+	_, err := m.GetDatum("/c")
+	testutil.FatalIfErr(t, err)
+	limitHitsCheck()
+	if len(m.LabelValues) != 2 {
+		t.Errorf("Expected 2 labelvalues got %#v", m.LabelValues)
+	}
+	// "/b" was updated least recently, so is evicted.
+	if x := m.FindLabelValueOrNil([]string{"/b"}); x != nil {
+		t.Errorf("found label /b which is unexpected: %#v", x)
+	}
+	if x := m.FindLabelValueOrNil([]string{"/a"}); x == nil {
+		t.Errorf("label /a not found")
+	}
+}
+
 func TestRemoveMetricLabelValue(t *testing.T) {
 	m := NewMetric("test", "prog", Counter, Int, "a", "b", "c")
 	_, e := m.GetDatum("a", "a", "a")
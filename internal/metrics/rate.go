@@ -0,0 +1,114 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/mtail/internal/metrics/datum"
+)
+
+// rateWindow is a ring of the totals of a LabelValue sampled each second,
+// from which the rate over the Metric's Window is computed.  The total is the
+// sum of the increases in the LabelValue, so the difference between adjacent
+// samples is the count in that second, even if the counter was reset in it.
+type rateWindow struct {
+	samples []float64
+	next    int // Index of the oldest sample, to be replaced by the next.
+}
+
+// newRateWindow returns a window of the seconds in window, filled with total.
+// The oldest sample is from the start of the window, so there is one more
+// sample than there are seconds.
+func newRateWindow(window time.Duration, total float64) *rateWindow {
+	w := &rateWindow{samples: make([]float64, int(window/time.Second)+1)}
+	for i := range w.samples {
+		w.samples[i] = total
+	}
+	return w
+}
+
+// datumTotal returns the sum of the increases in a counter datum as a float.
+func datumTotal(d datum.Datum) float64 {
+	switch d := d.(type) {
+	case *datum.Int:
+		return float64(d.Increase())
+	case *datum.Float:
+		return d.Increase()
+	}
+	return 0
+}
+
+// AdvanceWindow moves the window of each LabelValue of the Metric on by a
+// second, by sampling its current total.  Metrics without a Window are left
+// alone.
+func (m *Metric) AdvanceWindow() {
+	if m.Window < time.Second {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	// Rebuilt on each advance, so the windows of removed LabelValues go too.
+	windows := make(map[*LabelValue]*rateWindow, len(m.LabelValues))
+	for _, lv := range m.LabelValues {
+		total := datumTotal(lv.Value)
+		w, ok := m.windows[lv]
+		if !ok {
+			// A LabelValue not created by GetDatum, e.g. one carried over
+			// from an earlier program, starts counting from now.
+			w = newRateWindow(m.Window, total)
+		}
+		w.samples[w.next] = total
+		w.next = (w.next + 1) % len(w.samples)
+		windows[lv] = w
+	}
+	m.windows = windows
+}
+
+// rate returns the per-second rate of the LabelValue's total over the
+// Metric's Window.  The caller must hold the metric's lock.
+func (m *Metric) rate(lv *LabelValue) float64 {
+	w, ok := m.windows[lv]
+	if !ok {
+		return 0
+	}
+	return (datumTotal(lv.Value) - w.samples[w.next]) / m.Window.Seconds()
+}
+
+// AdvanceWindows moves the windows of the rate metrics in the store on by a
+// second.
+func (s *Store) AdvanceWindows() {
+	/* #nosec G104 always returns nil */
+	s.Range(func(m *Metric) error {
+		m.AdvanceWindow()
+		return nil
+	})
+}
+
+// StartRateLoop runs a permanent goroutine that advances the windows of the
+// rate metrics in the store every second.
+func (s *Store) StartRateLoop(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.AdvanceWindows()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// ExportKind returns the Kind the Metric is exported as.  The rate of a
+// counter goes up and down, so a Metric with a Window is exported as a Gauge.
+func (m *Metric) ExportKind() Kind {
+	if m.Window >= time.Second {
+		return Gauge
+	}
+	return m.Kind
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/google/mtail/internal/testutil"
+)
+
+func emittedRate(t *testing.T, m *Metric) float64 {
+	t.Helper()
+	c := make(chan *LabelSet)
+	go m.EmitLabelSets(c)
+	ls := <-c
+	for range c {
+	}
+	f, ok := ls.Datum.(*datum.Float)
+	if !ok {
+		t.Fatalf("rate datum is %T, not a Float", ls.Datum)
+	}
+	return f.Get()
+}
+
+func TestRateWindow(t *testing.T) {
+	m := NewMetric("requests", "prog", Counter, Int)
+	m.Window = 3 * time.Second
+	d, err := m.GetDatum()
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, Gauge, m.ExportKind())
+
+	ts := time.Now()
+	// 3 in the first second, 6 in the second, none in the third.
+	for _, inc := range []int64{3, 6, 0} {
+		datum.IncIntBy(d, inc, ts)
+		m.AdvanceWindow()
+	}
+	testutil.ExpectNoDiff(t, 3.0, emittedRate(t, m))
+
+	// The first second falls out of the window.
+	m.AdvanceWindow()
+	testutil.ExpectNoDiff(t, 2.0, emittedRate(t, m))
+
+	// A reset counts the new total as an increase on top of the others.
+	datum.SetInt(d, 1, ts)
+	testutil.ExpectNoDiff(t, 7.0/3, emittedRate(t, m))
+
+	// The raw total is unchanged.
+	testutil.ExpectNoDiff(t, int64(1), datum.GetInt(d))
+}
+
+func TestRateWindowCarriedOver(t *testing.T) {
+	m := NewMetric("requests", "prog", Counter, Int)
+	d, err := m.GetDatum()
+	testutil.FatalIfErr(t, err)
+	datum.SetInt(d, 100, time.Now())
+	// The metric is made a rate metric after its LabelValue was created, so
+	// it counts from the first advance.
+	m.Window = 2 * time.Second
+	testutil.ExpectNoDiff(t, 0.0, emittedRate(t, m))
+	m.AdvanceWindow()
+	datum.IncIntBy(d, 4, time.Now())
+	testutil.ExpectNoDiff(t, 2.0, emittedRate(t, m))
+}
+
+func TestNoRateWindow(t *testing.T) {
+	m := NewMetric("requests", "prog", Counter, Int)
+	_, err := m.GetDatum()
+	testutil.FatalIfErr(t, err)
+	m.AdvanceWindow()
+	testutil.ExpectNoDiff(t, Counter, m.ExportKind())
+	c := make(chan *LabelSet)
+	go m.EmitLabelSets(c)
+	ls := <-c
+	if _, ok := ls.Datum.(*datum.Int); !ok {
+		t.Errorf("datum is %T, not the Int total", ls.Datum)
+	}
+}
@@ -6,8 +6,10 @@ package metrics
 import (
 	"context"
 	"encoding/json"
+	"expvar"
 	"io"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,6 +17,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// metricEvictions counts the label values removed from metrics because they
+// expired or exceeded their metric's limit.
+var metricEvictions = expvar.NewInt("metric_evictions_total")
+
 // Store contains Metrics.
 type Store struct {
 	searchMu sync.RWMutex // read for iterate and insert, write for delete
@@ -123,7 +129,34 @@ func (s *Store) ClearMetrics() {
 	s.Metrics = make(map[string][]*Metric)
 }
 
-// MarshalJSON returns a JSON byte string representing the Store.
+// RemoveProgramMetrics removes all the metrics of the program prog from the
+// store, and returns them.
+func (s *Store) RemoveProgramMetrics(prog string) []*Metric {
+	s.insertMu.Lock()
+	defer s.insertMu.Unlock()
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+	var removed []*Metric
+	for name, ml := range s.Metrics {
+		var kept []*Metric
+		for _, m := range ml {
+			if m.Program == prog {
+				removed = append(removed, m)
+				continue
+			}
+			kept = append(kept, m)
+		}
+		if len(kept) == 0 {
+			delete(s.Metrics, name)
+			continue
+		}
+		s.Metrics[name] = kept
+	}
+	return removed
+}
+
+// MarshalJSON returns a JSON byte string representing the Store.  Metrics
+// are ordered by name and then program, so that the output is deterministic.
 func (s *Store) MarshalJSON() (b []byte, err error) {
 	s.searchMu.RLock()
 	defer s.searchMu.RUnlock()
@@ -131,6 +164,12 @@ func (s *Store) MarshalJSON() (b []byte, err error) {
 	for _, ml := range s.Metrics {
 		ms = append(ms, ml...)
 	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ms[i].Name != ms[j].Name {
+			return ms[i].Name < ms[j].Name
+		}
+		return ms[i].Program < ms[j].Program
+	})
 	return json.Marshal(ms)
 }
 
@@ -151,6 +190,19 @@ func (s *Store) Range(f func(*Metric) error) error {
 	return nil
 }
 
+// Snapshot returns the Metrics present in the store.  The store is only
+// locked while the list is copied, so a slow reader of the Metrics doesn't
+// hold up programs adding new ones.  The Metrics are not locked.
+func (s *Store) Snapshot() []*Metric {
+	s.searchMu.RLock()
+	defer s.searchMu.RUnlock()
+	var r []*Metric
+	for _, ml := range s.Metrics {
+		r = append(r, ml...)
+	}
+	return r
+}
+
 // Gc iterates through the Store looking for metrics that can be tidied up,
 // if they are passed their expiry or sized greater than their limit.
 func (s *Store) Gc() error {
@@ -160,20 +212,24 @@ func (s *Store) Gc() error {
 		if m.Limit > 0 && len(m.LabelValues) >= m.Limit {
 			for i := len(m.LabelValues); i > m.Limit; i-- {
 				m.RemoveOldestDatum()
+				metricEvictions.Add(1)
 			}
 		}
-		for i := 0; i < len(m.LabelValues); i++ {
-			lv := m.LabelValues[i]
-			if lv.Expiry <= 0 {
-				continue
+		// Find the expired label values first, as removing them takes the
+		// metric's write lock.
+		var expired [][]string
+		m.RLock()
+		for _, lv := range m.LabelValues {
+			if lv.Expiry > 0 && now.Sub(lv.Value.TimeUTC()) > lv.Expiry {
+				expired = append(expired, lv.Labels)
 			}
-			if now.Sub(lv.Value.TimeUTC()) > lv.Expiry {
-				err := m.RemoveDatum(lv.Labels...)
-				if err != nil {
-					return err
-				}
-				i--
+		}
+		m.RUnlock()
+		for _, labels := range expired {
+			if err := m.RemoveDatum(labels...); err != nil {
+				return err
 			}
+			metricEvictions.Add(1)
 		}
 		return nil
 	})
@@ -63,6 +63,41 @@ func TestDuplicateMetric(t *testing.T) {
 	}
 }
 
+func TestRemoveProgramMetrics(t *testing.T) {
+	s := NewStore()
+	testutil.FatalIfErr(t, s.Add(NewMetric("foo", "prog", Counter, Int)))
+	testutil.FatalIfErr(t, s.Add(NewMetric("foo", "prog1", Counter, Int)))
+	testutil.FatalIfErr(t, s.Add(NewMetric("bar", "prog", Gauge, Int)))
+
+	removed := s.RemoveProgramMetrics("prog")
+	if len(removed) != 2 {
+		t.Errorf("should remove both metrics of prog: %v", removed)
+	}
+	if s.FindMetricOrNil("foo", "prog") != nil || s.FindMetricOrNil("foo", "prog1") == nil {
+		t.Errorf("should only remove foo of prog. Store: %v", s)
+	}
+	if _, ok := s.Metrics["bar"]; ok {
+		t.Errorf("should remove bar entirely. Store: %v", s)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	s := NewStore()
+	testutil.FatalIfErr(t, s.Add(NewMetric("foo", "prog", Counter, Int)))
+	testutil.FatalIfErr(t, s.Add(NewMetric("foo", "prog1", Counter, Int)))
+	testutil.FatalIfErr(t, s.Add(NewMetric("bar", "prog", Gauge, Int)))
+
+	snapshot := s.Snapshot()
+	if len(snapshot) != 3 {
+		t.Errorf("should contain all three metrics: %v", snapshot)
+	}
+	// Changes to the store after the snapshot is taken aren't seen in it.
+	s.ClearMetrics()
+	if len(snapshot) != 3 {
+		t.Errorf("snapshot changed with the store: %v", snapshot)
+	}
+}
+
 // A program can add a metric with the same name and of different type.
 // Prometheus behavior in this case is undefined.  @see
 // https://github.com/google/mtail/issues/130
@@ -150,6 +185,32 @@ func TestExpireOversizeDatum(t *testing.T) {
 	}
 }
 
+func TestExpireMetricDefaultExpiry(t *testing.T) {
+	s := NewStore()
+	m := NewMetric("foo", "prog", Counter, Int, "path")
+	m.Expiry = time.Minute
+	testutil.FatalIfErr(t, s.Add(m))
+	evictions := metricEvictions.Value()
+
+	d, err := m.GetDatum("/old")
+	testutil.FatalIfErr(t, err)
+	datum.SetInt(d, 1, time.Now().Add(-time.Hour))
+	d, err = m.GetDatum("/new")
+	testutil.FatalIfErr(t, err)
+	datum.SetInt(d, 1, time.Now())
+
+	testutil.FatalIfErr(t, s.Gc())
+	if lv := m.FindLabelValueOrNil([]string{"/old"}); lv != nil {
+		t.Errorf("lv not expired: %#v", lv)
+	}
+	if lv := m.FindLabelValueOrNil([]string{"/new"}); lv == nil {
+		t.Error("lv expired too soon")
+	}
+	if got := metricEvictions.Value() - evictions; got != 1 {
+		t.Errorf("expected 1 eviction, got %d", got)
+	}
+}
+
 func TestExpireManyMetrics(t *testing.T) {
 	s := NewStore()
 	m := NewMetric("foo", "prog", Counter, Int, "id")
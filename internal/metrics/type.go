@@ -20,6 +20,8 @@ const (
 	String
 	// Buckets indicates this metric is a histogram metric type.
 	Buckets
+	// Quantiles indicates this metric is a summary metric type.
+	Quantiles
 
 	endType // end of enumeration for testing
 )
@@ -34,6 +36,8 @@ func (t Type) String() string {
 		return "String"
 	case Buckets:
 		return "Buckets"
+	case Quantiles:
+		return "Quantiles"
 	}
 	return "?"
 }
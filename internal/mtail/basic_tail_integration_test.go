@@ -27,6 +27,7 @@ func TestBasicTail(t *testing.T) {
 	logFile := filepath.Join(logDir, "log")
 
 	lineCountCheck := m.ExpectMapExpvarDeltaWithDeadline("log_lines_total", logFile, 3)
+	byteCountCheck := m.ExpectMapExpvarDeltaWithDeadline("log_bytes_total", logFile, 6)
 	logCountCheck := m.ExpectExpvarDeltaWithDeadline("log_count", 1)
 
 	f := testutil.TestOpenFile(t, logFile)
@@ -39,11 +40,15 @@ func TestBasicTail(t *testing.T) {
 	m.PollWatched(1) // Expect to read 3 lines here.
 
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
 		lineCountCheck()
 	}()
+	go func() {
+		defer wg.Done()
+		byteCountCheck()
+	}()
 	go func() {
 		defer wg.Done()
 		logCountCheck()
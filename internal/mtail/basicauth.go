@@ -0,0 +1,50 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+)
+
+// httpAuthFailures counts the HTTP requests refused for lacking valid credentials.
+var httpAuthFailures = expvar.NewInt("http_auth_failures_total")
+
+// basicAuth holds the credentials that HTTP requests must present.  Only
+// their hashes are kept, so that they are compared in constant time however
+// long the presented credentials are.
+type basicAuth struct {
+	user, password [sha256.Size]byte
+}
+
+func newBasicAuth(user, password string) *basicAuth {
+	return &basicAuth{sha256.Sum256([]byte(user)), sha256.Sum256([]byte(password))}
+}
+
+// valid returns true if user and password are the required credentials.
+// Both are always compared, so the time taken doesn't tell which is wrong.
+func (a *basicAuth) valid(user, password string) bool {
+	u := sha256.Sum256([]byte(user))
+	p := sha256.Sum256([]byte(password))
+	userOK := subtle.ConstantTimeCompare(u[:], a.user[:])
+	passwordOK := subtle.ConstantTimeCompare(p[:], a.password[:])
+	return userOK&passwordOK == 1
+}
+
+// wrap returns a handler that serves requests with h only if they have the
+// required credentials, and otherwise responds 401 Unauthorized.
+func (a *basicAuth) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !a.valid(user, password) {
+			httpAuthFailures.Add(1)
+			w.Header().Set("WWW-Authenticate", `Basic realm="mtail", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
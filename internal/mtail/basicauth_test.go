@@ -0,0 +1,43 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestBasicAuth(t *testing.T) {
+	h := newBasicAuth("prometheus", "s3cret").wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, tc := range []struct {
+		name           string
+		user, password string
+		noAuth         bool
+		want           int
+	}{
+		{"valid", "prometheus", "s3cret", false, http.StatusNoContent},
+		{"wrong password", "prometheus", "s3cre", false, http.StatusUnauthorized},
+		{"wrong user", "grafana", "s3cret", false, http.StatusUnauthorized},
+		{"no credentials", "", "", true, http.StatusUnauthorized},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if !tc.noAuth {
+				r.SetBasicAuth(tc.user, tc.password)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			testutil.ExpectNoDiff(t, tc.want, w.Code)
+			if tc.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -32,3 +32,43 @@ func TestBadProgramFailsCompilation(t *testing.T) {
 		t.Error("compile failed not reported")
 	}
 }
+
+func TestCompileOnlyReportsAllBadPrograms(t *testing.T) {
+	testutil.SkipIfShort(t)
+	progDir := testutil.TestTempDir(t)
+
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(progDir, "bad1.mtail"), []byte("asdfasdf\n"), 0o666))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(progDir, "good.mtail"), []byte("counter foo\n/foo/ {\n  foo++\n}\n"), 0o666))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(progDir, "bad2.mtail"), []byte("/(/ {}\n"), 0o666))
+
+	ctx := context.Background()
+	_, err := mtail.New(ctx, metrics.NewStore(), mtail.ProgramPath(progDir), mtail.CompileOnly)
+	if err == nil {
+		t.Fatal("expected error from mtail")
+	}
+	for _, name := range []string{"bad1.mtail", "bad2.mtail"} {
+		if !strings.Contains(err.Error(), "compile failed for "+name) {
+			t.Errorf("compile failure of %s not reported in %q", name, err)
+		}
+	}
+	if strings.Contains(err.Error(), "good.mtail") {
+		t.Errorf("good.mtail reported as failed in %q", err)
+	}
+}
+
+func TestCompileOnlySingleFile(t *testing.T) {
+	testutil.SkipIfShort(t)
+	progDir := testutil.TestTempDir(t)
+
+	progPath := filepath.Join(progDir, "bad.mtail")
+	testutil.FatalIfErr(t, os.WriteFile(progPath, []byte("asdfasdf\n"), 0o666))
+
+	ctx := context.Background()
+	_, err := mtail.New(ctx, metrics.NewStore(), mtail.ProgramPath(progPath), mtail.CompileOnly)
+	if err == nil {
+		t.Fatal("expected error from mtail")
+	}
+	if !strings.Contains(err.Error(), "bad.mtail:1:") {
+		t.Errorf("error position not reported in %q", err)
+	}
+}
@@ -86,6 +86,17 @@ var exampleProgramTests = []struct {
 }
 
 func TestExamplePrograms(t *testing.T) {
+	testExamplePrograms(t)
+}
+
+// TestExampleProgramsOptimised checks that the optimised bytecode of the
+// examples produces the same metrics as the unoptimised programs.
+func TestExampleProgramsOptimised(t *testing.T) {
+	testExamplePrograms(t, mtail.OptimiseBytecode)
+}
+
+func testExamplePrograms(t *testing.T, options ...mtail.Option) {
+	t.Helper()
 	testutil.SkipIfShort(t)
 	for _, tc := range exampleProgramTests {
 		tc := tc
@@ -95,7 +106,8 @@ func TestExamplePrograms(t *testing.T) {
 				waker, _ := waker.NewTest(ctx, 0) // oneshot means we should never need to wake the stream
 				store := metrics.NewStore()
 				programFile := filepath.Join("../..", tc.programfile)
-				mtail, err := mtail.New(ctx, store, mtail.ProgramPath(programFile), mtail.LogPathPatterns(tc.logfile), mtail.OneShot, mtail.OmitMetricSource, mtail.DumpAstTypes, mtail.DumpBytecode, mtail.LogPatternPollWaker(waker), mtail.LogstreamPollWaker(waker))
+				opts := append([]mtail.Option{mtail.ProgramPath(programFile), mtail.LogPathPatterns(tc.logfile), mtail.OneShot, mtail.OmitMetricSource, mtail.DumpAstTypes, mtail.DumpBytecode, mtail.LogPatternPollWaker(waker), mtail.LogstreamPollWaker(waker)}, options...)
+				mtail, err := mtail.New(ctx, store, opts...)
 				testutil.FatalIfErr(t, err)
 
 				var wg sync.WaitGroup
@@ -116,11 +128,14 @@ func TestExamplePrograms(t *testing.T) {
 
 				var storeList metrics.MetricSlice
 				store.Range(func(m *metrics.Metric) error {
-					storeList = append(storeList, m)
+					// Hidden metrics aren't exported, so aren't in the golden files.
+					if !m.Hidden {
+						storeList = append(storeList, m)
+					}
 					return nil
 				})
 
-				testutil.ExpectNoDiff(t, goldenStore, storeList, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, sync.RWMutex{}, datum.String{}))
+				testutil.ExpectNoDiff(t, goldenStore, storeList, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, metrics.LabelValue{}, sync.RWMutex{}, datum.String{}, datum.Int{}, datum.Float{}))
 			}))
 	}
 }
@@ -99,7 +99,7 @@ func TestFilePipeStreamComparison(t *testing.T) {
 				})
 
 				// Ignore the datum.Time field as well, as the results will be unstable otherwise.
-				testutil.ExpectNoDiff(t, fileMetrics, pipeMetrics, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, sync.RWMutex{}, datum.String{}), testutil.IgnoreFields(datum.BaseDatum{}, "Time"))
+				testutil.ExpectNoDiff(t, fileMetrics, pipeMetrics, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, metrics.LabelValue{}, sync.RWMutex{}, datum.String{}, datum.Int{}, datum.Float{}), testutil.IgnoreFields(datum.BaseDatum{}, "Time"))
 			}))
 	}
 }
@@ -191,7 +191,7 @@ func TestFileSocketStreamComparison(t *testing.T) {
 					})
 
 					// Ignore the datum.Time field as well, as the results will be unstable otherwise.
-					testutil.ExpectNoDiff(t, fileMetrics, sockMetrics, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, sync.RWMutex{}, datum.String{}), testutil.IgnoreFields(datum.BaseDatum{}, "Time"))
+					testutil.ExpectNoDiff(t, fileMetrics, sockMetrics, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, metrics.LabelValue{}, sync.RWMutex{}, datum.String{}, datum.Int{}, datum.Float{}), testutil.IgnoreFields(datum.BaseDatum{}, "Time"))
 				}))
 		}
 	}
@@ -0,0 +1,75 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/mtail"
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestExportFilters(t *testing.T) {
+	testutil.SkipIfShort(t)
+	workdir := testutil.TestTempDir(t)
+
+	logDir := filepath.Join(workdir, "logs")
+	testutil.FatalIfErr(t, os.Mkdir(logDir, 0o777))
+	progDir := filepath.Join(workdir, "progs")
+	testutil.FatalIfErr(t, os.Mkdir(progDir, 0o777))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(progDir, "filter.mtail"), []byte("counter shown\ncounter denied\nhidden counter internal\n/x/ {\n  shown++\n  denied++\n  internal++\n}\n"), 0o600))
+	sock := filepath.Join(workdir, "mtail.sock")
+
+	logFile := testutil.TestOpenFile(t, filepath.Join(logDir, "log"))
+	defer logFile.Close()
+
+	// The hidden metric is only exported where it's explicitly allowed.
+	m, stopM := mtail.TestStartServer(t, 1, mtail.ProgramPath(progDir), mtail.LogPathPatterns(logDir+"/*"), mtail.BindUnixSocket(sock),
+		mtail.ExportAllow("prometheus", "^(shown|denied|internal)$"), mtail.ExportDeny("", "^denied$"), mtail.ExportDeny("json", "^shown$"))
+	defer stopM()
+
+	shownCheck := m.ExpectProgMetricDeltaWithDeadline("shown", "filter.mtail", 1)
+	m.PollWatched(1)
+	testutil.WriteString(t, logFile, "x\n")
+	m.PollWatched(1)
+	shownCheck()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	get := func(path string) string {
+		t.Helper()
+		resp, err := client.Get("http://mtail" + path)
+		testutil.FatalIfErr(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		testutil.FatalIfErr(t, err)
+		return string(b)
+	}
+
+	prom := get("/metrics")
+	for _, name := range []string{"shown", "internal"} {
+		if !strings.Contains(prom, name+`{prog="filter.mtail"} 1`) {
+			t.Errorf("allowed metric %s not exported:\n%s", name, prom)
+		}
+	}
+	if strings.Contains(prom, "denied{") {
+		t.Errorf("metric denied exported:\n%s", prom)
+	}
+	json := get("/json")
+	for _, name := range []string{`"shown"`, `"denied"`, `"internal"`} {
+		if strings.Contains(json, name) {
+			t.Errorf("metric %s exported as json:\n%s", name, json)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters are reused between responses, as each holds large buffers.
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+// acceptsGzip returns true if the request's Accept-Encoding header lists gzip,
+// and doesn't give it a quality of zero.
+func acceptsGzip(r *http.Request) bool {
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(h, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			if strings.TrimSpace(name) != "gzip" {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if !ok {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err != nil || weight > 0
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body written to a response.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	// The length set by the handler is that of the uncompressed body.
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.Header().Get("Content-Type") == "" {
+		// Detect the type from the uncompressed body, as the server would
+		// have without the compression.
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+	w.Header().Del("Content-Length")
+	return w.zw.Write(b)
+}
+
+// gzipHandler returns a handler that serves requests with h, compressing the
+// response with gzip if the client accepts it.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(w)
+		defer func() {
+			zw.Close()
+			gzipWriters.Put(zw)
+		}()
+		h.ServeHTTP(&gzipResponseWriter{w, zw}, r)
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=0", false},
+		{"gzip;q=0.000", false},
+		{"br", false},
+		{"x-gzip", false},
+	} {
+		r := httptest.NewRequest("GET", "/json", nil)
+		if tc.header != "" {
+			r.Header.Set("Accept-Encoding", tc.header)
+		}
+		if got := acceptsGzip(r); got != tc.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestGzipHandler(t *testing.T) {
+	const body = `{"name": "lines_total"}`
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "23")
+		_, _ = io.WriteString(w, body)
+	}))
+
+	r := httptest.NewRequest("GET", "/json", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	testutil.ExpectNoDiff(t, "gzip", w.Header().Get("Content-Encoding"))
+	testutil.ExpectNoDiff(t, "", w.Header().Get("Content-Length"))
+	testutil.ExpectNoDiff(t, "application/json", w.Header().Get("Content-Type"))
+	zr, err := gzip.NewReader(w.Body)
+	testutil.FatalIfErr(t, err)
+	b, err := io.ReadAll(zr)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, body, string(b))
+
+	// Clients that don't accept gzip get the body as it is.
+	r = httptest.NewRequest("GET", "/json", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	testutil.ExpectNoDiff(t, "", w.Header().Get("Content-Encoding"))
+	testutil.ExpectNoDiff(t, body, w.Body.String())
+	testutil.ExpectNoDiff(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
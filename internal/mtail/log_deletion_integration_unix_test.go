@@ -31,6 +31,8 @@ func TestLogDeletion(t *testing.T) {
 
 	logCloseCheck := m.ExpectMapExpvarDeltaWithDeadline("log_closes_total", logFilepath, 1)
 	logCountCheck := m.ExpectExpvarDeltaWithDeadline("log_count", -1)
+	logRemovalsCheck := m.ExpectMapExpvarDeltaWithDeadline("log_removals_total", logFilepath, 1)
+	logRetirementsCheck := m.ExpectExpvarDeltaWithDeadline("log_retirements_total", 1)
 
 	m.PollWatched(1) // Force sync to EOF
 	glog.Info("remove")
@@ -38,6 +40,8 @@ func TestLogDeletion(t *testing.T) {
 
 	m.PollWatched(0) // one pass to stop
 	logCloseCheck()
+	logRemovalsCheck()
 	m.PollWatched(0) // one pass to remove completed stream
 	logCountCheck()
+	logRetirementsCheck()
 }
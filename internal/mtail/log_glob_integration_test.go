@@ -96,6 +96,30 @@ func TestGlobAfterStart(t *testing.T) {
 	logCountCheck()
 }
 
+func TestGlobAfterStartReadsFromStart(t *testing.T) {
+	testutil.SkipIfShort(t)
+
+	workdir := testutil.TestTempDir(t)
+
+	m, stopM := mtail.TestStartServer(t, 0, mtail.LogPathPatterns(filepath.Join(workdir, "worker-*.log")))
+	defer stopM()
+
+	m.PollWatched(0) // Force sync to EOF
+
+	logFilepath := filepath.Join(workdir, "worker-1.log")
+	lineCountCheck := m.ExpectMapExpvarDeltaWithDeadline("log_lines_total", logFilepath, 2)
+	discoveriesCheck := m.ExpectExpvarDeltaWithDeadline("log_discoveries_total", 1)
+	// Write before the next poll finds the file, so the lines are only seen
+	// if the new file is read from its start.
+	log := testutil.TestOpenFile(t, logFilepath)
+	defer log.Close()
+	testutil.WriteString(t, log, "line 1\nline 2\n")
+	m.PollWatched(0) // Find the new file.
+	m.PollWatched(0) // Force sync to EOF
+	lineCountCheck()
+	discoveriesCheck()
+}
+
 func TestGlobIgnoreFolder(t *testing.T) {
 	testutil.SkipIfShort(t)
 
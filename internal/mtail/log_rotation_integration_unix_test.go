@@ -50,7 +50,13 @@ func TestLogRotation(t *testing.T) {
 			defer stopM()
 
 			logOpensTotalCheck := m.ExpectMapExpvarDeltaWithDeadline("log_opens_total", logFile, 1)
-			logLinesTotalCheck := m.ExpectMapExpvarDeltaWithDeadline("log_lines_total", logFile, 3)
+			// If the logstream is removed before the new log is found, the log's
+			// counters are removed with it, and only the new log's line is counted.
+			wantLines := int64(3)
+			if tc {
+				wantLines = 1
+			}
+			logLinesTotalCheck := m.ExpectMapExpvarDeltaWithDeadline("log_lines_total", logFile, wantLines)
 
 			testutil.WriteString(t, f, "line 1\n")
 			m.PollWatched(1)
@@ -5,6 +5,7 @@ package mtail
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"expvar"
 	"net"
@@ -43,20 +44,25 @@ type Server struct {
 
 	reg *prometheus.Registry
 
-	listener net.Listener // Configured with bind address.
+	listener net.Listener  // Configured with bind address.
+	tlsCerts *certReloader // If not nil, the HTTP server is served over TLS with this certificate.
+	auth     *basicAuth    // If not nil, the credentials HTTP requests must present.
 
 	buildInfo BuildInfo // go build information
 
-	programPath        string // path to programs to load
-	oneShot            bool   // if set, mtail reads log files from the beginning, once, then exits
-	compileOnly        bool   // if set, mtail compiles programs then exit
-	httpDebugEndpoints bool   // if set, mtail will enable debug endpoints
-	httpInfoEndpoints  bool   // if set, mtail will enable info endpoints for progz and varz
+	ready chan struct{} // closed once the programs have been loaded and the tailer started
+
+	programPaths       []string // paths to programs to load
+	oneShot            bool     // if set, mtail reads log files from the beginning, once, then exits
+	compileOnly        bool     // if set, mtail compiles programs then exit
+	httpDebugEndpoints bool     // if set, mtail will enable debug endpoints
+	httpInfoEndpoints  bool     // if set, mtail will enable info endpoints for progz and varz
+	reloadEndpoint     bool     // if set, mtail will reload programs on a POST to /reload
 }
 
 // initRuntime constructs a new runtime and performs the initial load of program files in the program directory.
 func (m *Server) initRuntime() (err error) {
-	m.r, err = runtime.New(m.lines, &m.wg, m.programPath, m.store, m.rOpts...)
+	m.r, err = runtime.New(m.lines, &m.wg, "", m.store, append(m.rOpts, runtime.ProgramPaths(m.programPaths...))...)
 	return
 }
 
@@ -87,6 +93,13 @@ func (m *Server) initTailer() (err error) {
 	return
 }
 
+// promErrorLog logs the errors gathering metrics for a Prometheus scrape.
+type promErrorLog struct{}
+
+func (promErrorLog) Println(v ...interface{}) {
+	glog.Warningln(v...)
+}
+
 // initHTTPServer begins the http server.
 func (m *Server) initHTTPServer() error {
 	initDone := make(chan struct{})
@@ -108,21 +121,42 @@ func (m *Server) initHTTPServer() error {
 	}
 	if m.httpInfoEndpoints {
 		mux.HandleFunc("/favicon.ico", FaviconHandler)
-		mux.HandleFunc("/varz", http.HandlerFunc(m.e.HandleVarz))
-		mux.Handle("/progz", http.HandlerFunc(m.r.ProgzHandler))
+		mux.Handle("/varz", gzipHandler(http.HandlerFunc(m.e.HandleVarz)))
+		mux.Handle("/progz", m.whenReady(func(w http.ResponseWriter, r *http.Request) { m.r.ProgzHandler(w, r) }))
 	}
-	mux.Handle("/", m)
-	mux.Handle("/metrics", promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{}))
-	mux.HandleFunc("/json", http.HandlerFunc(m.e.HandleJSON))
-	mux.HandleFunc("/graphite", http.HandlerFunc(m.e.HandleGraphite))
+	if m.reloadEndpoint {
+		mux.Handle("/reload", m.whenReady(func(w http.ResponseWriter, r *http.Request) { m.r.ReloadHandler(w, r) }))
+	}
+	mux.Handle("/", m.whenReady(m.ServeHTTP))
+	mux.HandleFunc("/healthz", m.healthzHandler)
+	mux.HandleFunc("/readyz", m.readyzHandler)
+	// The Prometheus handler compresses its response itself if the client
+	// accepts gzip.
+	mux.Handle("/metrics", promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{
+		// Serve the metrics that could be gathered if some couldn't, rather
+		// than failing the whole scrape.
+		ErrorHandling: promhttp.ContinueOnError,
+		ErrorLog:      promErrorLog{},
+	}))
+	mux.Handle("/json", gzipHandler(http.HandlerFunc(m.e.HandleJSON)))
+	mux.Handle("/graphite", gzipHandler(http.HandlerFunc(m.e.HandleGraphite)))
 	zpages.Handle(mux, "/")
 
+	var handler http.Handler = mux
+	if m.auth != nil {
+		// Liveness and readiness probes reveal nothing, so don't need credentials.
+		top := http.NewServeMux()
+		top.HandleFunc("/healthz", m.healthzHandler)
+		top.HandleFunc("/readyz", m.readyzHandler)
+		top.Handle("/", m.auth.wrap(mux))
+		handler = top
+	}
 	srv := &http.Server{
 		ReadTimeout:       1 * time.Second,
 		WriteTimeout:      1 * time.Second,
 		IdleTimeout:       30 * time.Second,
 		ReadHeaderTimeout: 2 * time.Second,
-		Handler:           mux,
+		Handler:           handler,
 	}
 
 	var wg sync.WaitGroup
@@ -133,8 +167,16 @@ func (m *Server) initHTTPServer() error {
 	go func() {
 		defer wg.Done()
 		<-initDone
-		glog.Infof("Listening on %s", m.listener.Addr())
-		if err := srv.Serve(m.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if m.tlsCerts != nil {
+			glog.Infof("Listening on %s with TLS", m.listener.Addr())
+			srv.TLSConfig = &tls.Config{GetCertificate: m.tlsCerts.GetCertificate, MinVersion: tls.VersionTLS12}
+			err = srv.ServeTLS(m.listener, "", "")
+		} else {
+			glog.Infof("Listening on %s", m.listener.Addr())
+			err = srv.Serve(m.listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errc <- err
 		}
 	}()
@@ -175,22 +217,55 @@ func New(ctx context.Context, store *metrics.Store, options ...Option) (*Server,
 		lines: make(chan *logline.LogLine),
 		// Using a non-pedantic registry means we can be looser with metrics that
 		// are not fully specified at startup.
-		reg: prometheus.NewRegistry(),
+		reg:   prometheus.NewRegistry(),
+		ready: make(chan struct{}),
 	}
 	m.rOpts = append(m.rOpts, runtime.PrometheusRegisterer(m.reg))
 
 	// TODO(jaq): Should these move to initExporter?
 	expvarDescs := map[string]*prometheus.Desc{
 		// internal/tailer/file.go
-		"log_errors_total":    prometheus.NewDesc("log_errors_total", "number of IO errors encountered per log file", []string{"logfile"}, nil),
-		"log_rotations_total": prometheus.NewDesc("log_rotations_total", "number of log rotation events per log file", []string{"logfile"}, nil),
-		"log_truncates_total": prometheus.NewDesc("log_truncates_total", "number of log truncation events log file", []string{"logfile"}, nil),
-		"log_lines_total":     prometheus.NewDesc("log_lines_total", "number of lines read per log file", []string{"logfile"}, nil),
+		"log_errors_total":      prometheus.NewDesc("log_errors_total", "number of IO errors encountered per log file", []string{"logfile"}, nil),
+		"log_rotations_total":   prometheus.NewDesc("log_rotations_total", "number of log rotation events per log file", []string{"logfile"}, nil),
+		"log_truncations_total": prometheus.NewDesc("log_truncations_total", "number of log truncation events per log file", []string{"logfile"}, nil),
+		"log_removals_total":    prometheus.NewDesc("log_removals_total", "number of log files that stopped being tailed because they were removed", []string{"logfile"}, nil),
+		// internal/tailer/tail.go
+		"log_discoveries_total": prometheus.NewDesc("log_discoveries_total", "number of log files matched by the log patterns after startup", nil, nil),
+		"log_retirements_total": prometheus.NewDesc("log_retirements_total", "number of log files no longer tailed after being read to the end once removed or rotated away", nil, nil),
+		// internal/tailer/logstream/decode.go
+		"log_lines_total": prometheus.NewDesc("log_lines_total", "number of lines read per log file", []string{"logfile"}, nil),
+		"log_bytes_total": prometheus.NewDesc("log_bytes_total", "number of bytes read per log file", []string{"logfile"}, nil),
+		// internal/metrics/store.go
+		"metric_evictions_total": prometheus.NewDesc("metric_evictions_total", "number of metric label values removed by expiry or size limit", nil, nil),
+		// internal/exporter/export.go
+		"metric_push_duration_seconds":  prometheus.NewDesc("metric_push_duration_seconds", "time taken by the last push of metrics per export backend", []string{"backend"}, nil),
+		"metric_push_connections_total": prometheus.NewDesc("metric_push_connections_total", "number of connections opened to push metrics per collector address", []string{"addr"}, nil),
+		"metric_push_retry_backlog":     prometheus.NewDesc("metric_push_retry_backlog", "number of failed metric pushes waiting to be retried", nil, nil),
+		"metric_push_dropped_total":     prometheus.NewDesc("metric_push_dropped_total", "number of metric pushes dropped after running out of retries, or without retrying, per collector address", []string{"addr"}, nil),
+		// internal/exporter/stale.go
+		"metric_stale_series": prometheus.NewDesc("metric_stale_series", "number of series left out of the exports for not being updated within -stale_after", nil, nil),
+		// internal/mtail/basicauth.go
+		"http_auth_failures_total": prometheus.NewDesc("http_auth_failures_total", "number of HTTP requests refused for lacking valid basic auth credentials", nil, nil),
 		// internal/runtime/loader.go
-		"lines_total":               prometheus.NewDesc("lines_total", "number of lines received by the program loader", nil, nil),
-		"prog_loads_total":          prometheus.NewDesc("prog_loads_total", "number of program load events by program source filename", []string{"prog"}, nil),
-		"prog_load_errors_total":    prometheus.NewDesc("prog_load_errors_total", "number of errors encountered when loading per program source filename", []string{"prog"}, nil),
-		"prog_runtime_errors_total": prometheus.NewDesc("prog_runtime_errors_total", "number of errors encountered when executing programs per source filename", []string{"prog"}, nil),
+		"lines_total":                        prometheus.NewDesc("lines_total", "number of lines received by the program loader", nil, nil),
+		"lines_dropped_total":                prometheus.NewDesc("lines_dropped_total", "number of lines dropped per program source filename because the program could not keep up", []string{"prog"}, nil),
+		"prog_loads_total":                   prometheus.NewDesc("prog_loads_total", "number of program load events by program source filename", []string{"prog"}, nil),
+		"prog_load_errors_total":             prometheus.NewDesc("prog_load_errors_total", "number of errors encountered when loading per program source filename", []string{"prog"}, nil),
+		"prog_last_load_timestamp_seconds":   prometheus.NewDesc("prog_last_load_timestamp_seconds", "time of the last successful load per program source filename, in seconds since the epoch", []string{"prog"}, nil),
+		"prog_count":                         prometheus.NewDesc("prog_count", "number of programs loaded and processing lines", nil, nil),
+		"prog_compile_duration_seconds":      prometheus.NewDesc("prog_compile_duration_seconds", "time taken by the last compile per program source filename", []string{"prog"}, nil),
+		"prog_unloads_total":                 prometheus.NewDesc("prog_unloads_total", "number of program unload events by program source filename", []string{"prog"}, nil),
+		"prog_reloads_total":                 prometheus.NewDesc("prog_reloads_total", "number of times all programs were reloaded on a signal", nil, nil),
+		"prog_lines_total":                   prometheus.NewDesc("prog_lines_total", "number of lines processed per program source filename", []string{"prog"}, nil),
+		"prog_lines_matched_total":           prometheus.NewDesc("prog_lines_matched_total", "number of lines that matched at least one pattern per program source filename", []string{"prog"}, nil),
+		"prog_lines_unmatched_total":         prometheus.NewDesc("prog_lines_unmatched_total", "number of lines that matched no pattern per program source filename", []string{"prog"}, nil),
+		"prog_line_processing_seconds_total": prometheus.NewDesc("prog_line_processing_seconds_total", "wall clock time spent processing lines per program source filename", []string{"prog"}, nil),
+		"prog_time_parse_errors_total":       prometheus.NewDesc("prog_time_parse_errors_total", "number of timestamps that failed to parse per source filename", []string{"prog"}, nil),
+		"prog_json_field_misses_total":       prometheus.NewDesc("prog_json_field_misses_total", "number of json_field calls that found no field per program source filename", []string{"prog"}, nil),
+		"prog_logfmt_field_misses_total":     prometheus.NewDesc("prog_logfmt_field_misses_total", "number of logfmt_field calls that found no field per program source filename", []string{"prog"}, nil),
+		"prog_runtime_errors_total":          prometheus.NewDesc("prog_runtime_errors_total", "number of errors encountered when executing programs per source filename", []string{"prog"}, nil),
+		// internal/runtime/vm/vm.go
+		"vm_timeouts_total": prometheus.NewDesc("vm_timeouts_total", "number of lines abandoned per program source filename because processing them took longer than the execution timeout", []string{"prog"}, nil),
 	}
 	m.reg.MustRegister(
 		collectors.NewGoCollector(),
@@ -204,17 +279,20 @@ func New(ctx context.Context, store *metrics.Store, options ...Option) (*Server,
 	if err := m.initExporter(); err != nil {
 		return nil, err
 	}
+	// The HTTP server is started before the programs are loaded, so that
+	// probes of /readyz are answered while mtail starts up.
 	//nolint:contextcheck // TODO
-	if err := m.initRuntime(); err != nil {
+	if err := m.initHTTPServer(); err != nil {
 		return nil, err
 	}
-	if err := m.initTailer(); err != nil {
+	//nolint:contextcheck // TODO
+	if err := m.initRuntime(); err != nil {
 		return nil, err
 	}
-	//nolint:contextcheck // TODO
-	if err := m.initHTTPServer(); err != nil {
+	if err := m.initTailer(); err != nil {
 		return nil, err
 	}
+	close(m.ready)
 	return m, nil
 }
 
@@ -236,5 +314,12 @@ func (m *Server) Run() error {
 		glog.Info("compile-only is set, exiting")
 		return nil
 	}
+	if m.e != nil {
+		// The tailer and the programs have finished with every line they
+		// read, so send the final metric values to push targets before
+		// exiting.
+		glog.Info("pushing final metrics")
+		m.e.PushMetrics()
+	}
 	return nil
 }
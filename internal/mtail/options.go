@@ -5,6 +5,7 @@ package mtail
 
 import (
 	"errors"
+	"io"
 	"net"
 	"os"
 	"path/filepath"
@@ -23,14 +24,28 @@ type Option interface {
 	apply(*Server) error
 }
 
-// ProgramPath sets the path to find mtail programs in the Server.
+// ProgramPath sets the path to find mtail programs in the Server.  It may be
+// given more than once to load programs from several paths.
 type ProgramPath string
 
 func (opt ProgramPath) apply(m *Server) error {
-	m.programPath = filepath.Clean(string(opt))
-	if _, err := os.Stat(m.programPath); os.IsNotExist(err) {
+	path := filepath.Clean(string(opt))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return err
 	}
+	m.programPaths = append(m.programPaths, path)
+	return nil
+}
+
+// ProgramManifest sets the path of a file listing the mtail programs for the Server to load.
+type ProgramManifest string
+
+func (opt ProgramManifest) apply(m *Server) error {
+	path := filepath.Clean(string(opt))
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	m.rOpts = append(m.rOpts, runtime.ProgramManifest(path))
 	return nil
 }
 
@@ -54,6 +69,22 @@ func (opt IgnoreRegexPattern) apply(m *Server) error {
 	return nil
 }
 
+// ReadBufferSize sets the size in bytes of the buffer each log is read into.
+type ReadBufferSize int
+
+func (opt ReadBufferSize) apply(m *Server) error {
+	m.tOpts = append(m.tOpts, tailer.ReadBufferSize(int(opt)))
+	return nil
+}
+
+// LogEncoding sets the character encoding of the logs, by IANA name.
+type LogEncoding string
+
+func (opt LogEncoding) apply(m *Server) error {
+	m.tOpts = append(m.tOpts, tailer.LogEncoding(string(opt)))
+	return nil
+}
+
 // BindAddress sets the HTTP server address in Server.
 func BindAddress(address, port string) Option {
 	return &bindAddress{address, port}
@@ -87,6 +118,49 @@ func (opt BindUnixSocket) apply(m *Server) error {
 	return err
 }
 
+// TLSCertificate serves the HTTP server over TLS, with the certificate and
+// key in the PEM encoded files certFile and keyFile.  The files are loaded
+// again when they change.
+func TLSCertificate(certFile, keyFile string) Option {
+	return &tlsCertificate{certFile, keyFile}
+}
+
+type tlsCertificate struct {
+	certFile, keyFile string
+}
+
+var ErrTLSCertificatePair = errors.New("both a TLS certificate and key must be supplied")
+
+func (opt tlsCertificate) apply(m *Server) error {
+	if opt.certFile == "" || opt.keyFile == "" {
+		return ErrTLSCertificatePair
+	}
+	var err error
+	m.tlsCerts, err = newCertReloader(opt.certFile, opt.keyFile)
+	return err
+}
+
+// BasicAuth requires every HTTP request to the Server to present the user
+// and password with HTTP basic authentication, except probes of /healthz
+// and /readyz.
+func BasicAuth(user, password string) Option {
+	return &basicAuthOption{user, password}
+}
+
+type basicAuthOption struct {
+	user, password string
+}
+
+var ErrBasicAuthPair = errors.New("both a basic auth user and password must be supplied")
+
+func (opt basicAuthOption) apply(m *Server) error {
+	if opt.user == "" || opt.password == "" {
+		return ErrBasicAuthPair
+	}
+	m.auth = newBasicAuth(opt.user, opt.password)
+	return nil
+}
+
 // SetBuildInfo sets the mtail program build information in the Server.
 type SetBuildInfo BuildInfo
 
@@ -109,6 +183,36 @@ func (opt overrideLocation) apply(m *Server) error {
 	return nil
 }
 
+// TraceExecutionTo makes the programs write a trace of their execution of each line to w.
+func TraceExecutionTo(w io.Writer) Option {
+	return &traceExecutionTo{w}
+}
+
+type traceExecutionTo struct {
+	io.Writer
+}
+
+func (opt traceExecutionTo) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.TraceExecutionTo(opt.Writer))
+	return nil
+}
+
+// LintTo makes the Server check its programs for likely mistakes and write
+// what it finds to w, then exit without running them.
+func LintTo(w io.Writer) Option {
+	return &lintTo{w}
+}
+
+type lintTo struct {
+	io.Writer
+}
+
+func (opt lintTo) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.LintTo(opt.Writer))
+	m.compileOnly = true
+	return nil
+}
+
 // StaleLogGcWaker triggers garbage collection runs for stale logs in the tailer.
 func StaleLogGcWaker(w waker.Waker) Option {
 	return &staleLogGcWaker{w}
@@ -137,6 +241,20 @@ func (opt logPatternPollWaker) apply(m *Server) error {
 	return nil
 }
 
+// ManifestPollWaker triggers checks of the program manifest for changes, which reload the programs.
+func ManifestPollWaker(w waker.Waker) Option {
+	return &manifestPollWaker{w}
+}
+
+type manifestPollWaker struct {
+	waker.Waker
+}
+
+func (opt manifestPollWaker) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.ManifestPollWaker(opt.Waker))
+	return nil
+}
+
 // LogstreamPollWaker triggers polls on the filesystem for new logs that match the log glob streams.
 func LogstreamPollWaker(w waker.Waker) Option {
 	return &logstreamPollWaker{w}
@@ -151,6 +269,41 @@ func (opt logstreamPollWaker) apply(m *Server) error {
 	return nil
 }
 
+// StateFile records how far each log file has been read in the file at
+// pathname, checkpointing it each time w wakes, so that reading resumes there
+// when mtail restarts.
+func StateFile(pathname string, w waker.Waker) Option {
+	return &stateFile{pathname, w}
+}
+
+type stateFile struct {
+	pathname string
+	waker.Waker
+}
+
+func (opt stateFile) apply(m *Server) error {
+	m.tOpts = append(m.tOpts, tailer.StateFile(opt.pathname, opt.Waker))
+	return nil
+}
+
+// MultilineRecords joins the continuation lines of the logs matching glob
+// into records that begin with a line matching the regular expression start.
+func MultilineRecords(glob, start string, maxSize int, timeout time.Duration) Option {
+	return &multilineRecords{glob, start, maxSize, timeout}
+}
+
+type multilineRecords struct {
+	glob    string
+	start   string
+	maxSize int
+	timeout time.Duration
+}
+
+func (opt multilineRecords) apply(m *Server) error {
+	m.tOpts = append(m.tOpts, tailer.Multiline(opt.glob, opt.start, opt.maxSize, opt.timeout))
+	return nil
+}
+
 type niladicOption struct {
 	applyfunc func(m *Server) error
 }
@@ -169,6 +322,22 @@ var OneShot = &niladicOption{
 	},
 }
 
+// ReadFromStart reads the log files found at startup from their start.
+var ReadFromStart = &niladicOption{
+	func(m *Server) error {
+		m.tOpts = append(m.tOpts, tailer.ReadFromStart)
+		return nil
+	},
+}
+
+// AllowRiskyRegex sets the Server to load programs with patterns that have nested unbounded repetitions.
+var AllowRiskyRegex = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.AllowRiskyRegex())
+		return nil
+	},
+}
+
 // CompileOnly sets compile-only mode in the Server.
 var CompileOnly = &niladicOption{
 	func(m *Server) error {
@@ -178,6 +347,14 @@ var CompileOnly = &niladicOption{
 	},
 }
 
+// RecursivePrograms instructs the Server to load programs from subdirectories of the ProgramPath.
+var RecursivePrograms = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.RecursivePrograms())
+		return nil
+	},
+}
+
 // DumpAst instructs the Server's compiler to print the AST after parsing.
 var DumpAst = &niladicOption{
 	func(m *Server) error {
@@ -186,6 +363,22 @@ var DumpAst = &niladicOption{
 	},
 }
 
+// DumpAstTo makes the Server print the AST of each program after parsing to
+// w, then exit without running them.
+func DumpAstTo(w io.Writer) Option {
+	return &dumpAstTo{w}
+}
+
+type dumpAstTo struct {
+	io.Writer
+}
+
+func (opt dumpAstTo) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.DumpAstTo(opt.Writer), runtime.CompileOnly())
+	m.compileOnly = true
+	return nil
+}
+
 // DumpAstTypes instructs the Server's copmiler to print the AST after type checking.
 var DumpAstTypes = &niladicOption{
 	func(m *Server) error {
@@ -194,6 +387,14 @@ var DumpAstTypes = &niladicOption{
 	},
 }
 
+// DumpBytecodeDir instructs the Server's compiler to write each program's bytecode to a file in this directory after code generation.
+type DumpBytecodeDir string
+
+func (opt DumpBytecodeDir) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.DumpBytecodeDir(string(opt)))
+	return nil
+}
+
 // DumpBytecode instructs the Server's compiuler to print the program bytecode after code generation.
 var DumpBytecode = &niladicOption{
 	func(m *Server) error {
@@ -202,6 +403,14 @@ var DumpBytecode = &niladicOption{
 	},
 }
 
+// OptimiseBytecode instructs the Server's compiler to run the peephole optimiser on the program bytecode.
+var OptimiseBytecode = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.OptimiseBytecode())
+		return nil
+	},
+}
+
 // HttpDebugEndpoints enables debug http endpoints
 var HTTPDebugEndpoints = &niladicOption{
 	func(m *Server) error {
@@ -218,6 +427,14 @@ var HTTPInfoEndpoints = &niladicOption{
 	},
 }
 
+// ReloadEndpoint enables the /reload http endpoint, which reloads all programs on a POST.
+var ReloadEndpoint = &niladicOption{
+	func(m *Server) error {
+		m.reloadEndpoint = true
+		return nil
+	},
+}
+
 // SyslogUseCurrentYear instructs the Server to use the current year for year-less log timestamp during parsing.
 var SyslogUseCurrentYear = &niladicOption{
 	func(m *Server) error {
@@ -226,6 +443,49 @@ var SyslogUseCurrentYear = &niladicOption{
 	},
 }
 
+// Hostname sets the hostname that the Server exports metrics as, instead of the machine's hostname.
+type Hostname string
+
+func (opt Hostname) apply(m *Server) error {
+	m.eOpts = append(m.eOpts, exporter.Hostname(string(opt)))
+	return nil
+}
+
+// HostnameLabel sets the Server to add a label of this name holding the hostname to every exported metric.
+type HostnameLabel string
+
+func (opt HostnameLabel) apply(m *Server) error {
+	m.eOpts = append(m.eOpts, exporter.HostnameLabel(string(opt)))
+	return nil
+}
+
+// ExportAllow limits the metrics exported to backend, or every backend if it
+// is empty, to those whose name matches the regular expression pattern.
+func ExportAllow(backend, pattern string) Option {
+	return &exportFilter{backend, pattern, true}
+}
+
+// ExportDeny stops metrics whose name matches the regular expression pattern
+// from being exported to backend, or every backend if it is empty.
+func ExportDeny(backend, pattern string) Option {
+	return &exportFilter{backend, pattern, false}
+}
+
+type exportFilter struct {
+	backend string
+	pattern string
+	allow   bool
+}
+
+func (opt exportFilter) apply(m *Server) error {
+	if opt.allow {
+		m.eOpts = append(m.eOpts, exporter.ExportAllow(opt.backend, opt.pattern))
+	} else {
+		m.eOpts = append(m.eOpts, exporter.ExportDeny(opt.backend, opt.pattern))
+	}
+	return nil
+}
+
 // OmitProgLabel sets the Server to not put the program name as a label in exported metrics.
 var OmitProgLabel = &niladicOption{
 	func(m *Server) error {
@@ -242,6 +502,22 @@ var OmitMetricSource = &niladicOption{
 	},
 }
 
+// PrefixMetricsWithProgram sets the Server to prefix the name of each metric with the name of its program.
+var PrefixMetricsWithProgram = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.PrefixMetricsWithProgram())
+		return nil
+	},
+}
+
+// ResetMetricsOnReload sets the Server to start the metrics of a reloaded program from zero.
+var ResetMetricsOnReload = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.ResetMetricsOnReload())
+		return nil
+	},
+}
+
 // EmitMetricTimestamp tells the Server to export the metric's timestamp.
 var EmitMetricTimestamp = &niladicOption{
 	func(m *Server) error {
@@ -283,6 +559,15 @@ func (opt MetricPushInterval) apply(m *Server) error {
 	return nil
 }
 
+// StaleAfter sets the Server to leave the series of metrics that haven't been
+// updated for this long out of its exports, until they are updated again.
+type StaleAfter time.Duration
+
+func (opt StaleAfter) apply(m *Server) error {
+	m.eOpts = append(m.eOpts, exporter.StaleAfter(time.Duration(opt)))
+	return nil
+}
+
 // MaxRegexpLength sets the maximum length an mtail regular expression can have, in terms of characters.
 type MaxRegexpLength int
 
@@ -291,6 +576,62 @@ func (opt MaxRegexpLength) apply(m *Server) error {
 	return nil
 }
 
+// MaxDimensionsPerMetric sets the number of label values a metric can hold unless it declares a limit.
+type MaxDimensionsPerMetric int
+
+func (opt MaxDimensionsPerMetric) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.MaxDimensionsPerMetric(int(opt)))
+	return nil
+}
+
+// VMWorkers sets the number of programs that the Server runs at the same time.
+type VMWorkers int
+
+func (opt VMWorkers) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.VMWorkers(int(opt)))
+	return nil
+}
+
+// VMExecutionTimeout sets how long a program may take to process a line before the line is abandoned.
+type VMExecutionTimeout time.Duration
+
+func (opt VMExecutionTimeout) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.VMExecutionTimeout(time.Duration(opt)))
+	return nil
+}
+
+// LineBufferSize sets the number of lines buffered for each program.
+type LineBufferSize int
+
+func (opt LineBufferSize) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.LineBufferSize(int(opt)))
+	return nil
+}
+
+// DropLinesWhenFull instructs the Server to drop lines for programs that can't keep up, instead of waiting for them.
+var DropLinesWhenFull = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.DropLinesWhenFull())
+		return nil
+	},
+}
+
+// NomatchWarnAfter sets the number of lines after which the Server warns about patterns that have never matched.
+type NomatchWarnAfter int64
+
+func (opt NomatchWarnAfter) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.NomatchWarnAfter(int64(opt)))
+	return nil
+}
+
+// MaxCaptures sets the maximum number of capture groups an mtail regular expression can have.
+type MaxCaptures int
+
+func (opt MaxCaptures) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.MaxCaptures(int(opt)))
+	return nil
+}
+
 // MaxRecursionDepth sets the maximum depth the abstract syntax tree built during lexation can have.
 type MaxRecursionDepth int
 
@@ -0,0 +1,82 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// isReady returns true once the programs found at startup have been loaded,
+// and the tailer started on the logs.  The tailer and runtime can only be
+// used by HTTP handlers once it does, as the HTTP server is started first.
+func (m *Server) isReady() bool {
+	select {
+	case <-m.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// whenReady serves requests with h once the Server is ready, and with 503
+// before then.
+func (m *Server) whenReady(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.isReady() {
+			http.Error(w, "mtail is starting", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// healthzHandler answers liveness probes: it returns 200 while the tailer is
+// reading logs and the runtime is dispatching their lines to the programs,
+// and 503 once either has stopped or the Server is shutting down.  It doesn't
+// look at the logs, programs or push collectors, so an idle mtail, or one
+// whose collectors are down, is still alive.  A Server that is still starting
+// up is alive too.
+func (m *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	var reason string
+	switch {
+	case m.ctx.Err() != nil:
+		reason = "shutting down"
+	case !m.isReady():
+	case m.t != nil && !m.t.Alive():
+		reason = "tailer has stopped"
+	case m.r != nil && !m.r.Alive():
+		reason = "runtime has stopped"
+	}
+	if reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, reason)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler answers readiness probes: it returns 503 until the programs
+// found at startup have been loaded and the tailer has started on the logs,
+// and 200 from then on, whatever happens to reloads of the programs.  It
+// returns 503 again once the Server is shutting down.
+func (m *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	var reason string
+	switch {
+	case m.ctx.Err() != nil:
+		reason = "shutting down"
+	case !m.isReady():
+		reason = "starting"
+	}
+	if reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, reason)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestProbes(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	sock := filepath.Join(dir, "mtail.sock")
+	// No logs match the pattern, but mtail is still alive.
+	m, stopM := TestStartServer(t, 0, LogPathPatterns(filepath.Join(dir, "*.log")), BindUnixSocket(sock), BasicAuth("prometheus", "s3cret"))
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	// The probe needs no credentials, though the other endpoints do.
+	resp, err := client.Get("http://mtail/healthz")
+	testutil.FatalIfErr(t, err)
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, http.StatusOK, resp.StatusCode)
+	testutil.ExpectNoDiff(t, "ok\n", string(b))
+	resp, err = client.Get("http://mtail/readyz")
+	testutil.FatalIfErr(t, err)
+	resp.Body.Close()
+	testutil.ExpectNoDiff(t, http.StatusOK, resp.StatusCode)
+	resp, err = client.Get("http://mtail/metrics")
+	testutil.FatalIfErr(t, err)
+	resp.Body.Close()
+	testutil.ExpectNoDiff(t, http.StatusUnauthorized, resp.StatusCode)
+
+	stopM()
+	w := httptest.NewRecorder()
+	m.healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	testutil.ExpectNoDiff(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestReadyzLatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &Server{ctx: ctx, ready: make(chan struct{})}
+	probe := func(h http.HandlerFunc) int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	// While starting, mtail is alive but not ready, and the pages that need
+	// the runtime aren't served.
+	testutil.ExpectNoDiff(t, http.StatusServiceUnavailable, probe(m.readyzHandler))
+	testutil.ExpectNoDiff(t, http.StatusOK, probe(m.healthzHandler))
+	testutil.ExpectNoDiff(t, http.StatusServiceUnavailable, probe(m.whenReady(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called before ready")
+	}).ServeHTTP))
+
+	close(m.ready)
+	testutil.ExpectNoDiff(t, http.StatusOK, probe(m.readyzHandler))
+
+	cancel()
+	testutil.ExpectNoDiff(t, http.StatusServiceUnavailable, probe(m.readyzHandler))
+}
@@ -101,6 +101,7 @@ func TestProgramUnloadIfDeleted(t *testing.T) {
 	defer stopM()
 
 	progLoadsTotalCheck := m.ExpectMapExpvarDeltaWithDeadline("prog_loads_total", "program.mtail", 1)
+	progCountCheck := m.ExpectExpvarDeltaWithDeadline("prog_count", 1)
 
 	progpath := filepath.Join(progDir, "program.mtail")
 	p := testutil.TestOpenFile(t, progpath)
@@ -109,12 +110,15 @@ func TestProgramUnloadIfDeleted(t *testing.T) {
 	m.PollWatched(0)
 
 	progLoadsTotalCheck()
+	progCountCheck()
 
 	progUnloadsTotalCheck := m.ExpectMapExpvarDeltaWithDeadline("prog_unloads_total", "program.mtail", 1)
+	progCountCheck = m.ExpectExpvarDeltaWithDeadline("prog_count", -1)
 
 	testutil.FatalIfErr(t, os.Remove(progpath))
 
 	m.PollWatched(1)
 
 	progUnloadsTotalCheck()
+	progCountCheck()
 }
@@ -45,11 +45,19 @@ func TestMakeServer(tb testing.TB, wakers int, options ...Option) *TestServer {
 	glog.Info("resetting counters")
 	expvar.Get("lines_total").(*expvar.Int).Set(0)
 	expvar.Get("log_count").(*expvar.Int).Set(0)
+	expvar.Get("prog_count").(*expvar.Int).Set(0)
 	expvar.Get("log_lines_total").(*expvar.Map).Init()
+	expvar.Get("log_bytes_total").(*expvar.Map).Init()
 	expvar.Get("log_opens_total").(*expvar.Map).Init()
 	expvar.Get("log_closes_total").(*expvar.Map).Init()
-	expvar.Get("file_truncates_total").(*expvar.Map).Init()
+	expvar.Get("log_truncations_total").(*expvar.Map).Init()
+	expvar.Get("log_removals_total").(*expvar.Map).Init()
+	expvar.Get("log_discoveries_total").(*expvar.Int).Set(0)
+	expvar.Get("log_retirements_total").(*expvar.Int).Set(0)
 	expvar.Get("prog_loads_total").(*expvar.Map).Init()
+	expvar.Get("prog_last_load_timestamp_seconds").(*expvar.Map).Init()
+	expvar.Get("prog_last_load_error").(*expvar.Map).Init()
+	expvar.Get("prog_compile_duration_seconds").(*expvar.Map).Init()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	waker, awaken := waker.NewTest(ctx, wakers)
@@ -0,0 +1,92 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// certReloader serves the certificate and key in a pair of files, and loads
+// them again when either file changes, so that a renewed certificate is used
+// without restarting mtail.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time // modification time of certFile when cert was loaded
+	keyTime  time.Time // modification time of keyFile when cert was loaded
+
+	failedCertTime time.Time // modification time of certFile when loading last failed
+	failedKeyTime  time.Time // modification time of keyFile when loading last failed
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// modTimes returns the modification times of the certificate and key files.
+func (c *certReloader) modTimes() (certTime, keyTime time.Time, err error) {
+	fi, err := os.Stat(c.certFile)
+	if err != nil {
+		return
+	}
+	certTime = fi.ModTime()
+	fi, err = os.Stat(c.keyFile)
+	if err != nil {
+		return
+	}
+	keyTime = fi.ModTime()
+	return
+}
+
+// reload loads the certificate and key.  The caller must hold c.mu, or be
+// the constructor.
+func (c *certReloader) reload() error {
+	certTime, keyTime, err := c.modTimes()
+	if err != nil {
+		return errors.Wrap(err, "loading TLS certificate")
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "loading TLS certificate")
+	}
+	c.cert, c.certTime, c.keyTime = &cert, certTime, keyTime
+	return nil
+}
+
+// GetCertificate returns the certificate for a TLS handshake, first loading
+// it again if its files have changed since it was last loaded.  If it can't
+// be loaded, for example because only one of the pair has been replaced so
+// far, the previous certificate is kept, and the files aren't loaded again
+// until one of them changes once more.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	certTime, keyTime, err := c.modTimes()
+	if err != nil {
+		return c.cert, nil
+	}
+	changed := !certTime.Equal(c.certTime) || !keyTime.Equal(c.keyTime)
+	failed := certTime.Equal(c.failedCertTime) && keyTime.Equal(c.failedKeyTime)
+	if changed && !failed {
+		if err := c.reload(); err != nil {
+			glog.Warning(err)
+			c.failedCertTime, c.failedKeyTime = certTime, keyTime
+		} else {
+			glog.Infof("Reloaded TLS certificate from %s", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+// writeTestCert writes a self-signed certificate for name, and its key, to
+// certFile and keyFile.
+func writeTestCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.FatalIfErr(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	testutil.FatalIfErr(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+}
+
+// certName returns the common name of the certificate c.
+func certName(t *testing.T, c *tls.Certificate) string {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	testutil.FatalIfErr(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	c, err := newCertReloader(certFile, keyFile)
+	testutil.FatalIfErr(t, err)
+	cert, err := c.GetCertificate(nil)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "first", certName(t, cert))
+
+	// A renewed certificate is loaded at the next handshake.
+	writeTestCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	testutil.FatalIfErr(t, os.Chtimes(certFile, later, later))
+	cert, err = c.GetCertificate(nil)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "second", certName(t, cert))
+
+	// A broken pair keeps the previous certificate.
+	testutil.FatalIfErr(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	broken := later.Add(time.Minute)
+	testutil.FatalIfErr(t, os.Chtimes(keyFile, broken, broken))
+	cert, err = c.GetCertificate(nil)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "second", certName(t, cert))
+
+	// The pair that failed isn't loaded again until one of its files changes.
+	writeTestCert(t, certFile, keyFile, "third")
+	testutil.FatalIfErr(t, os.Chtimes(certFile, later, later))
+	testutil.FatalIfErr(t, os.Chtimes(keyFile, broken, broken))
+	cert, err = c.GetCertificate(nil)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "second", certName(t, cert))
+	fixed := broken.Add(time.Minute)
+	testutil.FatalIfErr(t, os.Chtimes(keyFile, fixed, fixed))
+	cert, err = c.GetCertificate(nil)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, "third", certName(t, cert))
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "mtail")
+	sock := filepath.Join(dir, "mtail.sock")
+
+	_, stopM := TestStartServer(t, 0, BindUnixSocket(sock), TLSCertificate(certFile, keyFile))
+	defer stopM()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+		// #nosec G402 -- the test certificate is self-signed.
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://mtail/metrics")
+	testutil.FatalIfErr(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %s", resp.Status)
+	}
+	if resp.TLS == nil || resp.TLS.PeerCertificates[0].Subject.CommonName != "mtail" {
+		t.Errorf("not served with the certificate: %v", resp.TLS)
+	}
+
+	if _, err := New(context.Background(), nil, TLSCertificate(certFile, "")); err != ErrTLSCertificatePair {
+		t.Errorf("half a pair: got %v, want %v", err, ErrTLSCertificatePair)
+	}
+}
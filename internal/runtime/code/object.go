@@ -11,8 +11,10 @@ import (
 
 // Object is the data and bytecode resulting from compiled program source.
 type Object struct {
-	Program []Instr           // The program bytecode.
-	Strings []string          // Static strings.
-	Regexps []*regexp.Regexp  // Static regular expressions.
-	Metrics []*metrics.Metric // Metrics accessible to this program.
+	Program  []Instr           // The program bytecode.
+	Strings  []string          // Static strings.
+	Regexps  []*regexp.Regexp  // Static regular expressions.
+	Literals []string          // A substring that a match of each regular expression must contain, or empty if it has none.
+	Metrics  []*metrics.Metric // Metrics accessible to this program.
+	Includes []string          // Pathnames of the files included by the program source.
 }
@@ -44,6 +44,7 @@ const (
 	Fget                     // Pop a datum off the stack, and push its float value back on the stack.
 	Sget                     // Pop a datum off the stack, and push its string value back on the stack.
 	Tolower                  // Convert the string at the top of the stack to lowercase.
+	Toupper                  // Convert the string at the top of the stack to uppercase.
 	Length                   // Compute the length of a string.
 	Cat                      // string concatenation
 	Setmatched               // Set "matched" flag
@@ -61,6 +62,7 @@ const (
 	Fset // Floating point assignment
 
 	Getfilename // Push input.Filename onto the stack.
+	Getfield    // Push the structured field of the input named by operand onto the stack.
 
 	// Conversions.
 	I2f // int to float
@@ -77,6 +79,9 @@ const (
 	// String opcodes.
 	Subst
 	Rsubst
+	Substr      // Push the substring of a string given its start and length.
+	Jsonfield   // Push the field of a JSON string at a dotted path.
+	Logfmtfield // Push the value of a key in a logfmt string.
 
 	lastOpcode
 )
@@ -117,6 +122,7 @@ var opNames = map[Opcode]string{
 	Fget:        "fget",
 	Sget:        "sget",
 	Tolower:     "tolower",
+	Toupper:     "toupper",
 	Length:      "length",
 	Cat:         "cat",
 	Setmatched:  "setmatched",
@@ -130,6 +136,7 @@ var opNames = map[Opcode]string{
 	Fpow:        "fpow",
 	Fset:        "fset",
 	Getfilename: "getfilename",
+	Getfield:    "getfield",
 	I2f:         "i2f",
 	S2i:         "s2i",
 	S2f:         "s2f",
@@ -140,6 +147,9 @@ var opNames = map[Opcode]string{
 	Scmp:        "scmp",
 	Subst:       "subst",
 	Rsubst:      "rsubst",
+	Substr:      "substr",
+	Jsonfield:   "jsonfield",
+	Logfmtfield: "logfmtfield",
 }
 
 func (o Opcode) String() string {
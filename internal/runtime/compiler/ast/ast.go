@@ -209,9 +209,13 @@ type VarDecl struct {
 	Hidden       bool
 	Keys         []string
 	Limit        int64
+	Expiry       time.Duration
+	Window       time.Duration
 	Buckets      []float64
+	Quantiles    []float64
 	Kind         metrics.Kind
 	ExportedName string
+	Help         string
 	Symbol       *symbol.Symbol
 }
 
@@ -222,6 +226,8 @@ func (n *VarDecl) Pos() *position.Position {
 func (n *VarDecl) Type() types.Type {
 	if n.Kind == metrics.Histogram {
 		return types.Buckets
+	} else if n.Kind == metrics.Summary {
+		return types.Quantiles
 	} else if n.Symbol != nil {
 		return n.Symbol.Type
 	}
@@ -433,6 +439,19 @@ func (n *StopStmt) Type() types.Type {
 	return types.None
 }
 
+type IncludeStmt struct {
+	P    position.Position
+	Path string
+}
+
+func (n *IncludeStmt) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *IncludeStmt) Type() types.Type {
+	return types.None
+}
+
 // mergepositionlist is a helper that merges the positions of all the nodes in a list.
 func mergepositionlist(l []Node) *position.Position {
 	if len(l) == 0 {
@@ -6,6 +6,7 @@ package checker
 import (
 	goerrors "errors"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -16,11 +17,13 @@ import (
 	"github.com/google/mtail/internal/runtime/compiler/parser"
 	"github.com/google/mtail/internal/runtime/compiler/symbol"
 	"github.com/google/mtail/internal/runtime/compiler/types"
+	"github.com/google/mtail/internal/runtime/strftime"
 )
 
 const (
 	defaultMaxRegexpLength   = 1024
 	defaultMaxRecursionDepth = 100
+	defaultMaxCaptures       = 100
 )
 
 // checker holds data for a semantic checker.
@@ -35,13 +38,16 @@ type checker struct {
 	tooDeep           bool
 	maxRecursionDepth int
 	maxRegexLength    int
+	maxCaptures       int
+	allowRiskyRegex   bool
 }
 
 // Check performs a semantic check of the astNode, and returns a potentially
 // modified astNode and either a list of errors found, or nil if the program is
 // semantically valid.  At the completion of Check, the symbol table and type
-// annotation are also complete.
-func Check(node ast.Node, maxRegexpLength int, maxRecursionDepth int) (ast.Node, error) {
+// annotation are also complete.  Patterns with nested unbounded repetitions
+// are errors, unless allowRiskyRegex is set, when they are warned about.
+func Check(node ast.Node, maxRegexpLength int, maxRecursionDepth int, maxCaptures int, allowRiskyRegex bool) (ast.Node, error) {
 	// set defaults
 	if maxRegexpLength == 0 {
 		maxRegexpLength = defaultMaxRegexpLength
@@ -49,15 +55,37 @@ func Check(node ast.Node, maxRegexpLength int, maxRecursionDepth int) (ast.Node,
 	if maxRecursionDepth == 0 {
 		maxRecursionDepth = defaultMaxRecursionDepth
 	}
+	if maxCaptures == 0 {
+		maxCaptures = defaultMaxCaptures
+	}
 
-	c := &checker{maxRegexLength: maxRegexpLength, maxRecursionDepth: maxRecursionDepth}
+	c := &checker{maxRegexLength: maxRegexpLength, maxRecursionDepth: maxRecursionDepth, maxCaptures: maxCaptures, allowRiskyRegex: allowRiskyRegex}
 	node = ast.Walk(c, node)
 	if len(c.errors) > 0 {
 		return node, c.errors
 	}
+	node = ast.Walk(intDefaulter{}, node)
 	return node, nil
 }
 
+// intDefaulter binds the types left unknown after checking to Int, such as
+// that of a metric that is only ever incremented, as a Float would have been
+// inferred from the program by now.
+type intDefaulter struct{}
+
+func (d intDefaulter) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
+	if t := node.Type(); t != nil {
+		if v, ok := t.Root().(*types.Variable); ok {
+			v.SetInstance(types.Int)
+		}
+	}
+	return d, node
+}
+
+func (d intDefaulter) VisitAfter(node ast.Node) ast.Node {
+	return node
+}
+
 // VisitBefore performs most of the symbol table construction, so that symbols
 // are guaranteed to exist before their use.
 func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
@@ -81,11 +109,28 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		n.Scope = symbol.NewScope(c.scope)
 		c.scope = n.Scope
 		glog.V(2).Infof("Created new scope %v in condstmt", n.Scope)
-		return c, n
+		if n.Cond != nil {
+			n.Cond = ast.Walk(c, n.Cond)
+		}
+		n.Truth = ast.Walk(c, n.Truth)
+		// The else block only runs when the condition doesn't hold, so
+		// capture groups defined by the condition aren't visible in it.
+		if n.Else != nil {
+			c.scope = n.Scope.Parent
+			n.Else = ast.Walk(c, n.Else)
+			c.scope = n.Scope
+		}
+		return nil, c.VisitAfter(n)
 
 	case *ast.CaprefTerm:
 		if n.Symbol == nil {
 			sym := c.scope.Lookup(n.Name, symbol.CaprefSymbol)
+			if t, ok := types.Fields[n.Name]; sym == nil && ok && n.IsNamed {
+				// A field of the log line, which has no regular expression
+				// bound to it.
+				sym = symbol.NewSymbol(n.Name, symbol.CaprefSymbol, n.Pos())
+				sym.Type = t
+			}
 			if sym == nil {
 				msg := fmt.Sprintf("Capture group `$%s' was not defined by a regular expression visible to this scope.", n.Name)
 				if n.IsNamed {
@@ -105,6 +150,7 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 
 	case *ast.VarDecl:
 		n.Symbol = symbol.NewSymbol(n.Name, symbol.VarSymbol, n.Pos())
+		n.Symbol.Binding = n
 		if alt := c.scope.Insert(n.Symbol); alt != nil {
 			c.errors.Add(n.Pos(), fmt.Sprintf("Redeclaration of metric `%s' previously declared at %s", n.Name, alt.Pos))
 			c.depth--
@@ -112,7 +158,7 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		}
 		var rType types.Type
 		switch n.Kind {
-		case metrics.Counter, metrics.Gauge, metrics.Timer, metrics.Histogram:
+		case metrics.Counter, metrics.Gauge, metrics.Timer, metrics.Histogram, metrics.Summary:
 			// TODO(jaq): This should be a numeric type, unless we want to
 			// enforce more specific rules like "Counter can only be Int."
 			rType = types.NewVariable()
@@ -128,6 +174,58 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			c.depth--
 			return nil, n
 		}
+		if len(n.Quantiles) > 0 && n.Kind != metrics.Summary {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Can't specify quantiles for non-summary metric `%s'.", n.Name))
+			c.depth--
+			return nil, n
+		}
+		if n.Kind == metrics.Summary {
+			if len(n.Quantiles) < 1 {
+				c.errors.Add(n.Pos(), fmt.Sprintf("Summary `%s' needs at least one quantile.", n.Name))
+				c.depth--
+				return nil, n
+			}
+			for i, q := range n.Quantiles {
+				// Written to also reject NaN, which compares false to everything.
+				if !(q > 0 && q < 1) {
+					c.errors.Add(n.Pos(), fmt.Sprintf("Quantiles of summary `%s' must be between 0 and 1, not %g.", n.Name, q))
+					c.depth--
+					return nil, n
+				}
+				if i > 0 && !(q > n.Quantiles[i-1]) {
+					c.errors.Add(n.Pos(), fmt.Sprintf("Quantiles of summary `%s' must be in increasing order, but %g follows %g.", n.Name, q, n.Quantiles[i-1]))
+					c.depth--
+					return nil, n
+				}
+			}
+		}
+		if n.Window > 0 {
+			if n.Kind != metrics.Counter {
+				c.errors.Add(n.Pos(), fmt.Sprintf("Can't specify a rate window for non-counter metric `%s'.", n.Name))
+				c.depth--
+				return nil, n
+			}
+			if n.Window < time.Second || n.Window%time.Second != 0 {
+				c.errors.Add(n.Pos(), fmt.Sprintf("Rate window of counter `%s' must be a whole number of seconds, not %s.", n.Name, n.Window))
+				c.depth--
+				return nil, n
+			}
+		}
+		if n.Kind == metrics.Histogram {
+			if len(n.Buckets) < 2 {
+				c.errors.Add(n.Pos(), fmt.Sprintf("Histogram `%s' needs at least two bucket boundaries.", n.Name))
+				c.depth--
+				return nil, n
+			}
+			for i := 1; i < len(n.Buckets); i++ {
+				// Written to also reject NaN, which compares false to everything.
+				if !(n.Buckets[i] > n.Buckets[i-1]) {
+					c.errors.Add(n.Pos(), fmt.Sprintf("Bucket boundaries of histogram `%s' must be in increasing order, but %g follows %g.", n.Name, n.Buckets[i], n.Buckets[i-1]))
+					c.depth--
+					return nil, n
+				}
+			}
+		}
 		if len(n.Keys) > 0 {
 			// One type per key
 			keyTypes := make([]types.Type, 0, len(n.Keys))
@@ -232,6 +330,11 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		return c, n
 
 	case *ast.DelStmt:
+		// An unindexed metric names all of its data, so it doesn't need its
+		// full set of keys.
+		if ix, ok := n.N.(*ast.IndexedExpr); ok && len(ix.Index.(*ast.ExprList).Children) == 0 {
+			n.N = ix.LHS
+		}
 		n.N = ast.Walk(c, n.N)
 		return c, n
 	}
@@ -251,7 +354,7 @@ func (c *checker) checkSymbolTable() {
 					// Don't warn about the zeroth capture group; it's not user-defined.
 					continue
 				}
-				glog.Infof("capture group reference `%s' at %s appears to be unused", sym.Name, sym.Pos)
+				glog.Warningf("capture group reference `%s' at %s appears to be unused; a non-capturing group `(?:...)' is faster", sym.Name, sym.Pos)
 				continue
 			}
 			c.errors.Add(sym.Pos, fmt.Sprintf("Declaration of %s `%s' here is never used.", sym.Kind, sym.Name))
@@ -459,6 +562,11 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 
 			// First handle the Tl <= Tr and vice versa.
 			t := types.LeastUpperBound(lT, rT)
+			// A string compared with a number, such as a capture group
+			// compared with a threshold, is compared as a number.
+			if types.Equals(t, types.String) && (isNumeric(lT) || isNumeric(rT)) {
+				t = types.Float
+			}
 			var err *types.TypeError
 			if types.AsTypeError(t, &err) {
 				if goerrors.Is(err, types.ErrTypeMismatch) {
@@ -495,11 +603,21 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				glog.V(2).Infof("Emitting convnode %+v", conv)
 			}
 
-		case parser.ASSIGN, parser.ADD_ASSIGN:
-			// e1 = e2; e1 += e2
+		case parser.ASSIGN, parser.ADD_ASSIGN, parser.SUB_ASSIGN:
+			// e1 = e2; e1 += e2; e1 -= e2
 			// O ⊢ e1 : Tl, O ⊢ e2 : Tr
 			// Tr <= Tl
 			// ⇒ O ⊢ e : Tl
+			//
+			// Numeric metrics can't hold strings, so a string value is
+			// converted to a number when assigned, failing at runtime if it
+			// doesn't parse.
+			if isNumericMetric(n.LHS) && types.Equals(rT, types.String) {
+				conv := &ast.ConvExpr{N: n.RHS}
+				conv.SetType(types.Float)
+				n.RHS = conv
+				rT = types.Float
+			}
 			rType = lT
 			// TODO(jaq): the rT <= lT relationship is not correctly encoded here.
 			t := types.LeastUpperBound(lT, rT)
@@ -523,6 +641,10 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				n.SetType(types.Error)
 				return n
 			}
+			if n.Op == parser.SUB_ASSIGN && !c.checkDecrement(n, n.LHS) {
+				n.SetType(types.Error)
+				return n
+			}
 
 		case parser.MATCH, parser.NOT_MATCH:
 			// e1 =~ e2, e1 !~ e2
@@ -599,6 +721,18 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				n.SetType(types.Error)
 				return n
 			}
+			if n.Op == parser.DEC && !c.checkDecrement(n, n.Expr) {
+				n.SetType(types.Error)
+				return n
+			}
+
+			// A variable whose type is not yet known may still be
+			// inferred as a Float by a later assignment, so leave it
+			// unbound; intDefaulter binds it to Int otherwise.
+			if _, ok := n.Expr.Type().Root().(*types.Variable); ok {
+				rType = n.Expr.Type()
+				break
+			}
 
 			rType = types.NewVariable()
 			wantType := types.Function(types.Int, types.Int)
@@ -617,9 +751,12 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				n.SetType(types.InternalError)
 				return n
 			}
-			// After unification, the expr still has to be of Int type.
-			if !types.OccursIn(types.Int, []types.Type{uTypeOperator.Args[0]}) {
-				c.errors.Add(n.Expr.Pos(), fmt.Sprintf("type mismatch: expecting an Int for %s, not %v.", parser.Kind(n.Op), n.Expr.Type()))
+			// After unification, the expr still has to be of Int type, or
+			// Float if it was already inferred to be.
+			if types.Equals(n.Expr.Type(), types.Float) {
+				rType = types.Float
+			} else if !types.OccursIn(types.Int, []types.Type{uTypeOperator.Args[0]}) {
+				c.errors.Add(n.Expr.Pos(), fmt.Sprintf("type mismatch: expecting an Int or Float for %s, not %v.", parser.Kind(n.Op), n.Expr.Type()))
 				n.SetType(types.Error)
 				return n
 			}
@@ -779,6 +916,11 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 
 		gotType := types.Function(argTypes...)
 		wantType := types.FreshType(types.Builtins[n.Name])
+		if want, ok := wantType.(*types.Operator); ok && len(want.Args) != len(gotType.Args) {
+			c.errors.Add(n.Pos(), fmt.Sprintf("call to `%s': expecting %d arguments, not %d.", n.Name, len(want.Args)-1, len(gotType.Args)-1))
+			n.SetType(types.Error)
+			return n
+		}
 		uType := types.Unify(wantType, gotType)
 		var err *types.TypeError
 		if types.AsTypeError(uType, &err) {
@@ -803,27 +945,48 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 			// defined at compile time, we can verify it can be use as a format
 			// string by parsing itself.
 			if f, ok := n.Args.(*ast.ExprList).Children[1].(*ast.StringLit); ok {
-				// Layout strings can contain an underscore to indicate a digit
-				// field if the layout field can contain two digits; but they
-				// won't parse themselves.  Zulu Timezones in the layout need
-				// to be converted to offset in the parsed time.
-				timeStr := strings.ReplaceAll(strings.ReplaceAll(f.Text, "_", ""), "Z", "+")
-				glog.V(2).Infof("time_str is %q", timeStr)
-				_, err := time.Parse(f.Text, timeStr)
-				if err != nil {
-					glog.Infof("time.Parse(%q, %q) failed: %s", f.Text, timeStr, err)
-					c.errors.Add(f.Pos(), fmt.Sprintf("invalid time format string %q\n\tRefer to the documentation at https://golang.org/pkg/time/#pkg-constants for advice.", f.Text))
-					n.SetType(types.Error)
-					return n
+				// The VM parses strftime-style formats itself, so they only
+				// need their conversions checked.
+				if strftime.IsFormat(f.Text) {
+					if _, err := strftime.Compile(f.Text); err != nil {
+						c.errors.Add(f.Pos(), err.Error())
+						n.SetType(types.Error)
+						return n
+					}
+				} else {
+					// Layout strings can contain an underscore to indicate a digit
+					// field if the layout field can contain two digits; but they
+					// won't parse themselves.  Zulu Timezones in the layout need
+					// to be converted to offset in the parsed time.
+					timeStr := strings.ReplaceAll(strings.ReplaceAll(f.Text, "_", ""), "Z", "+")
+					glog.V(2).Infof("time_str is %q", timeStr)
+					_, err := time.Parse(f.Text, timeStr)
+					if err != nil {
+						glog.Infof("time.Parse(%q, %q) failed: %s", f.Text, timeStr, err)
+						c.errors.Add(f.Pos(), fmt.Sprintf("invalid time format string %q\n\tRefer to the documentation at https://golang.org/pkg/time/#pkg-constants for advice.", f.Text))
+						n.SetType(types.Error)
+						return n
+					}
 				}
 			} else {
 				c.errors.Add(n.Pos(), "Internal error: exprlist child is not string literal.")
 				return n
 			}
 
-		case "tolower":
+		case "getenv":
+			// The environment is read when the program is loaded, so that its
+			// value is a constant of the program.
+			name, ok := n.Args.(*ast.ExprList).Children[0].(*ast.StringLit)
+			if !ok {
+				c.errors.Add(n.Args.(*ast.ExprList).Children[0].Pos(), "Expecting a string constant for argument 1 of getenv().")
+				n.SetType(types.Error)
+				return n
+			}
+			return &ast.StringLit{P: n.P, Text: os.Getenv(name.Text)}
+
+		case "tolower", "toupper":
 			if !types.Equals(gotType.Args[0], types.String) {
-				c.errors.Add(n.Args.(*ast.ExprList).Children[0].Pos(), fmt.Sprintf("Expecting a String for argument 1 of tolower(), not %v.", gotType.Args[0]))
+				c.errors.Add(n.Args.(*ast.ExprList).Children[0].Pos(), fmt.Sprintf("Expecting a String for argument 1 of %s(), not %v.", n.Name, gotType.Args[0]))
 				n.SetType(types.Error)
 				return n
 			}
@@ -853,11 +1016,16 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 
 	case *ast.DelStmt:
 		if ix, ok := n.N.(*ast.IndexedExpr); ok {
-			if len(ix.Index.(*ast.ExprList).Children) == 0 {
-				c.errors.Add(n.N.Pos(), "Cannot delete this.\n\tTry deleting an index from this dimensioned metric.")
+			ix.LHS.(*ast.IDTerm).Lvalue = true
+			return n
+		}
+		if id, ok := n.N.(*ast.IDTerm); ok && id.Symbol != nil && id.Symbol.Kind == symbol.VarSymbol {
+			// del m removes every datum of the metric.
+			if n.Expiry > 0 {
+				c.errors.Add(n.N.Pos(), "Cannot expire all of a metric at once.\n\tTry deleting an index from this dimensioned metric.")
 				return n
 			}
-			ix.LHS.(*ast.IDTerm).Lvalue = true
+			id.Lvalue = true
 			return n
 		}
 		c.errors.Add(n.N.Pos(), "Cannot delete this.\n\tTry deleting from a dimensioned metric with this as an index.")
@@ -866,6 +1034,47 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 	return node
 }
 
+// isNumeric returns true if t is an integer or floating point type.
+func isNumeric(t types.Type) bool {
+	return types.Equals(t, types.Int) || types.Equals(t, types.Float)
+}
+
+// isNumericMetric returns true if n names a metric that holds a number.
+func isNumericMetric(n ast.Node) bool {
+	if v, ok := n.(*ast.IndexedExpr); ok {
+		n = v.LHS
+	}
+	id, ok := n.(*ast.IDTerm)
+	if !ok || id.Symbol == nil {
+		return false
+	}
+	decl, ok := id.Symbol.Binding.(*ast.VarDecl)
+	return ok && decl.Kind != metrics.Text
+}
+
+// checkDecrement reports an error at n if the metric named by the
+// expression e can't go down.  Counters only ever increase, so only gauges
+// and timers can be decremented.
+func (c *checker) checkDecrement(n, e ast.Node) bool {
+	if v, ok := e.(*ast.IndexedExpr); ok {
+		e = v.LHS
+	}
+	id, ok := e.(*ast.IDTerm)
+	if !ok || id.Symbol == nil {
+		return true
+	}
+	decl, ok := id.Symbol.Binding.(*ast.VarDecl)
+	if !ok {
+		return true
+	}
+	switch decl.Kind {
+	case metrics.Gauge, metrics.Timer:
+		return true
+	}
+	c.errors.Add(n.Pos(), fmt.Sprintf("Can't decrement %s `%s'; only gauges and timers can be decremented.", strings.ToLower(decl.Kind.String()), id.Name))
+	return false
+}
+
 // checkRegex is a helper method to compile and check a regular expression, and
 // to generate its capture groups as symbols.
 func (c *checker) checkRegex(pattern string, n ast.Node) {
@@ -875,6 +1084,18 @@ func (c *checker) checkRegex(pattern string, n ast.Node) {
 		return
 	}
 	if reAst, err := types.ParseRegexp(pattern); err == nil {
+		if ncap := reAst.MaxCap(); ncap > c.maxCaptures {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Exceeded maximum number of capture groups in a pattern of %d with %d.\n\tEach capture group slows down matching; use a non-capturing group `(?:...)' for those not referred to.", c.maxCaptures, ncap))
+			return
+		}
+		if hasNestedRepeat(reAst) {
+			msg := fmt.Sprintf("Pattern `%s' has a nested unbounded repetition, which can match the same text in exponentially many ways.\n\tRewrite it so that each repetition must match different text, or use --allow_risky_regex.", pattern)
+			if c.allowRiskyRegex {
+				glog.Warningf("%s: %s", n.Pos(), msg)
+			} else {
+				c.errors.Add(n.Pos(), msg)
+			}
+		}
 		// We reserve the names of the capturing groups as declarations
 		// of those symbols, so that future CAPREF tokens parsed can
 		// retrieve their value.  By recording them in the symbol table, we
@@ -127,7 +127,14 @@ counter bar by a, b
 	  timestamp()
 	}
 	`,
-		[]string{"builtin parameter mismatch:2:4-13: call to `strptime': type mismatch; expected String→String→None received incomplete type"},
+		[]string{"builtin parameter mismatch:2:4-13: call to `strptime': expecting 2 arguments, not 0."},
+	},
+
+	{
+		"bad strftime conversion",
+		`strptime("2017-10-16", "%Y-%m-%Q")
+`,
+		[]string{"bad strftime conversion:1:24-33: unsupported conversion %Q in strftime format \"%Y-%m-%Q\""},
 	},
 
 	{
@@ -203,6 +210,33 @@ const ID /bar/
 		[]string{"def with two nexts:6:5-8: Can't use `next' statement twice in a decorator."},
 	},
 
+	{
+		"histogram with one bucket boundary",
+		`histogram foo buckets 1
+/(\d+)/ {
+  foo = $1
+}`,
+		[]string{"histogram with one bucket boundary:1:11-13: Histogram `foo' needs at least two bucket boundaries."},
+	},
+
+	{
+		"histogram with unsorted buckets",
+		`histogram foo buckets 0.1, 5, 1
+/(\d+)/ {
+  foo = $1
+}`,
+		[]string{"histogram with unsorted buckets:1:11-13: Bucket boundaries of histogram `foo' must be in increasing order, but 1 follows 5."},
+	},
+
+	{
+		"histogram with repeated bucket",
+		`histogram foo buckets 1, 1
+/(\d+)/ {
+  foo = $1
+}`,
+		[]string{"histogram with repeated bucket:1:11-13: Bucket boundaries of histogram `foo' must be in increasing order, but 1 follows 1."},
+	},
+
 	{
 		"counter with buckets",
 		`counter foo buckets 1, 2, 3
@@ -212,6 +246,60 @@ foo = $1
 		[]string{"counter with buckets:1:9-11: Can't specify buckets for non-histogram metric `foo'."},
 	},
 
+	{
+		"histogram with quantiles",
+		`histogram foo buckets 1, 2 quantiles 0.5
+/(\d)/ {
+foo = $1
+}`,
+		[]string{"histogram with quantiles:1:11-13: Can't specify quantiles for non-summary metric `foo'."},
+	},
+
+	{
+		"summary without quantiles",
+		`summary foo
+/(\d)/ {
+foo = $1
+}`,
+		[]string{"summary without quantiles:1:9-11: Summary `foo' needs at least one quantile."},
+	},
+
+	{
+		"summary quantile out of range",
+		`summary foo quantiles 0.5, 1
+/(\d)/ {
+foo = $1
+}`,
+		[]string{"summary quantile out of range:1:9-11: Quantiles of summary `foo' must be between 0 and 1, not 1."},
+	},
+
+	{
+		"summary quantiles out of order",
+		`summary foo quantiles 0.9, 0.5
+/(\d)/ {
+foo = $1
+}`,
+		[]string{"summary quantiles out of order:1:9-11: Quantiles of summary `foo' must be in increasing order, but 0.5 follows 0.9."},
+	},
+
+	{
+		"gauge with rate",
+		`gauge foo rate over 60s
+/(\d)/ {
+foo = $1
+}`,
+		[]string{"gauge with rate:1:7-9: Can't specify a rate window for non-counter metric `foo'."},
+	},
+
+	{
+		"rate window too short",
+		`counter foo rate over 500ms
+/(\d)/ {
+foo++
+}`,
+		[]string{"rate window too short:1:9-11: Rate window of counter `foo' must be a whole number of seconds, not 500ms."},
+	},
+
 	{
 		"next outside of decorator",
 		`def x{
@@ -232,6 +320,17 @@ next
 		[]string{"use decorator in decorator:2:1-2: Decorator `@x' is not completely defined yet.", "\tTry removing @x from here.", "use decorator in decorator:2:1-2: No symbols found in decorator `@x'.", "\tTry adding a `next' statement inside the `{}' block."},
 	},
 
+	{
+		"capref used in else",
+		`counter c by a
+/(\d+)/ {
+  c[$1]++
+} else {
+  c[$1]++
+}`,
+		[]string{"capref used in else:5:5-6: Capture group `$1' was not defined by a regular expression visible to this scope.", "\tCheck that there are at least 1 pairs of parentheses."},
+	},
+
 	{
 		"delete incorrect object",
 		`/(.*)/ {
@@ -260,7 +359,14 @@ del $0
 		`histogram#
 m del#
 m`,
-		[]string{"delete a histogram:3:7: Cannot delete this.", "\tTry deleting an index from this dimensioned metric."},
+		[]string{"delete a histogram:2:11: Histogram `m' needs at least two bucket boundaries."},
+	},
+
+	{
+		"expire a whole metric",
+		`counter m by a
+del m after 1h`,
+		[]string{"expire a whole metric:2:5: Cannot expire all of a metric at once.", "\tTry deleting an index from this dimensioned metric."},
 	},
 
 	{
@@ -275,6 +381,12 @@ m`,
 		[]string{"regexp too long:1:1-1027: Exceeded maximum regular expression pattern length of 1024 bytes with 1025.", "\tExcessively long patterns are likely to cause compilation and runtime performance problems."},
 	},
 
+	{
+		"too many captures",
+		"/" + strings.Repeat("(c)", 101) + "/ {}",
+		[]string{"too many captures:1:1-305: Exceeded maximum number of capture groups in a pattern of 100 with 101.", "\tEach capture group slows down matching; use a non-capturing group `(?:...)' for those not referred to."},
+	},
+
 	{
 		"strptime invalid args",
 		`strptime("",8)
@@ -282,12 +394,32 @@ m`,
 		[]string{"strptime invalid args:1:13: Expecting a format string for argument 2 of strptime(), not Int."},
 	},
 
+	{
+		"decrement counter",
+		`counter i
+/.*/ {
+  i--
+}
+`,
+		[]string{"decrement counter:3:3-5: Can't decrement counter `i'; only gauges and timers can be decremented."},
+	},
+
+	{
+		"subtract from counter",
+		`counter i
+/(\d+)/ {
+  i -= $1
+}
+`,
+		[]string{"subtract from counter:3:3-9: Can't decrement counter `i'; only gauges and timers can be decremented."},
+	},
+
 	{
 		"inc invalid args",
 		`text l
 l++
 `,
-		[]string{"inc invalid args:2:1: type mismatch: expecting an Int for INC, not String."},
+		[]string{"inc invalid args:2:1: type mismatch: expecting an Int or Float for INC, not String."},
 	},
 
 	{
@@ -312,6 +444,33 @@ l++=l
 		[]string{"tolower non string:1:9: Expecting a String for argument 1 of tolower(), not Int."},
 	},
 
+	{
+		"getenv non constant",
+		`counter r by c
+/(.)/ {
+  r[getenv($1)]++
+}
+`,
+		[]string{"getenv non constant:3:12-13: Expecting a string constant for argument 1 of getenv()."},
+	},
+
+	{
+		"substr too few arguments",
+		`substr("foo", 1)
+`,
+		[]string{"substr too few arguments:1:1-16: call to `substr': expecting 3 arguments, not 2."},
+	},
+
+	{
+		"toupper numeric capture",
+		`counter r by m
+/(\d+)/ {
+  r[toupper($1)]++
+}
+`,
+		[]string{"toupper numeric capture:3:13-14: Expecting a String for argument 1 of toupper(), not Int."},
+	},
+
 	{
 		"dec non var",
 		`strptime("", "")--
@@ -338,6 +497,19 @@ l++=l
 		[]string{"negate None:1:2-17: type mismatch; expected Int received None for `~' operator."},
 	},
 
+	{
+		"nested repetition",
+		`/(a+)+$/ {}
+/x(\w+\s?)*y/ {}
+`,
+		[]string{
+			"nested repetition:1:1-8: Pattern `(a+)+$' has a nested unbounded repetition, which can match the same text in exponentially many ways.",
+			"\tRewrite it so that each repetition must match different text, or use --allow_risky_regex.",
+			"nested repetition:2:1-13: Pattern `x(\\w+\\s?)*y' has a nested unbounded repetition, which can match the same text in exponentially many ways.",
+			"\tRewrite it so that each repetition must match different text, or use --allow_risky_regex.",
+		},
+	},
+
 	// 	{"match against gauge",
 	// 		`gauge t
 	// t = 6 =~ t
@@ -352,7 +524,7 @@ func TestCheckInvalidPrograms(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			ast, err := parser.Parse(tc.name, strings.NewReader(tc.program))
 			testutil.FatalIfErr(t, err)
-			ast, err = checker.Check(ast, 0, 0)
+			ast, err = checker.Check(ast, 0, 0, 0, false)
 			if err == nil {
 				s := parser.Sexp{}
 				s.EmitTypes = true
@@ -486,6 +658,12 @@ counter test
   }
 }`},
 
+	{"syslog fields", `
+counter c by host
+$syslog_severity <= 3 && $syslog_facility == 4 {
+  c[$syslog_hostname]++
+}`},
+
 	{"capref used in def", `
 /(?P<x>\d+)/ && $x > 0 {
 }`},
@@ -539,9 +717,14 @@ text foo
 `},
 
 	{"decrement", `
-counter i
+gauge i
 /.*/ {
   i--
+}`},
+	{"subtract from gauge", `
+gauge balance
+/(?P<v>\d+)/ {
+  balance -= $v
 }`},
 	{"stop", `
 stop
@@ -577,6 +760,51 @@ foo = subst(",", "", $1)
 	{"regexp subst", `
 subst(/\d+/, "d", "1234")
 `},
+	{"json_field", `
+counter status by code
+counter bytes_total
+/^{.*}$/ {
+  status[json_field($0, "response.code")]++
+  bytes_total += int(json_field($0, "response.bytes"))
+}`},
+	{"logfmt_field", `
+counter requests by level
+/^(?P<line>.*)$/ {
+  requests[logfmt_field($line, "level")]++
+}`},
+	{"substr", `
+counter class by c
+/(\d+)/ {
+  class[substr($1, 0, 1)]++
+}`},
+	{"strftime format", `
+/^(\S+ \S+)/ {
+  strptime($1, "%Y-%m-%d %H:%M:%S")
+}
+`},
+	{"delete whole metric", `
+counter m by a
+/x/ {
+  del m
+}
+`},
+	{
+		"inc float counter",
+		`counter kb_total
+/one/ {
+  kb_total++
+}
+/(?P<kb>\d+\.\d+) kb/ {
+  kb_total += $kb
+}
+`,
+	},
+
+	{
+		"repetition of distinct text",
+		`/(\d+\.)+\d+/ {}
+`,
+	},
 }
 
 func TestCheckValidPrograms(t *testing.T) {
@@ -585,7 +813,7 @@ func TestCheckValidPrograms(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			ast, err := parser.Parse(tc.name, strings.NewReader(tc.program))
 			testutil.FatalIfErr(t, err)
-			ast, err = checker.Check(ast, 0, 0)
+			ast, err = checker.Check(ast, 0, 0, 0, false)
 			if *checkerTestDebug {
 				s := parser.Sexp{}
 				s.EmitTypes = true
@@ -636,7 +864,7 @@ func TestCheckTypeExpressions(t *testing.T) {
 	for _, tc := range checkerTypeExpressionTests {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			ast, err := checker.Check(tc.expr, 0, 0)
+			ast, err := checker.Check(tc.expr, 0, 0, 0, false)
 			testutil.FatalIfErr(t, err)
 
 			if !testutil.ExpectNoDiff(t, tc.expected, ast.Type().Root()) {
@@ -647,3 +875,10 @@ func TestCheckTypeExpressions(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckAllowRiskyRegex(t *testing.T) {
+	ast, err := parser.Parse("risky", strings.NewReader("/(a+)+$/ {}\n"))
+	testutil.FatalIfErr(t, err)
+	_, err = checker.Check(ast, 0, 0, 0, true)
+	testutil.FatalIfErr(t, err)
+}
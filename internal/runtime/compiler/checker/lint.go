@@ -0,0 +1,236 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package checker
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+
+	"github.com/google/mtail/internal/runtime/compiler/ast"
+	"github.com/google/mtail/internal/runtime/compiler/position"
+	"github.com/google/mtail/internal/runtime/compiler/symbol"
+	"github.com/google/mtail/internal/runtime/compiler/types"
+)
+
+// Severity is how likely a lint finding is to be a mistake.
+type Severity int
+
+const (
+	// Warning findings are likely mistakes, or make the program slower.
+	Warning Severity = iota
+	// Error findings are parts of the program that can never work.
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	default:
+		panic("unexpected severity")
+	}
+}
+
+// Finding is a problem in a program found by Lint.
+type Finding struct {
+	Pos      position.Position
+	Severity Severity
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Pos, f.Severity, f.Message)
+}
+
+// linter collects the findings of Lint while walking the program.
+type linter struct {
+	findings []Finding
+
+	inDeco int // Depth of decorator definitions containing the current node.
+
+	captures map[*symbol.Symbol]struct{} // Capture groups of patterns outside decorator definitions.
+	decls    []*ast.VarDecl              // Metrics, in order of declaration.
+	set      map[*symbol.Symbol]struct{} // Metrics that the program changes.
+}
+
+// Lint checks a program that has passed Check for mistakes that aren't
+// errors in the language, and returns what it finds in order of their
+// position in the source:
+//   - capture groups that are never referred to,
+//   - metrics that are used but never changed, so are always zero,
+//   - patterns with an anchor that can't be reached, so never match.
+//
+// Metrics that are never used at all are already compile errors, so aren't
+// repeated.
+func Lint(node ast.Node) []Finding {
+	l := &linter{
+		captures: make(map[*symbol.Symbol]struct{}),
+		set:      make(map[*symbol.Symbol]struct{}),
+	}
+	ast.Walk(l, node)
+	for sym := range l.captures {
+		if !sym.Used {
+			l.add(*sym.Pos, Warning, fmt.Sprintf("Capture group `%s' is never used.\n\tA non-capturing group `(?:...)' is faster.", sym.Name))
+		}
+	}
+	for _, decl := range l.decls {
+		if !decl.Symbol.Used {
+			continue
+		}
+		if _, ok := l.set[decl.Symbol]; !ok {
+			l.add(decl.P, Warning, fmt.Sprintf("Metric `%s' is declared but never changed, so is always exported as zero.", decl.Name))
+		}
+	}
+	sort.SliceStable(l.findings, func(i, j int) bool {
+		a, b := l.findings[i].Pos, l.findings[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Startcol < b.Startcol
+	})
+	return l.findings
+}
+
+func (l *linter) add(pos position.Position, severity Severity, msg string) {
+	l.findings = append(l.findings, Finding{pos, severity, msg})
+}
+
+func (l *linter) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
+	switch n := node.(type) {
+	case *ast.DecoDecl:
+		// The users of a decorator choose which of its capture groups to
+		// refer to, so its patterns aren't checked for unused ones.
+		l.inDeco++
+	case *ast.VarDecl:
+		if n.Symbol != nil {
+			l.decls = append(l.decls, n)
+		}
+	case *ast.IDTerm:
+		// The metrics of del statements are not walked, as deleting a
+		// metric's data doesn't give it a value.
+		if n.Lvalue && n.Symbol != nil {
+			l.set[n.Symbol] = struct{}{}
+		}
+	case *ast.CondStmt:
+		if n.Scope != nil && l.inDeco == 0 {
+			for _, sym := range n.Scope.Symbols {
+				if sym.Kind == symbol.CaprefSymbol && sym.Addr != 0 {
+					l.captures[sym] = struct{}{}
+				}
+			}
+		}
+	case *ast.PatternExpr:
+		if n.Pattern != "" {
+			l.lintPattern(n)
+		}
+	}
+	return l, node
+}
+
+func (l *linter) VisitAfter(node ast.Node) ast.Node {
+	if _, ok := node.(*ast.DecoDecl); ok {
+		l.inDeco--
+	}
+	return node
+}
+
+// lintPattern reports anchors in the pattern n that can't be reached.
+func (l *linter) lintPattern(n *ast.PatternExpr) {
+	re, err := types.ParseRegexp(n.Pattern)
+	if err != nil {
+		return
+	}
+	if anchor := unreachableAnchor(re); anchor != "" {
+		l.add(*n.Pos(), Error, fmt.Sprintf("Pattern `%s' can't match where it has `%s'.\n\tText must be matched before the start of the line, or after its end.", n.Pattern, anchor))
+	}
+}
+
+// unreachableAnchor returns the first anchor in re that follows text that must
+// be matched, if it's a beginning anchor, or precedes it, if it's an end
+// anchor.  Log lines hold no newlines, so line anchors are treated as text
+// anchors.  It returns the empty string if every anchor can be reached.
+func unreachableAnchor(re *syntax.Regexp) string {
+	if re.Op == syntax.OpConcat {
+		for i, sub := range re.Sub {
+			if anchorsStart(sub) {
+				for _, before := range re.Sub[:i] {
+					if !matchesEmpty(before) {
+						return "^"
+					}
+				}
+			}
+			if anchorsEnd(sub) {
+				for _, after := range re.Sub[i+1:] {
+					if !matchesEmpty(after) {
+						return "$"
+					}
+				}
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if anchor := unreachableAnchor(sub); anchor != "" {
+			return anchor
+		}
+	}
+	return ""
+}
+
+// anchorsStart returns true if re can only match at the start of the text.
+func anchorsStart(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpBeginLine:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return anchorsStart(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && anchorsStart(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if anchorsStart(sub) {
+				return true
+			}
+		}
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !anchorsStart(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// anchorsEnd returns true if re can only match at the end of the text.
+func anchorsEnd(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEndText, syntax.OpEndLine:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return anchorsEnd(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && anchorsEnd(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if anchorsEnd(sub) {
+				return true
+			}
+		}
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !anchorsEnd(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
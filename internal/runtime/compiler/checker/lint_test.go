@@ -0,0 +1,72 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package checker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/runtime/compiler/checker"
+	"github.com/google/mtail/internal/runtime/compiler/parser"
+	"github.com/google/mtail/internal/testutil"
+)
+
+var lintTests = []struct {
+	name     string
+	program  string
+	findings []string
+}{
+	{
+		"clean",
+		"counter a by x\n/(?P<x>\\w+) / {\n  a[$x]++\n}\n/^foo$/ {\n  del a[\"y\"]\n}\n",
+		nil,
+	},
+	{
+		"unused capture",
+		"counter a\n/(\\d+) (\\w+)/ {\n  a += $1\n}\n",
+		[]string{"unused capture:2:1-13: warning: Capture group `2' is never used.\n\tA non-capturing group `(?:...)' is faster."},
+	},
+	{
+		"never changed",
+		"counter a\ngauge b\n/x/ {\n  b = a\n  del a\n}\n",
+		[]string{"never changed:1:9: warning: Metric `a' is declared but never changed, so is always exported as zero."},
+	},
+	{
+		"start anchor after text",
+		"counter a\n/foo^bar/ {\n  a++\n}\n",
+		[]string{"start anchor after text:2:1-9: error: Pattern `foo^bar' can't match where it has `^'.\n\tText must be matched before the start of the line, or after its end."},
+	},
+	{
+		"end anchor before text",
+		"counter a\n/(?:foo$|bar$)baz/ {\n  a++\n}\n",
+		[]string{"end anchor before text:2:1-18: error: Pattern `(?:foo$|bar$)baz' can't match where it has `$'.\n\tText must be matched before the start of the line, or after its end."},
+	},
+	{
+		"optional text before anchor",
+		"counter a\n/a*^b?$/ {\n  a++\n}\n",
+		nil,
+	},
+	{
+		"decorator captures",
+		"counter a by x\ndef d {\n  /(\\w+) (\\w+)/ {\n    next\n  }\n}\n@d {\n  a[$1]++\n}\n",
+		nil,
+	},
+}
+
+func TestLint(t *testing.T) {
+	for _, tc := range lintTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ast, err := parser.Parse(tc.name, strings.NewReader(tc.program))
+			testutil.FatalIfErr(t, err)
+			ast, err = checker.Check(ast, 0, 0, 0, false)
+			testutil.FatalIfErr(t, err)
+			var got []string
+			for _, f := range checker.Lint(ast) {
+				got = append(got, f.String())
+			}
+			testutil.ExpectNoDiff(t, tc.findings, got)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package checker
+
+import (
+	"regexp/syntax"
+)
+
+// hasNestedRepeat returns true if re has a subexpression that repeats another
+// unbounded repetition without limit, such that each repetition of the outer
+// can be made up of the inner alone, like `(a+)+` or `(\w+\s?)*`.  The text
+// such a subexpression matches can be split between the repetitions in
+// exponentially many ways, which backtracking regular expression engines try
+// one by one.
+func hasNestedRepeat(re *syntax.Regexp) bool {
+	if isUnboundedRepeat(re) && repeatsUnbounded(re.Sub[0]) {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasNestedRepeat(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnboundedRepeat returns true if re repeats its subexpression without an
+// upper limit.
+func isUnboundedRepeat(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		return true
+	case syntax.OpRepeat:
+		return re.Max == -1
+	}
+	return false
+}
+
+// repeatsUnbounded returns true if re can match using only an unbounded
+// repetition, with whatever else it contains matching the empty string.
+func repeatsUnbounded(re *syntax.Regexp) bool {
+	if isUnboundedRepeat(re) {
+		return true
+	}
+	switch re.Op {
+	case syntax.OpCapture, syntax.OpQuest, syntax.OpRepeat:
+		return repeatsUnbounded(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if repeatsUnbounded(sub) {
+				return true
+			}
+		}
+	case syntax.OpConcat:
+		for i, sub := range re.Sub {
+			if !repeatsUnbounded(sub) {
+				continue
+			}
+			rest := true
+			for j, other := range re.Sub {
+				if j != i && !matchesEmpty(other) {
+					rest = false
+					break
+				}
+			}
+			if rest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesEmpty returns true if re can match the empty string.
+func matchesEmpty(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpStar, syntax.OpQuest,
+		syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return matchesEmpty(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min == 0 || matchesEmpty(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if matchesEmpty(sub) {
+				return true
+			}
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !matchesEmpty(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -95,6 +95,8 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			dtyp = metrics.String
 		case types.Equals(types.Buckets, t):
 			dtyp = metrics.Buckets
+		case types.Equals(types.Quantiles, t):
+			dtyp = metrics.Quantiles
 		default:
 			if !types.IsComplete(t) {
 				glog.Infof("Incomplete type %v for %#v", t, n)
@@ -103,6 +105,7 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		}
 		m := metrics.NewMetric(name, c.name, n.Kind, dtyp, n.Keys...)
 		m.SetSource(n.Pos().String())
+		m.Help = n.Help
 		// Scalar counters can be initialized to zero.  Dimensioned counters we
 		// don't know the values of the labels yet.  Gauges and Timers we can't
 		// assume start at zero.
@@ -126,25 +129,14 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		}
 
 		if n.Kind == metrics.Histogram {
-			if len(n.Buckets) < 2 {
-				c.errorf(n.Pos(), "a histogram need at least two boundaries")
-				return nil, n
-			}
-			if n.Buckets[0] >= n.Buckets[1] {
-				c.errorf(n.Pos(), "buckets boundaries must be sorted")
-				return nil, n
-			}
-
+			// The checker has ensured there are at least two boundaries, in
+			// increasing order.
 			if n.Buckets[0] > 0 {
 				m.Buckets = append(m.Buckets, datum.Range{0, n.Buckets[0]})
 			}
 			m.Buckets = append(m.Buckets, datum.Range{n.Buckets[0], n.Buckets[1]})
 			min := n.Buckets[1]
 			for _, max := range n.Buckets[2:] {
-				if max <= min {
-					c.errorf(n.Pos(), "buckets boundaries must be sorted")
-					return nil, n
-				}
 				m.Buckets = append(m.Buckets, datum.Range{min, max})
 				min = max
 			}
@@ -160,6 +152,21 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			}
 		}
 
+		if n.Kind == metrics.Summary {
+			// The checker has ensured there is at least one quantile, in
+			// increasing order.
+			m.Quantiles = n.Quantiles
+
+			if len(n.Keys) == 0 {
+				// Calling GetDatum here causes the storage to be allocated.
+				_, err := m.GetDatum()
+				if err != nil {
+					c.errorf(n.Pos(), "%s", err)
+					return nil, n
+				}
+			}
+		}
+
 		m.Hidden = n.Hidden
 		// int is int64 only on 64bit platforms.  To be fair MaxInt is a
 		// ridiculously excessive size for this anyway, you're going to use 2GiB
@@ -169,6 +176,8 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			return nil, n
 		}
 		m.Limit = int(n.Limit)
+		m.Expiry = n.Expiry
+		m.Window = n.Window
 
 		n.Symbol.Binding = m
 		n.Symbol.Addr = len(c.obj.Metrics)
@@ -204,6 +213,11 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			return nil, n
 		}
 		c.obj.Regexps = append(c.obj.Regexps, re)
+		var literal string
+		if reAst, err := types.ParseRegexp(n.Pattern); err == nil {
+			literal = types.RequiredLiteral(reAst)
+		}
+		c.obj.Literals = append(c.obj.Literals, literal)
 		// Store the location of this regular expression in the PatternExpr
 		n.Index = len(c.obj.Regexps) - 1
 		return nil, n
@@ -229,12 +243,14 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		if n.Symbol == nil || n.Symbol.Kind != symbol.VarSymbol {
 			break
 		}
-		if n.Symbol.Binding == nil {
+		// The checker binds the declaration, which is replaced by the metric
+		// when the declaration is generated.
+		m, ok := n.Symbol.Binding.(*metrics.Metric)
+		if !ok {
 			c.errorf(n.Pos(), "No metric bound to identifier %q", n.Name)
 			return nil, n
 		}
 		c.emit(n, code.Mload, n.Symbol.Addr)
-		m := n.Symbol.Binding.(*metrics.Metric)
 		c.emit(n, code.Dload, len(m.Keys))
 
 		if !n.Lvalue {
@@ -258,16 +274,21 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		}
 
 	case *ast.CaprefTerm:
-		if n.Symbol == nil || n.Symbol.Binding == nil {
+		_, isField := types.Fields[n.Name]
+		switch {
+		case n.Symbol != nil && n.Symbol.Binding == nil && isField:
+			c.emit(n, code.Getfield, n.Name)
+		case n.Symbol == nil || n.Symbol.Binding == nil:
 			c.errorf(n.Pos(), "No regular expression bound to capref %q", n.Name)
 			return nil, n
+		default:
+			rn := n.Symbol.Binding.(*ast.PatternExpr)
+			// rn.index contains the index of the compiled regular expression object
+			// in the re slice of the object code
+			c.emit(n, code.Push, rn.Index)
+			// n.Symbol.Addr is the capture group offset
+			c.emit(n, code.Capref, n.Symbol.Addr)
 		}
-		rn := n.Symbol.Binding.(*ast.PatternExpr)
-		// rn.index contains the index of the compiled regular expression object
-		// in the re slice of the object code
-		c.emit(n, code.Push, rn.Index)
-		// n.Symbol.Addr is the capture group offset
-		c.emit(n, code.Capref, n.Symbol.Addr)
 		if types.Equals(n.Type(), types.Float) {
 			c.emit(n, code.S2f, nil)
 		} else if types.Equals(n.Type(), types.Int) {
@@ -326,6 +347,10 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		// overwrite the dload instruction
 		pc := c.pc()
 		c.obj.Program[pc].Opcode = code.Del
+		if _, ok := n.N.(*ast.IDTerm); ok {
+			// Deleting without an index removes every datum.
+			c.obj.Program[pc].Operand = 0
+		}
 		if n.Expiry > 0 {
 			c.obj.Program[pc].Opcode = code.Expire
 		}
@@ -360,7 +385,7 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			c.setLabel(lEnd)
 			return nil, n
 
-		case parser.ADD_ASSIGN:
+		case parser.ADD_ASSIGN, parser.SUB_ASSIGN:
 			if !types.Equals(n.Type(), types.Int) {
 				// Double-emit the lhs so that it can be assigned to
 				ast.Walk(c, n.LHS)
@@ -423,14 +448,18 @@ func getOpcodeForType(op int, opT types.Type) (code.Opcode, error) {
 }
 
 var builtin = map[string]code.Opcode{
-	"getfilename": code.Getfilename,
-	"len":         code.Length,
-	"settime":     code.Settime,
-	"strptime":    code.Strptime,
-	"strtol":      code.S2i,
-	"subst":       code.Subst,
-	"timestamp":   code.Timestamp,
-	"tolower":     code.Tolower,
+	"getfilename":  code.Getfilename,
+	"json_field":   code.Jsonfield,
+	"len":          code.Length,
+	"logfmt_field": code.Logfmtfield,
+	"settime":      code.Settime,
+	"strptime":     code.Strptime,
+	"strtol":       code.S2i,
+	"subst":        code.Subst,
+	"substr":       code.Substr,
+	"timestamp":    code.Timestamp,
+	"tolower":      code.Tolower,
+	"toupper":      code.Toupper,
 }
 
 func (c *codegen) VisitAfter(node ast.Node) ast.Node {
@@ -549,6 +578,30 @@ func (c *codegen) VisitAfter(node ast.Node) ast.Node {
 				c.errorf(n.Pos(), "invalid type for add-assignment: %v", n.Type())
 				return n
 			}
+		case parser.SUB_ASSIGN:
+			// When operand is not nil, dec pops the delta from the stack.
+			switch {
+			case types.Equals(n.Type(), types.Int):
+				c.emit(n, code.Dec, 0)
+			case types.Equals(n.Type(), types.Float):
+				// Already walked the lhs and rhs of this expression
+				opcode, err := getOpcodeForType(parser.MINUS, n.Type())
+				if err != nil {
+					c.errorf(n.Pos(), "%s", err)
+					return n
+				}
+				c.emit(n, opcode, nil)
+				// And a second lhs
+				opcode, err = getOpcodeForType(parser.ASSIGN, n.Type())
+				if err != nil {
+					c.errorf(n.Pos(), "%s", err)
+					return n
+				}
+				c.emit(n, opcode, nil)
+			default:
+				c.errorf(n.Pos(), "invalid type for subtract-assignment: %v", n.Type())
+				return n
+			}
 		case parser.PLUS, parser.MINUS, parser.MUL, parser.DIV, parser.MOD, parser.POW, parser.ASSIGN:
 			opcode, err := getOpcodeForType(n.Op, n.Type())
 			if err != nil {
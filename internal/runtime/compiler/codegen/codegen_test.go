@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/runtime/code"
 	"github.com/google/mtail/internal/runtime/compiler/ast"
 	"github.com/google/mtail/internal/runtime/compiler/checker"
@@ -38,6 +39,80 @@ var testCodeGenPrograms = []struct {
 			{code.Setmatched, true, 1},
 		},
 	},
+	{
+		"assign string capture to gauge",
+		"gauge depth\n/depth (\\S+)/ {\n  depth = $1\n}\n",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 10, 1},
+			{code.Setmatched, false, 1},
+			{code.Mload, 0, 2},
+			{code.Dload, 0, 2},
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.S2f, nil, 2},
+			{code.Fset, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+	{
+		"named capture groups as keys",
+		"counter hits by method, status\n/(?P<method>[A-Z]+) (?<status>\\S+)/ {\n  hits[$method][$status]++\n}\n",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 11, 1},
+			{code.Setmatched, false, 1},
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.Push, 0, 2},
+			{code.Capref, 2, 2},
+			{code.Mload, 0, 2},
+			{code.Dload, 2, 2},
+			{code.Inc, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+	{
+		"toupper as key",
+		"counter requests by method\n/(\\S+)/ {\n  requests[toupper($1)]++\n}\n",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 10, 1},
+			{code.Setmatched, false, 1},
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.Toupper, 1, 2},
+			{code.Mload, 0, 2},
+			{code.Dload, 1, 2},
+			{code.Inc, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+	{
+		"compare string capture to float",
+		"counter slow\n/(\\S+)/ {\n  $1 > 1.0 {\n    slow++\n  }\n}\n",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 19, 1},
+			{code.Setmatched, false, 1},
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.S2f, nil, 2},
+			{code.Push, 1.0, 2},
+			{code.Fcmp, 1, 2},
+			{code.Jnm, 11, 2},
+			{code.Push, true, 2},
+			{code.Jmp, 12, 2},
+			{code.Push, false, 2},
+			{code.Jnm, 18, 2},
+			{code.Setmatched, false, 2},
+			{code.Mload, 0, 3},
+			{code.Dload, 0, 3},
+			{code.Inc, nil, 3},
+			{code.Setmatched, true, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
 	{
 		"count a",
 		"counter a_count\n/a$/ { a_count++\n }\n",
@@ -480,6 +555,16 @@ del a["string"]
 			{code.Del, 1, 2},
 		},
 	},
+	{
+		"del all", `
+counter a by b
+del a
+`,
+		[]code.Instr{
+			{code.Mload, 0, 2},
+			{code.Del, 0, 2},
+		},
+	},
 	{
 		"del after", `
 counter a by b
@@ -536,6 +621,96 @@ getfilename()
 		},
 	},
 
+	{
+		"json_field", `counter status by code
+/^{.*}$/ {
+  status[json_field($0, "response.code")]++
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 11, 1},
+			{code.Setmatched, false, 1},
+			{code.Push, 0, 2},
+			{code.Capref, 0, 2},
+			{code.Str, 0, 2},
+			{code.Jsonfield, 2, 2},
+			{code.Mload, 0, 2},
+			{code.Dload, 1, 2},
+			{code.Inc, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+
+	{
+		"logfmt_field", `counter requests by level
+/^.*$/ {
+  requests[logfmt_field($0, "level")]++
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 11, 1},
+			{code.Setmatched, false, 1},
+			{code.Push, 0, 2},
+			{code.Capref, 0, 2},
+			{code.Str, 0, 2},
+			{code.Logfmtfield, 2, 2},
+			{code.Mload, 0, 2},
+			{code.Dload, 1, 2},
+			{code.Inc, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+
+	{
+		"substr", `counter class by c
+/(\S+)/ {
+  class[substr($1, 0, 1)]++
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 12, 1},
+			{code.Setmatched, false, 1},
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.Push, int64(0), 2},
+			{code.Push, int64(1), 2},
+			{code.Substr, 3, 2},
+			{code.Mload, 0, 2},
+			{code.Dload, 1, 2},
+			{code.Inc, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+
+	{
+		"syslog fields",
+		`counter c by host
+$syslog_severity <= 3 {
+  c[$syslog_hostname]++
+}
+`,
+		[]code.Instr{
+			{code.Getfield, "syslog_severity", 1},
+			{code.S2i, nil, 1},
+			{code.Push, int64(3), 1},
+			{code.Icmp, 1, 1},
+			{code.Jm, 7, 1},
+			{code.Push, true, 1},
+			{code.Jmp, 8, 1},
+			{code.Push, false, 1},
+			{code.Jnm, 15, 1},
+			{code.Setmatched, false, 1},
+			{code.Getfield, "syslog_hostname", 2},
+			{code.Mload, 0, 2},
+			{code.Dload, 1, 2},
+			{code.Inc, nil, 2},
+			{code.Setmatched, true, 1},
+		},
+	},
+
 	{
 		"dimensioned counter",
 		`counter c by a,b,c
@@ -749,6 +924,49 @@ gauge foo
 			{code.Setmatched, true, 2},
 		},
 	},
+	{
+		"sub assign int", `
+gauge foo
+/(\d+)/ {
+  foo -= $1
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 2},
+			{code.Jnm, 10, 2},
+			{code.Setmatched, false, 2},
+			{code.Mload, 0, 3},
+			{code.Dload, 0, 3},
+			{code.Push, 0, 3},
+			{code.Capref, 1, 3},
+			{code.S2i, nil, 3},
+			{code.Dec, 0, 3},
+			{code.Setmatched, true, 2},
+		},
+	},
+	{
+		"sub assign float", `
+gauge foo
+/(\d+\.\d+)/ {
+  foo -= $1
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 2},
+			{code.Jnm, 13, 2},
+			{code.Setmatched, false, 2},
+			{code.Mload, 0, 3},
+			{code.Dload, 0, 3},
+			{code.Mload, 0, 3},
+			{code.Dload, 0, 3},
+			{code.Push, 0, 3},
+			{code.Capref, 1, 3},
+			{code.S2f, nil, 3},
+			{code.Fsub, nil, 3},
+			{code.Fset, nil, 3},
+			{code.Setmatched, true, 2},
+		},
+	},
 	{
 		"match expression", `
 	counter foo
@@ -918,7 +1136,7 @@ text foo
 		},
 	},
 	{"decrement", `
-counter i
+gauge i
 // {
   i--
 }`, []code.Instr{
@@ -1051,7 +1269,7 @@ func TestCodeGenFromSource(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			ast, err := parser.Parse(tc.name, strings.NewReader(tc.source))
 			testutil.FatalIfErr(t, err)
-			ast, err = checker.Check(ast, 0, 0)
+			ast, err = checker.Check(ast, 0, 0, 0, false)
 			if *codegenTestDebug {
 				s := parser.Sexp{}
 				s.EmitTypes = true
@@ -1136,3 +1354,64 @@ func TestCodeGenFromAST(t *testing.T) {
 		})
 	}
 }
+
+func TestCodeGenGetenv(t *testing.T) {
+	t.Setenv("MTAIL_TEST_CLUSTER", "west")
+	source := `counter requests by cluster
+/./ {
+  requests[getenv("MTAIL_TEST_CLUSTER")]++
+  requests[getenv("MTAIL_TEST_UNSET")]++
+}
+`
+	ast, err := parser.Parse("getenv", strings.NewReader(source))
+	testutil.FatalIfErr(t, err)
+	ast, err = checker.Check(ast, 0, 0, 0, false)
+	testutil.FatalIfErr(t, err)
+	obj, err := codegen.CodeGen("getenv", ast)
+	testutil.FatalIfErr(t, err)
+	// The environment is read at compile time, so the values are constants.
+	testutil.ExpectNoDiff(t, []string{"west", ""}, obj.Strings)
+}
+
+func TestCodeGenIncrementedOnlyIsInt(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		source string
+	}{
+		{"assigned to a gauge", "counter tmp\ngauge g\n/x/ {\n  tmp++\n  g = tmp\n}\n"},
+		{"added to a counter", "counter c\ncounter last\n/x/ {\n  last++\n  c += last\n}\n"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ast, err := parser.Parse(tc.name, strings.NewReader(tc.source))
+			testutil.FatalIfErr(t, err)
+			ast, err = checker.Check(ast, 0, 0, 0, false)
+			testutil.FatalIfErr(t, err)
+			obj, err := codegen.CodeGen(tc.name, ast)
+			testutil.FatalIfErr(t, err)
+			for _, m := range obj.Metrics {
+				if m.Type != metrics.Int {
+					t.Errorf("metric %s is %v, want Int", m.Name, m.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestCodeGenRequiredLiterals(t *testing.T) {
+	source := `counter c
+/status=(\d+)/ {
+  c++
+}
+/(?i)error|warning/ {
+  c++
+}
+`
+	ast, err := parser.Parse("literals", strings.NewReader(source))
+	testutil.FatalIfErr(t, err)
+	ast, err = checker.Check(ast, 0, 0, 0, false)
+	testutil.FatalIfErr(t, err)
+	obj, err := codegen.CodeGen("literals", ast)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, []string{"status=", ""}, obj.Literals)
+}
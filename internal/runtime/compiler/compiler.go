@@ -4,8 +4,8 @@
 package compiler
 
 import (
+	"fmt"
 	"io"
-	"path/filepath"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/runtime/code"
@@ -18,10 +18,15 @@ import (
 
 type Compiler struct {
 	emitAst             bool
+	astWriter           io.Writer // Write the AST to this instead of the INFO log, if not nil.
 	emitAstTypes        bool
 	maxRegexpLength     int
 	maxRecursionDepth   int
+	maxCaptures         int
+	allowRiskyRegex     bool
 	disableOptimisation bool
+	optimiseBytecode    bool
+	baseDir             string // Directory that program names are relative to, for resolving includes.
 }
 
 func New(options ...Option) (*Compiler, error) {
@@ -52,6 +57,15 @@ func EmitAst() Option {
 	}
 }
 
+// EmitAstTo writes the AST after the parse phase to w.
+func EmitAstTo(w io.Writer) Option {
+	return func(c *Compiler) error {
+		c.emitAst = true
+		c.astWriter = w
+		return nil
+	}
+}
+
 // EmitAstTypes emits the AST with types after the type checking phase.
 func EmitAstTypes() Option {
 	return func(c *Compiler) error {
@@ -76,6 +90,23 @@ func MaxRecursionDepth(maxRecursionDepth int) Option {
 	}
 }
 
+// MaxCaptures sets the maximum number of capture groups a regular expression can have.
+func MaxCaptures(maxCaptures int) Option {
+	return func(c *Compiler) error {
+		c.maxCaptures = maxCaptures
+		return nil
+	}
+}
+
+// AllowRiskyRegex makes patterns with nested unbounded repetitions warnings
+// rather than errors.
+func AllowRiskyRegex() Option {
+	return func(c *Compiler) error {
+		c.allowRiskyRegex = true
+		return nil
+	}
+}
+
 // DisableOptimisation disables the optimisation phase.
 func DisableOptimisation() Option {
 	return func(c *Compiler) error {
@@ -84,20 +115,55 @@ func DisableOptimisation() Option {
 	}
 }
 
+// OptimiseBytecode enables the peephole optimisation of the generated bytecode.
+func OptimiseBytecode() Option {
+	return func(c *Compiler) error {
+		c.optimiseBytecode = true
+		return nil
+	}
+}
+
+// BaseDir sets the directory that program names are relative to, so that
+// include statements are resolved relative to the including program.
+func BaseDir(dir string) Option {
+	return func(c *Compiler) error {
+		c.baseDir = dir
+		return nil
+	}
+}
+
 // Compile compiles a program from the input into bytecode and data stored in an Object, or a list
 // of compile errors.
-func (c *Compiler) Compile(name string, input io.Reader) (obj *code.Object, err error) {
-	name = filepath.Base(name)
+func (c *Compiler) Compile(name string, input io.Reader) (*code.Object, error) {
+	obj, _, err := c.compile(name, input, false)
+	return obj, err
+}
 
+// CompileAndLint compiles a program like Compile, and also returns the likely
+// mistakes in it that checker.Lint finds in the type checked AST.
+func (c *Compiler) CompileAndLint(name string, input io.Reader) (*code.Object, []checker.Finding, error) {
+	return c.compile(name, input, true)
+}
+
+func (c *Compiler) compile(name string, input io.Reader, lint bool) (obj *code.Object, findings []checker.Finding, err error) {
 	var ast ast.Node
 
 	ast, err = parser.Parse(name, input)
 	if err != nil {
 		return
 	}
+	var includes []string
+	ast, includes, err = c.resolveIncludes(name, ast)
+	if err != nil {
+		return
+	}
 	if c.emitAst {
 		s := parser.Sexp{}
-		glog.Infof("%s AST:\n%s", name, s.Dump(ast))
+		if c.astWriter != nil {
+			fmt.Fprintf(c.astWriter, "%s AST:\n%s", name, s.Dump(ast))
+		} else {
+			glog.Infof("%s AST:\n%s", name, s.Dump(ast))
+		}
 	}
 
 	if !c.disableOptimisation {
@@ -111,10 +177,13 @@ func (c *Compiler) Compile(name string, input io.Reader) (obj *code.Object, err
 		}
 	}
 
-	ast, err = checker.Check(ast, c.maxRegexpLength, c.maxRecursionDepth)
+	ast, err = checker.Check(ast, c.maxRegexpLength, c.maxRecursionDepth, c.maxCaptures, c.allowRiskyRegex)
 	if err != nil {
 		return
 	}
+	if lint {
+		findings = checker.Lint(ast)
+	}
 	if c.emitAstTypes {
 		s := parser.Sexp{}
 		s.EmitTypes = true
@@ -134,5 +203,11 @@ func (c *Compiler) Compile(name string, input io.Reader) (obj *code.Object, err
 	}
 
 	obj, err = codegen.CodeGen(name, ast)
+	if obj != nil {
+		obj.Includes = includes
+		if err == nil && c.optimiseBytecode {
+			obj.Program = opt.OptimiseBytecode(obj.Program)
+		}
+	}
 	return
 }
@@ -4,6 +4,8 @@
 package compiler_test
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -49,3 +51,66 @@ func TestCompileCodegen(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCompileInclude(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.Mkdir(filepath.Join(dir, "nginx"), 0o700))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "common.inc"), []byte("const IP /\\d+\\.\\d+\\.\\d+\\.\\d+/\n"), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "nginx", "fragments.inc"), []byte("include \"../common.inc\"\ncounter requests\n"), 0o600))
+
+	c, err := compiler.New(compiler.BaseDir(dir))
+	testutil.FatalIfErr(t, err)
+	r := strings.NewReader(`include "fragments.inc"
+// + IP {
+  requests++
+}
+`)
+	obj, err := c.Compile("nginx/access.mtail", r)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, []string{filepath.Join(dir, "nginx", "fragments.inc"), filepath.Join(dir, "common.inc")}, obj.Includes)
+}
+
+func TestCompileIncludeErrors(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "a.inc"), []byte("include \"b.inc\"\n"), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "b.inc"), []byte("include \"a.inc\"\n"), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "bad.inc"), []byte("asdf\n"), 0o600))
+
+	c, err := compiler.New(compiler.BaseDir(dir))
+	testutil.FatalIfErr(t, err)
+	for _, tc := range []struct {
+		name string
+		prog string
+		want string
+	}{
+		{"circular", "include \"a.inc\"\n", "b.inc:1:9-15: circular include of \"a.inc\""},
+		{"self", "include \"test.mtail\"\n", "test.mtail:1:9-20: circular include of \"test.mtail\""},
+		{"missing", "include \"missing.inc\"\n", "test.mtail:1:9-21: include failed"},
+		{"parse error", "include \"bad.inc\"\n", "bad.inc:1:"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := c.Compile("test.mtail", strings.NewReader(tc.prog))
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %q does not contain %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEmitAstTo(t *testing.T) {
+	var out strings.Builder
+	c, err := compiler.New(compiler.EmitAstTo(&out))
+	testutil.FatalIfErr(t, err)
+	_, err = c.Compile("test", strings.NewReader("histogram h by code buckets 1, 2\n/(\\d+)/ {\n  h[$1] = $1\n}\n"))
+	testutil.FatalIfErr(t, err)
+	got := out.String()
+	for _, want := range []string{"test AST:\n", "histogram h (code)", `"(\\d+)"`, "( ;;*ast.CondStmt @ test:2:1-"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("AST doesn't contain %q:\n%s", want, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package errors
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/google/mtail/internal/runtime/compiler/position"
 	"github.com/pkg/errors"
@@ -51,6 +52,64 @@ func (p ErrorList) Error() string {
 	return r.String()[:r.Len()-1]
 }
 
+// Unwrap returns the individual errors in the list.
+func (p ErrorList) Unwrap() []error {
+	errs := make([]error, 0, len(p))
+	for _, e := range p {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// WithSource formats the errors in the list like Error does, following each
+// error in the file filename with the line of src that it occurred on and a
+// caret marking the columns of the error.  Errors in other files, such as
+// those included by filename, are formatted without an excerpt.
+func (p ErrorList) WithSource(filename string, src []byte) string {
+	if len(p) == 0 {
+		return p.Error()
+	}
+	lines := strings.Split(string(src), "\n")
+	var r strings.Builder
+	for i, e := range p {
+		if i > 0 {
+			r.WriteString("\n")
+		}
+		r.WriteString(e.Error())
+		if e.pos.Filename != filename || e.pos.Line < 0 || e.pos.Line >= len(lines) {
+			continue
+		}
+		line := strings.TrimRight(lines[e.pos.Line], "\r")
+		if e.pos.Startcol < 0 || e.pos.Startcol > len(line) {
+			continue
+		}
+		r.WriteString("\n\t" + line + "\n\t" + marker(line, e.pos.Startcol, e.pos.Endcol))
+	}
+	return r.String()
+}
+
+// marker returns a line that places a caret under the byte column startcol of
+// line, and tildes under the rest of the span up to endcol.  Tabs in line are
+// copied so the caret lines up however wide they're displayed.
+func marker(line string, startcol, endcol int) string {
+	var r strings.Builder
+	for _, c := range line[:startcol] {
+		if c == '\t' {
+			r.WriteRune('\t')
+		} else {
+			r.WriteRune(' ')
+		}
+	}
+	r.WriteRune('^')
+	if endcol >= len(line) {
+		endcol = len(line) - 1
+	}
+	if endcol > startcol {
+		r.WriteString(strings.Repeat("~", utf8.RuneCountInString(line[startcol:endcol+1])-1))
+	}
+	return r.String()
+}
+
 func Errorf(format string, args ...interface{}) error {
 	return errors.Errorf(format, args...)
 }
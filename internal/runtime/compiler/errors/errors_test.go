@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/google/mtail/internal/runtime/compiler/errors"
+	"github.com/google/mtail/internal/runtime/compiler/position"
 )
 
 func TestNilErrorPosition(t *testing.T) {
@@ -18,3 +19,23 @@ func TestNilErrorPosition(t *testing.T) {
 		t.Errorf("want %q, got %q", expected, r)
 	}
 }
+
+func TestWithSource(t *testing.T) {
+	src := []byte("counter a\n/foo/ {\n\tb++\n}\n")
+	e := errors.ErrorList{}
+	e.Add(&position.Position{"prog.mtail", 2, 1, 1}, "Identifier `b' not declared.")
+	e.Add(&position.Position{"prog.mtail", 1, 0, 4}, "bad regexp")
+	e.Add(&position.Position{"other.mtail", 0, 0, 0}, "included error")
+	e.Add(&position.Position{"prog.mtail", 9, 0, 0}, "past the end")
+	expected := "prog.mtail:3:2: Identifier `b' not declared.\n" +
+		"\t\tb++\n" +
+		"\t\t^\n" +
+		"prog.mtail:2:1-5: bad regexp\n" +
+		"\t/foo/ {\n" +
+		"\t^~~~~\n" +
+		"other.mtail:1:1: included error\n" +
+		"prog.mtail:10:1: past the end"
+	if r := e.WithSource("prog.mtail", src); r != expected {
+		t.Errorf("want %q, got %q", expected, r)
+	}
+}
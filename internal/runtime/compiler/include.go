@@ -0,0 +1,110 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/runtime/compiler/ast"
+	"github.com/google/mtail/internal/runtime/compiler/errors"
+	"github.com/google/mtail/internal/runtime/compiler/parser"
+)
+
+// includer replaces include statements in an AST with the statements parsed
+// from the included file.
+type includer struct {
+	baseDir   string   // The directory that program names are relative to.
+	name      string   // The name of the file containing the include statements.
+	stack     []string // Names of the files currently being included, outermost first.
+	pathnames []string // Pathnames of every file included so far.
+	errors    errors.ErrorList
+}
+
+func (i *includer) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
+	return i, n
+}
+
+func (i *includer) VisitAfter(node ast.Node) ast.Node {
+	n, ok := node.(*ast.StmtList)
+	if !ok {
+		return node
+	}
+	children := make([]ast.Node, 0, len(n.Children))
+	for _, child := range n.Children {
+		inc, ok := child.(*ast.IncludeStmt)
+		if !ok {
+			children = append(children, child)
+			continue
+		}
+		included := i.include(inc)
+		if included != nil {
+			// Splice the statements in, so they share the including scope.
+			children = append(children, included.Children...)
+		}
+	}
+	n.Children = children
+	return n
+}
+
+// include parses the file named by the include statement inc, and returns its
+// statements with any nested includes resolved.
+func (i *includer) include(inc *ast.IncludeStmt) *ast.StmtList {
+	name := inc.Path
+	if !filepath.IsAbs(name) {
+		name = filepath.ToSlash(filepath.Join(filepath.Dir(i.name), name))
+	}
+	for _, s := range i.stack {
+		if s == name {
+			i.errors.Add(inc.Pos(), fmt.Sprintf("circular include of %q", inc.Path))
+			return nil
+		}
+	}
+	pathname := filepath.FromSlash(name)
+	if !filepath.IsAbs(pathname) {
+		pathname = filepath.Join(i.baseDir, pathname)
+	}
+	glog.V(2).Infof("including %s from %s", pathname, i.name)
+	f, err := os.Open(filepath.Clean(pathname))
+	if err != nil {
+		i.errors.Add(inc.Pos(), fmt.Sprintf("include failed: %s", err))
+		return nil
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			glog.Warning(err)
+		}
+	}()
+	i.pathnames = append(i.pathnames, pathname)
+	root, err := parser.Parse(name, f)
+	if err != nil {
+		if l, ok := err.(errors.ErrorList); ok {
+			i.errors.Append(l)
+		} else {
+			i.errors.Add(inc.Pos(), err.Error())
+		}
+		return nil
+	}
+	outer := i.name
+	i.name = name
+	i.stack = append(i.stack, name)
+	root = ast.Walk(i, root)
+	i.stack = i.stack[:len(i.stack)-1]
+	i.name = outer
+	return root.(*ast.StmtList)
+}
+
+// resolveIncludes inlines the files named by the include statements in the
+// program name, searching relative to the directory of the including file.
+// It returns the new AST, and the pathnames of all the files included.
+func (c *Compiler) resolveIncludes(name string, root ast.Node) (ast.Node, []string, error) {
+	i := &includer{baseDir: c.baseDir, name: name, stack: []string{name}}
+	root = ast.Walk(i, root)
+	if len(i.errors) > 0 {
+		return root, i.pathnames, i.errors
+	}
+	return root, i.pathnames, nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package opt
+
+import (
+	"github.com/google/mtail/internal/runtime/code"
+)
+
+// OptimiseBytecode runs a peephole optimiser over the instructions of a
+// compiled program, returning the new program.  It collapses comparisons that
+// are converted to a boolean only to be branched on, folds branches and
+// comparisons on constants, and drops instructions that can't be reached.
+// Jump targets are rewritten to match the shorter program.
+func OptimiseBytecode(prog []code.Instr) []code.Instr {
+	for {
+		keep, changed := peephole(prog)
+		if !changed {
+			return prog
+		}
+		prog = compact(prog, keep)
+	}
+}
+
+func isJump(op code.Opcode) bool {
+	return op == code.Jmp || op == code.Jm || op == code.Jnm
+}
+
+// jumpTargets counts the jumps to each instruction of prog.
+func jumpTargets(prog []code.Instr) map[int]int {
+	targets := make(map[int]int)
+	for _, i := range prog {
+		if isJump(i.Opcode) {
+			targets[i.Operand.(int)]++
+		}
+	}
+	return targets
+}
+
+// peephole rewrites instructions of prog in place, and reports which of them
+// remain in the program.
+func peephole(prog []code.Instr) (keep []bool, changed bool) {
+	targets := jumpTargets(prog)
+	keep = make([]bool, len(prog))
+	for pc := range keep {
+		keep[pc] = true
+	}
+	// drop removes the instructions in [from, to) from the program, which is
+	// only correct if none of them are the target of a jump from elsewhere.
+	drop := func(from, to int) {
+		for pc := from; pc < to; pc++ {
+			keep[pc] = false
+		}
+		changed = true
+	}
+	for pc := 0; pc < len(prog); pc++ {
+		i := prog[pc]
+		switch {
+		case (i.Opcode == code.Jm || i.Opcode == code.Jnm) && isBoolBranch(prog, pc, targets):
+			// A comparison result is turned into a boolean and then
+			// immediately branched on:
+			//   jc A; push true; jmp B; A: push false; B: jnm C
+			// which is the same as branching on the comparison directly.
+			prog[pc].Operand = prog[pc+4].Operand
+			drop(pc+1, pc+5)
+			pc += 4
+
+		case i.Opcode == code.Push && pc+1 < len(prog) && targets[pc+1] == 0 &&
+			(prog[pc+1].Opcode == code.Jm || prog[pc+1].Opcode == code.Jnm):
+			b, ok := i.Operand.(bool)
+			if !ok {
+				continue
+			}
+			// A constant is pushed only to be popped by the branch, so the
+			// branch is taken always or never.
+			if b == (prog[pc+1].Opcode == code.Jm) {
+				prog[pc] = code.Instr{Opcode: code.Jmp, Operand: prog[pc+1].Operand, SourceLine: i.SourceLine}
+				drop(pc+1, pc+2)
+			} else {
+				drop(pc, pc+2)
+			}
+			pc++
+
+		case i.Opcode == code.Push && pc+2 < len(prog) && targets[pc+1] == 0 && targets[pc+2] == 0 &&
+			prog[pc+1].Opcode == code.Push:
+			r, ok := foldCompare(i.Operand, prog[pc+1].Operand, prog[pc+2])
+			if !ok {
+				continue
+			}
+			prog[pc].Operand = r
+			drop(pc+1, pc+3)
+			pc += 2
+
+		case i.Opcode == code.Jmp && i.Operand.(int) == pc+1:
+			// Jumping to the next instruction does nothing.
+			drop(pc, pc+1)
+
+		case i.Opcode == code.Jmp || i.Opcode == code.Stop:
+			// Nothing after an unconditional jump or stop is reached unless
+			// it is jumped to.
+			end := pc + 1
+			for end < len(prog) && targets[end] == 0 {
+				end++
+			}
+			if end > pc+1 {
+				drop(pc+1, end)
+			}
+			pc = end - 1
+		}
+	}
+	return keep, changed
+}
+
+// isBoolBranch returns true if the conditional jump at pc starts the sequence
+// that converts a comparison to a boolean and then branches on it, and no
+// other jumps go into the middle of the sequence.
+func isBoolBranch(prog []code.Instr, pc int, targets map[int]int) bool {
+	if pc+4 >= len(prog) {
+		return false
+	}
+	return prog[pc].Operand.(int) == pc+3 &&
+		prog[pc+1].Opcode == code.Push && prog[pc+1].Operand == true &&
+		prog[pc+2].Opcode == code.Jmp && prog[pc+2].Operand.(int) == pc+4 &&
+		prog[pc+3].Opcode == code.Push && prog[pc+3].Operand == false &&
+		prog[pc+4].Opcode == code.Jnm &&
+		targets[pc+1] == 0 && targets[pc+2] == 0 && targets[pc+3] == 1 && targets[pc+4] == 1
+}
+
+// foldCompare evaluates the typed comparison instruction cmp on two constants.
+func foldCompare(a, b interface{}, cmp code.Instr) (bool, bool) {
+	op, ok := cmp.Operand.(int)
+	if !ok {
+		return false, false
+	}
+	switch cmp.Opcode {
+	case code.Icmp:
+		x, xok := a.(int64)
+		y, yok := b.(int64)
+		if !xok || !yok {
+			return false, false
+		}
+		switch op {
+		case -1:
+			return x < y, true
+		case 0:
+			return x == y, true
+		case 1:
+			return x > y, true
+		}
+	case code.Fcmp:
+		x, xok := a.(float64)
+		y, yok := b.(float64)
+		if !xok || !yok {
+			return false, false
+		}
+		switch op {
+		case -1:
+			return x < y, true
+		case 0:
+			return x == y, true
+		case 1:
+			return x > y, true
+		}
+	}
+	return false, false
+}
+
+// compact returns the instructions of prog that are kept, with jump targets
+// moved to the new location of their instruction.  A jump to a dropped
+// instruction goes to the next instruction that is kept.
+func compact(prog []code.Instr, keep []bool) []code.Instr {
+	newPc := make([]int, len(prog)+1)
+	n := 0
+	for pc := range prog {
+		newPc[pc] = n
+		if keep[pc] {
+			n++
+		}
+	}
+	newPc[len(prog)] = n
+	r := make([]code.Instr, 0, n)
+	for pc, i := range prog {
+		if !keep[pc] {
+			continue
+		}
+		if isJump(i.Opcode) {
+			i.Operand = newPc[i.Operand.(int)]
+		}
+		r = append(r, i)
+	}
+	return r
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package opt_test
+
+import (
+	"testing"
+
+	"github.com/google/mtail/internal/runtime/code"
+	"github.com/google/mtail/internal/runtime/compiler/opt"
+	"github.com/google/mtail/internal/testutil"
+)
+
+var bytecodeOptimiserTests = []struct {
+	name string
+	prog []code.Instr
+	want []code.Instr
+}{
+	{
+		"comparison branch",
+		[]code.Instr{
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.Push, int64(3), 2},
+			{code.Icmp, 1, 2},
+			{code.Jnm, 7, 2},
+			{code.Push, true, 2},
+			{code.Jmp, 8, 2},
+			{code.Push, false, 2},
+			{code.Jnm, 12, 2},
+			{code.Mload, 0, 3},
+			{code.Dload, 0, 3},
+			{code.Inc, nil, 3},
+		},
+		[]code.Instr{
+			{code.Push, 0, 2},
+			{code.Capref, 1, 2},
+			{code.Push, int64(3), 2},
+			{code.Icmp, 1, 2},
+			{code.Jnm, 8, 2},
+			{code.Mload, 0, 3},
+			{code.Dload, 0, 3},
+			{code.Inc, nil, 3},
+		},
+	},
+	{
+		"negated comparison branch",
+		[]code.Instr{
+			{code.Icmp, 0, 2},
+			{code.Jm, 4, 2},
+			{code.Push, true, 2},
+			{code.Jmp, 5, 2},
+			{code.Push, false, 2},
+			{code.Jnm, 7, 2},
+			{code.Setmatched, false, 2},
+		},
+		[]code.Instr{
+			{code.Icmp, 0, 2},
+			{code.Jm, 3, 2},
+			{code.Setmatched, false, 2},
+		},
+	},
+	{
+		"constant true branch",
+		[]code.Instr{
+			{code.Push, true, 1},
+			{code.Jnm, 3, 1},
+			{code.Setmatched, false, 1},
+			{code.Setmatched, true, 1},
+		},
+		[]code.Instr{
+			{code.Setmatched, false, 1},
+			{code.Setmatched, true, 1},
+		},
+	},
+	{
+		"constant false branch",
+		[]code.Instr{
+			{code.Push, false, 1},
+			{code.Jnm, 4, 1},
+			{code.Setmatched, false, 1},
+			{code.Setmatched, true, 1},
+			{code.Mload, 0, 2},
+		},
+		[]code.Instr{
+			{code.Mload, 0, 2},
+		},
+	},
+	{
+		"constant comparison",
+		[]code.Instr{
+			{code.Push, 1.0, 1},
+			{code.Push, 2.0, 1},
+			{code.Fcmp, -1, 1},
+			{code.Jnm, 5, 1},
+			{code.Setmatched, false, 1},
+			{code.Setmatched, true, 1},
+		},
+		[]code.Instr{
+			{code.Setmatched, false, 1},
+			{code.Setmatched, true, 1},
+		},
+	},
+	{
+		"unreachable after jump",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 5, 1},
+			{code.Jmp, 6, 1},
+			{code.Mload, 0, 2},
+			{code.Inc, nil, 2},
+			{code.Mload, 1, 3},
+			{code.Inc, nil, 3},
+		},
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 3, 1},
+			{code.Jmp, 4, 1},
+			{code.Mload, 1, 3},
+			{code.Inc, nil, 3},
+		},
+	},
+	{
+		"unreachable after stop",
+		[]code.Instr{
+			{code.Stop, nil, 1},
+			{code.Mload, 0, 2},
+			{code.Inc, nil, 2},
+		},
+		[]code.Instr{
+			{code.Stop, nil, 1},
+		},
+	},
+	{
+		"jump to next",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 3, 1},
+			{code.Jmp, 3, 1},
+			{code.Setmatched, true, 1},
+		},
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 2, 1},
+			{code.Setmatched, true, 1},
+		},
+	},
+	{
+		"shared false label is kept",
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 6, 1},
+			{code.Match, 1, 1},
+			{code.Jnm, 6, 1},
+			{code.Push, true, 1},
+			{code.Jmp, 7, 1},
+			{code.Push, false, 1},
+			{code.Jnm, 9, 1},
+			{code.Setmatched, false, 1},
+		},
+		[]code.Instr{
+			{code.Match, 0, 1},
+			{code.Jnm, 6, 1},
+			{code.Match, 1, 1},
+			{code.Jnm, 6, 1},
+			{code.Push, true, 1},
+			{code.Jmp, 7, 1},
+			{code.Push, false, 1},
+			{code.Jnm, 9, 1},
+			{code.Setmatched, false, 1},
+		},
+	},
+}
+
+func TestOptimiseBytecode(t *testing.T) {
+	for _, tc := range bytecodeOptimiserTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := opt.OptimiseBytecode(tc.prog)
+			testutil.ExpectNoDiff(t, tc.want, got)
+		})
+	}
+}
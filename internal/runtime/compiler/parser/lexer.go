@@ -30,10 +30,13 @@ var keywords = map[string]Kind{
 	"gauge":     GAUGE,
 	"hidden":    HIDDEN,
 	"histogram": HISTOGRAM,
+	"include":   INCLUDE,
 	"limit":     LIMIT,
 	"next":      NEXT,
 	"otherwise": OTHERWISE,
+	"quantiles": QUANTILES,
 	"stop":      STOP,
+	"summary":   SUMMARY,
 	"text":      TEXT,
 	"timer":     TIMER,
 }
@@ -42,16 +45,21 @@ var keywords = map[string]Kind{
 var builtins = []string{
 	"bool",
 	"float",
+	"getenv",
 	"getfilename",
 	"int",
+	"json_field",
 	"len",
+	"logfmt_field",
 	"settime",
 	"string",
 	"strptime",
 	"strtol",
 	"subst",
+	"substr",
 	"timestamp",
 	"tolower",
+	"toupper",
 }
 
 // Dictionary returns a list of all keywords and builtins of the language.
@@ -242,6 +250,9 @@ func lexProg(l *Lexer) stateFn {
 		case r == '-':
 			l.accept()
 			l.emit(DEC)
+		case r == '=':
+			l.accept()
+			l.emit(SUB_ASSIGN)
 		case isDigit(r):
 			l.backup()
 			return lexNumeric
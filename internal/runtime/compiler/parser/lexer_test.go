@@ -44,7 +44,7 @@ var lexerTests = []lexerTest{
 		{COMMA, ",", position.Position{"punctuation", 0, 6, 6}},
 		{EOF, "", position.Position{"punctuation", 0, 7, 7}},
 	}},
-	{"operators", "- + = ++ += < > <= >= == != * / << >> & | ^ ~ ** % || && =~ !~ --", []Token{
+	{"operators", "- + = ++ += < > <= >= == != * / << >> & | ^ ~ ** % || && =~ !~ -- -=", []Token{
 		{MINUS, "-", position.Position{"operators", 0, 0, 0}},
 		{PLUS, "+", position.Position{"operators", 0, 2, 2}},
 		{ASSIGN, "=", position.Position{"operators", 0, 4, 4}},
@@ -71,11 +71,12 @@ var lexerTests = []lexerTest{
 		{MATCH, "=~", position.Position{"operators", 0, 57, 58}},
 		{NOT_MATCH, "!~", position.Position{"operators", 0, 60, 61}},
 		{DEC, "--", position.Position{"operators", 0, 63, 64}},
-		{EOF, "", position.Position{"operators", 0, 65, 65}},
+		{SUB_ASSIGN, "-=", position.Position{"operators", 0, 66, 67}},
+		{EOF, "", position.Position{"operators", 0, 68, 68}},
 	}},
 	{
 		"keywords",
-		"counter\ngauge\nas\nby\nhidden\ndef\nnext\nconst\ntimer\notherwise\nelse\ndel\ntext\nafter\nstop\nhistogram\nbuckets\n",
+		"counter\ngauge\nas\nby\nhidden\ndef\nnext\nconst\ntimer\notherwise\nelse\ndel\ntext\nafter\nstop\nhistogram\nbuckets\ninclude\n",
 		[]Token{
 			{COUNTER, "counter", position.Position{"keywords", 0, 0, 6}},
 			{NL, "\n", position.Position{"keywords", 1, 7, -1}},
@@ -111,12 +112,14 @@ var lexerTests = []lexerTest{
 			{NL, "\n", position.Position{"keywords", 16, 9, -1}},
 			{BUCKETS, "buckets", position.Position{"keywords", 16, 0, 6}},
 			{NL, "\n", position.Position{"keywords", 17, 7, -1}},
-			{EOF, "", position.Position{"keywords", 17, 0, 0}},
+			{INCLUDE, "include", position.Position{"keywords", 17, 0, 6}},
+			{NL, "\n", position.Position{"keywords", 18, 7, -1}},
+			{EOF, "", position.Position{"keywords", 18, 0, 0}},
 		},
 	},
 	{
 		"builtins",
-		"strptime\ntimestamp\ntolower\nlen\nstrtol\nsettime\ngetfilename\nint\nbool\nfloat\nstring\nsubst\n",
+		"strptime\ntimestamp\ntolower\nlen\nstrtol\nsettime\ngetfilename\nint\nbool\nfloat\nstring\nsubst\ntoupper\n",
 		[]Token{
 			{BUILTIN, "strptime", position.Position{"builtins", 0, 0, 7}},
 			{NL, "\n", position.Position{"builtins", 1, 8, -1}},
@@ -142,7 +145,9 @@ var lexerTests = []lexerTest{
 			{NL, "\n", position.Position{"builtins", 11, 6, -1}},
 			{BUILTIN, "subst", position.Position{"builtins", 11, 0, 4}},
 			{NL, "\n", position.Position{"builtins", 12, 5, -1}},
-			{EOF, "", position.Position{"builtins", 12, 0, 0}},
+			{BUILTIN, "toupper", position.Position{"builtins", 12, 0, 6}},
+			{NL, "\n", position.Position{"builtins", 13, 7, -1}},
+			{EOF, "", position.Position{"builtins", 13, 0, 0}},
 		},
 	},
 	{"numbers", "1 23 3.14 1.61.1 -1 -1.0 1h 0d 3d -1.5h 15m 24h0m0s 1e3 1e-3 .11 123.456e7", []Token{
@@ -9,6 +9,7 @@ import __yyfmt__ "fmt"
 //line parser.y:6
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/golang/glog"
@@ -17,7 +18,7 @@ import (
 	"github.com/google/mtail/internal/runtime/compiler/position"
 )
 
-//line parser.y:19
+//line parser.y:20
 type mtailSymType struct {
 	yys      int
 	intVal   int64
@@ -38,63 +39,68 @@ const GAUGE = 57348
 const TIMER = 57349
 const TEXT = 57350
 const HISTOGRAM = 57351
-const AFTER = 57352
-const AS = 57353
-const BY = 57354
-const CONST = 57355
-const HIDDEN = 57356
-const DEF = 57357
-const DEL = 57358
-const NEXT = 57359
-const OTHERWISE = 57360
-const ELSE = 57361
-const STOP = 57362
-const BUCKETS = 57363
-const LIMIT = 57364
-const BUILTIN = 57365
-const REGEX = 57366
-const STRING = 57367
-const CAPREF = 57368
-const CAPREF_NAMED = 57369
-const ID = 57370
-const DECO = 57371
-const INTLITERAL = 57372
-const FLOATLITERAL = 57373
-const DURATIONLITERAL = 57374
-const INC = 57375
-const DEC = 57376
-const DIV = 57377
-const MOD = 57378
-const MUL = 57379
-const MINUS = 57380
-const PLUS = 57381
-const POW = 57382
-const SHL = 57383
-const SHR = 57384
-const LT = 57385
-const GT = 57386
-const LE = 57387
-const GE = 57388
-const EQ = 57389
-const NE = 57390
-const BITAND = 57391
-const XOR = 57392
-const BITOR = 57393
-const NOT = 57394
-const AND = 57395
-const OR = 57396
-const ADD_ASSIGN = 57397
-const ASSIGN = 57398
-const MATCH = 57399
-const NOT_MATCH = 57400
-const LCURLY = 57401
-const RCURLY = 57402
-const LPAREN = 57403
-const RPAREN = 57404
-const LSQUARE = 57405
-const RSQUARE = 57406
-const COMMA = 57407
-const NL = 57408
+const SUMMARY = 57352
+const AFTER = 57353
+const AS = 57354
+const BY = 57355
+const CONST = 57356
+const HIDDEN = 57357
+const DEF = 57358
+const DEL = 57359
+const NEXT = 57360
+const OTHERWISE = 57361
+const ELSE = 57362
+const STOP = 57363
+const BUCKETS = 57364
+const LIMIT = 57365
+const INCLUDE = 57366
+const QUANTILES = 57367
+const BUILTIN = 57368
+const REGEX = 57369
+const STRING = 57370
+const CAPREF = 57371
+const CAPREF_NAMED = 57372
+const ID = 57373
+const DECO = 57374
+const INTLITERAL = 57375
+const FLOATLITERAL = 57376
+const DURATIONLITERAL = 57377
+const INC = 57378
+const DEC = 57379
+const DIV = 57380
+const MOD = 57381
+const MUL = 57382
+const MINUS = 57383
+const PLUS = 57384
+const POW = 57385
+const SHL = 57386
+const SHR = 57387
+const LT = 57388
+const GT = 57389
+const LE = 57390
+const GE = 57391
+const EQ = 57392
+const NE = 57393
+const BITAND = 57394
+const XOR = 57395
+const BITOR = 57396
+const NOT = 57397
+const AND = 57398
+const OR = 57399
+const ADD_ASSIGN = 57400
+const SUB_ASSIGN = 57401
+const ASSIGN = 57402
+const MATCH = 57403
+const NOT_MATCH = 57404
+const LCURLY = 57405
+const RCURLY = 57406
+const LPAREN = 57407
+const RPAREN = 57408
+const LSQUARE = 57409
+const RSQUARE = 57410
+const COMMA = 57411
+const NL = 57412
+const DECL = 57413
 
 var mtailToknames = [...]string{
 	"$end",
@@ -106,6 +112,7 @@ var mtailToknames = [...]string{
 	"TIMER",
 	"TEXT",
 	"HISTOGRAM",
+	"SUMMARY",
 	"AFTER",
 	"AS",
 	"BY",
@@ -119,6 +126,8 @@ var mtailToknames = [...]string{
 	"STOP",
 	"BUCKETS",
 	"LIMIT",
+	"INCLUDE",
+	"QUANTILES",
 	"BUILTIN",
 	"REGEX",
 	"STRING",
@@ -152,6 +161,7 @@ var mtailToknames = [...]string{
 	"AND",
 	"OR",
 	"ADD_ASSIGN",
+	"SUB_ASSIGN",
 	"ASSIGN",
 	"MATCH",
 	"NOT_MATCH",
@@ -163,6 +173,7 @@ var mtailToknames = [...]string{
 	"RSQUARE",
 	"COMMA",
 	"NL",
+	"DECL",
 }
 
 var mtailStatenames = [...]string{}
@@ -171,9 +182,9 @@ const mtailEofCode = 1
 const mtailErrCode = 2
 const mtailInitialStackSize = 16
 
-//line parser.y:733
+//line parser.y:810
 
-//  tokenpos returns the position of the current token.
+// tokenpos returns the position of the current token.
 func tokenpos(mtaillex mtailLexer) position.Position {
 	return mtaillex.(*parser).t.Pos
 }
@@ -192,184 +203,196 @@ func positionFromMark(mtaillex mtailLexer) position.Position {
 }
 
 //line yacctab:1
-var mtailExca = [...]int{
+var mtailExca = [...]int16{
 	-1, 1,
 	1, -1,
 	-2, 0,
 	-1, 2,
 	1, 1,
-	5, 93,
-	6, 93,
-	7, 93,
-	8, 93,
-	9, 93,
-	-2, 124,
-	-1, 22,
-	66, 24,
-	-2, 68,
-	-1, 106,
-	5, 93,
-	6, 93,
-	7, 93,
-	8, 93,
-	9, 93,
-	-2, 124,
+	16, 135,
+	17, 135,
+	19, 135,
+	26, 135,
+	32, 135,
+	38, 135,
+	-2, 95,
+	-1, 23,
+	70, 25,
+	-2, 70,
+	-1, 110,
+	16, 135,
+	17, 135,
+	19, 135,
+	26, 135,
+	32, 135,
+	38, 135,
+	-2, 95,
 }
 
 const mtailPrivate = 57344
 
-const mtailLast = 249
-
-var mtailAct = [...]int{
-	171, 88, 126, 28, 15, 91, 42, 44, 27, 30,
-	103, 127, 41, 24, 20, 86, 40, 167, 22, 128,
-	163, 182, 19, 26, 45, 29, 104, 25, 36, 34,
-	35, 43, 54, 38, 39, 87, 181, 85, 89, 125,
-	108, 46, 36, 34, 35, 43, 47, 38, 39, 90,
-	162, 163, 62, 63, 2, 31, 49, 87, 76, 77,
-	68, 130, 74, 73, 37, 138, 62, 63, 50, 112,
-	93, 94, 117, 97, 96, 118, 168, 50, 37, 119,
-	120, 70, 72, 71, 121, 122, 123, 66, 67, 124,
-	107, 129, 185, 184, 111, 79, 80, 81, 82, 83,
-	84, 169, 106, 131, 179, 135, 132, 175, 15, 133,
-	129, 43, 27, 110, 100, 101, 99, 134, 20, 102,
-	174, 135, 22, 173, 87, 129, 19, 160, 87, 151,
-	156, 142, 155, 157, 158, 87, 87, 87, 164, 166,
-	165, 161, 153, 159, 140, 154, 152, 136, 139, 178,
-	177, 13, 141, 116, 66, 67, 115, 49, 105, 109,
-	11, 23, 1, 176, 10, 129, 180, 12, 64, 145,
-	13, 65, 36, 34, 35, 43, 75, 38, 39, 11,
-	23, 98, 183, 10, 95, 69, 12, 92, 61, 78,
-	18, 36, 34, 35, 43, 170, 38, 39, 143, 31,
-	56, 57, 58, 59, 60, 172, 144, 137, 37, 36,
-	34, 35, 43, 16, 38, 39, 146, 55, 31, 33,
-	51, 53, 114, 48, 9, 8, 7, 37, 49, 113,
-	6, 32, 16, 21, 52, 17, 31, 148, 147, 5,
-	50, 14, 4, 3, 0, 37, 0, 149, 150,
+const mtailLast = 266
+
+var mtailAct = [...]uint8{
+	185, 92, 131, 190, 16, 43, 29, 95, 42, 45,
+	28, 132, 133, 107, 21, 20, 30, 108, 41, 48,
+	46, 56, 181, 199, 31, 177, 198, 27, 26, 37,
+	35, 36, 44, 93, 39, 40, 176, 177, 112, 91,
+	89, 90, 130, 49, 23, 25, 37, 35, 36, 44,
+	94, 39, 40, 65, 66, 2, 32, 80, 81, 65,
+	66, 71, 91, 135, 97, 98, 38, 143, 101, 100,
+	111, 182, 51, 32, 115, 77, 78, 76, 121, 52,
+	200, 122, 195, 38, 52, 123, 124, 73, 75, 74,
+	125, 126, 127, 128, 147, 134, 129, 116, 83, 84,
+	85, 86, 87, 88, 183, 110, 104, 105, 103, 140,
+	136, 106, 16, 137, 134, 194, 138, 196, 28, 69,
+	70, 188, 21, 20, 187, 140, 69, 70, 44, 141,
+	134, 91, 168, 164, 146, 174, 91, 170, 171, 172,
+	167, 173, 178, 91, 91, 91, 180, 179, 175, 169,
+	166, 165, 23, 139, 114, 144, 189, 14, 47, 37,
+	35, 36, 44, 193, 39, 40, 51, 11, 24, 203,
+	202, 10, 192, 191, 12, 53, 55, 13, 50, 134,
+	197, 37, 35, 36, 44, 51, 39, 40, 109, 120,
+	145, 54, 119, 67, 113, 14, 38, 52, 1, 201,
+	151, 150, 68, 79, 102, 11, 24, 99, 32, 10,
+	72, 96, 12, 82, 64, 13, 19, 142, 38, 37,
+	35, 36, 44, 17, 39, 40, 184, 161, 157, 156,
+	58, 59, 60, 61, 62, 63, 148, 186, 158, 160,
+	155, 159, 149, 154, 163, 153, 32, 162, 152, 57,
+	34, 118, 9, 8, 7, 117, 38, 6, 33, 22,
+	18, 17, 5, 15, 4, 3,
 }
 
-var mtailPact = [...]int{
-	-1000, -1000, 166, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, 83, -1000, -1000, -13, 205, -1000, -34, 195, 13,
-	13, -1000, 54, -1000, 21, 32, -1000, 7, 1, -1000,
-	52, 184, -25, -1000, -1000, -1000, -1000, 184, -1000, -1000,
-	29, -1000, 35, -1000, 79, -40, 139, -1000, -13, -21,
-	-1000, 85, -13, 17, -1000, 128, -1000, -1000, -1000, -1000,
-	-1000, -40, -1000, -1000, -40, -1000, -1000, -1000, -40, -40,
-	-1000, -1000, -1000, -40, -40, -40, -1000, -1000, -40, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, 54, -1000, 134, 184,
-	-1, -1000, -40, -1000, -1000, -40, -1000, -1000, -40, -1000,
-	-1000, -1000, -1000, -1000, -1000, -13, 147, -1000, 3, 120,
-	-13, -1000, 121, 226, -1000, -1000, -1000, 184, 184, 83,
-	184, 184, 184, 17, 184, -14, -1000, 13, -1000, 33,
-	-1000, 184, 184, 184, 21, 42, -1000, -1000, -1000, -45,
-	41, -1000, 69, -1000, -1000, -1000, -1000, 95, 82, 119,
-	74, 13, 32, -1000, -1000, -1000, 52, 13, 13, -1000,
-	-1000, 29, -1000, 184, 35, 79, -1000, -1000, -1000, -1000,
-	-29, -1000, -1000, -1000, -1000, -1000, -44, -1000, -1000, -1000,
-	-1000, 95, 62, -1000, -1000, -1000,
+var mtailPact = [...]int16{
+	-32768, -32768, 191, -32768, -32768, -32768, -32768, -32768, -32768, -32768,
+	-32768, 97, -32768, 130, -32768, -20, 159, -32768, -49, 225,
+	3, 3, -32768, 90, -32768, 19, 35, -32768, 17, -4,
+	-32768, 52, 18, -34, -32768, -32768, -32768, -32768, 18, -32768,
+	-32768, 20, -32768, 27, -32768, 68, -53, -32768, 168, -32768,
+	-20, -27, -32768, 123, -20, 131, -32768, 161, -32768, -32768,
+	-32768, -32768, -32768, -32768, -53, -32768, -32768, -53, -32768, -32768,
+	-32768, -53, -53, -32768, -32768, -32768, -53, -53, -53, -53,
+	-32768, -32768, -53, -32768, -32768, -32768, -32768, -32768, -32768, -32768,
+	90, -32768, 140, 18, -3, -32768, -53, -32768, -32768, -53,
+	-32768, -32768, -53, -32768, -32768, -32768, -32768, -32768, -32768, -20,
+	153, -32768, 1, 163, -20, -32768, 83, 216, -32768, -32768,
+	-32768, 18, 18, 97, 18, 18, 18, 18, 131, 18,
+	-32, -32768, 3, -32768, 46, -32768, 18, 18, 18, 19,
+	41, -32768, -32768, -32768, -44, 33, -32768, 69, -32768, -32768,
+	-32768, -32768, -32768, -32768, -32768, -32768, 93, 128, 139, 139,
+	82, 47, 86, -32768, 3, 35, -32768, -32768, -32768, 52,
+	3, 3, 3, -32768, -32768, 20, -32768, 18, 27, 68,
+	-32768, -32768, -32768, -32768, -43, -32768, -32768, -32768, -32768, -32768,
+	-46, -32768, -32768, -46, -32768, -32768, 45, -32768, 93, 136,
+	-32768, -32768, -32768, -32768,
 }
 
-var mtailPgo = [...]int{
-	0, 54, 243, 39, 41, 242, 241, 239, 235, 3,
-	7, 6, 15, 5, 233, 9, 16, 27, 11, 231,
-	12, 13, 19, 230, 229, 226, 225, 25, 23, 224,
-	222, 219, 2, 217, 216, 206, 205, 0, 198, 195,
-	190, 189, 187, 185, 168, 184, 181, 176, 171, 169,
-	163, 162, 10, 1, 159,
+var mtailPgo = [...]int16{
+	0, 55, 265, 42, 19, 264, 263, 262, 260, 6,
+	9, 5, 41, 7, 259, 24, 18, 28, 11, 258,
+	8, 45, 12, 257, 255, 254, 253, 16, 27, 252,
+	251, 250, 2, 249, 248, 245, 243, 242, 240, 237,
+	0, 236, 226, 216, 213, 211, 210, 193, 207, 204,
+	203, 202, 201, 3, 200, 198, 13, 1, 194,
 }
 
-var mtailR1 = [...]int{
-	0, 51, 1, 1, 2, 2, 2, 2, 2, 2,
-	2, 2, 2, 2, 5, 5, 5, 6, 6, 6,
-	7, 7, 4, 8, 8, 14, 14, 18, 18, 18,
-	18, 44, 44, 17, 17, 43, 43, 43, 15, 15,
-	41, 41, 41, 41, 41, 41, 16, 16, 42, 42,
-	11, 11, 45, 45, 28, 28, 47, 47, 22, 21,
-	21, 21, 10, 10, 46, 46, 46, 46, 13, 13,
-	12, 12, 48, 48, 9, 9, 9, 9, 9, 9,
-	9, 9, 19, 19, 20, 31, 31, 3, 3, 32,
-	32, 27, 23, 40, 40, 24, 24, 24, 24, 24,
-	30, 30, 33, 33, 33, 33, 33, 38, 39, 39,
-	37, 35, 34, 49, 50, 50, 50, 50, 25, 26,
-	29, 29, 36, 36, 53, 54, 52, 52,
+var mtailR1 = [...]int8{
+	0, 55, 1, 1, 2, 2, 2, 2, 2, 2,
+	2, 2, 2, 2, 2, 5, 5, 5, 6, 6,
+	6, 7, 7, 4, 8, 8, 14, 14, 14, 18,
+	18, 18, 18, 47, 47, 17, 17, 46, 46, 46,
+	15, 15, 44, 44, 44, 44, 44, 44, 16, 16,
+	45, 45, 11, 11, 48, 48, 28, 28, 50, 50,
+	22, 21, 21, 21, 10, 10, 49, 49, 49, 49,
+	13, 13, 12, 12, 51, 51, 9, 9, 9, 9,
+	9, 9, 9, 9, 19, 19, 20, 31, 31, 3,
+	3, 32, 32, 27, 23, 43, 43, 24, 24, 24,
+	24, 24, 24, 24, 24, 24, 30, 30, 33, 33,
+	33, 33, 33, 33, 41, 42, 42, 40, 37, 38,
+	34, 35, 36, 54, 52, 53, 53, 53, 53, 25,
+	26, 29, 29, 39, 39, 57, 58, 56, 56,
 }
 
-var mtailR2 = [...]int{
+var mtailR2 = [...]int8{
 	0, 1, 0, 2, 1, 1, 1, 1, 1, 1,
-	1, 4, 1, 1, 4, 2, 3, 1, 4, 1,
-	1, 2, 3, 1, 1, 4, 4, 1, 1, 4,
-	4, 1, 1, 1, 4, 1, 1, 1, 1, 4,
-	1, 1, 1, 1, 1, 1, 1, 4, 1, 1,
-	1, 4, 1, 1, 4, 4, 1, 1, 1, 1,
-	4, 4, 1, 4, 1, 1, 1, 1, 1, 2,
-	1, 2, 1, 1, 1, 1, 1, 1, 1, 3,
-	1, 1, 1, 4, 1, 4, 5, 1, 3, 1,
-	1, 5, 3, 0, 1, 2, 2, 2, 2, 1,
-	1, 1, 1, 1, 1, 1, 1, 2, 1, 3,
-	1, 2, 2, 2, 1, 1, 3, 3, 4, 3,
-	5, 3, 1, 1, 0, 0, 0, 1,
+	1, 4, 1, 2, 1, 4, 2, 3, 1, 4,
+	1, 1, 2, 3, 1, 1, 4, 4, 4, 1,
+	1, 4, 4, 1, 1, 1, 4, 1, 1, 1,
+	1, 4, 1, 1, 1, 1, 1, 1, 1, 4,
+	1, 1, 1, 4, 1, 1, 4, 4, 1, 1,
+	1, 1, 4, 4, 1, 4, 1, 1, 1, 1,
+	1, 2, 1, 2, 1, 1, 1, 1, 1, 1,
+	1, 3, 1, 1, 1, 4, 1, 4, 5, 1,
+	3, 1, 1, 5, 3, 0, 1, 2, 2, 2,
+	2, 2, 2, 2, 2, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 2, 1, 3, 1, 2, 1,
+	2, 2, 3, 2, 2, 1, 1, 3, 3, 4,
+	3, 5, 3, 1, 1, 0, 0, 0, 1,
 }
 
-var mtailChk = [...]int{
-	-1000, -51, -1, -2, -5, -7, -23, -25, -26, -29,
-	17, 13, 20, 4, -6, -53, 66, -8, -40, -22,
-	-18, -14, -12, 14, -21, -17, -28, -13, -9, -27,
-	-15, 52, -19, -31, 26, 27, 25, 61, 30, 31,
-	-16, -20, -11, 28, -10, -20, -4, 59, 18, 23,
-	35, 15, 29, 16, 66, -33, 5, 6, 7, 8,
-	9, -44, 53, 54, -44, -48, 33, 34, 39, -43,
-	49, 51, 50, 56, 55, -47, 57, 58, -41, 43,
-	44, 45, 46, 47, 48, -13, -12, -9, -53, 63,
-	-18, -13, -42, 41, 42, -45, 39, 38, -46, 37,
-	35, 36, 40, -52, 66, 19, -1, -4, 61, -54,
-	28, -4, -12, -24, -30, 28, 25, -52, -52, -52,
-	-52, -52, -52, -52, -52, -3, -32, -18, -22, -53,
-	62, -52, -52, -52, -21, -53, -4, 60, 62, -3,
-	24, -4, 10, -38, -35, -49, -34, 12, 11, 21,
-	22, -18, -17, -28, -27, -20, -15, -18, -18, -22,
-	-9, -16, 64, 65, -11, -10, -13, 62, 35, 32,
-	-39, -37, -36, 28, 25, 25, -50, 31, 30, 30,
-	-32, 65, 65, -37, 31, 30,
+var mtailChk = [...]int16{
+	-32768, -55, -1, -2, -5, -7, -23, -25, -26, -29,
+	18, 14, 21, 24, 4, -6, -57, 70, -8, -43,
+	-22, -18, -14, -12, 15, -21, -17, -28, -13, -9,
+	-27, -15, 55, -19, -31, 29, 30, 28, 65, 33,
+	34, -16, -20, -11, 31, -10, -20, 28, -4, 63,
+	19, 26, 38, 16, 32, 17, 70, -33, 5, 6,
+	7, 8, 9, 10, -47, 56, 57, -47, -51, 36,
+	37, 42, -46, 52, 54, 53, 60, 58, 59, -50,
+	61, 62, -44, 46, 47, 48, 49, 50, 51, -13,
+	-12, -9, -57, 67, -18, -13, -45, 44, 45, -48,
+	42, 41, -49, 40, 38, 39, 43, -56, 70, 20,
+	-1, -4, 65, -58, 31, -4, -12, -24, -30, 31,
+	28, -56, -56, -56, -56, -56, -56, -56, -56, -56,
+	-3, -32, -18, -22, -57, 66, -56, -56, -56, -21,
+	-57, -4, 64, 66, -3, 27, -4, 11, -41, -37,
+	-52, -54, -34, -35, -36, -38, 13, 12, 22, 25,
+	23, 11, 31, 28, -18, -17, -28, -27, -20, -15,
+	-18, -18, -18, -22, -9, -16, 68, 69, -11, -10,
+	-13, 66, 38, 35, -42, -40, -39, 31, 28, 28,
+	-53, 34, 33, -53, 33, 35, 31, -32, 69, 69,
+	35, -40, 34, 33,
 }
 
-var mtailDef = [...]int{
+var mtailDef = [...]int16{
 	2, -2, -2, 3, 4, 5, 6, 7, 8, 9,
-	10, 0, 12, 13, 0, 0, 20, 0, 0, 17,
-	19, 23, -2, 94, 58, 27, 28, 62, 70, 59,
-	33, 124, 74, 75, 76, 77, 78, 124, 80, 81,
-	38, 82, 46, 84, 50, 126, 15, 2, 0, 0,
-	125, 0, 0, 124, 21, 0, 102, 103, 104, 105,
-	106, 126, 31, 32, 126, 71, 72, 73, 126, 126,
-	35, 36, 37, 126, 126, 126, 56, 57, 126, 40,
-	41, 42, 43, 44, 45, 69, 68, 70, 0, 124,
-	0, 62, 126, 48, 49, 126, 52, 53, 126, 64,
-	65, 66, 67, 124, 127, 0, -2, 16, 124, 0,
-	0, 119, 121, 92, 99, 100, 101, 124, 124, 124,
-	124, 124, 124, 124, 124, 0, 87, 89, 90, 0,
-	79, 124, 124, 124, 11, 0, 14, 22, 85, 0,
-	0, 118, 0, 95, 96, 97, 98, 0, 0, 0,
-	0, 18, 29, 30, 60, 61, 34, 25, 26, 54,
-	55, 39, 83, 124, 47, 51, 63, 86, 91, 120,
-	107, 108, 110, 122, 123, 111, 113, 114, 115, 112,
-	88, 0, 0, 109, 116, 117,
+	10, 0, 12, 0, 14, 0, 0, 21, 0, 0,
+	18, 20, 24, -2, 96, 60, 29, 30, 64, 72,
+	61, 35, 135, 76, 77, 78, 79, 80, 135, 82,
+	83, 40, 84, 48, 86, 52, 137, 13, 16, 2,
+	0, 0, 136, 0, 0, 135, 22, 0, 108, 109,
+	110, 111, 112, 113, 137, 33, 34, 137, 73, 74,
+	75, 137, 137, 37, 38, 39, 137, 137, 137, 137,
+	58, 59, 137, 42, 43, 44, 45, 46, 47, 71,
+	70, 72, 0, 135, 0, 64, 137, 50, 51, 137,
+	54, 55, 137, 66, 67, 68, 69, 135, 138, 0,
+	-2, 17, 135, 0, 0, 130, 132, 94, 105, 106,
+	107, 135, 135, 135, 135, 135, 135, 135, 135, 135,
+	0, 89, 91, 92, 0, 81, 135, 135, 135, 11,
+	0, 15, 23, 87, 0, 0, 129, 0, 97, 98,
+	99, 100, 101, 102, 103, 104, 0, 0, 0, 0,
+	0, 0, 0, 119, 19, 31, 32, 62, 63, 36,
+	26, 27, 28, 56, 57, 41, 85, 135, 49, 53,
+	65, 88, 93, 131, 114, 115, 117, 133, 134, 118,
+	124, 125, 126, 123, 120, 121, 0, 90, 0, 0,
+	122, 116, 127, 128,
 }
 
-var mtailTok1 = [...]int{
+var mtailTok1 = [...]int8{
 	1,
 }
 
-var mtailTok2 = [...]int{
+var mtailTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
 	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
 	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
 	52, 53, 54, 55, 56, 57, 58, 59, 60, 61,
-	62, 63, 64, 65, 66,
+	62, 63, 64, 65, 66, 67, 68, 69, 70, 71,
 }
 
-var mtailTok3 = [...]int{
+var mtailTok3 = [...]int8{
 	0,
 }
 
@@ -378,12 +401,12 @@ var mtailErrorMessages = [...]struct {
 	token int
 	msg   string
 }{
-	{109, 4, "unexpected end of file, expecting '/' to end regex"},
-	{15, 1, "unexpected end of file, expecting '}' to end block"},
-	{15, 1, "unexpected end of file, expecting '}' to end block"},
-	{15, 1, "unexpected end of file, expecting '}' to end block"},
-	{14, 63, "unexpected indexing of an expression"},
-	{14, 66, "statement with no effect, missing an assignment, `+' concatenation, or `{}' block?"},
+	{113, 4, "unexpected end of file, expecting '/' to end regex"},
+	{19, 1, "unexpected end of file, expecting '}' to end block"},
+	{19, 1, "unexpected end of file, expecting '}' to end block"},
+	{19, 1, "unexpected end of file, expecting '}' to end block"},
+	{15, 67, "unexpected indexing of an expression"},
+	{15, 70, "statement with no effect, missing an assignment, `+' concatenation, or `{}' block?"},
 }
 
 //line yaccpar:1
@@ -419,7 +442,7 @@ func mtailNewParser() mtailParser {
 	return &mtailParserImpl{}
 }
 
-const mtailFlag = -1000
+const mtailFlag = -32768
 
 func mtailTokname(c int) string {
 	if c >= 1 && c-1 < len(mtailToknames) {
@@ -458,9 +481,9 @@ func mtailErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := mtailPact[state]
+	base := int(mtailPact[state])
 	for tok := TOKSTART; tok-1 < len(mtailToknames); tok++ {
-		if n := base + tok; n >= 0 && n < mtailLast && mtailChk[mtailAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < mtailLast && int(mtailChk[int(mtailAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -470,13 +493,13 @@ func mtailErrorMessage(state, lookAhead int) string {
 
 	if mtailDef[state] == -2 {
 		i := 0
-		for mtailExca[i] != -1 || mtailExca[i+1] != state {
+		for mtailExca[i] != -1 || int(mtailExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; mtailExca[i] >= 0; i += 2 {
-			tok := mtailExca[i]
+			tok := int(mtailExca[i])
 			if tok < TOKSTART || mtailExca[i+1] == 0 {
 				continue
 			}
@@ -507,30 +530,30 @@ func mtaillex1(lex mtailLexer, lval *mtailSymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = mtailTok1[0]
+		token = int(mtailTok1[0])
 		goto out
 	}
 	if char < len(mtailTok1) {
-		token = mtailTok1[char]
+		token = int(mtailTok1[char])
 		goto out
 	}
 	if char >= mtailPrivate {
 		if char < mtailPrivate+len(mtailTok2) {
-			token = mtailTok2[char-mtailPrivate]
+			token = int(mtailTok2[char-mtailPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(mtailTok3); i += 2 {
-		token = mtailTok3[i+0]
+		token = int(mtailTok3[i+0])
 		if token == char {
-			token = mtailTok3[i+1]
+			token = int(mtailTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = mtailTok2[1] /* unknown char */
+		token = int(mtailTok2[1]) /* unknown char */
 	}
 	if mtailDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", mtailTokname(token), uint(char))
@@ -585,7 +608,7 @@ mtailstack:
 	mtailS[mtailp].yys = mtailstate
 
 mtailnewstate:
-	mtailn = mtailPact[mtailstate]
+	mtailn = int(mtailPact[mtailstate])
 	if mtailn <= mtailFlag {
 		goto mtaildefault /* simple state */
 	}
@@ -596,8 +619,8 @@ mtailnewstate:
 	if mtailn < 0 || mtailn >= mtailLast {
 		goto mtaildefault
 	}
-	mtailn = mtailAct[mtailn]
-	if mtailChk[mtailn] == mtailtoken { /* valid shift */
+	mtailn = int(mtailAct[mtailn])
+	if int(mtailChk[mtailn]) == mtailtoken { /* valid shift */
 		mtailrcvr.char = -1
 		mtailtoken = -1
 		mtailVAL = mtailrcvr.lval
@@ -610,7 +633,7 @@ mtailnewstate:
 
 mtaildefault:
 	/* default state action */
-	mtailn = mtailDef[mtailstate]
+	mtailn = int(mtailDef[mtailstate])
 	if mtailn == -2 {
 		if mtailrcvr.char < 0 {
 			mtailrcvr.char, mtailtoken = mtaillex1(mtaillex, &mtailrcvr.lval)
@@ -619,18 +642,18 @@ mtaildefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if mtailExca[xi+0] == -1 && mtailExca[xi+1] == mtailstate {
+			if mtailExca[xi+0] == -1 && int(mtailExca[xi+1]) == mtailstate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			mtailn = mtailExca[xi+0]
+			mtailn = int(mtailExca[xi+0])
 			if mtailn < 0 || mtailn == mtailtoken {
 				break
 			}
 		}
-		mtailn = mtailExca[xi+1]
+		mtailn = int(mtailExca[xi+1])
 		if mtailn < 0 {
 			goto ret0
 		}
@@ -652,10 +675,10 @@ mtaildefault:
 
 			/* find a state where "error" is a legal shift action */
 			for mtailp >= 0 {
-				mtailn = mtailPact[mtailS[mtailp].yys] + mtailErrCode
+				mtailn = int(mtailPact[mtailS[mtailp].yys]) + mtailErrCode
 				if mtailn >= 0 && mtailn < mtailLast {
-					mtailstate = mtailAct[mtailn] /* simulate a shift of "error" */
-					if mtailChk[mtailstate] == mtailErrCode {
+					mtailstate = int(mtailAct[mtailn]) /* simulate a shift of "error" */
+					if int(mtailChk[mtailstate]) == mtailErrCode {
 						goto mtailstack
 					}
 				}
@@ -691,7 +714,7 @@ mtaildefault:
 	mtailpt := mtailp
 	_ = mtailpt // guard against "declared and not used"
 
-	mtailp -= mtailR2[mtailn]
+	mtailp -= int(mtailR2[mtailn])
 	// mtailp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if mtailp+1 >= len(mtailS) {
@@ -702,16 +725,16 @@ mtaildefault:
 	mtailVAL = mtailS[mtailp+1]
 
 	/* consult goto table to find next state */
-	mtailn = mtailR1[mtailn]
-	mtailg := mtailPgo[mtailn]
+	mtailn = int(mtailR1[mtailn])
+	mtailg := int(mtailPgo[mtailn])
 	mtailj := mtailg + mtailS[mtailp].yys + 1
 
 	if mtailj >= mtailLast {
-		mtailstate = mtailAct[mtailg]
+		mtailstate = int(mtailAct[mtailg])
 	} else {
-		mtailstate = mtailAct[mtailj]
-		if mtailChk[mtailstate] != -mtailn {
-			mtailstate = mtailAct[mtailg]
+		mtailstate = int(mtailAct[mtailj])
+		if int(mtailChk[mtailstate]) != -mtailn {
+			mtailstate = int(mtailAct[mtailg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -719,19 +742,19 @@ mtaildefault:
 
 	case 1:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:93
+//line parser.y:101
 		{
 			mtaillex.(*parser).root = mtailDollar[1].n
 		}
 	case 2:
 		mtailDollar = mtailS[mtailpt-0 : mtailpt+1]
-//line parser.y:101
+//line parser.y:109
 		{
 			mtailVAL.n = &ast.StmtList{}
 		}
 	case 3:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:105
+//line parser.y:113
 		{
 			mtailVAL.n = mtailDollar[1].n
 			if mtailDollar[2].n != nil {
@@ -740,73 +763,79 @@ mtaildefault:
 		}
 	case 4:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:116
+//line parser.y:124
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
 	case 5:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:118
+//line parser.y:126
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
 	case 6:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:120
+//line parser.y:128
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
 	case 7:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:122
+//line parser.y:130
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
 	case 8:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:124
+//line parser.y:132
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
 	case 9:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:126
+//line parser.y:134
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
 	case 10:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:128
+//line parser.y:136
 		{
 			mtailVAL.n = &ast.NextStmt{tokenpos(mtaillex)}
 		}
 	case 11:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:132
+//line parser.y:140
 		{
 			mtailVAL.n = &ast.PatternFragment{ID: mtailDollar[2].n, Expr: mtailDollar[4].n}
 		}
 	case 12:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:136
+//line parser.y:144
 		{
 			mtailVAL.n = &ast.StopStmt{tokenpos(mtaillex)}
 		}
 	case 13:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:148
+		{
+			mtailVAL.n = &ast.IncludeStmt{tokenpos(mtaillex), mtailDollar[2].text}
+		}
+	case 14:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:140
+//line parser.y:152
 		{
 			mtailVAL.n = &ast.Error{tokenpos(mtaillex), mtailDollar[1].text}
 		}
-	case 14:
+	case 15:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:148
+//line parser.y:160
 		{
 			mtailVAL.n = &ast.CondStmt{mtailDollar[1].n, mtailDollar[2].n, mtailDollar[4].n, nil}
 		}
-	case 15:
+	case 16:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:152
+//line parser.y:164
 		{
 			if mtailDollar[1].n != nil {
 				mtailVAL.n = &ast.CondStmt{mtailDollar[1].n, mtailDollar[2].n, nil, nil}
@@ -814,22 +843,22 @@ mtaildefault:
 				mtailVAL.n = mtailDollar[2].n
 			}
 		}
-	case 16:
+	case 17:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:160
+//line parser.y:172
 		{
 			o := &ast.OtherwiseStmt{positionFromMark(mtaillex)}
 			mtailVAL.n = &ast.CondStmt{o, mtailDollar[3].n, nil, nil}
 		}
-	case 17:
+	case 18:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:168
+//line parser.y:180
 		{
 			mtailVAL.n = &ast.UnaryExpr{P: tokenpos(mtaillex), Expr: mtailDollar[1].n, Op: MATCH}
 		}
-	case 18:
+	case 19:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:172
+//line parser.y:184
 		{
 			mtailVAL.n = &ast.BinaryExpr{
 				LHS: &ast.UnaryExpr{P: tokenpos(mtaillex), Expr: mtailDollar[1].n, Op: MATCH},
@@ -837,665 +866,732 @@ mtaildefault:
 				Op:  mtailDollar[2].op,
 			}
 		}
-	case 19:
+	case 20:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:180
+//line parser.y:192
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 20:
+	case 21:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:186
+//line parser.y:198
 		{
 			mtailVAL.n = nil
 		}
-	case 21:
+	case 22:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:188
+//line parser.y:200
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 22:
+	case 23:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:194
+//line parser.y:206
 		{
 			mtailVAL.n = mtailDollar[2].n
 		}
-	case 23:
+	case 24:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:202
+//line parser.y:214
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 24:
+	case 25:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:204
+//line parser.y:216
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 25:
+	case 26:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:210
+//line parser.y:222
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 26:
+	case 27:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:214
+//line parser.y:226
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 27:
+	case 28:
+		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
+//line parser.y:230
+		{
+			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
+		}
+	case 29:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:222
+//line parser.y:238
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 28:
+	case 30:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:224
+//line parser.y:240
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 29:
+	case 31:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:226
+//line parser.y:242
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 30:
+	case 32:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:230
+//line parser.y:246
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 31:
+	case 33:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:237
+//line parser.y:253
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 32:
+	case 34:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:239
+//line parser.y:255
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 33:
+	case 35:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:245
+//line parser.y:261
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 34:
+	case 36:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:247
+//line parser.y:263
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 35:
+	case 37:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:254
+//line parser.y:270
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 36:
+	case 38:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:256
+//line parser.y:272
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 37:
+	case 39:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:258
+//line parser.y:274
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 38:
+	case 40:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:264
+//line parser.y:280
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 39:
+	case 41:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:266
+//line parser.y:282
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 40:
+	case 42:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:273
+//line parser.y:289
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 41:
+	case 43:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:275
+//line parser.y:291
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 42:
+	case 44:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:277
+//line parser.y:293
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 43:
+	case 45:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:279
+//line parser.y:295
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 44:
+	case 46:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:281
+//line parser.y:297
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 45:
+	case 47:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:283
+//line parser.y:299
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 46:
+	case 48:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:289
+//line parser.y:305
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 47:
+	case 49:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:291
+//line parser.y:307
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 48:
+	case 50:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:298
+//line parser.y:314
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 49:
+	case 51:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:300
+//line parser.y:316
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 50:
+	case 52:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:306
+//line parser.y:322
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 51:
+	case 53:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:308
+//line parser.y:324
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 52:
+	case 54:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:315
+//line parser.y:331
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 53:
+	case 55:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:317
+//line parser.y:333
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 54:
+	case 56:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:323
+//line parser.y:339
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 55:
+	case 57:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:327
+//line parser.y:343
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 56:
+	case 58:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:334
+//line parser.y:350
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 57:
+	case 59:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:336
+//line parser.y:352
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 58:
+	case 60:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:343
+//line parser.y:359
 		{
 			mtailVAL.n = &ast.PatternExpr{Expr: mtailDollar[1].n}
 		}
-	case 59:
+	case 61:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:351
+//line parser.y:367
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 60:
+	case 62:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:353
+//line parser.y:369
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: PLUS}
 		}
-	case 61:
+	case 63:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:357
+//line parser.y:373
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: PLUS}
 		}
-	case 62:
+	case 64:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:365
+//line parser.y:381
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 63:
+	case 65:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:367
+//line parser.y:383
 		{
 			mtailVAL.n = &ast.BinaryExpr{LHS: mtailDollar[1].n, RHS: mtailDollar[4].n, Op: mtailDollar[2].op}
 		}
-	case 64:
+	case 66:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:374
+//line parser.y:390
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 65:
+	case 67:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:376
+//line parser.y:392
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 66:
+	case 68:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:378
+//line parser.y:394
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 67:
+	case 69:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:380
+//line parser.y:396
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 68:
+	case 70:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:386
+//line parser.y:402
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 69:
+	case 71:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:388
+//line parser.y:404
 		{
 			mtailVAL.n = &ast.UnaryExpr{P: tokenpos(mtaillex), Expr: mtailDollar[2].n, Op: mtailDollar[1].op}
 		}
-	case 70:
+	case 72:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:396
+//line parser.y:412
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 71:
+	case 73:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:398
+//line parser.y:414
 		{
 			mtailVAL.n = &ast.UnaryExpr{P: tokenpos(mtaillex), Expr: mtailDollar[1].n, Op: mtailDollar[2].op}
 		}
-	case 72:
+	case 74:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:405
+//line parser.y:421
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 73:
+	case 75:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:407
+//line parser.y:423
 		{
 			mtailVAL.op = mtailDollar[1].op
 		}
-	case 74:
+	case 76:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:413
+//line parser.y:429
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 75:
+	case 77:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:415
+//line parser.y:431
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 76:
+	case 78:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:417
+//line parser.y:433
 		{
 			mtailVAL.n = &ast.CaprefTerm{tokenpos(mtaillex), mtailDollar[1].text, false, nil}
 		}
-	case 77:
+	case 79:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:421
+//line parser.y:437
 		{
 			mtailVAL.n = &ast.CaprefTerm{tokenpos(mtaillex), mtailDollar[1].text, true, nil}
 		}
-	case 78:
+	case 80:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:425
+//line parser.y:441
 		{
 			mtailVAL.n = &ast.StringLit{tokenpos(mtaillex), mtailDollar[1].text}
 		}
-	case 79:
+	case 81:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:429
+//line parser.y:445
 		{
 			mtailVAL.n = mtailDollar[2].n
 		}
-	case 80:
+	case 82:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:433
+//line parser.y:449
 		{
 			mtailVAL.n = &ast.IntLit{tokenpos(mtaillex), mtailDollar[1].intVal}
 		}
-	case 81:
+	case 83:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:437
+//line parser.y:453
 		{
 			mtailVAL.n = &ast.FloatLit{tokenpos(mtaillex), mtailDollar[1].floatVal}
 		}
-	case 82:
+	case 84:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:445
+//line parser.y:461
 		{
 			// Build an empty IndexedExpr so that the recursive rule below doesn't need to handle the alternative.
 			mtailVAL.n = &ast.IndexedExpr{LHS: mtailDollar[1].n, Index: &ast.ExprList{}}
 		}
-	case 83:
+	case 85:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:450
+//line parser.y:466
 		{
 			mtailVAL.n = mtailDollar[1].n
 			mtailVAL.n.(*ast.IndexedExpr).Index.(*ast.ExprList).Children = append(
 				mtailVAL.n.(*ast.IndexedExpr).Index.(*ast.ExprList).Children,
 				mtailDollar[3].n.(*ast.ExprList).Children...)
 		}
-	case 84:
+	case 86:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:461
+//line parser.y:477
 		{
 			mtailVAL.n = &ast.IDTerm{tokenpos(mtaillex), mtailDollar[1].text, nil, false}
 		}
-	case 85:
+	case 87:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:469
+//line parser.y:485
 		{
 			mtailVAL.n = &ast.BuiltinExpr{P: positionFromMark(mtaillex), Name: mtailDollar[2].text, Args: nil}
 		}
-	case 86:
+	case 88:
 		mtailDollar = mtailS[mtailpt-5 : mtailpt+1]
-//line parser.y:473
+//line parser.y:489
 		{
 			mtailVAL.n = &ast.BuiltinExpr{P: positionFromMark(mtaillex), Name: mtailDollar[2].text, Args: mtailDollar[4].n}
 		}
-	case 87:
+	case 89:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:482
+//line parser.y:498
 		{
 			mtailVAL.n = &ast.ExprList{}
 			mtailVAL.n.(*ast.ExprList).Children = append(mtailVAL.n.(*ast.ExprList).Children, mtailDollar[1].n)
 		}
-	case 88:
+	case 90:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:487
+//line parser.y:503
 		{
 			mtailVAL.n = mtailDollar[1].n
 			mtailVAL.n.(*ast.ExprList).Children = append(mtailVAL.n.(*ast.ExprList).Children, mtailDollar[3].n)
 		}
-	case 89:
+	case 91:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:495
+//line parser.y:511
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 90:
+	case 92:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:497
+//line parser.y:513
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 91:
+	case 93:
 		mtailDollar = mtailS[mtailpt-5 : mtailpt+1]
-//line parser.y:503
+//line parser.y:519
 		{
 			mtailVAL.n = &ast.PatternLit{P: positionFromMark(mtaillex), Pattern: mtailDollar[4].text}
 		}
-	case 92:
+	case 94:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:511
+//line parser.y:527
 		{
 			mtailVAL.n = mtailDollar[3].n
 			d := mtailVAL.n.(*ast.VarDecl)
 			d.Kind = mtailDollar[2].kind
 			d.Hidden = mtailDollar[1].flag
 		}
-	case 93:
+	case 95:
 		mtailDollar = mtailS[mtailpt-0 : mtailpt+1]
-//line parser.y:522
+//line parser.y:538
 		{
 			mtailVAL.flag = false
 		}
-	case 94:
+	case 96:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:526
+//line parser.y:542
 		{
 			mtailVAL.flag = true
 		}
-	case 95:
+	case 97:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:534
+//line parser.y:550
 		{
 			mtailVAL.n = mtailDollar[1].n
 			mtailVAL.n.(*ast.VarDecl).Keys = mtailDollar[2].texts
 		}
-	case 96:
+	case 98:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:539
+//line parser.y:555
 		{
 			mtailVAL.n = mtailDollar[1].n
 			mtailVAL.n.(*ast.VarDecl).ExportedName = mtailDollar[2].text
 		}
-	case 97:
+	case 99:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:544
+//line parser.y:560
 		{
 			mtailVAL.n = mtailDollar[1].n
 			mtailVAL.n.(*ast.VarDecl).Buckets = mtailDollar[2].floats
 		}
-	case 98:
+	case 100:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:565
+		{
+			mtailVAL.n = mtailDollar[1].n
+			mtailVAL.n.(*ast.VarDecl).Quantiles = mtailDollar[2].floats
+		}
+	case 101:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:549
+//line parser.y:570
 		{
 			mtailVAL.n = mtailDollar[1].n
 			mtailVAL.n.(*ast.VarDecl).Limit = mtailDollar[2].intVal
 		}
-	case 99:
+	case 102:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:575
+		{
+			mtailVAL.n = mtailDollar[1].n
+			mtailVAL.n.(*ast.VarDecl).Expiry = mtailDollar[2].duration
+		}
+	case 103:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:580
+		{
+			mtailVAL.n = mtailDollar[1].n
+			mtailVAL.n.(*ast.VarDecl).Window = mtailDollar[2].duration
+		}
+	case 104:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:585
+		{
+			mtailVAL.n = mtailDollar[1].n
+			mtailVAL.n.(*ast.VarDecl).Help = mtailDollar[2].text
+		}
+	case 105:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:554
+//line parser.y:590
 		{
 			mtailVAL.n = mtailDollar[1].n
 		}
-	case 100:
+	case 106:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:562
+//line parser.y:598
 		{
 			mtailVAL.n = &ast.VarDecl{P: tokenpos(mtaillex), Name: mtailDollar[1].text}
 		}
-	case 101:
+	case 107:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:566
+//line parser.y:602
 		{
 			mtailVAL.n = &ast.VarDecl{P: tokenpos(mtaillex), Name: mtailDollar[1].text}
 		}
-	case 102:
+	case 108:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:574
+//line parser.y:610
 		{
 			mtailVAL.kind = metrics.Counter
 		}
-	case 103:
+	case 109:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:578
+//line parser.y:614
 		{
 			mtailVAL.kind = metrics.Gauge
 		}
-	case 104:
+	case 110:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:582
+//line parser.y:618
 		{
 			mtailVAL.kind = metrics.Timer
 		}
-	case 105:
+	case 111:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:586
+//line parser.y:622
 		{
 			mtailVAL.kind = metrics.Text
 		}
-	case 106:
+	case 112:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:590
+//line parser.y:626
 		{
 			mtailVAL.kind = metrics.Histogram
 		}
-	case 107:
+	case 113:
+		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
+//line parser.y:630
+		{
+			mtailVAL.kind = metrics.Summary
+		}
+	case 114:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:598
+//line parser.y:638
 		{
 			mtailVAL.texts = mtailDollar[2].texts
 		}
-	case 108:
+	case 115:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:605
+//line parser.y:645
 		{
 			mtailVAL.texts = make([]string, 0)
 			mtailVAL.texts = append(mtailVAL.texts, mtailDollar[1].text)
 		}
-	case 109:
+	case 116:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:610
+//line parser.y:650
 		{
 			mtailVAL.texts = mtailDollar[1].texts
 			mtailVAL.texts = append(mtailVAL.texts, mtailDollar[3].text)
 		}
-	case 110:
+	case 117:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:618
+//line parser.y:658
 		{
 			mtailVAL.text = mtailDollar[1].text
 		}
-	case 111:
+	case 118:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:624
+//line parser.y:664
 		{
 			mtailVAL.text = mtailDollar[2].text
 		}
-	case 112:
+	case 119:
+		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
+//line parser.y:672
+		{
+			mtailVAL.text = mtailDollar[1].text
+		}
+	case 120:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:631
+//line parser.y:679
 		{
 			mtailVAL.intVal = mtailDollar[2].intVal
 		}
-	case 113:
+	case 121:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:687
+		{
+			mtailVAL.duration = mtailDollar[2].duration
+		}
+	case 122:
+		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
+//line parser.y:697
+		{
+			if mtailDollar[1].text != "rate" || mtailDollar[2].text != "over" {
+				mtaillex.(*parser).Error(fmt.Sprintf("syntax error: unexpected %q in declaration, expecting `rate over'", mtailDollar[1].text+" "+mtailDollar[2].text))
+			}
+			mtailVAL.duration = mtailDollar[3].duration
+		}
+	case 123:
 		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
-//line parser.y:639
+//line parser.y:708
 		{
 			mtailVAL.floats = mtailDollar[2].floats
 		}
-	case 114:
+	case 124:
+		mtailDollar = mtailS[mtailpt-2 : mtailpt+1]
+//line parser.y:716
+		{
+			mtailVAL.floats = mtailDollar[2].floats
+		}
+	case 125:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:645
+//line parser.y:722
 		{
 			mtailVAL.floats = make([]float64, 0)
 			mtailVAL.floats = append(mtailVAL.floats, mtailDollar[1].floatVal)
 		}
-	case 115:
+	case 126:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:650
+//line parser.y:727
 		{
 			mtailVAL.floats = make([]float64, 0)
 			mtailVAL.floats = append(mtailVAL.floats, float64(mtailDollar[1].intVal))
 		}
-	case 116:
+	case 127:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:655
+//line parser.y:732
 		{
 			mtailVAL.floats = mtailDollar[1].floats
 			mtailVAL.floats = append(mtailVAL.floats, mtailDollar[3].floatVal)
 		}
-	case 117:
+	case 128:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:660
+//line parser.y:737
 		{
 			mtailVAL.floats = mtailDollar[1].floats
 			mtailVAL.floats = append(mtailVAL.floats, float64(mtailDollar[3].intVal))
 		}
-	case 118:
+	case 129:
 		mtailDollar = mtailS[mtailpt-4 : mtailpt+1]
-//line parser.y:668
+//line parser.y:745
 		{
 			mtailVAL.n = &ast.DecoDecl{P: markedpos(mtaillex), Name: mtailDollar[3].text, Block: mtailDollar[4].n}
 		}
-	case 119:
+	case 130:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:676
+//line parser.y:753
 		{
 			mtailVAL.n = &ast.DecoStmt{markedpos(mtaillex), mtailDollar[2].text, mtailDollar[3].n, nil, nil}
 		}
-	case 120:
+	case 131:
 		mtailDollar = mtailS[mtailpt-5 : mtailpt+1]
-//line parser.y:684
+//line parser.y:761
 		{
 			mtailVAL.n = &ast.DelStmt{P: positionFromMark(mtaillex), N: mtailDollar[3].n, Expiry: mtailDollar[5].duration}
 		}
-	case 121:
+	case 132:
 		mtailDollar = mtailS[mtailpt-3 : mtailpt+1]
-//line parser.y:688
+//line parser.y:765
 		{
 			mtailVAL.n = &ast.DelStmt{P: positionFromMark(mtaillex), N: mtailDollar[3].n}
 		}
-	case 122:
+	case 133:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:695
+//line parser.y:772
 		{
 			mtailVAL.text = mtailDollar[1].text
 		}
-	case 123:
+	case 134:
 		mtailDollar = mtailS[mtailpt-1 : mtailpt+1]
-//line parser.y:699
+//line parser.y:776
 		{
 			mtailVAL.text = mtailDollar[1].text
 		}
-	case 124:
+	case 135:
 		mtailDollar = mtailS[mtailpt-0 : mtailpt+1]
-//line parser.y:709
+//line parser.y:786
 		{
 			glog.V(2).Infof("position marked at %v", tokenpos(mtaillex))
 			mtaillex.(*parser).pos = tokenpos(mtaillex)
 		}
-	case 125:
+	case 136:
 		mtailDollar = mtailS[mtailpt-0 : mtailpt+1]
-//line parser.y:719
+//line parser.y:796
 		{
 			mtaillex.(*parser).inRegex()
 		}
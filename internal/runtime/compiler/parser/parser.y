@@ -6,6 +6,7 @@
 package parser
 
 import (
+    "fmt"
     "time"
 
     "github.com/google/mtail/internal/metrics"
@@ -37,18 +38,19 @@ import (
 %type <n> delete_stmt metric_name_spec builtin_expr arg_expr
 %type <kind> metric_type_spec
 %type <intVal> metric_limit_spec
-%type <text> metric_as_spec id_or_string metric_by_expr
+%type <duration> metric_after_spec metric_rate_spec
+%type <text> metric_as_spec metric_help_spec id_or_string metric_by_expr
 %type <texts> metric_by_spec metric_by_expr_list
 %type <flag> metric_hide_spec
 %type <op> rel_op shift_op bitwise_op logical_op add_op mul_op match_op postfix_op
-%type <floats> metric_buckets_spec metric_buckets_list
+%type <floats> metric_buckets_spec metric_buckets_list metric_quantiles_spec
 // Tokens and types are defined here.
 // Invalid input
 %token <text> INVALID
 // Types
-%token COUNTER GAUGE TIMER TEXT HISTOGRAM
+%token COUNTER GAUGE TIMER TEXT HISTOGRAM SUMMARY
 // Reserved words
-%token AFTER AS BY CONST HIDDEN DEF DEL NEXT OTHERWISE ELSE STOP BUCKETS LIMIT
+%token AFTER AS BY CONST HIDDEN DEF DEL NEXT OTHERWISE ELSE STOP BUCKETS LIMIT INCLUDE QUANTILES
 // Builtins
 %token <text> BUILTIN
 // Literals: re2 syntax regular expression, quoted strings, regex capture group
@@ -67,13 +69,19 @@ import (
 %token <op> SHL SHR
 %token <op> LT GT LE GE EQ NE
 %token <op> BITAND XOR BITOR NOT AND OR
-%token <op> ADD_ASSIGN ASSIGN
+%token <op> ADD_ASSIGN SUB_ASSIGN ASSIGN
 %token <op> MATCH NOT_MATCH
 // Punctuation
 %token LCURLY RCURLY LPAREN RPAREN LSQUARE RSQUARE
 %token COMMA
 %token NL
 
+// A string following a metric declaration is the metric's help text, and an
+// identifier is the start of a `rate over' spec, rather than the start of the
+// next statement.
+%nonassoc DECL
+%nonassoc STRING ID
+
 %start start
 
 // The %error directive takes a list of tokens describing a parser state in error, and an error message.
@@ -136,6 +144,10 @@ stmt
   {
     $$ = &ast.StopStmt{tokenpos(mtaillex)}
   }
+  | INCLUDE STRING
+  {
+    $$ = &ast.IncludeStmt{tokenpos(mtaillex), $2}
+  }
   | INVALID
   {
     $$ = &ast.Error{tokenpos(mtaillex), $1}
@@ -214,6 +226,10 @@ assign_expr
   {
     $$ = &ast.BinaryExpr{LHS: $1, RHS: $4, Op: $2}
   }
+  | unary_expr SUB_ASSIGN opt_nl logical_expr
+  {
+    $$ = &ast.BinaryExpr{LHS: $1, RHS: $4, Op: $2}
+  }
   ;
 
 /* Logical expressions perform comparisons with logical operators. */
@@ -507,7 +523,7 @@ regex_pattern
 
 /* Declaration creates a new metric. */
 metric_declaration
-  : metric_hide_spec metric_type_spec metric_decl_attr_spec
+  : metric_hide_spec metric_type_spec metric_decl_attr_spec %prec DECL
   {
     $$ = $3
     d := $$.(*ast.VarDecl)
@@ -545,11 +561,31 @@ metric_decl_attr_spec
     $$ = $1
     $$.(*ast.VarDecl).Buckets = $2
   }
+  | metric_decl_attr_spec metric_quantiles_spec
+  {
+    $$ = $1
+    $$.(*ast.VarDecl).Quantiles = $2
+  }
   | metric_decl_attr_spec metric_limit_spec
   {
     $$ = $1
     $$.(*ast.VarDecl).Limit = $2
   }
+  | metric_decl_attr_spec metric_after_spec
+  {
+    $$ = $1
+    $$.(*ast.VarDecl).Expiry = $2
+  }
+  | metric_decl_attr_spec metric_rate_spec
+  {
+    $$ = $1
+    $$.(*ast.VarDecl).Window = $2
+  }
+  | metric_decl_attr_spec metric_help_spec
+  {
+    $$ = $1
+    $$.(*ast.VarDecl).Help = $2
+  }
   | metric_name_spec
   {
     $$ = $1
@@ -590,6 +626,10 @@ metric_type_spec
   {
     $$ = metrics.Histogram
   }
+  | SUMMARY
+  {
+    $$ = metrics.Summary
+  }
   ;
 
 /* By specification describes index keys for a multidimensional variable. */
@@ -626,6 +666,14 @@ metric_as_spec
   }
   ;
 
+/* Help specification describes the metric for export. */
+metric_help_spec
+  : STRING
+  {
+    $$ = $1
+  }
+  ;
+
 metric_limit_spec
   : LIMIT INTLITERAL
   {
@@ -633,6 +681,35 @@ metric_limit_spec
   }
   ;
 
+/* After specification describes how long a datum may be idle before it is removed. */
+metric_after_spec
+  : AFTER DURATIONLITERAL
+  {
+    $$ = $2
+  }
+  ;
+
+/* Rate specification describes the window over which a counter's rate is exported.
+ * `rate' and `over' are only keywords here, so they are lexed as identifiers
+ * and can still name variables elsewhere. */
+metric_rate_spec
+  : ID ID DURATIONLITERAL
+  {
+    if $1 != "rate" || $2 != "over" {
+      mtaillex.(*parser).Error(fmt.Sprintf("syntax error: unexpected %q in declaration, expecting `rate over'", $1 + " " + $2))
+    }
+    $$ = $3
+  }
+  ;
+
+/* Quantiles specification describes the quantiles estimated by a summary type. */
+metric_quantiles_spec
+  : QUANTILES metric_buckets_list
+  {
+    $$ = $2
+  }
+  ;
+
 /* Bucket specification describes the bucketing arrangement in a histogram type. */
 metric_buckets_spec
   : BUCKETS metric_buckets_list
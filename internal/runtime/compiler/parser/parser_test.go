@@ -49,6 +49,16 @@ var parserTests = []struct {
 		"counter foo by a, b limit 100",
 	},
 
+	{
+		"declare counter with help",
+		"counter http_requests \"Total HTTP requests served\"\n",
+	},
+
+	{
+		"declare dimensioned counter with help",
+		"counter http_requests by code \"Total HTTP requests served\" limit 100\n",
+	},
+
 	{
 		"declare multi-dimensioned counter",
 		"counter foo by bar, baz, quux\n",
@@ -78,6 +88,22 @@ var parserTests = []struct {
 		"declare histogram",
 		"histogram foo buckets 0, 1, 2\n",
 	},
+	{
+		"declare dimensioned metric with expiry",
+		"counter foo by a after 1h\n",
+	},
+	{
+		"declare summary",
+		"summary request_seconds by code quantiles 0.5, 0.9, 0.99\n",
+	},
+	{
+		"declare rate counter",
+		"counter requests by code rate over 1m0s\n",
+	},
+	{
+		"rate and over as variable names",
+		"counter rate\ncounter over by rate\nrate++\nover[rate] += 1\n",
+	},
 	{
 		"declare histogram float",
 		"histogram foo buckets 0, 0.01, 0.1, 1, 10\n",
@@ -113,6 +139,15 @@ var parserTests = []struct {
 `,
 	},
 
+	{
+		"subtract from gauge",
+		`gauge balance
+/(\d+)/ {
+  balance -= $1
+}
+`,
+	},
+
 	{
 		"regex match includes escaped slashes",
 		"counter foo\n" +
@@ -429,6 +464,12 @@ $foo =~ X {
   stop
 }`},
 
+	{"include", `
+include "common.mtail"
+// {
+  stop
+}`},
+
 	{"substitution", `
 /(\d,\d)/ {
   subst(",", ",", $1)
@@ -486,6 +527,15 @@ func TestParserRoundTrip(t *testing.T) {
 	}
 }
 
+func TestParseMetricHelp(t *testing.T) {
+	root, err := Parse("help", strings.NewReader("counter \"http-requests\" by code \"Total HTTP requests served\"\n"))
+	testutil.FatalIfErr(t, err)
+	decl := root.(*ast.StmtList).Children[0].(*ast.VarDecl)
+	if decl.Name != "http-requests" || decl.Help != "Total HTTP requests served" {
+		t.Errorf("unexpected declaration %#v", decl)
+	}
+}
+
 type parserInvalidProgram struct {
 	name    string
 	program string
@@ -588,6 +638,12 @@ var parserInvalidPrograms = []parserInvalidProgram{
 		"counter foo by a limit 10, b",
 		[]string{"dimensioned limit per dimension:1:26: syntax error: unexpected COMMA"},
 	},
+
+	{
+		"misspelt rate over",
+		"counter foo rate under 1m\n",
+		[]string{"misspelt rate over:1:24-25: syntax error: unexpected \"rate under\" in declaration, expecting `rate over'"},
+	},
 }
 
 func TestParseInvalidPrograms(t *testing.T) {
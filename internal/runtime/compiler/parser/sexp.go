@@ -114,6 +114,8 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			s.emit("=")
 		case ADD_ASSIGN:
 			s.emit("+=")
+		case SUB_ASSIGN:
+			s.emit("-=")
 		case MOD:
 			s.emit("%")
 		case MATCH:
@@ -145,6 +147,10 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			s.emit("timer ")
 		case metrics.Text:
 			s.emit("text ")
+		case metrics.Histogram:
+			s.emit("histogram ")
+		case metrics.Summary:
+			s.emit("summary ")
 		}
 		s.emit(v.Name)
 		if len(v.Keys) > 0 {
@@ -196,6 +202,9 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.StopStmt:
 		s.emit("stop")
 
+	case *ast.IncludeStmt:
+		s.emit("include \"" + v.Path + "\"")
+
 	case *ast.DecoDecl:
 		s.emit(fmt.Sprintf("%q", v.Name))
 		s.newline()
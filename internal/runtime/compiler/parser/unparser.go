@@ -139,6 +139,8 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			u.emit(" = ")
 		case ADD_ASSIGN:
 			u.emit(" += ")
+		case SUB_ASSIGN:
+			u.emit(" -= ")
 		case MOD:
 			u.emit(" % ")
 		case MATCH:
@@ -183,6 +185,8 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			u.emit("text ")
 		case metrics.Histogram:
 			u.emit("histogram ")
+		case metrics.Summary:
+			u.emit("summary ")
 		}
 		u.emit(v.Name)
 		if len(v.Keys) > 0 {
@@ -191,6 +195,12 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		if v.Limit > 0 {
 			u.emit(fmt.Sprintf(" limit %d", v.Limit))
 		}
+		if v.Expiry > 0 {
+			u.emit(fmt.Sprintf(" after %s", v.Expiry))
+		}
+		if v.Window > 0 {
+			u.emit(fmt.Sprintf(" rate over %s", v.Window))
+		}
 		if len(v.Buckets) > 0 {
 			buckets := strings.Builder{}
 			buckets.WriteString(" buckets ")
@@ -199,6 +209,17 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			}
 			u.emit(buckets.String()[:buckets.Len()-2])
 		}
+		if len(v.Quantiles) > 0 {
+			quantiles := strings.Builder{}
+			quantiles.WriteString(" quantiles ")
+			for _, f := range v.Quantiles {
+				quantiles.WriteString(fmt.Sprintf("%f, ", f))
+			}
+			u.emit(quantiles.String()[:quantiles.Len()-2])
+		}
+		if v.Help != "" {
+			u.emit(fmt.Sprintf(" %q", v.Help))
+		}
 
 	case *ast.UnaryExpr:
 		switch v.Op {
@@ -270,6 +291,9 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.StopStmt:
 		u.emit("stop")
 
+	case *ast.IncludeStmt:
+		u.emit("include \"" + v.Path + "\"")
+
 	default:
 		panic(fmt.Sprintf("unfound undefined type %T", n))
 	}
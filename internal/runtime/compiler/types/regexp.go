@@ -5,6 +5,7 @@ package types
 
 import (
 	"regexp/syntax"
+	"unicode/utf8"
 )
 
 // ParseRegexp ensures we use the same regexp syntax.Flags across all
@@ -17,3 +18,55 @@ func ParseRegexp(pattern string) (re *syntax.Regexp, err error) {
 	re = re.Simplify()
 	return
 }
+
+// RequiredLiteral returns the longest string that every match of re must
+// contain, or the empty string if no such literal can be found.
+func RequiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if !isRequirableLiteral(re) {
+			return ""
+		}
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return RequiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return ""
+		}
+		return RequiredLiteral(re.Sub[0])
+	case syntax.OpConcat:
+		// Adjacent literals in a concatenation form one longer literal.
+		var longest, run string
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && isRequirableLiteral(sub) {
+				run += string(sub.Rune)
+				if len(run) > len(longest) {
+					longest = run
+				}
+				continue
+			}
+			run = ""
+			if l := RequiredLiteral(sub); len(l) > len(longest) {
+				longest = l
+			}
+		}
+		return longest
+	}
+	return ""
+}
+
+// isRequirableLiteral returns true if the text matched by the literal re is
+// the literal itself.  Case insensitive literals can match other text, as
+// can the replacement character, which matches invalid UTF-8.
+func isRequirableLiteral(re *syntax.Regexp) bool {
+	if re.Flags&syntax.FoldCase != 0 {
+		return false
+	}
+	for _, r := range re.Rune {
+		if r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
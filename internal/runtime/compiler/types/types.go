@@ -244,7 +244,8 @@ var (
 	String        = &Operator{"String", []Type{}}
 	Pattern       = &Operator{"Pattern", []Type{}}
 	// TODO(jaq): use composite type so we can typecheck the bucket directly, e.g. hist[j] = i.
-	Buckets = &Operator{"Buckets", []Type{}}
+	Buckets   = &Operator{"Buckets", []Type{}}
+	Quantiles = &Operator{"Quantiles", []Type{}}
 
 	// Numeric types can be either Int or Float.
 	Numeric = Alternate(Int, Float)
@@ -252,18 +253,34 @@ var (
 
 // Builtins is a mapping of the builtin language functions to their type definitions.
 var Builtins = map[string]Type{
-	"int":         Function(NewVariable(), Int),
-	"bool":        Function(NewVariable(), Bool),
-	"float":       Function(NewVariable(), Float),
-	"string":      Function(NewVariable(), String),
-	"timestamp":   Function(Int),
-	"len":         Function(String, Int),
-	"settime":     Function(Int, None),
-	"strptime":    Function(String, String, None),
-	"strtol":      Function(String, Int, Int),
-	"tolower":     Function(String, String),
-	"getfilename": Function(String),
-	"subst":       Function(Pattern, String, String, String),
+	"int":          Function(NewVariable(), Int),
+	"bool":         Function(NewVariable(), Bool),
+	"float":        Function(NewVariable(), Float),
+	"string":       Function(NewVariable(), String),
+	"timestamp":    Function(Int),
+	"len":          Function(String, Int),
+	"settime":      Function(Int, None),
+	"strptime":     Function(String, String, None),
+	"strtol":       Function(String, Int, Int),
+	"tolower":      Function(String, String),
+	"toupper":      Function(String, String),
+	"getenv":       Function(String, String),
+	"getfilename":  Function(String),
+	"json_field":   Function(String, String, String),
+	"logfmt_field": Function(String, String, String),
+	"subst":        Function(Pattern, String, String, String),
+	"substr":       Function(String, Int, Int, String),
+}
+
+// Fields is a mapping of the named capture group references that are always
+// in scope to their types.  Their values are the structured fields of a log
+// line given by its source, rather than groups of a regular expression, and
+// are empty, or 0 for the integer fields, when the source does not provide
+// them.
+var Fields = map[string]Type{
+	"syslog_facility": Int,
+	"syslog_severity": Int,
+	"syslog_hostname": String,
 }
 
 // FreshType returns a new type from the provided type scheme, replacing any
@@ -577,8 +594,11 @@ func inferGroupType(group *syntax.Regexp) Type {
 }
 
 // getCaptureGroup returns the Regexp node of the capturing group numbered cgID
-// in re.
+// in re.  Group zero is the whole of re.
 func getCaptureGroup(re *syntax.Regexp, cgID int) *syntax.Regexp {
+	if cgID == 0 {
+		return re
+	}
 	if re.Op == syntax.OpCapture && re.Cap == cgID {
 		return re.Sub[0]
 	}
@@ -345,6 +345,39 @@ func TestInferCaprefType(t *testing.T) {
 			if !Equals(tc.typ, r) {
 				t.Errorf("Types don't match: %q inferred %v, not %v", tc.pattern, r, tc.typ)
 			}
+			// The whole match is group zero.
+			re, err = ParseRegexp(tc.pattern)
+			testutil.FatalIfErr(t, err)
+			if r := InferCaprefType(re, 0); !Equals(tc.typ, r) {
+				t.Errorf("Types don't match: %q inferred %v for $0, not %v", tc.pattern, r, tc.typ)
+			}
+		})
+	}
+}
+
+var requiredLiteralTests = []struct {
+	pattern string
+	literal string
+}{
+	{`foo`, "foo"},
+	{`^(\S+) GET (\S+) HTTP/1\.1$`, " HTTP/1.1"},
+	{`status=(\d+) bytes=(?P<bytes>\d+)`, "status="},
+	{`(error: (\w+))+`, "error: "},
+	{`x{2,}y`, "x"},
+	{`foo|bar`, ""},
+	{`(foo)?bar`, "bar"},
+	{`(?i)error`, ""},
+	{`\d+`, ""},
+	{`.*`, ""},
+}
+
+func TestRequiredLiteral(t *testing.T) {
+	for _, tc := range requiredLiteralTests {
+		tc := tc
+		t.Run(tc.pattern, func(t *testing.T) {
+			re, err := ParseRegexp(tc.pattern)
+			testutil.FatalIfErr(t, err)
+			testutil.ExpectNoDiff(t, tc.literal, RequiredLiteral(re))
 		})
 	}
 }
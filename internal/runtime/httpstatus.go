@@ -4,11 +4,16 @@
 package runtime
 
 import (
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"sort"
+	"time"
 
+	"github.com/golang/glog"
 	"github.com/google/mtail/internal/runtime/vm"
 )
 
@@ -21,6 +26,10 @@ const loaderTemplate = `
 <th>load errors</th>
 <th>load successes</th>
 <th>unloads</th>
+<th>lines</th>
+<th>matched lines</th>
+<th>unmatched lines</th>
+<th>processing time (s)</th>
 <th>runtime errors</th>
 <th>last runtime error</th>
 </tr>
@@ -37,6 +46,10 @@ No compile errors
 <td>{{index $.Loaderrors $name}}</td>
 <td>{{index $.Loadsuccess $name}}</td>
 <td>{{index $.Unloads $name}}</td>
+<td>{{index $.Lines $name}}</td>
+<td>{{index $.LinesMatched $name}}</td>
+<td>{{index $.LinesUnmatched $name}}</td>
+<td>{{index $.ProcessingTime $name}}</td>
 <td>{{index $.RuntimeErrors $name}}</td>
 <td><pre>{{index $.RuntimeErrorString $name}}</pre></td>
 </tr>
@@ -58,6 +71,10 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 		Loaderrors         map[string]string
 		Loadsuccess        map[string]string
 		Unloads            map[string]string
+		Lines              map[string]string
+		LinesMatched       map[string]string
+		LinesUnmatched     map[string]string
+		ProcessingTime     map[string]string
 		RuntimeErrors      map[string]string
 		RuntimeErrorString map[string]string
 	}{
@@ -68,6 +85,10 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 		make(map[string]string),
 		make(map[string]string),
 		make(map[string]string),
+		make(map[string]string),
+		make(map[string]string),
+		make(map[string]string),
+		make(map[string]string),
 	}
 	for name := range r.programErrors {
 		if ProgLoadErrors.Get(name) != nil {
@@ -79,6 +100,18 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 		if ProgUnloads.Get(name) != nil {
 			data.Unloads[name] = ProgUnloads.Get(name).String()
 		}
+		if vm.ProgLines.Get(name) != nil {
+			data.Lines[name] = vm.ProgLines.Get(name).String()
+		}
+		if vm.ProgLinesMatched.Get(name) != nil {
+			data.LinesMatched[name] = vm.ProgLinesMatched.Get(name).String()
+		}
+		if vm.ProgLinesUnmatched.Get(name) != nil {
+			data.LinesUnmatched[name] = vm.ProgLinesUnmatched.Get(name).String()
+		}
+		if vm.ProgLineProcessingTime.Get(name) != nil {
+			data.ProcessingTime[name] = vm.ProgLineProcessingTime.Get(name).String()
+		}
 		if vm.ProgRuntimeErrors.Get(name) != nil {
 			data.RuntimeErrors[name] = vm.ProgRuntimeErrors.Get(name).String()
 		}
@@ -92,6 +125,95 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 	return t.Execute(w, data)
 }
 
+const progzTemplate = `
+<html>
+<head>
+<title>mtail programs</title>
+</head>
+<body>
+<h1>Programs</h1>
+<table border="1">
+<tr>
+<th>program name</th>
+<th>loaded at</th>
+<th>last compile error</th>
+<th>failed at</th>
+<th>compile time</th>
+<th>lines</th>
+<th>matched lines</th>
+<th>unmatched lines</th>
+</tr>
+{{range .}}
+<tr>
+<td>{{if .Loaded.IsZero}}{{.Name}}{{else}}<a href="?prog={{.Name}}">{{.Name}}</a>{{end}}</td>
+<td>{{if .Loaded.IsZero}}not loaded{{else}}{{.Loaded.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td>
+<td>{{if .Error}}<pre>{{.Error}}</pre>{{else}}No compile errors{{end}}</td>
+<td>{{if not .Failed.IsZero}}{{.Failed.Format "2006-01-02T15:04:05Z07:00"}}{{end}}</td>
+<td>{{if .CompileTime}}{{.CompileTime}}{{end}}</td>
+<td>{{.Lines}}</td>
+<td>{{.LinesMatched}}</td>
+<td>{{.LinesUnmatched}}</td>
+</tr>
+{{end}}
+</table>
+</body>
+</html>
+`
+
+// progzRow is the status of a program shown on the /progz page.
+type progzRow struct {
+	Name           string
+	Loaded         time.Time     // When the running program was loaded, zero if it is not running.
+	Error          error         // Error from the last load attempt.
+	Failed         time.Time     // When the last load attempt failed, zero if it succeeded.
+	CompileTime    time.Duration // Time taken by the last compile.
+	Lines          string
+	LinesMatched   string
+	LinesUnmatched string
+}
+
+// progzRows returns the status of each program the runtime has loaded or
+// tried to load, sorted by name.
+func (r *Runtime) progzRows() []progzRow {
+	r.programErrorMu.RLock()
+	defer r.programErrorMu.RUnlock()
+	r.handleMu.RLock()
+	defer r.handleMu.RUnlock()
+	// A program compiled with CompileAndRun has a handle but no entry in
+	// programErrors, so the rows are the union of the two.
+	names := make(map[string]struct{}, len(r.handles)+len(r.programErrors))
+	for name := range r.handles {
+		names[name] = struct{}{}
+	}
+	for name := range r.programErrors {
+		names[name] = struct{}{}
+	}
+	rows := make([]progzRow, 0, len(names))
+	for name := range names {
+		row := progzRow{Name: name, Error: r.programErrors[name], Failed: r.programErrorTimes[name], Lines: "0", LinesMatched: "0", LinesUnmatched: "0"}
+		if h, ok := r.handles[name]; ok {
+			row.Loaded = h.loaded
+		}
+		if v, ok := ProgCompileDuration.Get(name).(*expvar.Float); ok {
+			row.CompileTime = time.Duration(v.Value() * float64(time.Second))
+		}
+		if v := vm.ProgLines.Get(name); v != nil {
+			row.Lines = v.String()
+		}
+		if v := vm.ProgLinesMatched.Get(name); v != nil {
+			row.LinesMatched = v.String()
+		}
+		if v := vm.ProgLinesUnmatched.Get(name); v != nil {
+			row.LinesUnmatched = v.String()
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows
+}
+
+// ProgzHandler serves the status of each program, or the bytecode of the
+// program named by the prog query parameter.
 func (r *Runtime) ProgzHandler(w http.ResponseWriter, req *http.Request) {
 	prog := req.URL.Query().Get("prog")
 	if prog != "" {
@@ -106,12 +228,51 @@ func (r *Runtime) ProgzHandler(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(w, "\nLast runtime error:\n%s", handle.vm.RuntimeErrorString())
 		return
 	}
-	r.handleMu.RLock()
-	defer r.handleMu.RUnlock()
+	t, err := template.New("progz").Parse(progzTemplate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Add("Content-type", "text/html")
-	fmt.Fprintf(w, "<ul>")
-	for prog := range r.handles {
-		fmt.Fprintf(w, "<li><a href=\"?prog=%s\">%s</a></li>", prog, prog)
+	if err := t.Execute(w, r.progzRows()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// reloadResult is the outcome of loading one program, as reported by ReloadHandler.
+type reloadResult struct {
+	Name    string   `json:"name"`
+	Success bool     `json:"success"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// ReloadHandler reloads all programs on a POST request, and responds with the
+// result of loading each one as JSON.  Reloads requested while one is in
+// progress wait for it to finish.
+func (r *Runtime) ReloadHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Reload requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ProgReloads.Add(1)
+	results, err := r.LoadPrograms()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rs := make([]reloadResult, 0, len(results))
+	for _, result := range results {
+		rr := reloadResult{Name: result.Name, Success: result.Success}
+		for _, err := range result.Errors {
+			rr.Errors = append(rr.Errors, err.Error())
+		}
+		rs = append(rs, rr)
+	}
+	w.Header().Set("Content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(struct {
+		Programs []reloadResult `json:"programs"`
+	}{rs}); err != nil {
+		glog.Info(err)
 	}
-	fmt.Fprintf(w, "</ul>")
 }
@@ -4,10 +4,14 @@
 package runtime
 
 import (
+	"io"
+	"path/filepath"
 	"time"
 
 	"github.com/google/mtail/internal/runtime/compiler"
 	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/google/mtail/internal/waker"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -22,11 +26,42 @@ func OverrideLocation(loc *time.Location) Option {
 	}
 }
 
-// CompileOnly sets the Runtime to compile programs only, without executing them.
+// ProgramPaths adds more directories or program files for the Runtime to load
+// programs from, as well as the program path given to New.  Programs from
+// different paths must have different names.
+func ProgramPaths(paths ...string) Option {
+	return func(r *Runtime) error {
+		for _, path := range paths {
+			r.programPaths = append(r.programPaths, filepath.Clean(path))
+		}
+		return nil
+	}
+}
+
+// ProgramManifest sets the Runtime to load exactly the programs listed in the
+// manifest file at path, instead of scanning the program path.
+func ProgramManifest(path string) Option {
+	return func(r *Runtime) error {
+		r.programManifest = path
+		return nil
+	}
+}
+
+// ManifestPollWaker sets the Runtime to check the program manifest for
+// changes each time w wakes, and reload the programs if it has changed.
+func ManifestPollWaker(w waker.Waker) Option {
+	return func(r *Runtime) error {
+		r.manifestWaker = w
+		return nil
+	}
+}
+
+// CompileOnly sets the Runtime to compile programs only, without executing
+// them.  All programs are compiled, and any compile errors are returned together.
 func CompileOnly() Option {
 	return func(r *Runtime) error {
 		r.compileOnly = true
-		return ErrorsAbort()(r)
+		return nil
 	}
 }
 
@@ -46,6 +81,14 @@ func DumpAst() Option {
 	}
 }
 
+// DumpAstTo writes the AST of each program after parsing to w.
+func DumpAstTo(w io.Writer) Option {
+	return func(r *Runtime) error {
+		r.cOpts = append(r.cOpts, compiler.EmitAstTo(w))
+		return nil
+	}
+}
+
 // DumpAstTypes emits the AST after type checking.
 func DumpAstTypes() Option {
 	return func(r *Runtime) error {
@@ -62,6 +105,23 @@ func DumpBytecode() Option {
 	}
 }
 
+// OptimiseBytecode instructs the compiler to run the peephole optimiser on the generated bytecode.
+func OptimiseBytecode() Option {
+	return func(r *Runtime) error {
+		r.cOpts = append(r.cOpts, compiler.OptimiseBytecode())
+		return nil
+	}
+}
+
+// DumpBytecodeDir instructs the loader to write the compiled bytecode of each
+// program to a file named for the program in dir after code generation.
+func DumpBytecodeDir(dir string) Option {
+	return func(r *Runtime) error {
+		r.dumpBytecodeDir = dir
+		return nil
+	}
+}
+
 // SyslogUseCurrentYear instructs the VM to annotate yearless timestamps with the current year.
 func SyslogUseCurrentYear() Option {
 	return func(r *Runtime) error {
@@ -86,6 +146,99 @@ func MaxRecursionDepth(maxRecursionDepth int) Option {
 	}
 }
 
+// MaxCaptures sets the maximum number of capture groups an mtail regular expression can have.
+func MaxCaptures(maxCaptures int) Option {
+	return func(r *Runtime) error {
+		r.cOpts = append(r.cOpts, compiler.MaxCaptures(maxCaptures))
+		return nil
+	}
+}
+
+// AllowRiskyRegex makes the Runtime load programs whose patterns have nested
+// unbounded repetitions, with a warning, instead of refusing to.
+func AllowRiskyRegex() Option {
+	return func(r *Runtime) error {
+		r.cOpts = append(r.cOpts, compiler.AllowRiskyRegex())
+		return nil
+	}
+}
+
+// MaxDimensionsPerMetric sets the number of label values a dimensioned
+// metric can hold, unless its declaration gives a `limit`.  A metric at its
+// limit evicts its least recently updated label value to make room for a new
+// one.  Zero means no limit.
+func MaxDimensionsPerMetric(max int) Option {
+	return func(r *Runtime) error {
+		if max < 0 {
+			return errors.Errorf("max dimensions per metric %d must not be negative", max)
+		}
+		r.maxDimensions = max
+		return nil
+	}
+}
+
+// VMWorkers limits the number of programs that process lines at the same
+// time to n.  Each program processes its lines in order on its own
+// goroutine, and without a limit all programs run at once.  Zero means no
+// limit.
+func VMWorkers(n int) Option {
+	return func(r *Runtime) error {
+		if n < 0 {
+			return errors.Errorf("vm workers %d must not be negative", n)
+		}
+		if n > 0 {
+			r.vmWorkers = make(chan struct{}, n)
+		}
+		return nil
+	}
+}
+
+// LineBufferSize sets the number of lines buffered for each program, before
+// the program is considered to be falling behind.
+func LineBufferSize(n int) Option {
+	return func(r *Runtime) error {
+		if n < 0 {
+			return errors.Errorf("line buffer size %d must not be negative", n)
+		}
+		r.lineBufferSize = n
+		return nil
+	}
+}
+
+// DropLinesWhenFull makes the Runtime drop a line for a program whose line
+// buffer is full, counting it in `lines_dropped_total`, rather than waiting
+// for the program to catch up.  This keeps the logs being read at the cost of
+// the program missing lines.
+func DropLinesWhenFull() Option {
+	return func(r *Runtime) error {
+		r.dropLines = true
+		return nil
+	}
+}
+
+// VMExecutionTimeout makes each program abandon processing a line once it has
+// taken longer than d, so that the lines after it are still processed.  Zero
+// means no timeout.
+func VMExecutionTimeout(d time.Duration) Option {
+	return func(r *Runtime) error {
+		if d < 0 {
+			return errors.Errorf("vm execution timeout %s must not be negative", d)
+		}
+		r.vmTimeout = d
+		return nil
+	}
+}
+
+// NomatchWarnAfter makes each program log a warning for each of its patterns
+// that hasn't matched any of the first n lines it processes.  Zero disables
+// the warning.
+func NomatchWarnAfter(n int64) Option {
+	return func(r *Runtime) error {
+		r.nomatchWarnAfter = n
+		return nil
+	}
+}
+
 // OmitMetricSource instructs the Runtime to not annotate metrics with their program source when added to the metric store.
 func OmitMetricSource() Option {
 	return func(r *Runtime) error {
@@ -94,6 +247,26 @@ func OmitMetricSource() Option {
 	}
 }
 
+// PrefixMetricsWithProgram instructs the Runtime to prefix the name of each
+// metric added to the metric store with the name of its program, so that
+// metrics of the same name in different programs are kept apart.
+func PrefixMetricsWithProgram() Option {
+	return func(r *Runtime) error {
+		r.prefixWithProgram = true
+		return nil
+	}
+}
+
+// ResetMetricsOnReload instructs the Runtime to not carry over the values of
+// a program's metrics when the program is reloaded, so that the new version
+// starts from zero.
+func ResetMetricsOnReload() Option {
+	return func(r *Runtime) error {
+		r.resetOnReload = true
+		return nil
+	}
+}
+
 // PrometheusRegisterer passes in a registry for setting up exported metrics.
 func PrometheusRegisterer(reg prometheus.Registerer) Option {
 	return func(r *Runtime) error {
@@ -117,3 +290,31 @@ func TraceExecution() Option {
 		return nil
 	}
 }
+
+// TraceExecutionTo instructs the VMs to write a trace of each instruction
+// they execute, and the patterns matched by each line, to w.
+func TraceExecutionTo(w io.Writer) Option {
+	return func(r *Runtime) error {
+		r.traceWriter = w
+		return nil
+	}
+}
+
+// LintTo sets the Runtime to check programs for likely mistakes, and write
+// what it finds to w, instead of executing them.  Programs with compile errors
+// or lint errors fail to load, as in compile-only mode.
+func LintTo(w io.Writer) Option {
+	return func(r *Runtime) error {
+		r.compileOnly = true
+		r.lintWriter = w
+		return nil
+	}
+}
+
+// RecursivePrograms instructs the Runtime to also load programs found in subdirectories of the program path.
+func RecursivePrograms() Option {
+	return func(r *Runtime) error {
+		r.recursive = true
+		return nil
+	}
+}
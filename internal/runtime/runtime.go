@@ -10,10 +10,13 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"expvar"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"syscall"
@@ -22,8 +25,12 @@ import (
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/runtime/code"
 	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/runtime/compiler/checker"
+	compilererrors "github.com/google/mtail/internal/runtime/compiler/errors"
 	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/google/mtail/internal/waker"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -37,80 +44,356 @@ var (
 	ProgUnloads = expvar.NewMap("prog_unloads_total")
 	// ProgLoadErrors counts the number of program load errors.
 	ProgLoadErrors = expvar.NewMap("prog_load_errors_total")
+	// ProgReloads counts the number of times all programs were reloaded on a signal or request.
+	ProgReloads = expvar.NewInt("prog_reloads_total")
+	// LinesDropped counts the number of lines dropped per program because
+	// its line buffer was full.
+	LinesDropped = expvar.NewMap("lines_dropped_total")
+	// ProgLastLoadTime holds the time of the last successful load of each
+	// program, in seconds since the epoch.
+	ProgLastLoadTime = expvar.NewMap("prog_last_load_timestamp_seconds")
+	// ProgLastLoadError holds the error of each program whose last load
+	// failed.  A program is removed once it loads again, or is removed from
+	// the program path.
+	ProgLastLoadError = expvar.NewMap("prog_last_load_error")
+	// ProgCompileDuration holds the time taken by the last compile of each
+	// program, in seconds, whether or not it succeeded.
+	ProgCompileDuration = expvar.NewMap("prog_compile_duration_seconds")
+	// ProgCount holds the number of programs loaded and processing lines.
+	ProgCount = expvar.NewInt("prog_count")
 )
 
 const (
 	fileExt = ".mtail"
 )
 
+// LoadResult describes the outcome of loading a single program.
+type LoadResult struct {
+	Name    string  // The program name.
+	Success bool    // True if the program compiled and is running.
+	Errors  []error // The compiler diagnostics, or the load error, if the program failed.
+}
+
+// LoadErrors holds the error of each program that failed to load.  Each wraps
+// the compiler's diagnostics for its program, so they can be inspected with
+// errors.As as well as printed.
+type LoadErrors []error
+
+func (e LoadErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d programs failed to compile:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+func (e LoadErrors) Unwrap() []error {
+	return e
+}
+
+// ResultErrors returns the errors of the programs in results that failed to
+// load, as LoadErrors, or nil if every program loaded.  This lets programs
+// embedding the runtime report every failure from LoadPrograms at once.
+func ResultErrors(results []LoadResult) error {
+	var errs LoadErrors
+	for _, result := range results {
+		if !result.Success && len(result.Errors) > 0 {
+			errs = append(errs, resultError(result))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
 // LoadAllPrograms loads all programs in a directory and starts watching the
 // directory for filesystem changes.  Any compile errors are stored for later retrieival.
-// This function returns an error if an internal error occurs.
+// This function returns an error if an internal error occurs.  In compile-only
+// mode, a LoadErrors of every program that failed is returned.
 func (r *Runtime) LoadAllPrograms() error {
-	if r.programPath == "" {
-		glog.V(2).Info("Programpath is empty, loading nothing")
-		return nil
-	}
-	s, err := os.Stat(r.programPath)
+	results, err := r.LoadPrograms()
 	if err != nil {
-		return errors.Wrapf(err, "failed to stat %q", r.programPath)
+		return err
+	}
+	if !r.compileOnly {
+		return nil
 	}
+	return ResultErrors(results)
+}
+
+// LoadPrograms loads all programs in the program path, like LoadAllPrograms,
+// and returns the result of loading each one.  Programs that are no longer
+// present are unloaded.  The returned error is non-nil only if an internal
+// error occurs, or if a program fails to load and errors abort the loader.
+func (r *Runtime) LoadPrograms() ([]LoadResult, error) {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	var names, pathnames []string
 	switch {
-	case s.IsDir():
-		dirents, rerr := os.ReadDir(r.programPath)
-		if rerr != nil {
-			return errors.Wrapf(rerr, "Failed to list programs in %q", r.programPath)
+	case r.programManifest != "":
+		var err error
+		names, pathnames, err = r.manifestPrograms()
+		if err != nil {
+			return nil, err
+		}
+	case len(r.programPaths) == 0:
+		glog.V(2).Info("Programpath is empty, loading nothing")
+		return nil, nil
+	default:
+		for _, programPath := range r.programPaths {
+			s, err := os.Stat(programPath)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to stat %q", programPath)
+			}
+			if !s.IsDir() {
+				names = append(names, r.programName(programPath, programPath))
+				pathnames = append(pathnames, programPath)
+				continue
+			}
+			dirPathnames, err := r.programPathnames(programPath)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to list programs in %q", programPath)
+			}
+			for _, pathname := range dirPathnames {
+				names = append(names, r.programName(programPath, pathname))
+			}
+			pathnames = append(pathnames, dirPathnames...)
 		}
+	}
+
+	markDeleted := make(map[string]struct{})
+	r.handleMu.RLock()
+	for name := range r.handles {
+		glog.Infof("added %s", name)
+		markDeleted[name] = struct{}{}
+	}
+	r.handleMu.RUnlock()
+	var results []LoadResult
+	loaded := make(map[string]string) // program names to the pathname they were loaded from
+	for i, pathname := range pathnames {
+		name := names[i]
+		if prev, ok := loaded[name]; ok && isProgram(name) {
+			// Programs of the same name in different program paths would
+			// replace each other, so only the first is loaded.
+			err := errors.Errorf("program %s at %q has the same name as the program loaded from %q", name, pathname, prev)
+			ProgLoadErrors.Add(name, 1)
+			r.setProgramError(name, err)
+			glog.Info(err)
+			results = append(results, LoadResult{Name: name, Errors: []error{err}})
+			if r.errorsAbort {
+				return results, err
+			}
+			continue
+		}
+		result, ok, err := r.loadProgram(name, pathname)
+		if ok {
+			loaded[name] = pathname
+			results = append(results, result)
+		}
+		if err != nil && r.errorsAbort {
+			return results, err
+		}
+		glog.Infof("unmarking %s", name)
+		delete(markDeleted, name)
+	}
+	for name := range markDeleted {
+		glog.Infof("unloading %s", name)
+		r.UnloadProgram(name)
+	}
+	r.forgetRemovedPrograms(names)
+	return results, nil
+}
 
-		markDeleted := make(map[string]struct{})
-		r.handleMu.RLock()
-		for name := range r.handles {
-			glog.Infof("added %s", name)
-			markDeleted[name] = struct{}{}
+// programPathnames lists the candidate program files in the program
+// directory dir.  If recursive loading is enabled, subdirectories are walked
+// as well, skipping any hidden directories.
+func (r *Runtime) programPathnames(dir string) ([]string, error) {
+	var pathnames []string
+	if !r.recursive {
+		dirents, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
 		}
-		r.handleMu.RUnlock()
 		for _, dirent := range dirents {
 			if dirent.IsDir() {
 				continue
 			}
-			err = r.LoadProgram(filepath.Join(r.programPath, dirent.Name()))
-			if err != nil {
-				if r.errorsAbort {
-					return err
-				}
-				glog.Warning(err)
-			}
-			glog.Infof("unmarking %s", filepath.Base(dirent.Name()))
-			delete(markDeleted, filepath.Base(dirent.Name()))
+			pathnames = append(pathnames, filepath.Join(dir, dirent.Name()))
 		}
-		for name := range markDeleted {
-			glog.Infof("unloading %s", name)
-			r.UnloadProgram(name)
-		}
-	default:
-		err = r.LoadProgram(r.programPath)
+		return pathnames, nil
+	}
+	err := filepath.WalkDir(dir, func(pathname string, d fs.DirEntry, err error) error {
 		if err != nil {
-			if r.errorsAbort {
-				return err
+			return err
+		}
+		if d.IsDir() {
+			if pathname != dir && strings.HasPrefix(d.Name(), ".") {
+				glog.V(2).Infof("Skipping %s because it is a hidden directory.", pathname)
+				return filepath.SkipDir
 			}
-			glog.Warning(err)
+			return nil
 		}
+		pathnames = append(pathnames, pathname)
+		return nil
+	})
+	return pathnames, err
+}
+
+// manifestPrograms reads the program manifest, which lists the pathnames of
+// programs to load one per line.  Blank lines and lines starting with '#' are
+// ignored.  Relative pathnames are resolved against the directory containing
+// the manifest, and name the program.  It returns the program names and their
+// pathnames in the order listed.
+func (r *Runtime) manifestPrograms() ([]string, []string, error) {
+	b, err := os.ReadFile(filepath.Clean(r.programManifest))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read program manifest %q", r.programManifest)
 	}
-	return nil
+	var names, pathnames []string
+	seen := make(map[string]struct{})
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := filepath.ToSlash(filepath.Clean(line))
+		if _, ok := seen[name]; ok {
+			glog.Infof("Skipping duplicate manifest entry %s", line)
+			continue
+		}
+		seen[name] = struct{}{}
+		pathname := line
+		if !filepath.IsAbs(pathname) {
+			pathname = filepath.Join(filepath.Dir(r.programManifest), pathname)
+		}
+		names = append(names, name)
+		pathnames = append(pathnames, pathname)
+	}
+	return names, pathnames, nil
+}
+
+// programName returns the name of the program loaded from pathname in the
+// program path dir.  This is the basename of the file, unless recursive
+// loading is enabled, in which case it is the path relative to the program
+// directory so that programs of the same name in different subdirectories do
+// not collide.
+func (r *Runtime) programName(dir, pathname string) string {
+	if r.recursive {
+		if rel, err := filepath.Rel(dir, pathname); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(pathname)
+}
+
+// programPathOf returns the program path that contains pathname, or the
+// empty string if it is in none of them.
+func (r *Runtime) programPathOf(pathname string) string {
+	pathname = filepath.Clean(pathname)
+	for _, programPath := range r.programPaths {
+		if pathname == programPath || strings.HasPrefix(pathname, programPath+string(filepath.Separator)) {
+			return programPath
+		}
+	}
+	return ""
+}
+
+// compilerFor returns the compiler for the program at pathname, which
+// resolves includes relative to the program path that contains it.
+func (r *Runtime) compilerFor(pathname string) *compiler.Compiler {
+	if c, ok := r.compilers[r.programPathOf(pathname)]; ok {
+		return c
+	}
+	return r.c
 }
 
 // LoadProgram loads or reloads a program from the full pathname programPath.  The name of
-// the program is the basename of the file.
+// the program is the basename of the file, or the path relative to the
+// program directory when loading recursively.
 func (r *Runtime) LoadProgram(programPath string) error {
-	name := filepath.Base(programPath)
-	if strings.HasPrefix(name, ".") {
-		glog.V(2).Infof("Skipping %s because it is a hidden file.", programPath)
-		return nil
+	_, _, err := r.loadProgram(r.programName(r.programPathOf(programPath), programPath), programPath)
+	if err != nil && (r.errorsAbort || r.compileOnly) {
+		return err
 	}
-	if filepath.Ext(name) != fileExt {
-		glog.V(2).Infof("Skipping %s due to file extension.", programPath)
-		return nil
+	return nil
+}
+
+// loadProgram loads the program at programPath as name, returning the result
+// of the load and the error if it failed.  The returned bool is false if the
+// file is not a program and was skipped.
+func (r *Runtime) loadProgram(name, programPath string) (LoadResult, bool, error) {
+	if !isProgram(name) {
+		glog.V(2).Infof("Skipping %s because it is a hidden file or due to file extension.", programPath)
+		return LoadResult{}, false, nil
+	}
+	err := r.readAndRun(name, programPath)
+	r.setProgramError(name, err)
+	if err != nil {
+		if !r.errorsAbort && !r.compileOnly {
+			glog.Infof("Compile errors for %s:\n%s", name, err)
+		}
+		return LoadResult{Name: name, Errors: diagnostics(err)}, true, err
+	}
+	return LoadResult{Name: name, Success: true}, true, nil
+}
+
+// setProgramError records err as the result of the last attempt to load the
+// program name, clearing the previous error if err is nil.
+func (r *Runtime) setProgramError(name string, err error) {
+	r.programErrorMu.Lock()
+	defer r.programErrorMu.Unlock()
+	r.programErrors[name] = err
+	if err == nil {
+		delete(r.programErrorTimes, name)
+		ProgLastLoadError.Delete(name)
+		return
+	}
+	r.programErrorTimes[name] = time.Now()
+	msg := new(expvar.String)
+	msg.Set(err.Error())
+	ProgLastLoadError.Set(name, msg)
+}
+
+// forgetRemovedPrograms clears the result of the last load of each program
+// that is no longer one of names, whether it loaded or not, so that programs
+// that have been removed aren't reported with a stale error.
+func (r *Runtime) forgetRemovedPrograms(names []string) {
+	present := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		present[name] = struct{}{}
+	}
+	r.programErrorMu.Lock()
+	defer r.programErrorMu.Unlock()
+	for name := range r.programErrors {
+		if _, ok := present[name]; ok {
+			continue
+		}
+		delete(r.programErrors, name)
+		delete(r.programErrorTimes, name)
+		ProgLastLoadError.Delete(name)
 	}
+}
+
+// recordLoad counts a successful load of the program name, and records its time.
+func recordLoad(name string) {
+	ProgLoads.Add(name, 1)
+	t := new(expvar.Int)
+	t.Set(time.Now().Unix())
+	ProgLastLoadTime.Set(name, t)
+}
+
+// isProgram returns true if name is the name of a program file, and not a
+// hidden file or a file with another extension.
+func isProgram(name string) bool {
+	return !strings.HasPrefix(filepath.Base(name), ".") && filepath.Ext(name) == fileExt
+}
+
+// readAndRun opens the program at programPath and compiles and runs it as name.
+func (r *Runtime) readAndRun(name, programPath string) error {
 	f, err := os.OpenFile(filepath.Clean(programPath), os.O_RDONLY, 0o600)
 	if err != nil {
 		ProgLoadErrors.Add(name, 1)
@@ -121,14 +404,74 @@ func (r *Runtime) LoadProgram(programPath string) error {
 			glog.Warning(err)
 		}
 	}()
-	r.programErrorMu.Lock()
-	defer r.programErrorMu.Unlock()
-	r.programErrors[name] = r.CompileAndRun(name, f)
-	if r.programErrors[name] != nil {
-		if r.errorsAbort {
-			return r.programErrors[name]
+	return r.compileAndRun(r.compilerFor(programPath), name, f)
+}
+
+// compileError is returned by CompileAndRun when the compiler rejects a
+// program, and keeps the compiler's diagnostics available to the loader.
+type compileError struct {
+	name string
+	errs error
+	src  []byte // Program source, to quote the lines that errors occur on.
+}
+
+func (e *compileError) Error() string {
+	var list compilererrors.ErrorList
+	if errors.As(e.errs, &list) {
+		return fmt.Sprintf("compile failed for %s:\n%s", e.name, list.WithSource(e.name, e.src))
+	}
+	return fmt.Sprintf("compile failed for %s:\n%s", e.name, e.errs)
+}
+
+func (e *compileError) Unwrap() error {
+	return e.errs
+}
+
+// diagnostics splits a load error into the individual compiler diagnostics,
+// if it was a compile error.  Each diagnostic is a compile error of its own,
+// so that it still quotes the line of the source it occurs on.
+func diagnostics(err error) []error {
+	var ce *compileError
+	var list compilererrors.ErrorList
+	if !errors.As(err, &ce) || !errors.As(ce.errs, &list) || len(list) == 0 {
+		return []error{err}
+	}
+	errs := make([]error, 0, len(list))
+	for i := range list {
+		errs = append(errs, &compileError{ce.name, list[i : i+1], ce.src})
+	}
+	return errs
+}
+
+// resultError returns the error of the failed load result, with its compiler
+// diagnostics merged back into one compile error for the program.
+func resultError(result LoadResult) error {
+	var merged compilererrors.ErrorList
+	var ce *compileError
+	for _, err := range result.Errors {
+		var list compilererrors.ErrorList
+		if !errors.As(err, &ce) || !errors.As(ce.errs, &list) {
+			// Only a compile error has diagnostics; other load errors are
+			// the one error the program failed with.
+			return err
 		}
-		glog.Infof("Compile errors for %s:\n%s", name, r.programErrors[name])
+		merged = append(merged, list...)
+	}
+	return &compileError{ce.name, merged, ce.src}
+}
+
+// reportLint writes the lint findings of the program name to the lint
+// writer, and returns an error if any of them are errors.
+func (r *Runtime) reportLint(name string, findings []checker.Finding) error {
+	var lintErrors int
+	for _, f := range findings {
+		fmt.Fprintln(r.lintWriter, f)
+		if f.Severity == checker.Error {
+			lintErrors++
+		}
+	}
+	if lintErrors > 0 {
+		return errors.Errorf("lint found %d errors in %s", lintErrors, name)
 	}
 	return nil
 }
@@ -139,6 +482,11 @@ func (r *Runtime) LoadProgram(programPath string) error {
 // it.  If the new program fails to compile, any existing virtual machine with
 // the same name remains running.
 func (r *Runtime) CompileAndRun(name string, input io.Reader) error {
+	return r.compileAndRun(r.c, name, input)
+}
+
+// compileAndRun compiles the program name with c, and runs it as CompileAndRun does.
+func (r *Runtime) compileAndRun(c *compiler.Compiler, name string, input io.Reader) error {
 	glog.V(2).Infof("CompileAndRun %s", name)
 	var buf bytes.Buffer
 	tee := io.TeeReader(input, &buf)
@@ -151,62 +499,236 @@ func (r *Runtime) CompileAndRun(name string, input io.Reader) error {
 	r.handleMu.RLock()
 	vh, ok := r.handles[name]
 	r.handleMu.RUnlock()
-	if ok && bytes.Equal(vh.contentHash, contentHash) {
+	if ok && bytes.Equal(vh.contentHash, contentHash) && bytes.Equal(vh.includesHash, hashFiles(vh.includes)) {
 		glog.V(1).Infof("contents match, not recompiling %q", name)
 		return nil
 	}
-	obj, errs := r.c.Compile(name, &buf)
+	src := buf.Bytes()
+	start := time.Now()
+	var obj *code.Object
+	var findings []checker.Finding
+	var errs error
+	if r.lintWriter != nil {
+		obj, findings, errs = c.CompileAndLint(name, &buf)
+	} else {
+		obj, errs = c.Compile(name, &buf)
+	}
+	d := new(expvar.Float)
+	d.Set(time.Since(start).Seconds())
+	ProgCompileDuration.Set(name, d)
 	if errs != nil {
 		ProgLoadErrors.Add(name, 1)
-		return errors.Errorf("compile failed for %s:\n%s", name, errs)
+		return &compileError{name, errs, src}
 	}
 	if obj == nil {
 		ProgLoadErrors.Add(name, 1)
 		return errors.Errorf("internal error: compilation failed for %s: no program returned, but no errors", name)
 	}
+	if r.lintWriter != nil {
+		return r.reportLint(name, findings)
+	}
 	v := vm.New(name, obj, r.syslogUseCurrentYear, r.overrideLocation, r.logRuntimeErrors, r.trace)
+	if r.traceWriter != nil {
+		v.SetTraceWriter(r.traceWriter)
+	}
+	if r.nomatchWarnAfter > 0 {
+		v.SetNomatchWarnAfter(r.nomatchWarnAfter)
+	}
+	if r.vmWorkers != nil {
+		v.SetWorkers(r.vmWorkers)
+	}
+	if r.vmTimeout > 0 {
+		v.SetTimeout(r.vmTimeout)
+	}
 
 	if r.dumpBytecode {
 		glog.Info("Dumping program objects and bytecode\n", v.DumpByteCode())
 	}
-
-	// Load the metrics from the compilation into the global metric storage for export.
-	for _, m := range v.Metrics {
-		if !m.Hidden {
-			if r.omitMetricSource {
-				m.Source = ""
-			}
-			err := r.ms.Add(m)
-			if err != nil {
-				return err
-			}
+	if r.dumpBytecodeDir != "" {
+		if err := r.writeBytecode(name, v); err != nil {
+			glog.Warningf("Failed to write bytecode of %s: %s", name, err)
 		}
 	}
 
-	ProgLoads.Add(name, 1)
-	glog.Infof("Loaded program %s", name)
-
 	if r.compileOnly {
+		if err := r.addMetrics(v, nil); err != nil {
+			return err
+		}
+		recordLoad(name)
+		glog.Infof("Loaded program %s", name)
 		return nil
 	}
 
+	// The swap happens entirely under the handle lock, so the line dispatcher
+	// sends each line to either the complete old program or the complete new
+	// one.
 	r.handleMu.Lock()
 	defer r.handleMu.Unlock()
-	// Terminates the existing vm.
-	if handle, ok := r.handles[name]; ok {
-		close(handle.lines)
+	if r.stopped {
+		return errors.Errorf("not loading %s, the line dispatcher has stopped", name)
+	}
+	// Terminates the existing vm, and waits for it to finish any line in
+	// progress so that it makes no further changes to its metrics while they
+	// are carried over into the new program's metrics.
+	old, ok := r.handles[name]
+	if ok {
+		close(old.lines)
+		<-old.done
+	}
+	// The previous program's metrics are replaced by the new program's, so
+	// those it no longer declares, or declares differently, are dropped.
+	var removed, previous []*metrics.Metric
+	if ok {
+		removed = r.ms.RemoveProgramMetrics(name)
+		if !r.resetOnReload {
+			previous = removed
+		}
+	}
+	if err := r.addMetrics(v, previous); err != nil {
+		if ok {
+			// Keep the previous program running, with its own metrics.
+			r.ms.RemoveProgramMetrics(name)
+			for _, m := range removed {
+				if err := r.ms.Add(m); err != nil {
+					glog.Warningf("Failed to restore metric %s of %s: %s", m.Name, name, err)
+				}
+			}
+			r.startVM(name, old)
+		}
+		return err
+	}
+	recordLoad(name)
+	vm.ResetLineCounts(name)
+	glog.Infof("Loaded program %s", name)
+	r.startVM(name, &vmHandle{contentHash: contentHash, includes: obj.Includes, includesHash: hashFiles(obj.Includes), vm: v, loaded: time.Now()})
+	return nil
+}
+
+// Close stops the goroutine that reloads programs on SIGHUP.  The programs
+// keep processing lines until the lines channel is closed.  Close may be
+// called more than once.
+func (r *Runtime) Close() {
+	r.quitOnce.Do(func() { close(r.signalQuit) })
+}
+
+// writeBytecode writes the disassembly of the program name to a file named
+// after it in the bytecode dump directory, replacing any earlier version.
+func (r *Runtime) writeBytecode(name string, v *vm.VM) error {
+	pathname := filepath.Join(r.dumpBytecodeDir, name+".bytecode")
+	if err := os.MkdirAll(filepath.Dir(pathname), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(pathname, []byte(v.DumpByteCode()), 0o644)
+}
+
+// addMetrics loads the metrics from the compilation into the global metric storage for export.
+func (r *Runtime) addMetrics(v *vm.VM, previous []*metrics.Metric) error {
+	for _, m := range v.Metrics {
+		if m.Limit == 0 && len(m.Keys) > 0 {
+			m.Limit = r.maxDimensions
+		}
+		if r.omitMetricSource {
+			m.Source = ""
+		}
+		if r.prefixWithProgram {
+			m.Name = programPrefix(m.Program) + "_" + m.Name
+		}
+		for _, p := range previous {
+			if sameMetric(p, m) {
+				if err := carryOver(p, m); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		// Hidden metrics are stored too, but are left out of the exports
+		// unless an export filter allows them.
+		if err := r.ms.Add(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameMetric returns true if the metrics p and m have the same name, kind,
+// type and dimensions, and the same buckets or quantiles, so the values of p
+// can be carried over into m when a program is reloaded.  Where they're
+// declared in the program doesn't matter.
+func sameMetric(p, m *metrics.Metric) bool {
+	return p.Name == m.Name && p.Kind == m.Kind && p.Type == m.Type &&
+		reflect.DeepEqual(p.Keys, m.Keys) && reflect.DeepEqual(p.Buckets, m.Buckets) && reflect.DeepEqual(p.Quantiles, m.Quantiles)
+}
+
+// carryOver copies the values of the metric p, of the previous version of a
+// program, into m.
+func carryOver(p, m *metrics.Metric) error {
+	p.RLock()
+	defer p.RUnlock()
+	for _, lv := range p.LabelValues {
+		if err := m.RemoveDatum(lv.Labels...); err != nil {
+			return err
+		}
+		if err := m.AppendLabelValue(&metrics.LabelValue{Labels: lv.Labels, Value: lv.Value, Expiry: lv.Expiry}); err != nil {
+			return err
+		}
 	}
-	lines := make(chan *logline.LogLine)
-	r.handles[name] = &vmHandle{contentHash: contentHash, vm: v, lines: lines}
-	r.wg.Add(1)
-	go v.Run(lines, &r.wg)
 	return nil
 }
 
+// programPrefix returns the metric name prefix for the program name, which
+// is the name without its extension and with any characters that can't
+// appear in a metric name replaced by underscores, e.g. `nginx/errors.mtail`
+// becomes `nginx_errors`.
+func programPrefix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(name, fileExt))
+}
+
+// startVM starts a goroutine running the vm in h as the program name, with a
+// new line channel.  The caller must hold handleMu.
+func (r *Runtime) startVM(name string, h *vmHandle) {
+	lines := make(chan *logline.LogLine, r.lineBufferSize)
+	done := make(chan struct{})
+	h.lines, h.done = lines, done
+	r.handles[name] = h
+	ProgCount.Set(int64(len(r.handles)))
+	r.wg.Add(1)
+	go func() {
+		defer close(done)
+		h.vm.Run(lines, &r.wg)
+	}()
+}
+
+// hashFiles returns a hash of the contents of the files at pathnames, so that
+// changes to files included by a program are noticed.  Files that can't be
+// read contribute their error instead.
+func hashFiles(pathnames []string) []byte {
+	if len(pathnames) == 0 {
+		return nil
+	}
+	hasher := sha256.New()
+	for _, pathname := range pathnames {
+		b, err := os.ReadFile(filepath.Clean(pathname))
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		hasher.Write(b)
+	}
+	return hasher.Sum(nil)
+}
+
 type vmHandle struct {
-	contentHash []byte
-	vm          *vm.VM
-	lines       chan *logline.LogLine
+	contentHash  []byte
+	includes     []string // pathnames of the files included by the program
+	includesHash []byte   // hash of the contents of the included files
+	vm           *vm.VM
+	lines        chan *logline.LogLine
+	done         chan struct{} // closed when the vm has stopped running
+	loaded       time.Time     // when the program was loaded
 }
 
 // Runtime handles the lifecycle of programs and virtual machines, by watching
@@ -221,24 +743,46 @@ type Runtime struct {
 	cOpts []compiler.Option // options for constructing `c`
 	c     *compiler.Compiler
 
-	programPath string // Path that contains mtail programs.
+	programPaths    []string                      // Paths that contain mtail programs, either directories or program files.
+	programManifest string                        // Path of a file listing the programs to load, instead of scanning programPaths.
+	manifestWaker   waker.Waker                   // Wakes to check programManifest for changes, if not nil.
+	recursive       bool                          // Load programs from subdirectories of programPaths too.
+	compilers       map[string]*compiler.Compiler // Compilers that resolve includes relative to each of programPaths.
+
+	loadMu sync.Mutex // serialises loads of all programs
 
 	handleMu sync.RWMutex         // guards accesses to handles
 	handles  map[string]*vmHandle // map of program names to virtual machines
+	stopped  bool                 // set when the line dispatcher has finished, after which no VMs are started
 
-	programErrorMu sync.RWMutex     // guards access to programErrors
-	programErrors  map[string]error // errors from the last compile attempt of the program
+	dispatchDone chan struct{} // closed when the line dispatcher has finished
+
+	programErrorMu    sync.RWMutex         // guards access to programErrors
+	programErrors     map[string]error     // errors from the last compile attempt of the program
+	programErrorTimes map[string]time.Time // when the last compile attempt of the program failed, if it did
 
 	overrideLocation     *time.Location // Instructs the vm to override the timezone with the specified zone.
 	compileOnly          bool           // Only compile programs and report errors, do not load VMs.
 	errorsAbort          bool           // Compiler errors abort the loader.
 	dumpBytecode         bool           // Instructs the loader to dump to stdout the compiled program after compilation.
+	dumpBytecodeDir      string         // Instructs the loader to write the compiled program to a file in this directory after compilation.
 	syslogUseCurrentYear bool           // Instructs the VM to overwrite zero years with the current year in a strptime instruction.
 	omitMetricSource     bool
-	logRuntimeErrors     bool // Instruct the VM to emit runtime errors to the log.
-	trace                bool // Trace execution of each VM.
+	prefixWithProgram    bool          // Prefix metric names with their program name in the store.
+	resetOnReload        bool          // Remove a program's metrics from the store when it is reloaded, rather than carrying their values over.
+	maxDimensions        int           // The size limit of metrics that don't declare one, if positive.
+	nomatchWarnAfter     int64         // Warn about patterns that haven't matched after this many lines, if positive.
+	vmWorkers            chan struct{} // Limits the number of programs processing a line at once, if not nil.
+	vmTimeout            time.Duration // Abandon a line that takes a program longer than this, if positive.
+	lineBufferSize       int           // The number of lines buffered for each program.
+	dropLines            bool          // Drop lines for a program whose line buffer is full, rather than wait for it.
+	logRuntimeErrors     bool          // Instruct the VM to emit runtime errors to the log.
+	trace                bool          // Trace execution of each VM.
+	traceWriter          io.Writer     // Write the execution of each VM to this, if not nil.
+	lintWriter           io.Writer     // Write the lint findings of each program to this, and don't run them, if not nil.
 
 	signalQuit chan struct{} // When closed stops the signal handler goroutine.
+	quitOnce   sync.Once     // Ensures signalQuit is closed once.
 }
 
 // New creates a new program loader that reads programs from programPath.
@@ -247,21 +791,45 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 		return nil, errors.New("loader needs a store")
 	}
 	r := &Runtime{
-		ms:            store,
-		programPath:   programPath,
-		handles:       make(map[string]*vmHandle),
-		programErrors: make(map[string]error),
-		signalQuit:    make(chan struct{}),
+		ms:                store,
+		handles:           make(map[string]*vmHandle),
+		programErrors:     make(map[string]error),
+		programErrorTimes: make(map[string]time.Time),
+		signalQuit:        make(chan struct{}),
+		dispatchDone:      make(chan struct{}),
 	}
 	initDone := make(chan struct{})
 	defer close(initDone)
+	if programPath != "" {
+		r.programPaths = append(r.programPaths, filepath.Clean(programPath))
+	}
 	var err error
 	if err = r.SetOption(options...); err != nil {
 		return nil, err
 	}
+	// Includes are resolved relative to the directory programs are named from.
+	if r.programManifest != "" {
+		r.cOpts = append(r.cOpts, compiler.BaseDir(filepath.Dir(r.programManifest)))
+	} else {
+		r.compilers = make(map[string]*compiler.Compiler)
+		for _, programPath := range r.programPaths {
+			baseDir := programPath
+			if s, err := os.Stat(baseDir); err == nil && !s.IsDir() {
+				baseDir = filepath.Dir(baseDir)
+			}
+			c, err := compiler.New(append(r.cOpts, compiler.BaseDir(baseDir))...)
+			if err != nil {
+				return nil, err
+			}
+			r.compilers[programPath] = c
+		}
+	}
 	if r.c, err = compiler.New(r.cOpts...); err != nil {
 		return nil, err
 	}
+	if len(r.programPaths) > 0 && r.programManifest == "" {
+		r.c = r.compilers[r.programPaths[0]]
+	}
 	// Defer shutdown handling to avoid a race on r.wg.
 	wg.Add(1)
 	defer func() {
@@ -275,53 +843,98 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done() // signal to owner we're done
+		defer close(r.dispatchDone)
 		<-initDone
 		for line := range lines {
 			LineCount.Add(1)
 			r.handleMu.RLock()
 			for prog := range r.handles {
-				r.handles[prog].lines <- line
+				if !r.dropLines {
+					r.handles[prog].lines <- line
+					continue
+				}
+				select {
+				case r.handles[prog].lines <- line:
+				default:
+					LinesDropped.Add(prog, 1)
+				}
 			}
 			r.handleMu.RUnlock()
 		}
 		glog.Info("END OF LINE")
 		glog.Infof("processed %s lines", LineCount.String())
-		close(r.signalQuit)
+		r.Close()
 		r.handleMu.Lock()
+		r.stopped = true
 		for prog := range r.handles {
 			close(r.handles[prog].lines)
 			delete(r.handles, prog)
 		}
+		ProgCount.Set(0)
 		r.handleMu.Unlock()
 	}()
-	if r.programPath == "" {
+	if len(r.programPaths) == 0 && r.programManifest == "" {
 		glog.Info("No program path specified, no programs will be loaded.")
 		return r, nil
 	}
+	if r.compileOnly {
+		// Programs are only compiled once, so there is nothing to reload.
+		if err := r.LoadAllPrograms(); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
 
-	// Create one goroutine that handles reload signals.
+	// Create one goroutine that handles reload signals.  The handler is
+	// installed before New returns so that a SIGHUP sent straight after
+	// startup doesn't terminate the process.  Programs are recompiled on a
+	// separate worker goroutine so that the signal loop stays responsive
+	// while a slow reload is in progress; signals that arrive during a reload
+	// are coalesced into one more reload after it.
+	n := make(chan os.Signal, 1)
+	signal.Notify(n, syscall.SIGHUP)
+	reload := make(chan struct{}, 1)
 	r.wg.Add(1)
 	go func() {
 		defer r.wg.Done()
-		<-initDone
-		if r.programPath == "" {
-			glog.Info("no program reload on SIGHUP without programPath")
-			return
-		}
-		n := make(chan os.Signal, 1)
-		signal.Notify(n, syscall.SIGHUP)
 		defer signal.Stop(n)
+		<-initDone
 		for {
 			select {
 			case <-r.signalQuit:
 				return
 			case <-n:
+				select {
+				case reload <- struct{}{}:
+					glog.Info("Received SIGHUP, reloading all programs")
+				default:
+					glog.Info("Received SIGHUP, reload already pending")
+				}
+			}
+		}
+	}()
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		<-initDone
+		for {
+			select {
+			case <-r.signalQuit:
+				return
+			case <-reload:
+				ProgReloads.Add(1)
+				// Programs that fail to compile keep their previous VM
+				// running; only the swap of a successfully compiled program
+				// takes the handle lock.
 				if err := r.LoadAllPrograms(); err != nil {
 					glog.Info(err)
 				}
 			}
 		}
 	}()
+	if r.programManifest != "" && r.manifestWaker != nil {
+		r.watchManifest(initDone, reload)
+	}
 	// Guarantee all existing programmes get loaded before we leave.
 	if err := r.LoadAllPrograms(); err != nil {
 		return nil, err
@@ -329,6 +942,54 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 	return r, nil
 }
 
+// watchManifest starts a goroutine that checks the program manifest each time
+// the manifest waker wakes, and sends on reload when the manifest has been
+// changed or replaced since it was last checked.
+func (r *Runtime) watchManifest(initDone <-chan struct{}, reload chan<- struct{}) {
+	last, err := os.Stat(r.programManifest)
+	if err != nil {
+		glog.Info(err)
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		<-initDone
+		for {
+			select {
+			case <-r.signalQuit:
+				return
+			case <-r.manifestWaker.Wake():
+			}
+			fi, err := os.Stat(r.programManifest)
+			if err != nil {
+				glog.V(1).Info(err)
+				continue
+			}
+			if last != nil && os.SameFile(last, fi) && fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size() {
+				continue
+			}
+			last = fi
+			select {
+			case reload <- struct{}{}:
+				glog.Infof("Program manifest %q changed, reloading all programs", r.programManifest)
+			default:
+				glog.Infof("Program manifest %q changed, reload already pending", r.programManifest)
+			}
+		}
+	}()
+}
+
+// Alive returns false once the line dispatcher has finished, after which no
+// more lines are sent to the programs.
+func (r *Runtime) Alive() bool {
+	select {
+	case <-r.dispatchDone:
+		return false
+	default:
+		return true
+	}
+}
+
 // SetOption takes one or more option functions and applies them in order to Runtime.
 func (r *Runtime) SetOption(options ...Option) error {
 	for _, option := range options {
@@ -340,11 +1001,20 @@ func (r *Runtime) SetOption(options ...Option) error {
 }
 
 // UnloadProgram removes the named program, any currently running VM goroutine.
+// The program may be named either by its program name or by its pathname.
 func (r *Runtime) UnloadProgram(pathname string) {
-	name := filepath.Base(pathname)
 	r.handleMu.Lock()
 	defer r.handleMu.Unlock()
-	close(r.handles[name].lines)
+	name := pathname
+	if _, ok := r.handles[name]; !ok {
+		name = r.programName(r.programPathOf(pathname), pathname)
+	}
+	handle, ok := r.handles[name]
+	if !ok {
+		return
+	}
+	close(handle.lines)
 	delete(r.handles, name)
+	ProgCount.Set(int64(len(r.handles)))
 	ProgUnloads.Add(name, 1)
 }
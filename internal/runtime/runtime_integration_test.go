@@ -1047,6 +1047,79 @@ a
 					},
 				},
 			},
+			{
+				Name:    "route",
+				Program: "regexp replace",
+				Kind:    metrics.Text,
+				Type:    metrics.String,
+				Hidden:  true,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.String{Value: "/v1/users/:num/orders/:num"},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "compare capture to float",
+		prog: `counter slow_requests
+
+/^(?P<latency>\S+)$/ {
+  $latency > 1.0 {
+    slow_requests++
+  }
+}
+`,
+		log: `0.5
+2.5
+abc
+10
+`,
+		errs: 1,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "slow_requests",
+				Program: "compare capture to float",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 2},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "set a counter to an absolute value",
+		prog: `counter requests_served
+
+/^served (?P<total>\d+)$/ {
+  requests_served = $total
+}
+`,
+		log: `served 40
+served 45
+served 3
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests_served",
+				Program: "set a counter to an absolute value",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 3},
+					},
+				},
+			},
 		},
 	},
 	{
@@ -1065,6 +1138,17 @@ N && 1 {
 }
 
 func TestRuntimeEndToEnd(t *testing.T) {
+	testRuntimeEndToEnd(t)
+}
+
+// TestRuntimeEndToEndOptimised checks that optimised bytecode produces the
+// same metrics as the unoptimised programs.
+func TestRuntimeEndToEndOptimised(t *testing.T) {
+	testRuntimeEndToEnd(t, OptimiseBytecode())
+}
+
+func testRuntimeEndToEnd(t *testing.T, options ...Option) {
+	t.Helper()
 	testutil.SkipIfShort(t)
 	if testing.Verbose() {
 		testutil.SetFlag(t, "vmodule", "vm=2,loader=2,checker=2")
@@ -1077,7 +1161,7 @@ func TestRuntimeEndToEnd(t *testing.T) {
 			store := metrics.NewStore()
 			lines := make(chan *logline.LogLine, 1)
 			var wg sync.WaitGroup
-			r, err := New(lines, &wg, "", store, ErrorsAbort(), DumpAst(), DumpAstTypes(), DumpBytecode(), OmitMetricSource(), TraceExecution())
+			r, err := New(lines, &wg, "", store, append([]Option{ErrorsAbort(), DumpAst(), DumpAstTypes(), DumpBytecode(), OmitMetricSource(), TraceExecution()}, options...)...)
 			testutil.FatalIfErr(t, err)
 			compileErrors := r.CompileAndRun(tc.name, strings.NewReader(tc.prog))
 			testutil.FatalIfErr(t, compileErrors)
@@ -1099,7 +1183,7 @@ func TestRuntimeEndToEnd(t *testing.T) {
 			})
 
 			// Ignore the datum.Time field as well, as the results will be unstable otherwise.
-			testutil.ExpectNoDiff(t, tc.metrics, ms, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, sync.RWMutex{}, datum.String{}), testutil.IgnoreFields(datum.BaseDatum{}, "Time"))
+			testutil.ExpectNoDiff(t, tc.metrics, ms, testutil.SortSlices(metrics.Less), testutil.IgnoreUnexported(metrics.Metric{}, metrics.LabelValue{}, sync.RWMutex{}, datum.String{}, datum.Int{}, datum.Float{}), testutil.IgnoreFields(datum.BaseDatum{}, "Time"))
 		})
 	}
 }
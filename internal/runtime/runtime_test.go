@@ -4,15 +4,29 @@
 package runtime
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
 	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+	"github.com/pkg/errors"
 )
 
 func TestNewRuntime(t *testing.T) {
@@ -79,3 +93,900 @@ func TestLoadProg(t *testing.T) {
 	close(lines)
 	wg.Wait()
 }
+
+func TestLoadAllProgramsRecursive(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+
+	for _, name := range []string{"top.mtail", "nginx/errors.mtail", "postgres/errors.mtail", ".hidden/skipped.mtail"} {
+		pathname := filepath.Join(tmpDir, name)
+		testutil.FatalIfErr(t, os.MkdirAll(filepath.Dir(pathname), 0o700))
+		f := testutil.TestOpenFile(t, pathname)
+		testutil.WriteString(t, f, testProgram)
+		testutil.FatalIfErr(t, f.Close())
+	}
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, tmpDir, store, RecursivePrograms())
+	testutil.FatalIfErr(t, err)
+
+	l.handleMu.RLock()
+	var got []string
+	for name := range l.handles {
+		got = append(got, name)
+	}
+	l.handleMu.RUnlock()
+	sort.Strings(got)
+	testutil.ExpectNoDiff(t, []string{"nginx/errors.mtail", "postgres/errors.mtail", "top.mtail"}, got)
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestLoadPrograms(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+
+	progs := map[string]string{
+		"good.mtail":  testProgram,
+		"bad.mtail":   "counter a\ncounter b\n",
+		"notprog.txt": "asdf",
+	}
+	for name, prog := range progs {
+		f := testutil.TestOpenFile(t, filepath.Join(tmpDir, name))
+		testutil.WriteString(t, f, prog)
+		testutil.FatalIfErr(t, f.Close())
+	}
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	l.programPaths = []string{tmpDir}
+
+	results, err := l.LoadPrograms()
+	testutil.FatalIfErr(t, err)
+	if len(results) != 2 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	if results[0].Name != "bad.mtail" || results[0].Success {
+		t.Errorf("bad.mtail should have failed: %v", results[0])
+	}
+	if len(results[0].Errors) != 2 {
+		t.Errorf("expected one diagnostic per unused declaration, got %v", results[0].Errors)
+	}
+	if results[1].Name != "good.mtail" || !results[1].Success || results[1].Errors != nil {
+		t.Errorf("good.mtail should have loaded: %v", results[1])
+	}
+
+	err = ResultErrors(results)
+	var loadErrors LoadErrors
+	if !errors.As(err, &loadErrors) || len(loadErrors) != 1 {
+		t.Fatalf("expected the error of bad.mtail, got %v", err)
+	}
+	var ce *compileError
+	if !errors.As(loadErrors[0], &ce) || ce.name != "bad.mtail" {
+		t.Errorf("expected a compile error for bad.mtail, got %v", loadErrors[0])
+	}
+	if ResultErrors(results[1:]) != nil {
+		t.Errorf("expected no error when every program loaded")
+	}
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestLoadProgramsSkipsUnchanged(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+	for _, name := range []string{"same.mtail", "changed.mtail"} {
+		testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(testProgram), 0o600))
+	}
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, tmpDir, store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	l.handleMu.RLock()
+	sameVM, changedVM := l.handles["same.mtail"].vm, l.handles["changed.mtail"].vm
+	l.handleMu.RUnlock()
+
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, "changed.mtail"), []byte(testProgram+"# changed\n"), 0o600))
+	loadsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_loads_total", "same.mtail", 0)
+	_, err = l.LoadPrograms()
+	testutil.FatalIfErr(t, err)
+	loadsCheck()
+
+	l.handleMu.RLock()
+	defer l.handleMu.RUnlock()
+	if l.handles["same.mtail"].vm != sameVM {
+		t.Error("unchanged program was recompiled")
+	}
+	if l.handles["changed.mtail"].vm == changedVM {
+		t.Error("changed program was not recompiled")
+	}
+}
+
+func TestLoadProgramsFromMultiplePaths(t *testing.T) {
+	store := metrics.NewStore()
+	dir1 := testutil.TestTempDir(t)
+	dir2 := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir1, "a.mtail"), []byte(testProgram), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir1, "c.mtail"), []byte(testProgram), 0o600))
+	// Includes are relative to the directory the program was loaded from.
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir2, "common.inc"), []byte("const FOO /foo/\n"), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir2, "b.mtail"), []byte("include \"common.inc\"\nFOO {}\n"), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir2, "c.mtail"), []byte(testProgram), 0o600))
+
+	loadErrorsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_load_errors_total", "c.mtail", 1)
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, dir1, store, ProgramPaths(dir2))
+	testutil.FatalIfErr(t, err)
+	loadErrorsCheck()
+
+	l.handleMu.RLock()
+	var names []string
+	for name := range l.handles {
+		names = append(names, name)
+	}
+	l.handleMu.RUnlock()
+	sort.Strings(names)
+	testutil.ExpectNoDiff(t, []string{"a.mtail", "b.mtail", "c.mtail"}, names)
+
+	l.programErrorMu.RLock()
+	err = l.programErrors["c.mtail"]
+	l.programErrorMu.RUnlock()
+	if err == nil || !strings.Contains(err.Error(), "has the same name") {
+		t.Errorf("expected name collision error for c.mtail, got %v", err)
+	}
+
+	// Reloading covers every program path.
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir2, "d.mtail"), []byte(testProgram), 0o600))
+	_, err = l.LoadPrograms()
+	testutil.FatalIfErr(t, err)
+	l.handleMu.RLock()
+	_, ok := l.handles["d.mtail"]
+	l.handleMu.RUnlock()
+	if !ok {
+		t.Error("d.mtail not loaded from second program path")
+	}
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestPrefixMetricsWithProgram(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+	for _, name := range []string{"nginx.mtail", "my-app.mtail"} {
+		testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, name), []byte("counter errors\n/error/ {\n  errors++\n}\n"), 0o600))
+	}
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	_, err := New(lines, &wg, tmpDir, store, PrefixMetricsWithProgram())
+	testutil.FatalIfErr(t, err)
+	close(lines)
+	wg.Wait()
+
+	var names []string
+	for name := range store.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	testutil.ExpectNoDiff(t, []string{"my_app_errors", "nginx_errors"}, names)
+}
+
+func TestMaxDimensionsPerMetric(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store, MaxDimensionsPerMetric(10))
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+
+	testutil.FatalIfErr(t, l.CompileAndRun("dims", strings.NewReader("counter a by x\ncounter b by x limit 100\ncounter c\n/(.*)/ {\n  a[$1]++\n  b[$1]++\n  c++\n}\n")))
+	for name, want := range map[string]int{"a": 10, "b": 100, "c": 0} {
+		if got := store.FindMetricOrNil(name, "dims").Limit; got != want {
+			t.Errorf("limit of %s: got %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestVMWorkersSameTotals(t *testing.T) {
+	progs := map[string]string{
+		"lines.mtail":  "counter lines_total\n/$/ {\n  lines_total++\n}\n",
+		"codes.mtail":  "counter codes by code\n/code=(?P<code>\\d+)/ {\n  codes[$code]++\n}\n",
+		"bytes.mtail":  "counter bytes_total\n/bytes=(?P<b>\\d+)/ {\n  bytes_total += $b\n}\n",
+		"latest.mtail": "gauge latest\n/code=(?P<code>\\d+)/ {\n  latest = $code\n}\n",
+	}
+	tmpDir := testutil.TestTempDir(t)
+	for name, prog := range progs {
+		testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(prog), 0o600))
+	}
+	const numLines = 500
+	run := func(options ...Option) map[string]string {
+		store := metrics.NewStore()
+		lines := make(chan *logline.LogLine)
+		var wg sync.WaitGroup
+		_, err := New(lines, &wg, tmpDir, store, options...)
+		testutil.FatalIfErr(t, err)
+		for i := 0; i < numLines; i++ {
+			lines <- logline.New(context.Background(), "log", fmt.Sprintf("code=%d bytes=%d", 200+i%5, i))
+		}
+		close(lines)
+		wg.Wait()
+		values := make(map[string]string)
+		testutil.FatalIfErr(t, store.Range(func(m *metrics.Metric) error {
+			for _, lv := range m.LabelValues {
+				values[m.Name+fmt.Sprint(lv.Labels)] = lv.Value.ValueString()
+			}
+			return nil
+		}))
+		return values
+	}
+	serial := run(VMWorkers(1))
+	if len(serial) == 0 {
+		t.Fatal("no metric values")
+	}
+	testutil.ExpectNoDiff(t, serial, run(VMWorkers(3)))
+	testutil.ExpectNoDiff(t, serial, run())
+}
+
+func TestDropLinesWhenFull(t *testing.T) {
+	tmpDir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, "slow.mtail"), []byte("counter lines_total\n/$/ {\n  lines_total++\n}\n"), 0o600))
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, tmpDir, store, VMWorkers(1), LineBufferSize(1), DropLinesWhenFull())
+	testutil.FatalIfErr(t, err)
+	// Take the only worker so that the program can't process any lines.
+	r.vmWorkers <- struct{}{}
+	before := expvarMapValue(t, "lines_dropped_total", "slow.mtail")
+	const numLines = 10
+	for i := 0; i < numLines; i++ {
+		lines <- logline.New(context.Background(), "log", "line")
+	}
+	// Give the worker back so that the program can finish its lines.
+	<-r.vmWorkers
+	close(lines)
+	wg.Wait()
+	dropped := expvarMapValue(t, "lines_dropped_total", "slow.mtail") - before
+	processed, err := store.FindMetricOrNil("lines_total", "slow.mtail").GetDatum()
+	testutil.FatalIfErr(t, err)
+	// One line is buffered, one may have been taken by the program before the
+	// next was sent, and the last may not have been dispatched before the
+	// worker was given back.
+	if dropped < numLines-3 || dropped > numLines-1 {
+		t.Errorf("dropped %d of %d lines, want between %d and %d", dropped, numLines, numLines-3, numLines-1)
+	}
+	if got := datum.GetInt(processed); got+dropped != numLines {
+		t.Errorf("processed %d and dropped %d of %d lines", got, dropped, numLines)
+	}
+}
+
+func expvarMapValue(t *testing.T, name, key string) int64 {
+	t.Helper()
+	if v, ok := testutil.TestGetExpvar(t, name).(*expvar.Map).Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestResetMetricsOnReload(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []Option
+		wantA   int64
+		wantB   bool
+	}{
+		{"carry over", nil, 1, false},
+		{"reset", []Option{ResetMetricsOnReload()}, 0, false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := metrics.NewStore()
+			lines := make(chan *logline.LogLine)
+			var wg sync.WaitGroup
+			l, err := New(lines, &wg, "", store, tc.options...)
+			testutil.FatalIfErr(t, err)
+			defer func() {
+				close(lines)
+				wg.Wait()
+			}()
+
+			name := "reset-" + strings.ReplaceAll(tc.name, " ", "-")
+			testutil.FatalIfErr(t, l.CompileAndRun(name, strings.NewReader("counter a\ncounter b\n/a/ {\n  a++\n  b++\n}\n")))
+			linesCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_lines_total", name, 1)
+			lines <- logline.New(context.Background(), "log", "a")
+			linesCheck()
+
+			testutil.FatalIfErr(t, l.CompileAndRun(name, strings.NewReader("counter a\n/a/ {\n  a++\n}\n")))
+			m := store.FindMetricOrNil("a", name)
+			if m == nil {
+				t.Fatal("metric a not found in store")
+			}
+			var got int64
+			if len(m.LabelValues) > 0 {
+				got = datum.GetInt(m.LabelValues[0].Value)
+			}
+			if got != tc.wantA {
+				t.Errorf("a after reload: got %d, want %d", got, tc.wantA)
+			}
+			if gotB := store.FindMetricOrNil("b", name) != nil; gotB != tc.wantB {
+				t.Errorf("b in store after reload: got %v, want %v", gotB, tc.wantB)
+			}
+		})
+	}
+}
+
+func TestReloadCarriesOverValues(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	send := func(line string) {
+		t.Helper()
+		linesCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_lines_total", "carry", 1)
+		lines <- logline.New(context.Background(), "log", line)
+		linesCheck()
+	}
+	value := func(name string, labels ...string) int64 {
+		t.Helper()
+		m := store.FindMetricOrNil(name, "carry")
+		if m == nil {
+			t.Fatalf("metric %s not found in store", name)
+		}
+		d, err := m.GetDatum(labels...)
+		testutil.FatalIfErr(t, err)
+		return datum.GetInt(d)
+	}
+
+	testutil.FatalIfErr(t, l.CompileAndRun("carry", strings.NewReader("counter total\ncounter requests by code\ncounter changes\ncounter removed\n/(\\d+)/ {\n  total++\n  requests[$1]++\n  changes++\n  removed++\n}\n")))
+	send("200")
+
+	// The declarations have moved, which doesn't stop their values from
+	// being carried over, but changes has a different kind.
+	testutil.FatalIfErr(t, l.CompileAndRun("carry", strings.NewReader("# tweaked\ngauge changes\ncounter requests by code\ncounter total\n/(\\d+)/ {\n  total++\n  requests[$1]++\n  changes++\n}\n")))
+	send("200")
+	testutil.ExpectNoDiff(t, int64(2), value("total"))
+	testutil.ExpectNoDiff(t, int64(2), value("requests", "200"))
+	testutil.ExpectNoDiff(t, int64(1), value("changes"))
+	if m := store.FindMetricOrNil("removed", "carry"); m != nil {
+		t.Errorf("metric no longer declared still in store: %v", m)
+	}
+}
+
+func TestCompileAndRunSwapDuringProcessing(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+
+	progs := []string{
+		"counter c by x\n/(.*)/ {\n  c[$1]++\n}\n",
+		"counter c by x\n# reloaded\n/(.*)/ {\n  c[$1]++\n}\n",
+	}
+	testutil.FatalIfErr(t, l.CompileAndRun("test", strings.NewReader(progs[0])))
+
+	const numLines = 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < numLines; i++ {
+			lines <- logline.New(context.Background(), "log", fmt.Sprintf("line %d", i))
+		}
+	}()
+	// Reload the program repeatedly while lines are flowing, alternating the
+	// source so each load has a new content hash.
+	for i := 1; ; i++ {
+		select {
+		case <-done:
+			close(lines)
+			wg.Wait()
+		default:
+			testutil.FatalIfErr(t, l.CompileAndRun("test", strings.NewReader(progs[i%2])))
+			continue
+		}
+		break
+	}
+
+	m := store.FindMetricOrNil("c", "test")
+	if m == nil {
+		t.Fatal("metric c not found in store")
+	}
+	if len(m.LabelValues) != numLines {
+		t.Errorf("unexpected label values: got %d, want %d", len(m.LabelValues), numLines)
+	}
+	for _, lv := range m.LabelValues {
+		if got := datum.GetInt(lv.Value); got != 1 {
+			t.Errorf("unexpected value for %v: got %d, want 1", lv.Labels, got)
+		}
+	}
+}
+
+func TestProgLineCounts(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+
+	testutil.FatalIfErr(t, l.CompileAndRun("linecounts", strings.NewReader("/a/ {}\n/b/ {}\n")))
+
+	linesCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_lines_total", "linecounts", 3)
+	matchedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_lines_matched_total", "linecounts", 2)
+	unmatchedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_lines_unmatched_total", "linecounts", 1)
+	for _, line := range []string{"a", "ab", "c"} {
+		lines <- logline.New(context.Background(), "log", line)
+	}
+	linesCheck()
+	matchedCheck()
+	unmatchedCheck()
+	if v, ok := testutil.TestGetExpvar(t, "prog_line_processing_seconds_total").(*expvar.Map).Get("linecounts").(*expvar.Float); !ok || v.Value() <= 0 {
+		t.Errorf("processing time not recorded: %v", v)
+	}
+
+	// A reloaded program starts counting again.
+	testutil.FatalIfErr(t, l.CompileAndRun("linecounts", strings.NewReader("/a/ {}\n")))
+	for _, name := range []string{"prog_lines_total", "prog_line_processing_seconds_total"} {
+		if v := testutil.TestGetExpvar(t, name).(*expvar.Map).Get("linecounts"); v != nil {
+			t.Errorf("%s not reset: %s", name, v)
+		}
+	}
+}
+
+func TestDumpBytecodeDir(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store, DumpBytecodeDir(dir))
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+
+	pathname := filepath.Join(dir, "test.mtail.bytecode")
+	for _, re := range []string{"first", "second"} {
+		testutil.FatalIfErr(t, l.CompileAndRun("test.mtail", strings.NewReader("/"+re+"/ {}\n")))
+		b, err := os.ReadFile(pathname)
+		testutil.FatalIfErr(t, err)
+		if !strings.Contains(string(b), "/"+re+"/") {
+			t.Errorf("bytecode dump doesn't contain the regexp %q:\n%s", re, b)
+		}
+	}
+}
+
+func TestTraceExecutionTo(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	var trace bytes.Buffer
+	l, err := New(lines, &wg, "", store, TraceExecutionTo(&trace))
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, l.CompileAndRun("trace", strings.NewReader("counter c by m\n/^(\\w+) / {\n  c[$1]++\n}\n")))
+
+	lines <- logline.New(context.Background(), "log", "GET /")
+	lines <- logline.New(context.Background(), "log", "nomatch")
+	close(lines)
+	wg.Wait()
+
+	got := trace.String()
+	for _, want := range []string{
+		`trace: input "GET /"`,
+		`capref     1  stack: ["GET"]`,
+		`mload     0  stack: ["GET" metric c]`,
+		`matched /^(\w+) /: ["GET " "GET"]`,
+		`trace: input "nomatch"`,
+		"no patterns matched",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("trace doesn't contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestLintTo(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	var findings bytes.Buffer
+	l, err := New(lines, &wg, "", store, LintTo(&findings))
+	testutil.FatalIfErr(t, err)
+
+	testutil.FatalIfErr(t, l.CompileAndRun("warn", strings.NewReader("counter c\ngauge g\n/x/ {\n  g = c\n}\n")))
+	if err := l.CompileAndRun("error", strings.NewReader("counter c\n/x^/ {\n  c++\n}\n")); err == nil {
+		t.Error("expected an error for a pattern that can never match")
+	}
+	if err := l.CompileAndRun("bad", strings.NewReader("counter c\n")); err == nil {
+		t.Error("expected the compile error")
+	}
+	got := findings.String()
+	for _, want := range []string{"warn:1:9: warning: Metric `c' is declared but never changed", "error:2:1-4: error: Pattern `x^'"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("findings don't contain %q:\n%s", want, got)
+		}
+	}
+	l.handleMu.RLock()
+	if len(l.handles) != 0 {
+		t.Errorf("linted programs were run: %v", l.handles)
+	}
+	l.handleMu.RUnlock()
+	close(lines)
+	wg.Wait()
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+	progPath := filepath.Join(tmpDir, "test.mtail")
+
+	f := testutil.TestOpenFile(t, progPath)
+	testutil.WriteString(t, f, testProgram)
+	testutil.FatalIfErr(t, f.Close())
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, tmpDir, store)
+	testutil.FatalIfErr(t, err)
+
+	l.handleMu.RLock()
+	oldVM := l.handles["test.mtail"].vm
+	l.handleMu.RUnlock()
+
+	// Break the program; the reload must keep the previous VM running.
+	f = testutil.TestOpenFile(t, progPath)
+	testutil.WriteString(t, f, "asdfasdf\n")
+	testutil.FatalIfErr(t, f.Close())
+
+	reloadsCheck := testutil.ExpectExpvarDeltaWithDeadline(t, "prog_reloads_total", 1)
+	testutil.FatalIfErr(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	reloadsCheck()
+
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		l.programErrorMu.RLock()
+		defer l.programErrorMu.RUnlock()
+		return l.programErrors["test.mtail"] != nil, nil
+	}, 10*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("broken program was not reloaded")
+	}
+	l.handleMu.RLock()
+	h, ok := l.handles["test.mtail"]
+	l.handleMu.RUnlock()
+	if !ok || h.vm != oldVM {
+		t.Errorf("previous VM not kept running after failed reload: %v", h)
+	}
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestReloadWithSyntaxErrorKeepsRunning(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+	progPath := filepath.Join(tmpDir, "test.mtail")
+	testutil.FatalIfErr(t, os.WriteFile(progPath, []byte("counter c\n/x/ {\n  c++\n}\n"), 0o600))
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	_, err := New(lines, &wg, tmpDir, store)
+	testutil.FatalIfErr(t, err)
+
+	testutil.FatalIfErr(t, os.WriteFile(progPath, []byte("counter c\n/x/ {\n"), 0o600))
+	loadErrorsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_load_errors_total", "test.mtail", 1)
+	testutil.FatalIfErr(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	loadErrorsCheck()
+
+	// The previous program still processes lines.
+	matchedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_lines_matched_total", "test.mtail", 1)
+	lines <- logline.New(context.Background(), "log", "x")
+	matchedCheck()
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestReloadWhenIncludeChanges(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+	incPath := filepath.Join(tmpDir, "common.inc")
+
+	testutil.FatalIfErr(t, os.WriteFile(incPath, []byte("const FOO /foo/\n"), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, "test.mtail"), []byte("include \"common.inc\"\ncounter c\nFOO {\n  c++\n}\n"), 0o600))
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, tmpDir, store)
+	testutil.FatalIfErr(t, err)
+
+	progLoadsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_loads_total", "test.mtail", 1)
+	// Unchanged sources are not recompiled.
+	testutil.FatalIfErr(t, l.LoadAllPrograms())
+	testutil.FatalIfErr(t, os.WriteFile(incPath, []byte("const FOO /bar/\n"), 0o600))
+	testutil.FatalIfErr(t, l.LoadAllPrograms())
+	progLoadsCheck()
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestLoadProgramsFromManifest(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+
+	for _, name := range []string{"a.mtail", "sub/b.mtail", "staged.mtail"} {
+		pathname := filepath.Join(tmpDir, name)
+		testutil.FatalIfErr(t, os.MkdirAll(filepath.Dir(pathname), 0o700))
+		testutil.FatalIfErr(t, os.WriteFile(pathname, []byte(testProgram), 0o600))
+	}
+	manifest := filepath.Join(tmpDir, "manifest")
+	testutil.FatalIfErr(t, os.WriteFile(manifest, []byte("# active programs\na.mtail\n\nsub/b.mtail\n"), 0o600))
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store, ProgramManifest(manifest))
+	testutil.FatalIfErr(t, err)
+
+	loaded := func() []string {
+		l.handleMu.RLock()
+		defer l.handleMu.RUnlock()
+		var names []string
+		for name := range l.handles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	testutil.ExpectNoDiff(t, []string{"a.mtail", "sub/b.mtail"}, loaded())
+
+	testutil.FatalIfErr(t, os.WriteFile(manifest, []byte("sub/b.mtail\nstaged.mtail\n"), 0o600))
+	testutil.FatalIfErr(t, l.LoadAllPrograms())
+	testutil.ExpectNoDiff(t, []string{"staged.mtail", "sub/b.mtail"}, loaded())
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestReloadOnManifestChange(t *testing.T) {
+	store := metrics.NewStore()
+	tmpDir := testutil.TestTempDir(t)
+
+	for _, name := range []string{"a.mtail", "b.mtail"} {
+		testutil.FatalIfErr(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(testProgram), 0o600))
+	}
+	manifest := filepath.Join(tmpDir, "manifest")
+	testutil.FatalIfErr(t, os.WriteFile(manifest, []byte("a.mtail\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, awaken := waker.NewTest(ctx, 1)
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store, ProgramManifest(manifest), ManifestPollWaker(w))
+	testutil.FatalIfErr(t, err)
+
+	loaded := func() []string {
+		l.handleMu.RLock()
+		defer l.handleMu.RUnlock()
+		var names []string
+		for name := range l.handles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	testutil.ExpectNoDiff(t, []string{"a.mtail"}, loaded())
+
+	testutil.FatalIfErr(t, os.WriteFile(manifest, []byte("a.mtail\nb.mtail\n"), 0o600))
+	awaken(1)
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		return len(loaded()) == 2, nil
+	}, 10*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Errorf("programs not reloaded after manifest change: %v", loaded())
+	}
+
+	close(lines)
+	wg.Wait()
+}
+
+func TestRuntimeClose(t *testing.T) {
+	testutil.TimeoutTest(5*time.Second, func(t *testing.T) { //nolint:thelper
+		store := metrics.NewStore()
+		tmpDir := testutil.TestTempDir(t)
+		progPath := filepath.Join(tmpDir, "test.mtail")
+		f := testutil.TestOpenFile(t, progPath)
+		testutil.WriteString(t, f, testProgram)
+		testutil.FatalIfErr(t, f.Close())
+
+		lines := make(chan *logline.LogLine)
+		var wg sync.WaitGroup
+		r, err := New(lines, &wg, tmpDir, store)
+		testutil.FatalIfErr(t, err)
+		r.Close()
+		r.Close()
+		// The programs still receive lines after Close.
+		lines <- logline.New(context.Background(), "test", "1")
+		close(lines)
+		wg.Wait()
+	})(t)
+}
+
+func TestProgramLoadErrorState(t *testing.T) {
+	store := metrics.NewStore()
+	dir := testutil.TestTempDir(t)
+	prog := filepath.Join(dir, "recovers.mtail")
+	testutil.FatalIfErr(t, os.WriteFile(prog, []byte("?\n"), 0o600))
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, dir, store)
+	testutil.FatalIfErr(t, err)
+
+	if v := ProgLastLoadError.Get("recovers.mtail"); v == nil || !strings.Contains(v.String(), "compile failed for recovers.mtail") {
+		t.Errorf("expected the load error, got %v", v)
+	}
+	if v := ProgLastLoadTime.Get("recovers.mtail"); v != nil {
+		t.Errorf("expected no load time for a program that never loaded, got %v", v)
+	}
+	if l.progzRows()[0].Failed.IsZero() {
+		t.Error("expected the time of the failure on /progz")
+	}
+
+	before := time.Now().Unix()
+	testutil.FatalIfErr(t, os.WriteFile(prog, []byte(testProgram), 0o600))
+	_, err = l.LoadPrograms()
+	testutil.FatalIfErr(t, err)
+	if v := ProgLastLoadError.Get("recovers.mtail"); v != nil {
+		t.Errorf("expected the error to be cleared, got %v", v)
+	}
+	v, ok := ProgLastLoadTime.Get("recovers.mtail").(*expvar.Int)
+	if !ok || v.Value() < before {
+		t.Errorf("expected a load time after %d, got %v", before, v)
+	}
+	if !l.progzRows()[0].Failed.IsZero() {
+		t.Error("expected the failure to be cleared on /progz")
+	}
+	close(lines)
+	wg.Wait()
+}
+
+func TestRemovedProgramLoadErrorCleared(t *testing.T) {
+	store := metrics.NewStore()
+	dir := testutil.TestTempDir(t)
+	prog := filepath.Join(dir, "removed.mtail")
+	testutil.FatalIfErr(t, os.WriteFile(prog, []byte("?\n"), 0o600))
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, dir, store)
+	testutil.FatalIfErr(t, err)
+	if v := ProgLastLoadError.Get("removed.mtail"); v == nil {
+		t.Error("expected the load error")
+	}
+
+	testutil.FatalIfErr(t, os.Remove(prog))
+	_, err = l.LoadPrograms()
+	testutil.FatalIfErr(t, err)
+	if v := ProgLastLoadError.Get("removed.mtail"); v != nil {
+		t.Errorf("expected the error of the removed program to be cleared, got %v", v)
+	}
+	if rows := l.progzRows(); len(rows) != 0 {
+		t.Errorf("expected no programs on /progz, got %v", rows)
+	}
+	close(lines)
+	wg.Wait()
+}
+
+func TestProgzHandler(t *testing.T) {
+	store := metrics.NewStore()
+	dir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "progz_ok.mtail"), []byte(testProgram), 0o600))
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "progz_bad.mtail"), []byte("?\n"), 0o600))
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, dir, store)
+	testutil.FatalIfErr(t, err)
+
+	w := httptest.NewRecorder()
+	l.ProgzHandler(w, httptest.NewRequest("GET", "/progz", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`<a href="?prog=progz_ok.mtail">progz_ok.mtail</a>`,
+		"No compile errors",
+		"<td>progz_bad.mtail</td>\n<td>not loaded</td>",
+		"compile failed for progz_bad.mtail",
+		"<th>compile time</th>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("progz page doesn't contain %q:\n%s", want, body)
+		}
+	}
+
+	for _, name := range []string{"progz_ok.mtail", "progz_bad.mtail"} {
+		if d, ok := ProgCompileDuration.Get(name).(*expvar.Float); !ok || d.Value() <= 0 {
+			t.Errorf("no compile duration for %s: %v", name, ProgCompileDuration.Get(name))
+		}
+	}
+
+	w = httptest.NewRecorder()
+	l.ProgzHandler(w, httptest.NewRequest("GET", "/progz?prog=progz_bad.mtail", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("code for program that failed to load: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestProgzRowsCompileAndRun(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, l.CompileAndRun("progz_direct", strings.NewReader(testProgram)))
+
+	rows := l.progzRows()
+	if len(rows) != 1 || rows[0].Name != "progz_direct" || rows[0].Loaded.IsZero() {
+		t.Errorf("expected a loaded row for progz_direct, got %v", rows)
+	}
+	close(lines)
+	wg.Wait()
+}
+
+func TestReloadHandler(t *testing.T) {
+	store := metrics.NewStore()
+	dir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "reload_ok.mtail"), []byte(testProgram), 0o600))
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, dir, store)
+	testutil.FatalIfErr(t, err)
+
+	w := httptest.NewRecorder()
+	l.ReloadHandler(w, httptest.NewRequest("GET", "/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("code for GET: got %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "reload_bad.mtail"), []byte("?\n"), 0o600))
+	w = httptest.NewRecorder()
+	l.ReloadHandler(w, httptest.NewRequest("POST", "/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code for POST: got %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var got struct {
+		Programs []reloadResult `json:"programs"`
+	}
+	testutil.FatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &got))
+	sort.Slice(got.Programs, func(i, j int) bool { return got.Programs[i].Name < got.Programs[j].Name })
+	if len(got.Programs) != 2 {
+		t.Fatalf("expected 2 programs, got %+v", got.Programs)
+	}
+	if got.Programs[0].Name != "reload_bad.mtail" || got.Programs[0].Success || len(got.Programs[0].Errors) == 0 {
+		t.Errorf("expected reload_bad.mtail to fail with errors, got %+v", got.Programs[0])
+	}
+	testutil.ExpectNoDiff(t, reloadResult{Name: "reload_ok.mtail", Success: true}, got.Programs[1])
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+// Package strftime parses timestamps with C-style strftime formats.
+//
+// Go time layouts have no way to quote literal text, so a format isn't
+// converted to one layout.  Instead the literal text of the format is matched
+// and removed from a timestamp, and only the text of the conversions is
+// parsed, with a layout made of the equivalent Go layout elements.
+package strftime
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// conversion is the Go layout element equivalent to a strftime conversion
+// specification, and a regular expression matching the text it parses.
+type conversion struct {
+	layout string
+	re     string
+}
+
+const (
+	name = `[A-Za-z]+`
+	num2 = `\d{2}`
+	hour = `\d{1,2}`
+	day  = `[ \d]?\d`
+)
+
+// conversions maps strftime conversion specifications to their Go layout.
+var conversions = map[byte]conversion{
+	'a': {"Mon", name},
+	'A': {"Monday", name},
+	'b': {"Jan", name},
+	'B': {"January", name},
+	'd': {"02", num2},
+	'D': {"01/02/06", `\d{2}/\d{2}/\d{2}`},
+	'e': {"_2", day},
+	'F': {"2006-01-02", `\d{4}-\d{2}-\d{2}`},
+	'h': {"Jan", name},
+	'H': {"15", hour},
+	'I': {"03", num2},
+	'j': {"002", `\d{3}`},
+	'm': {"01", num2},
+	'M': {"04", num2},
+	'p': {"PM", `[AaPp][Mm]`},
+	'S': {"05", num2},
+	'T': {"15:04:05", hour + `:\d{2}:\d{2}`},
+	'y': {"06", num2},
+	'Y': {"2006", `\d{4}`},
+	'z': {"Z0700", `Z|[+-]\d{4}`},
+	'Z': {"MST", `[A-Za-z]+(?:[+-]\d+)?|[+-]\d+`},
+}
+
+// sep separates the text of each conversion given to time.Parse.  It can't
+// appear in the text of any conversion, and isn't a layout element.
+const sep = "\x00"
+
+// Format is a strftime format compiled for parsing timestamps.
+type Format struct {
+	format string
+	re     *regexp.Regexp // Matches a timestamp, capturing the text of each conversion.
+	layout string         // Go layout of the conversions, separated by sep.
+}
+
+// IsFormat returns true if s is a strftime format rather than a Go layout.
+// Go layouts have no use for a '%'.
+func IsFormat(s string) bool {
+	return strings.ContainsRune(s, '%')
+}
+
+// Compile compiles the strftime format for parsing.  It returns an error if
+// the format has a conversion that isn't supported.
+func Compile(format string) (*Format, error) {
+	var re strings.Builder
+	var layouts []string
+	re.WriteString("^")
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			j := strings.IndexByte(format[i:], '%')
+			if j < 0 {
+				j = len(format) - i
+			}
+			re.WriteString(regexp.QuoteMeta(format[i : i+j]))
+			i += j - 1
+			continue
+		}
+		i++
+		if i == len(format) {
+			return nil, errors.Errorf("strftime format %q ends with a lone %%", format)
+		}
+		if format[i] == '%' {
+			re.WriteString("%")
+			continue
+		}
+		c, ok := conversions[format[i]]
+		if !ok {
+			return nil, errors.Errorf("unsupported conversion %%%c in strftime format %q", format[i], format)
+		}
+		re.WriteString("(" + c.re + ")")
+		layouts = append(layouts, c.layout)
+	}
+	re.WriteString("$")
+	return &Format{format, regexp.MustCompile(re.String()), strings.Join(layouts, sep)}, nil
+}
+
+// Layout returns the Go layout and value to give to time.Parse to parse the
+// timestamp value, which has the literal text of the format removed.
+func (f *Format) Layout(value string) (layout string, goValue string, err error) {
+	m := f.re.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", errors.Errorf("timestamp %q does not match strftime format %q", value, f.format)
+	}
+	return f.layout, strings.Join(m[1:], sep), nil
+}
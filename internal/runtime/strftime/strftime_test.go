@@ -0,0 +1,59 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package strftime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+var parseTests = []struct {
+	format, value string
+	want          time.Time
+}{
+	{"%Y-%m-%d %H:%M:%S", "2017-10-16 09:03:07", time.Date(2017, 10, 16, 9, 3, 7, 0, time.UTC)},
+	{"%d/%b/%Y:%T %z", "16/Oct/2017:09:03:07 +0000", time.Date(2017, 10, 16, 9, 3, 7, 0, time.UTC)},
+	{"%b %e %H:%M:%S", "Oct  6 09:03:07", time.Date(0, 10, 6, 9, 3, 7, 0, time.UTC)},
+	{"%Y%m%d%H%M", "201710160903", time.Date(2017, 10, 16, 9, 3, 0, 0, time.UTC)},
+	{"%F at %I:%M %p", "2017-10-16 at 09:03 PM", time.Date(2017, 10, 16, 21, 3, 0, 0, time.UTC)},
+	{"100%% %F", "100% 2017-10-16", time.Date(2017, 10, 16, 0, 0, 0, 0, time.UTC)},
+	// Literal text that is also Go layout elements is matched as it is.
+	{"Mon Jan 2 01 %Y-%m-%d", "Mon Jan 2 01 2017-10-16", time.Date(2017, 10, 16, 0, 0, 0, 0, time.UTC)},
+	{"day %j of %Y, PM", "day 289 of 2017, PM", time.Date(2017, 10, 16, 0, 0, 0, 0, time.UTC)},
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range parseTests {
+		tc := tc
+		t.Run(tc.format, func(t *testing.T) {
+			f, err := Compile(tc.format)
+			testutil.FatalIfErr(t, err)
+			layout, value, err := f.Layout(tc.value)
+			testutil.FatalIfErr(t, err)
+			got, err := time.Parse(layout, value)
+			testutil.FatalIfErr(t, err)
+			if !got.Equal(tc.want) {
+				t.Errorf("parsing %q with %q: got %s, want %s", tc.value, tc.format, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLayoutMismatch(t *testing.T) {
+	f, err := Compile("Jan %Y")
+	testutil.FatalIfErr(t, err)
+	if _, _, err := f.Layout("Feb 2017"); err == nil {
+		t.Error("expected an error for a timestamp without the literal text")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, format := range []string{"%Y-%m-%Q", "%Y %"} {
+		if _, err := Compile(format); err == nil {
+			t.Errorf("Compile(%q): expected error", format)
+		}
+	}
+}
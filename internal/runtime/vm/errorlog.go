@@ -0,0 +1,41 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"time"
+)
+
+// runtimeErrorLogInterval is the least time between the runtime errors logged
+// for each source line of a program, so that a line of a program that fails
+// on every log line doesn't flood the log.
+const runtimeErrorLogInterval = time.Minute
+
+// errorLogLimiter decides which runtime errors are logged, by source line.
+type errorLogLimiter map[int]*errorLog
+
+type errorLog struct {
+	last       time.Time // when the last error at the line was logged
+	suppressed int       // the errors at the line not logged since then
+}
+
+// allow reports whether an error at line should be logged at now, with the
+// number of errors at the line not logged since the last that was.
+func (l *errorLogLimiter) allow(line int, now time.Time) (int, bool) {
+	if *l == nil {
+		*l = make(errorLogLimiter)
+	}
+	e, ok := (*l)[line]
+	if !ok {
+		(*l)[line] = &errorLog{last: now}
+		return 0, true
+	}
+	if now.Sub(e.last) < runtimeErrorLogInterval {
+		e.suppressed++
+		return 0, false
+	}
+	suppressed := e.suppressed
+	e.last, e.suppressed = now, 0
+	return suppressed, true
+}
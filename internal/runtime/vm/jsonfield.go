@@ -0,0 +1,75 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// jsonDoc is a string parsed as JSON, kept so that a line is only parsed once
+// however many of its fields a program extracts.
+type jsonDoc struct {
+	value interface{}
+	err   error
+}
+
+// jsonField returns the field of the JSON document text at the dotted path,
+// where each element of the path is a key of an object, or the index of an
+// array counting from zero.  String fields are returned as they are, and
+// other fields as their JSON encoding.  A null field is empty.  It returns
+// false if text is not JSON or has no field at path.
+func (v *VM) jsonField(text, path string) (string, bool) {
+	doc, ok := v.json[text]
+	if !ok {
+		d := json.NewDecoder(strings.NewReader(text))
+		d.UseNumber()
+		doc = &jsonDoc{}
+		doc.err = d.Decode(&doc.value)
+		if v.json == nil {
+			v.json = make(map[string]*jsonDoc)
+		}
+		v.json[text] = doc
+	}
+	if doc.err != nil {
+		return "", false
+	}
+	field := doc.value
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch f := field.(type) {
+			case map[string]interface{}:
+				var ok bool
+				if field, ok = f[key]; !ok {
+					return "", false
+				}
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(f) {
+					return "", false
+				}
+				field = f[i]
+			default:
+				return "", false
+			}
+		}
+	}
+	switch f := field.(type) {
+	case nil:
+		return "", true
+	case string:
+		return f, true
+	case json.Number:
+		return f.String(), true
+	}
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(field); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(b.String(), "\n"), true
+}
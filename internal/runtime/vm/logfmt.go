@@ -0,0 +1,83 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// logfmtDoc is a string parsed as logfmt, kept so that a line is only parsed
+// once however many of its fields a program extracts.
+type logfmtDoc struct {
+	fields map[string]string
+}
+
+// parseLogfmt returns the fields of the logfmt record in text, which is a
+// sequence of key=value pairs separated by spaces.  A value containing spaces
+// is quoted in double quotes, with Go string escapes.  A key without a value
+// is empty, and of keys repeated the first wins.
+func parseLogfmt(text string) map[string]string {
+	fields := make(map[string]string)
+	s := text
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return fields
+		}
+		end := strings.IndexAny(s, "= \t")
+		if end < 0 {
+			end = len(s)
+		}
+		key := s[:end]
+		s = s[end:]
+		var value string
+		if strings.HasPrefix(s, "=") {
+			s = s[1:]
+			value, s = logfmtValue(s)
+		}
+		if _, ok := fields[key]; !ok && key != "" {
+			fields[key] = value
+		}
+	}
+}
+
+// logfmtValue returns the value at the start of s, and the rest of s.
+func logfmtValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			return s, ""
+		}
+		return s[:end], s[end:]
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			if value, err := strconv.Unquote(s[:i+1]); err == nil {
+				return value, s[i+1:]
+			}
+			return s[1:i], s[i+1:]
+		}
+	}
+	// An unterminated quote runs to the end of the line.
+	return s[1:], ""
+}
+
+// logfmtField returns the value of key in the logfmt record text.  It returns
+// false if text has no field named key.
+func (v *VM) logfmtField(text, key string) (string, bool) {
+	doc, ok := v.logfmt[text]
+	if !ok {
+		doc = &logfmtDoc{fields: parseLogfmt(text)}
+		if v.logfmt == nil {
+			v.logfmt = make(map[string]*logfmtDoc)
+		}
+		v.logfmt[text] = doc
+	}
+	value, ok := doc.fields[key]
+	return value, ok
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"time"
+)
+
+// zoneAbbreviations gives the UTC offset in seconds of common timezone
+// abbreviations.  time.Parse only knows the abbreviations of the location it
+// parses in, and gives any other abbreviation an offset of zero.
+// Abbreviations with no widely agreed meaning, like IST, are left out; the
+// North American meaning is used for CST.
+var zoneAbbreviations = map[string]int{
+	"HST":  -10 * 3600,
+	"AKST": -9 * 3600,
+	"AKDT": -8 * 3600,
+	"PST":  -8 * 3600,
+	"PDT":  -7 * 3600,
+	"MST":  -7 * 3600,
+	"MDT":  -6 * 3600,
+	"CST":  -6 * 3600,
+	"CDT":  -5 * 3600,
+	"EST":  -5 * 3600,
+	"EDT":  -4 * 3600,
+	"NST":  -(3*3600 + 1800),
+	"NDT":  -(2*3600 + 1800),
+	"WEST": 1 * 3600,
+	"BST":  1 * 3600,
+	"CET":  1 * 3600,
+	"CEST": 2 * 3600,
+	"EET":  2 * 3600,
+	"EEST": 3 * 3600,
+	"MSK":  3 * 3600,
+	"HKT":  8 * 3600,
+	"SGT":  8 * 3600,
+	"AWST": 8 * 3600,
+	"JST":  9 * 3600,
+	"KST":  9 * 3600,
+	"ACST": 9*3600 + 1800,
+	"ACDT": 10*3600 + 1800,
+	"AEST": 10 * 3600,
+	"AEDT": 11 * 3600,
+	"NZST": 12 * 3600,
+	"NZDT": 13 * 3600,
+}
+
+// fixZoneAbbreviation returns tm in its named zone, if time.Parse couldn't
+// find the offset of the zone's abbreviation and took it to be UTC.
+func fixZoneAbbreviation(tm time.Time) time.Time {
+	name, offset := tm.Zone()
+	if offset != 0 {
+		return tm
+	}
+	offset, ok := zoneAbbreviations[name]
+	if !ok {
+		return tm
+	}
+	year, month, day := tm.Date()
+	hour, min, sec := tm.Clock()
+	return time.Date(year, month, day, hour, min, sec, tm.Nanosecond(), time.FixedZone(name, offset))
+}
@@ -10,9 +10,11 @@ import (
 	"context"
 	"expvar"
 	"fmt"
+	"io"
 	"math"
 	"regexp"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,12 +27,32 @@ import (
 	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/metrics/datum"
 	"github.com/google/mtail/internal/runtime/code"
+	"github.com/google/mtail/internal/runtime/compiler/types"
+	"github.com/google/mtail/internal/runtime/strftime"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
 	ProgRuntimeErrors = expvar.NewMap("prog_runtime_errors_total")
+	// TimeParseErrors counts the strptime calls that failed to parse, by program.
+	TimeParseErrors = expvar.NewMap("prog_time_parse_errors_total")
+	// ProgLines counts the lines processed by each program.
+	ProgLines = expvar.NewMap("prog_lines_total")
+	// ProgLinesMatched counts the lines that matched at least one pattern in each program.
+	ProgLinesMatched = expvar.NewMap("prog_lines_matched_total")
+	// ProgLinesUnmatched counts the lines that matched no pattern in each program.
+	ProgLinesUnmatched = expvar.NewMap("prog_lines_unmatched_total")
+	// ProgLineProcessingTime accumulates the wall clock time each program spends
+	// processing lines.  Dividing by ProgLines gives the average cost per line.
+	ProgLineProcessingTime = expvar.NewMap("prog_line_processing_seconds_total")
+	// JSONFieldMisses counts the json_field calls that found no field, by program.
+	JSONFieldMisses = expvar.NewMap("prog_json_field_misses_total")
+	// LogfmtFieldMisses counts the logfmt_field calls that found no field, by program.
+	LogfmtFieldMisses = expvar.NewMap("prog_logfmt_field_misses_total")
+	// Timeouts counts the lines abandoned by each program because processing
+	// them took longer than the execution timeout.
+	Timeouts = expvar.NewMap("vm_timeouts_total")
 
 	LineProcessingDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "mtail",
@@ -57,15 +79,21 @@ type VM struct {
 	name string
 	prog []code.Instr
 
-	re      []*regexp.Regexp  // Regular expression constants
-	str     []string          // String constants
-	Metrics []*metrics.Metric // Metrics accessible to this program.
+	re       []*regexp.Regexp  // Regular expression constants
+	literals []string          // A substring required by each regular expression constant, if not empty
+	str      []string          // String constants
+	Metrics  []*metrics.Metric // Metrics accessible to this program.
 
-	timeMemos *lru.Cache // memo of time string parse results
+	timeMemos *lru.Cache                  // memo of time string parse results
+	strftimes map[string]*strftime.Format // strftime formats given to strptime, compiled
 
 	t *thread // Current thread of execution
 
-	input *logline.LogLine // Log line input to this round of execution.
+	input  *logline.LogLine      // Log line input to this round of execution.
+	json   map[string]*jsonDoc   // The strings parsed by json_field on this line.
+	logfmt map[string]*logfmtDoc // The strings parsed by logfmt_field on this line.
+
+	loaded map[datum.Datum]*metrics.LabelValue // The LabelValue of each datum loaded on this line, to Touch when the datum is updated.
 
 	terminate bool // Flag to stop the VM on this line of input.
 
@@ -74,10 +102,21 @@ type VM struct {
 	runtimeErrorMu sync.RWMutex // protects runtimeError
 	runtimeError   string       // records the last runtime error from errorf()
 
+	runtimeErrorLogs errorLogLimiter // limits the rate runtime errors are logged at, by source line
+
 	logRuntimeErrors     bool           // Emit runtime errors to the log.
 	syslogUseCurrentYear bool           // Overwrite zero years with the current year in a strptime.
 	loc                  *time.Location // Override local timezone with provided, if not empty.
 	trace                []int          // Record program counter in program execution, for testing.
+	traceOut             io.Writer      // Write each executed instruction and the stack to this, if not nil.
+
+	nomatchWarnAfter int64        // Warn about patterns that haven't matched after this many lines, if positive.
+	linesProcessed   int64        // Lines processed, counted only while nomatchWarnAfter is positive.
+	patternMatched   map[int]bool // Whether each pattern tried against lines has matched.
+
+	workers chan struct{} // If not nil, a token is held in this while processing each line.
+
+	timeout time.Duration // Abandon a line that takes longer than this to process, if positive.
 }
 
 // Push a value onto the stack.
@@ -98,15 +137,22 @@ func (v *VM) errorf(format string, args ...interface{}) {
 	i := v.prog[v.t.pc-1]
 	ProgRuntimeErrors.Add(v.name, 1)
 	v.runtimeErrorMu.Lock()
-	v.runtimeError = fmt.Sprintf(format+"\n", args...)
+	v.runtimeError = fmt.Sprintf("%s:%d: "+format+"\n", append([]interface{}{v.name, i.SourceLine + 1}, args...)...)
 	v.runtimeError += fmt.Sprintf(
-		"Error occurred at instruction %d {%s, %v}, originating in %s at line %d\n",
-		v.t.pc-1, i.Opcode, i.Operand, v.name, i.SourceLine+1)
+		"Error occurred at instruction %d {%s, %v}\n",
+		v.t.pc-1, i.Opcode, i.Operand)
 	v.runtimeError += fmt.Sprintf("Full input text from %q was %q", v.input.Filename, v.input.Line)
-	if v.logRuntimeErrors || bool(glog.V(1)) {
-		glog.Info(v.name + ": Runtime error: " + v.runtimeError)
-
-		glog.Infof("Set logging verbosity higher (-v1 or more) to see full VM state dump.")
+	if glog.V(1) {
+		glog.Info("Runtime error: " + v.runtimeError)
+	} else if v.logRuntimeErrors {
+		if suppressed, ok := v.runtimeErrorLogs.allow(i.SourceLine, time.Now()); ok {
+			msg := "Runtime error: " + v.runtimeError
+			if suppressed > 0 {
+				msg += fmt.Sprintf("\n%d more runtime errors at this line were not logged", suppressed)
+			}
+			glog.Info(msg)
+			glog.Infof("Set logging verbosity higher (-v1 or more) to see full VM state dump.")
+		}
 	}
 	if glog.V(1) {
 		glog.Infof("VM stack:\n%s", debug.Stack())
@@ -128,6 +174,16 @@ func (v *VM) errorf(format string, args ...interface{}) {
 	v.terminate = true
 }
 
+// anyMatched returns true if any pattern matched in this thread.
+func (t *thread) anyMatched() bool {
+	for _, m := range t.matches {
+		if m != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *thread) PopInt() (int64, error) {
 	val := t.Pop()
 	switch n := val.(type) {
@@ -306,18 +362,19 @@ func compare(a, b interface{}, opnd int) (bool, error) {
 	return false, errors.Errorf("cannot compare %T %q with %T %q", a, a, b, b)
 }
 
-// ParseTime performs location and syslog-year aware timestamp parsing.
-func (v *VM) ParseTime(layout, value string) (tm time.Time) {
-	var err error
+// ParseTime performs location and syslog-year aware timestamp parsing.  A
+// zone offset or abbreviation in the value takes precedence over the
+// location.
+func (v *VM) ParseTime(layout, value string) (tm time.Time, err error) {
 	if v.loc != nil {
 		tm, err = time.ParseInLocation(layout, value, v.loc)
 	} else {
 		tm, err = time.Parse(layout, value)
 	}
 	if err != nil {
-		v.errorf("strptime (%v, %v, %v) failed: %s", layout, value, v.loc, err)
 		return
 	}
+	tm = fixZoneAbbreviation(tm)
 	// Hack for yearless syslog.
 	if tm.Year() == 0 && v.syslogUseCurrentYear {
 		// No .UTC() as we use local time to match the local log.
@@ -331,6 +388,29 @@ func (v *VM) ParseTime(layout, value string) (tm time.Time) {
 	return
 }
 
+// parseStrptime parses the timestamp value with layout as ParseTime does,
+// except that layout may also be a strftime format.
+func (v *VM) parseStrptime(layout, value string) (time.Time, error) {
+	if strftime.IsFormat(layout) {
+		f, ok := v.strftimes[layout]
+		if !ok {
+			var err error
+			if f, err = strftime.Compile(layout); err != nil {
+				return time.Time{}, err
+			}
+			if v.strftimes == nil {
+				v.strftimes = make(map[string]*strftime.Format)
+			}
+			v.strftimes[layout] = f
+		}
+		var err error
+		if layout, value, err = f.Layout(value); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return v.ParseTime(layout, value)
+}
+
 // execute performs an instruction cycle in the VM. acting on the instruction
 // i in thread t.
 func (v *VM) execute(t *thread, i code.Instr) {
@@ -358,7 +438,7 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		// Store the results in the operandth element of the stack,
 		// where i.opnd == the matched re index
 		index := i.Operand.(int)
-		t.matches[index] = v.re[index].FindStringSubmatch(v.input.Line)
+		t.matches[index] = v.findStringSubmatch(index, v.input.Line)
 		t.Push(t.matches[index] != nil)
 
 	case code.Smatch:
@@ -369,7 +449,7 @@ func (v *VM) execute(t *thread, i code.Instr) {
 			v.errorf("+%v", err)
 			return
 		}
-		t.matches[index] = v.re[index].FindStringSubmatch(line)
+		t.matches[index] = v.findStringSubmatch(index, line)
 		t.Push(t.matches[index] != nil)
 
 	case code.Cmp:
@@ -484,7 +564,12 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		if n, ok := t.Pop().(datum.Datum); ok {
 			datum.IncIntBy(n, delta, t.time)
-			t.Push(datum.GetInt(n))
+			v.touch(n)
+			if f, ok := n.(*datum.Float); ok {
+				t.Push(f.Get())
+			} else {
+				t.Push(datum.GetInt(n))
+			}
 		} else {
 			v.errorf("Unexpected type to increment: %T %q", n, n)
 			return
@@ -504,7 +589,12 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		if n, ok := t.Pop().(datum.Datum); ok {
 			datum.DecIntBy(n, delta, t.time)
-			t.Push(datum.GetInt(n))
+			v.touch(n)
+			if f, ok := n.(*datum.Float); ok {
+				t.Push(f.Get())
+			} else {
+				t.Push(datum.GetInt(n))
+			}
 		} else {
 			v.errorf("Unexpected type to increment: %T %q", n, n)
 			return
@@ -519,6 +609,7 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		if n, ok := t.Pop().(datum.Datum); ok {
 			datum.SetInt(n, value, t.time)
+			v.touch(n)
 		} else {
 			v.errorf("Unexpected type to iset: %T %q", n, n)
 			return
@@ -533,6 +624,7 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		if n, ok := t.Pop().(datum.Datum); ok {
 			datum.SetFloat(n, value, t.time)
+			v.touch(n)
 		} else {
 			v.errorf("Unexpected type to fset: %T %q", n, n)
 			return
@@ -547,6 +639,7 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		if n, ok := t.Pop().(datum.Datum); ok {
 			datum.SetString(n, value, t.time)
+			v.touch(n)
 		} else {
 			v.errorf("Unexpected type to sset: %T %q", n, n)
 			return
@@ -580,9 +673,18 @@ func (v *VM) execute(t *thread, i code.Instr) {
 			// Store the result from the re'th index at the s'th index
 			ts = t.matches[re][s]
 		}
-		if cached, ok := v.timeMemos.Get(ts); !ok {
-			tm := v.ParseTime(layout, ts)
-			v.timeMemos.Add(ts, tm)
+		key := layout + "\x00" + ts
+		if cached, ok := v.timeMemos.Get(key); !ok {
+			tm, err := v.parseStrptime(layout, ts)
+			if err != nil {
+				// Keep processing the line, but without a timestamp override.
+				TimeParseErrors.Add(v.name, 1)
+				if v.logRuntimeErrors || bool(glog.V(1)) {
+					glog.Infof("%s: strptime(%q, %q) failed: %s", v.name, ts, layout, err)
+				}
+				return
+			}
+			v.timeMemos.Add(key, tm)
 			t.time = tm
 		} else {
 			t.time = cached.(time.Time)
@@ -752,13 +854,17 @@ func (v *VM) execute(t *thread, i code.Instr) {
 			// fmt.Printf("Keys: %v\n", keys)
 		}
 		// fmt.Printf("Keys: %v\n", keys)
-		d, err := m.GetDatum(keys...)
+		lv, err := m.GetLabelValue(keys...)
 		if err != nil {
-			v.errorf("dload (GetDatum) failed: %s", err)
+			v.errorf("dload (GetLabelValue) failed: %s", err)
 			return
 		}
-		// fmt.Printf("Found %v\n", d)
-		t.Push(d)
+		// fmt.Printf("Found %v\n", lv)
+		if v.loaded == nil {
+			v.loaded = make(map[datum.Datum]*metrics.LabelValue)
+		}
+		v.loaded[lv.Value] = lv
+		t.Push(lv.Value)
 
 	case code.Iget, code.Fget, code.Sget:
 		d, ok := t.Pop().(datum.Datum)
@@ -778,6 +884,11 @@ func (v *VM) execute(t *thread, i code.Instr) {
 	case code.Del:
 		m := t.Pop().(*metrics.Metric)
 		index := i.Operand.(int)
+		if index == 0 {
+			// No keys given; remove the whole metric.
+			m.RemoveAllDatums()
+			return
+		}
 		keys := make([]string, index)
 		for j := index - 1; j >= 0; j-- {
 			s, err := t.PopString()
@@ -820,6 +931,15 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		t.Push(strings.ToLower(s))
 
+	case code.Toupper:
+		// Uppercase a string from TOS, and push result back.
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(strings.ToUpper(s))
+
 	case code.Length:
 		// Compute the length of a string from TOS, and push result back.
 		s, err := t.PopString()
@@ -904,6 +1024,16 @@ func (v *VM) execute(t *thread, i code.Instr) {
 	case code.Getfilename:
 		t.Push(v.input.Filename)
 
+	case code.Getfield:
+		// A field this line's source doesn't provide is empty, which would
+		// fail to convert to an integer, so integer fields read as 0 instead.
+		name := i.Operand.(string)
+		f := v.input.Field(name)
+		if f == "" && types.Equals(types.Fields[name], types.Int) {
+			f = "0"
+		}
+		t.Push(f)
+
 	case code.Cat:
 		b, berr := t.PopString()
 		if berr != nil {
@@ -952,48 +1082,327 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		t.Push(v.re[pat].ReplaceAllLiteralString(val, repl))
 
+	case code.Jsonfield:
+		// A missing field is empty rather than an error, so that dimensions
+		// of lines without it are still counted.
+		path, perr := t.PopString()
+		if perr != nil {
+			v.errorf("%+v", perr)
+			return
+		}
+		text, terr := t.PopString()
+		if terr != nil {
+			v.errorf("%+v", terr)
+			return
+		}
+		field, ok := v.jsonField(text, path)
+		if !ok {
+			JSONFieldMisses.Add(v.name, 1)
+		}
+		t.Push(field)
+
+	case code.Logfmtfield:
+		key, kerr := t.PopString()
+		if kerr != nil {
+			v.errorf("%+v", kerr)
+			return
+		}
+		text, terr := t.PopString()
+		if terr != nil {
+			v.errorf("%+v", terr)
+			return
+		}
+		field, ok := v.logfmtField(text, key)
+		if !ok {
+			LogfmtFieldMisses.Add(v.name, 1)
+		}
+		t.Push(field)
+
+	case code.Substr:
+		// Indices out of range of the string are clamped to it, so the
+		// result is empty rather than an error.
+		length, lerr := t.PopInt()
+		if lerr != nil {
+			v.errorf("%+v", lerr)
+			return
+		}
+		start, serr := t.PopInt()
+		if serr != nil {
+			v.errorf("%+v", serr)
+			return
+		}
+		val, verr := t.PopString()
+		if verr != nil {
+			v.errorf("%+v", verr)
+			return
+		}
+		n := int64(len(val))
+		if start < 0 {
+			start = 0
+		}
+		if start > n {
+			start = n
+		}
+		end := n
+		if length < n-start {
+			end = start + length
+		}
+		if end < start {
+			end = start
+		}
+		t.Push(val[start:end])
+
 	default:
 		v.errorf("illegal instruction: %d", i.Opcode)
 	}
 }
 
+// touch records the wall-clock time that the datum n, loaded on this line,
+// was updated, so that it can be told apart from a series only updated by
+// lines with an old timestamp.
+func (v *VM) touch(n datum.Datum) {
+	if lv, ok := v.loaded[n]; ok {
+		lv.Touch(time.Now())
+	}
+}
+
 // ProcessLogLine handles the incoming lines by running a fetch-execute cycle
 // on the VM bytecode with the line as input to the program, until termination.
+// The line is abandoned if ctx is done, or processing it takes longer than
+// the VM's timeout, before the program terminates.
 func (v *VM) ProcessLogLine(ctx context.Context, line *logline.LogLine) {
 	start := time.Now()
+	t := new(thread)
+	var tb *bytes.Buffer
+	if v.traceOut != nil {
+		tb = new(bytes.Buffer)
+		fmt.Fprintf(tb, "%s: input %q\n", v.name, line.Line)
+	}
 	defer func() {
-		LineProcessingDurations.WithLabelValues(v.name).Observe(time.Since(start).Seconds())
+		if tb != nil {
+			v.writeTrace(tb, t)
+		}
+		elapsed := time.Since(start).Seconds()
+		LineProcessingDurations.WithLabelValues(v.name).Observe(elapsed)
+		ProgLineProcessingTime.AddFloat(v.name, elapsed)
+		ProgLines.Add(v.name, 1)
+		if v.nomatchWarnAfter > 0 {
+			v.checkPatternsMatch(t)
+		}
+		if t.anyMatched() {
+			ProgLinesMatched.Add(v.name, 1)
+		} else {
+			ProgLinesUnmatched.Add(v.name, 1)
+		}
 	}()
-	t := new(thread)
 	t.matched = false
 	v.t = t
 	v.input = line
+	for text := range v.json {
+		delete(v.json, text)
+	}
+	for text := range v.logfmt {
+		delete(v.logfmt, text)
+	}
+	for d := range v.loaded {
+		delete(v.loaded, d)
+	}
 	t.stack = make([]interface{}, 0)
 	t.matches = make(map[int][]string, len(v.re))
+	if v.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.timeout)
+		defer cancel()
+	}
+	done := ctx.Done()
 	for {
 		if t.pc >= len(v.prog) {
 			return
 		}
+		// Checked before every instruction, so before every match; a single
+		// match can't be interrupted, but takes time linear in the length of
+		// the line.
+		if done != nil {
+			select {
+			case <-done:
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					Timeouts.Add(v.name, 1)
+					glog.V(1).Infof("%s: abandoned line after %s at instruction %d: %q", v.name, time.Since(start), t.pc, line.Line)
+				}
+				if tb != nil {
+					fmt.Fprintf(tb, "  abandoned: %s\n", ctx.Err())
+				}
+				return
+			default:
+			}
+		}
 		if v.trace != nil {
 			v.trace = append(v.trace, t.pc)
 		}
+		pc := t.pc
 		i := v.prog[t.pc]
 		t.pc++
 		v.execute(t, i)
+		if tb != nil {
+			fmt.Fprintf(tb, "  %4d %12s %5v  stack: %s\n", pc, i.Opcode, i.Operand, traceStack(t.stack))
+		}
 		if v.terminate {
 			// Terminate only stops this invocation on this line of input; reset the terminate flag.
 			v.terminate = false
+			if tb != nil {
+				fmt.Fprintf(tb, "  terminated: %s\n", strings.SplitN(v.RuntimeErrorString(), "\n", 2)[0])
+			}
 			return
 		}
 	}
 }
 
+// findStringSubmatch matches the regular expression index against s, as
+// regexp.FindStringSubmatch does.  If the regular expression requires a
+// literal that s doesn't contain, the much slower regular expression match is
+// skipped.
+func (v *VM) findStringSubmatch(index int, s string) []string {
+	if index < len(v.literals) && v.literals[index] != "" && !strings.Contains(s, v.literals[index]) {
+		return nil
+	}
+	return v.re[index].FindStringSubmatch(s)
+}
+
+// SetTimeout makes the VM abandon processing a line once it has taken longer
+// than d, counting it in `vm_timeouts_total`, so that one expensive line can't
+// hold up the lines after it.
+func (v *VM) SetTimeout(d time.Duration) {
+	v.timeout = d
+}
+
+// SetWorkers makes the VM take a token from workers while it processes each
+// line, so that VMs sharing workers run at most cap(workers) lines at a time.
+func (v *VM) SetWorkers(workers chan struct{}) {
+	v.workers = workers
+}
+
+// SetNomatchWarnAfter makes the VM log a warning naming each pattern in the
+// program that hasn't matched any of the first n lines processed.  A pattern
+// that never matches is likely to have a mistake in it, or to be for logs
+// whose format has changed.
+func (v *VM) SetNomatchWarnAfter(n int64) {
+	v.nomatchWarnAfter = n
+	v.patternMatched = make(map[int]bool)
+	for _, i := range v.prog {
+		if i.Opcode == code.Match || i.Opcode == code.Smatch {
+			v.patternMatched[i.Operand.(int)] = false
+		}
+	}
+}
+
+// checkPatternsMatch records the patterns that matched the line processed by
+// t, and once nomatchWarnAfter lines have been processed warns about those
+// that never have.
+func (v *VM) checkPatternsMatch(t *thread) {
+	if v.linesProcessed >= v.nomatchWarnAfter {
+		return
+	}
+	for i, m := range t.matches {
+		if m != nil {
+			v.patternMatched[i] = true
+		}
+	}
+	v.linesProcessed++
+	if v.linesProcessed < v.nomatchWarnAfter {
+		return
+	}
+	for _, n := range v.unmatchedPatterns() {
+		glog.Warningf("Program %s: pattern /%s/ on line %d has not matched any of the first %d lines", v.name, v.re[n], v.patternLine(n), v.nomatchWarnAfter)
+	}
+}
+
+// unmatchedPatterns returns the indexes of the patterns that have not matched
+// any line, in order.
+func (v *VM) unmatchedPatterns() []int {
+	var unmatched []int
+	for i, matched := range v.patternMatched {
+		if !matched {
+			unmatched = append(unmatched, i)
+		}
+	}
+	sort.Ints(unmatched)
+	return unmatched
+}
+
+// patternLine returns the program source line of the first match against the
+// pattern n.
+func (v *VM) patternLine(n int) int {
+	for _, i := range v.prog {
+		if (i.Opcode == code.Match || i.Opcode == code.Smatch) && i.Operand.(int) == n {
+			return i.SourceLine + 1
+		}
+	}
+	return 0
+}
+
+// SetTraceWriter makes the VM write a trace of its execution of each line to
+// w.  The trace shows each instruction executed, the stack after it, and the
+// capture groups of the patterns that matched the line.
+func (v *VM) SetTraceWriter(w io.Writer) {
+	v.traceOut = w
+}
+
+// writeTrace completes the trace of the line processed by t, and writes it
+// to the trace writer in one piece so that traces from programs running
+// concurrently aren't interleaved.
+func (v *VM) writeTrace(tb *bytes.Buffer, t *thread) {
+	indexes := make([]int, 0, len(t.matches))
+	for i, m := range t.matches {
+		if m != nil {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		fmt.Fprintf(tb, "  matched /%s/: %q\n", v.re[i], t.matches[i])
+	}
+	if len(indexes) == 0 {
+		fmt.Fprintln(tb, "  no patterns matched")
+	}
+	if _, err := v.traceOut.Write(tb.Bytes()); err != nil {
+		glog.Info(err)
+	}
+}
+
+// traceStack formats the stack of a thread for the execution trace.
+func traceStack(stack []interface{}) string {
+	s := make([]string, 0, len(stack))
+	for _, x := range stack {
+		switch x := x.(type) {
+		case *metrics.Metric:
+			s = append(s, "metric "+x.Name)
+		case datum.Datum:
+			s = append(s, "datum "+x.ValueString())
+		case string:
+			s = append(s, strconv.Quote(x))
+		default:
+			s = append(s, fmt.Sprintf("%v", x))
+		}
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// ResetLineCounts clears the line statistics and processing time of the program name, so that a
+// reloaded program starts counting from zero.
+func ResetLineCounts(name string) {
+	ProgLines.Delete(name)
+	ProgLinesMatched.Delete(name)
+	ProgLinesUnmatched.Delete(name)
+	ProgLineProcessingTime.Delete(name)
+}
+
 // New creates a new virtual machine with the given name, and compiler
 // artifacts for executable and data segments.
 func New(name string, obj *code.Object, syslogUseCurrentYear bool, loc *time.Location, log bool, trace bool) *VM {
 	v := &VM{
 		name:                 name,
 		re:                   obj.Regexps,
+		literals:             obj.Literals,
 		str:                  obj.Strings,
 		Metrics:              obj.Metrics,
 		prog:                 obj.Program,
@@ -1054,7 +1463,13 @@ func (v *VM) Run(lines <-chan *logline.LogLine, wg *sync.WaitGroup) {
 	glog.V(1).Infof("started VM %q", v.name)
 	ctx := context.TODO()
 	for line := range lines {
+		if v.workers != nil {
+			v.workers <- struct{}{}
+		}
 		v.ProcessLogLine(ctx, line)
+		if v.workers != nil {
+			<-v.workers
+		}
 	}
 	glog.Infof("VM %q finished", v.name)
 }
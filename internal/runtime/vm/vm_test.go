@@ -5,7 +5,9 @@ package vm
 
 import (
 	"context"
+	"math"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -263,6 +265,33 @@ var instructions = []struct {
 		[]interface{}{"mixedcase"},
 		thread{pc: 0, matches: map[int][]string{}},
 	},
+	{
+		"tolower unicode",
+		code.Instr{code.Tolower, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"ÉCOLE Straße ΣΑΣ"},
+		[]interface{}{"école straße σασ"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"toupper",
+		code.Instr{code.Toupper, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"mIxeDCasE"},
+		[]interface{}{"MIXEDCASE"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"toupper unicode",
+		code.Instr{code.Toupper, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"école ǆ σας"},
+		[]interface{}{"ÉCOLE Ǆ ΣΑΣ"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
 	{
 		"length",
 		code.Instr{code.Length, 0, 0},
@@ -524,6 +553,15 @@ var instructions = []struct {
 		[]interface{}{testFilename},
 		thread{pc: 0, matches: map[int][]string{}},
 	},
+	{
+		"getfield missing",
+		code.Instr{code.Getfield, "syslog_hostname", 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{},
+		[]interface{}{""},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
 	{
 		"i2s",
 		code.Instr{code.I2s, nil, 0},
@@ -587,6 +625,78 @@ var instructions = []struct {
 		[]interface{}{"cat"},
 		thread{pc: 0, matches: map[int][]string{}},
 	},
+	{
+		"substr",
+		code.Instr{code.Substr, 3, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"404", int64(0), int64(1)},
+		[]interface{}{"4"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"jsonfield",
+		code.Instr{code.Jsonfield, 2, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{`{"response": {"code": 200}}`, "response.code"},
+		[]interface{}{"200"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"jsonfield missing",
+		code.Instr{code.Jsonfield, 2, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{`{"response": {"code": 200}}`, "request.method"},
+		[]interface{}{""},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"logfmtfield",
+		code.Instr{code.Logfmtfield, 2, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{`level=info msg="request done" dur=12ms`, "msg"},
+		[]interface{}{"request done"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr negative start",
+		code.Instr{code.Substr, 3, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"404", int64(-2), int64(2)},
+		[]interface{}{"40"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr negative length",
+		code.Instr{code.Substr, 3, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"404", int64(1), int64(-1)},
+		[]interface{}{""},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr length overflows",
+		code.Instr{code.Substr, 3, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"404", int64(1), int64(math.MaxInt64)},
+		[]interface{}{"04"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr start past end",
+		code.Instr{code.Substr, 3, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"404", int64(10), int64(1)},
+		[]interface{}{""},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
 }
 
 const testFilename = "test"
@@ -625,6 +735,27 @@ func TestInstrs(t *testing.T) {
 	}
 }
 
+func TestGetfieldInstr(t *testing.T) {
+	v := makeVM(code.Instr{code.Getfield, "syslog_hostname", 0}, nil)
+	ctx := logline.WithFields(context.Background(), map[string]string{"syslog_hostname": "host"})
+	v.input = logline.New(ctx, testFilename, "aaaab")
+	v.execute(v.t, v.prog[0])
+	if v.terminate {
+		t.Fatalf("Execution failed, see info log.")
+	}
+	testutil.ExpectNoDiff(t, []interface{}{"host"}, v.t.stack)
+}
+
+func TestGetfieldInstrMissingIntField(t *testing.T) {
+	v := makeVM(code.Instr{code.Getfield, "syslog_severity", 0}, nil)
+	v.execute(v.t, v.prog[0])
+	v.execute(v.t, code.Instr{Opcode: code.S2i})
+	if v.terminate {
+		t.Fatalf("Execution failed, see info log.")
+	}
+	testutil.ExpectNoDiff(t, []interface{}{int64(0)}, v.t.stack)
+}
+
 // makeVM is a helper method for construction a single-instruction VM.
 func makeVM(i code.Instr, m []*metrics.Metric) *VM {
 	obj := &code.Object{Metrics: m, Program: []code.Instr{i}}
@@ -678,6 +809,16 @@ func TestDatumSetInstrs(t *testing.T) {
 			},
 			expected: "2",
 		},
+		{
+			name: "inc float",
+			i:    code.Instr{code.Inc, nil, 0},
+			d:    1,
+			setup: func(t *thread, d datum.Datum) {
+				datum.SetFloat(d, 0.5, time.Unix(0, 0))
+				t.Push(d)
+			},
+			expected: "1.5",
+		},
 		{
 			name: "inc by str",
 			i:    code.Instr{code.Inc, 0, 0},
@@ -807,6 +948,77 @@ func TestStrptimeWithoutTimezone(t *testing.T) {
 	}
 }
 
+func TestStrptimeStrftimeFormat(t *testing.T) {
+	obj := &code.Object{Program: []code.Instr{{code.Strptime, 0, 0}}}
+	vm := New("strptimestrftime", obj, true, nil, false, false)
+	vm.t = new(thread)
+	vm.t.stack = make([]interface{}, 0)
+	vm.t.Push("Jan 2012-01-18 06:25:00")
+	vm.t.Push("Jan %Y-%m-%d %H:%M:%S")
+	vm.execute(vm.t, obj.Program[0])
+	if vm.t.time != time.Date(2012, 1, 18, 6, 25, 0, 0, time.UTC) {
+		t.Errorf("Time didn't parse with a strftime format: %s received", vm.t.time)
+	}
+}
+
+func TestParseTimeZones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	testutil.FatalIfErr(t, err)
+	for _, tc := range []struct {
+		name     string
+		loc      *time.Location
+		layout   string
+		value    string
+		expected time.Time
+	}{
+		{"positive offset", nil, "2006-01-02 15:04:05 Z0700", "2021-06-01 09:00:00 +0900", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"negative offset", nil, "2006-01-02 15:04:05 Z0700", "2021-06-01 09:00:00 -0330", time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)},
+		{"zulu", nil, "2006-01-02 15:04:05 Z0700", "2021-06-01 09:00:00 Z", time.Date(2021, 6, 1, 9, 0, 0, 0, time.UTC)},
+		{"offset overrides location", newYork, "2006-01-02 15:04:05 Z0700", "2021-06-01 09:00:00 +0900", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"abbreviation not in location", nil, "2006-01-02 15:04:05 MST", "2021-06-01 09:00:00 JST", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"negative abbreviation not in location", nil, "2006-01-02 15:04:05 MST", "2021-06-01 09:00:00 PDT", time.Date(2021, 6, 1, 16, 0, 0, 0, time.UTC)},
+		{"unknown abbreviation", nil, "2006-01-02 15:04:05 MST", "2021-06-01 09:00:00 XYZ", time.Date(2021, 6, 1, 9, 0, 0, 0, time.UTC)},
+		{"abbreviation in location", newYork, "2006-01-02 15:04:05 MST", "2021-01-10 09:00:00 EST", time.Date(2021, 1, 10, 14, 0, 0, 0, time.UTC)},
+		// America/New_York springs forward at 2021-03-14 02:00 EST, and
+		// falls back at 2021-11-07 02:00 EDT.
+		{"before spring forward", newYork, "2006-01-02 15:04:05", "2021-03-14 01:59:59", time.Date(2021, 3, 14, 6, 59, 59, 0, time.UTC)},
+		{"after spring forward", newYork, "2006-01-02 15:04:05", "2021-03-14 03:00:00", time.Date(2021, 3, 14, 7, 0, 0, 0, time.UTC)},
+		{"before fall back", newYork, "2006-01-02 15:04:05", "2021-11-07 00:59:59", time.Date(2021, 11, 7, 4, 59, 59, 0, time.UTC)},
+		{"after fall back", newYork, "2006-01-02 15:04:05", "2021-11-07 02:00:00", time.Date(2021, 11, 7, 7, 0, 0, 0, time.UTC)},
+		{"repeated hour with abbreviation", newYork, "2006-01-02 15:04:05 MST", "2021-11-07 01:30:00 EST", time.Date(2021, 11, 7, 6, 30, 0, 0, time.UTC)},
+		{"repeated hour with offset", newYork, "2006-01-02 15:04:05 Z0700", "2021-11-07 01:30:00 -0400", time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC)},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			vm := New(tc.name, &code.Object{}, false, tc.loc, false, false)
+			tm, err := vm.ParseTime(tc.layout, tc.value)
+			testutil.FatalIfErr(t, err)
+			if !tm.Equal(tc.expected) {
+				t.Errorf("ParseTime(%q, %q) = %s, want %s", tc.layout, tc.value, tm, tc.expected)
+			}
+		})
+	}
+}
+
+func TestStrptimeParseError(t *testing.T) {
+	obj := &code.Object{Program: []code.Instr{{code.Strptime, 0, 0}}}
+	vm := New("strptimeerror", obj, true, nil, false, false)
+	vm.t = new(thread)
+	vm.t.stack = make([]interface{}, 0)
+	vm.t.Push("not a time")
+	vm.t.Push("2006/01/02 15:04:05")
+	vm.execute(vm.t, obj.Program[0])
+	if vm.terminate {
+		t.Error("VM terminated on a strptime parse error")
+	}
+	if !vm.t.time.IsZero() {
+		t.Errorf("Time register set after a parse error: %s", vm.t.time)
+	}
+	if r := TimeParseErrors.Get("strptimeerror").String(); r != "1" {
+		t.Errorf("Expected 1 time parse error, got %s", r)
+	}
+}
+
 // code.Instructions with datum retrieve.
 func TestDatumFetchInstrs(t *testing.T) {
 	var m []*metrics.Metric
@@ -902,6 +1114,46 @@ func TestDeleteInstrs(t *testing.T) {
 	}
 }
 
+func TestDeleteAllInstr(t *testing.T) {
+	var m []*metrics.Metric
+	m = append(m,
+		metrics.NewMetric("a", "tst", metrics.Counter, metrics.Int, "a"),
+	)
+
+	for _, k := range []string{"x", "y"} {
+		_, err := m[0].GetDatum(k)
+		testutil.FatalIfErr(t, err)
+	}
+
+	v := makeVM(code.Instr{code.Del, 0, 0}, m)
+	v.t.Push(m[0])
+	v.execute(v.t, v.prog[0])
+	if v.terminate {
+		t.Fatal("execution failed, see info log")
+	}
+	if len(m[0].LabelValues) != 0 {
+		t.Errorf("label values not removed: %v", m[0].LabelValues)
+	}
+	if lv := m[0].FindLabelValueOrNil([]string{"x"}); lv != nil {
+		t.Errorf("label value still found: %v", lv)
+	}
+}
+
+func TestDeleteMissingInstr(t *testing.T) {
+	var m []*metrics.Metric
+	m = append(m,
+		metrics.NewMetric("a", "tst", metrics.Counter, metrics.Int, "a"),
+	)
+
+	v := makeVM(code.Instr{code.Del, 1, 0}, m)
+	v.t.Push("z")
+	v.t.Push(m[0])
+	v.execute(v.t, v.prog[0])
+	if v.terminate {
+		t.Fatal("deleting a missing key terminated execution")
+	}
+}
+
 func TestTimestampInstr(t *testing.T) {
 	var m []*metrics.Metric
 	now := time.Now().UTC()
@@ -927,3 +1179,215 @@ func TestTimestampInstr(t *testing.T) {
 		t.Errorf("Expecting timestamp to be %s, was %s", newT, tos)
 	}
 }
+
+func TestDatumUpdateTouchesLabelValue(t *testing.T) {
+	m := []*metrics.Metric{metrics.NewMetric("a", "tst", metrics.Counter, metrics.Int)}
+	obj := &code.Object{
+		Metrics: m,
+		Program: []code.Instr{
+			{code.Push, int64(37), 0},
+			{code.Settime, nil, 0},
+			{code.Mload, 0, 0},
+			{code.Dload, 0, 0},
+			{code.Inc, nil, 0},
+		},
+	}
+	v := New("touch", obj, true, nil, false, false)
+	start := time.Now()
+	v.ProcessLogLine(context.Background(), logline.New(context.Background(), testFilename, "a"))
+	lv := m[0].FindLabelValueOrNil([]string{})
+	if lv == nil {
+		t.Fatal("datum not created")
+	}
+	// The log's timestamp is old, but the update was just now.
+	testutil.ExpectNoDiff(t, time.Unix(37, 0).UTC(), lv.Value.TimeUTC().UTC())
+	if lv.Updated().Before(start) {
+		t.Errorf("updated at %s, before the line was processed at %s", lv.Updated(), start)
+	}
+}
+
+func TestNomatchWarnAfter(t *testing.T) {
+	obj := &code.Object{
+		Regexps: []*regexp.Regexp{regexp.MustCompile("a"), regexp.MustCompile("zzz")},
+		Program: []code.Instr{{code.Match, 0, 0}, {code.Match, 1, 2}},
+	}
+	v := New("nomatch", obj, true, nil, false, false)
+	v.SetNomatchWarnAfter(2)
+	for _, line := range []string{"a", "ab", "zzz"} {
+		v.ProcessLogLine(context.Background(), logline.New(context.Background(), testFilename, line))
+	}
+	// Only the first two lines are checked.
+	testutil.ExpectNoDiff(t, []int{1}, v.unmatchedPatterns())
+	if v.linesProcessed != 2 {
+		t.Errorf("lines processed: got %d, want 2", v.linesProcessed)
+	}
+	if line := v.patternLine(1); line != 3 {
+		t.Errorf("pattern line: got %d, want 3", line)
+	}
+}
+
+func TestMatchRequiredLiteral(t *testing.T) {
+	obj := &code.Object{
+		Regexps:  []*regexp.Regexp{regexp.MustCompile(`status=(\d+)`)},
+		Literals: []string{"status="},
+		Program:  []code.Instr{{code.Match, 0, 0}},
+	}
+	v := New("literal", obj, true, nil, false, false)
+	for _, tc := range []struct {
+		line     string
+		expected []string
+	}{
+		{"GET / status=200", []string{"status=200", "200"}},
+		{"GET / status=", nil},
+		{"GET / code=200", nil},
+	} {
+		v.ProcessLogLine(context.Background(), logline.New(context.Background(), testFilename, tc.line))
+		testutil.ExpectNoDiff(t, tc.expected, v.t.matches[0])
+	}
+}
+
+// BenchmarkMatch compares matching lines of a typical web server log,
+// few of which are errors, with and without the required literal check.
+func BenchmarkMatch(b *testing.B) {
+	re := regexp.MustCompile(`^(\S+) \S+ \S+ \[[^\]]+\] "[^"]*" 5\d\d (\d+) upstream error: (.*)$`)
+	lines := []string{
+		`192.168.0.1 - - [18/Jan/2012:06:25:00 +0000] "GET /index.html HTTP/1.1" 200 1024`,
+		`192.168.0.2 - - [18/Jan/2012:06:25:01 +0000] "GET /images/logo.png HTTP/1.1" 200 20480`,
+		`192.168.0.3 - - [18/Jan/2012:06:25:02 +0000] "POST /api/login HTTP/1.1" 302 0`,
+		`192.168.0.4 - - [18/Jan/2012:06:25:03 +0000] "GET /missing HTTP/1.1" 404 512`,
+		`192.168.0.5 - - [18/Jan/2012:06:25:04 +0000] "GET /api/data HTTP/1.1" 502 128 upstream error: connection refused`,
+	}
+	for _, bc := range []struct {
+		name     string
+		literals []string
+	}{
+		{"regexp", nil},
+		{"literal", []string{" upstream error: "}},
+	} {
+		bc := bc
+		b.Run(bc.name, func(b *testing.B) {
+			obj := &code.Object{Regexps: []*regexp.Regexp{re}, Literals: bc.literals, Program: []code.Instr{{code.Match, 0, 0}}}
+			v := New("bench", obj, true, nil, false, false)
+			ctx := context.Background()
+			ll := make([]*logline.LogLine, len(lines))
+			for i, line := range lines {
+				ll[i] = logline.New(ctx, testFilename, line)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				v.ProcessLogLine(ctx, ll[i%len(ll)])
+			}
+		})
+	}
+}
+
+func TestJSONField(t *testing.T) {
+	v := New("json", &code.Object{}, true, nil, false, false)
+	const doc = `{"a": {"b": "x", "n": 1.5, "ok": true, "none": null, "list": [{"id": 7}], "obj": {"k": "<v>"}}}`
+	for _, tc := range []struct {
+		text, path string
+		want       string
+		ok         bool
+	}{
+		{doc, "a.b", "x", true},
+		{doc, "a.n", "1.5", true},
+		{doc, "a.ok", "true", true},
+		{doc, "a.none", "", true},
+		{doc, "a.list.0.id", "7", true},
+		{doc, "a.obj", `{"k":"<v>"}`, true},
+		{doc, "a.list.1.id", "", false},
+		{doc, "a.b.c", "", false},
+		{doc, "b", "", false},
+		{"not json", "a", "", false},
+		{`"top"`, "", "top", true},
+	} {
+		got, ok := v.jsonField(tc.text, tc.path)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("jsonField(%q, %q) = %q, %v; want %q, %v", tc.text, tc.path, got, ok, tc.want, tc.ok)
+		}
+	}
+	// Each distinct string is parsed once, however the calls interleave.
+	if len(v.json) != 3 {
+		t.Errorf("parsed %d documents, want 3", len(v.json))
+	}
+}
+
+func TestLogfmtField(t *testing.T) {
+	v := New("logfmt", &code.Object{}, true, nil, false, false)
+	const record = `level=info msg="request \"done\" ok" dur=12ms path=/a=b debug empty= level=warn`
+	for _, tc := range []struct {
+		text, key string
+		want      string
+		ok        bool
+	}{
+		{record, "level", "info", true},
+		{record, "msg", `request "done" ok`, true},
+		{record, "dur", "12ms", true},
+		{record, "path", "/a=b", true},
+		{record, "debug", "", true},
+		{record, "empty", "", true},
+		{record, "missing", "", false},
+		{`msg="unterminated quote`, "msg", "unterminated quote", true},
+		{"", "msg", "", false},
+	} {
+		got, ok := v.logfmtField(tc.text, tc.key)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("logfmtField(%q, %q) = %q, %v; want %q, %v", tc.text, tc.key, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestErrorLogLimiter(t *testing.T) {
+	var l errorLogLimiter
+	start := time.Unix(0, 0)
+	for _, tc := range []struct {
+		line       int
+		after      time.Duration
+		suppressed int
+		ok         bool
+	}{
+		{3, 0, 0, true},
+		{3, time.Second, 0, false},
+		{3, 2 * time.Second, 0, false},
+		{4, 2 * time.Second, 0, true},
+		{3, runtimeErrorLogInterval, 2, true},
+		{3, runtimeErrorLogInterval + time.Second, 0, false},
+	} {
+		suppressed, ok := l.allow(tc.line, start.Add(tc.after))
+		if suppressed != tc.suppressed || ok != tc.ok {
+			t.Errorf("allow(%d, +%s) = %d, %v; want %d, %v", tc.line, tc.after, suppressed, ok, tc.suppressed, tc.ok)
+		}
+	}
+}
+
+func TestRuntimeErrorNamesSourceLine(t *testing.T) {
+	obj := &code.Object{Program: []code.Instr{
+		{code.Push, "abc", 0},
+		{code.S2i, nil, 22},
+	}}
+	v := New("foo.mtail", obj, true, nil, false, false)
+	v.ProcessLogLine(context.Background(), logline.New(context.Background(), "log", "abc"))
+	if got := strings.SplitN(v.RuntimeErrorString(), "\n", 2)[0]; !strings.HasPrefix(got, "foo.mtail:23: ") {
+		t.Errorf("runtime error %q doesn't start with the source line", got)
+	}
+}
+
+func TestProcessLogLineDeadline(t *testing.T) {
+	obj := &code.Object{
+		Regexps: []*regexp.Regexp{regexp.MustCompile("a")},
+		Program: []code.Instr{{code.Match, 0, 0}, {code.Jnm, 3, 0}, {code.Stop, nil, 0}},
+	}
+	v := New("slow", obj, true, nil, false, true)
+	v.SetTimeout(time.Hour)
+	v.ProcessLogLine(context.Background(), logline.New(context.Background(), testFilename, "a"))
+	testutil.ExpectNoDiff(t, []int{0, 1, 2}, v.trace)
+
+	// A line whose deadline has passed is abandoned before the match.
+	v.trace = v.trace[:0]
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	timeoutsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "vm_timeouts_total", "slow", 1)
+	v.ProcessLogLine(ctx, logline.New(context.Background(), testFilename, "a"))
+	timeoutsCheck()
+	testutil.ExpectNoDiff(t, []int{}, v.trace)
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package tailer
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+)
+
+// dirWatcher sends on its Events channel when files are added to or removed
+// from a watched directory, and closes it once the watcher is closed.
+type dirWatcher interface {
+	Events() <-chan struct{}
+	Close() error
+}
+
+// isDirPattern returns true if the log pattern path names a directory, whose
+// files are all tailed: it's a directory when the pattern is added, or ends in
+// a path separator.
+func isDirPattern(path string) bool {
+	if len(path) > 0 && os.IsPathSeparator(path[len(path)-1]) {
+		return true
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// pollDir tails each file in dir that isn't already tailed, and stops the
+// tailers of the files that have been removed from it.  Subdirectories, and
+// files matching the ignore pattern, are not tailed.
+func (t *Tailer) pollDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		pathname := filepath.Join(dir, entry.Name())
+		if t.Ignore(pathname) {
+			continue
+		}
+		present[pathname] = struct{}{}
+		if err := t.TailPath(pathname); err != nil {
+			glog.Info(err)
+		}
+	}
+	t.logstreamsMu.RLock()
+	defer t.logstreamsMu.RUnlock()
+	for pathname, l := range t.logstreams {
+		if filepath.Dir(pathname) != dir {
+			continue
+		}
+		if _, ok := present[pathname]; !ok {
+			glog.V(2).Infof("%q removed from %q, stopping", pathname, dir)
+			l.Stop()
+		}
+	}
+	return nil
+}
+
+// watchDir starts a goroutine that polls dir each time files are added to or
+// removed from it, so that new logs are tailed as soon as they are created.
+// If dir can't be watched, it's still polled along with the log patterns.
+func (t *Tailer) watchDir(dir string) {
+	w, err := newDirWatcher(dir)
+	if err != nil {
+		glog.Infof("Not watching %s, finding new logs in it when polling: %s", dir, err)
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer w.Close()
+		<-t.initDone
+		glog.Infof("Watching %s for new logs", dir)
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			case _, ok := <-w.Events():
+				if !ok {
+					return
+				}
+				t.pollMu.Lock()
+				err := t.pollDir(dir)
+				t.pollMu.Unlock()
+				if err != nil {
+					glog.Info(err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+//go:build linux
+// +build linux
+
+package tailer
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyWatcher watches a directory with inotify.
+type inotifyWatcher struct {
+	f      *os.File
+	events chan struct{}
+}
+
+// newDirWatcher watches dir for files being created in, moved into, removed
+// from or moved out of it.
+func newDirWatcher(dir string) (dirWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init1: %w", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, unix.IN_CREATE|unix.IN_MOVED_TO|unix.IN_DELETE|unix.IN_MOVED_FROM|unix.IN_ONLYDIR); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("inotify_add_watch %s: %w", dir, err)
+	}
+	// The descriptor is non-blocking, so reads from the file wait in the
+	// runtime's poller, and are woken when the file is closed.
+	w := &inotifyWatcher{f: os.NewFile(uintptr(fd), dir), events: make(chan struct{}, 1)}
+	go w.read()
+	return w, nil
+}
+
+// read sends an event for each read of the inotify file, until it's closed.
+// The events read are not decoded, as the directory is listed again whatever
+// they were, and an event already waiting to be received covers them too.
+func (w *inotifyWatcher) read() {
+	defer close(w.events)
+	buf := make([]byte, 4096)
+	for {
+		if _, err := w.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *inotifyWatcher) Events() <-chan struct{} {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+//go:build linux
+// +build linux
+
+package tailer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestWatchDirTailsNewLogs(t *testing.T) {
+	ta, _, _, dir, stop := makeTestTail(t)
+	defer stop()
+
+	// The log is found without polling the log patterns.
+	logfile := filepath.Join(dir, "new.log")
+	f := testutil.TestOpenFile(t, logfile)
+	defer f.Close()
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		ta.logstreamsMu.RLock()
+		defer ta.logstreamsMu.RUnlock()
+		_, ok := ta.logstreams[logfile]
+		return ok, nil
+	}, 10*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("new log in watched directory not tailed")
+	}
+
+	testutil.FatalIfErr(t, os.Remove(logfile))
+	ok, err = testutil.DoOrTimeout(func() (bool, error) {
+		ta.logstreamsMu.RLock()
+		defer ta.logstreamsMu.RUnlock()
+		return ta.logstreams[logfile].IsComplete(), nil
+	}, 10*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("tailer of log removed from watched directory not stopped")
+	}
+}
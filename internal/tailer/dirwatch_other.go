@@ -0,0 +1,18 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+//go:build !linux
+// +build !linux
+
+package tailer
+
+import (
+	"errors"
+)
+
+// newDirWatcher returns an error, as directories can't be watched on this
+// platform.  Watched directories are only listed again each time the log
+// patterns are polled.
+func newDirWatcher(dir string) (dirWatcher, error) {
+	return nil, errors.New("watching directories is not supported on this platform")
+}
@@ -19,6 +19,14 @@ const tailerTemplate = `
 <li><pre>{{$name}}</pre></li>
 {{end}}
 </ul>
+{{if $.Dirs}}
+<h3>Directories</h3>
+<ul>
+{{range $name, $val := $.Dirs}}
+<li><pre>{{$name}}</pre></li>
+{{end}}
+</ul>
+{{end}}
 <h3>Log files watched</h3>
 <table border="1">
 <tr>
@@ -27,6 +35,7 @@ const tailerTemplate = `
 <th>opens</th>
 <th>truncations</th>
 <th>lines read</th>
+<th>bytes read</th>
 </tr>
 {{range $name, $val := $.LogStreams}}
 <tr>
@@ -35,6 +44,7 @@ const tailerTemplate = `
 <td>{{index $.Opens $name}}</td>
 <td>{{index $.Truncs $name}}</td>
 <td>{{index $.Lines $name}}</td>
+<td>{{index $.Bytes $name}}</td>
 </tr>
 {{end}}
 </table>
@@ -53,13 +63,17 @@ func (t *Tailer) WriteStatusHTML(w io.Writer) error {
 	data := struct {
 		LogStreams map[string]logstream.LogStream
 		Patterns   map[string]struct{}
+		Dirs       map[string]struct{}
 		Opens      map[string]string
 		Lines      map[string]string
+		Bytes      map[string]string
 		Errors     map[string]string
 		Truncs     map[string]string
 	}{
 		t.logstreams,
 		t.globPatterns,
+		t.dirs,
+		make(map[string]string),
 		make(map[string]string),
 		make(map[string]string),
 		make(map[string]string),
@@ -71,8 +85,9 @@ func (t *Tailer) WriteStatusHTML(w io.Writer) error {
 	}{
 		{"log_errors_total", data.Errors},
 		{"log_opens_total", data.Opens},
-		{"file_truncates_total", data.Truncs},
+		{"log_truncations_total", data.Truncs},
 		{"log_lines_total", data.Lines},
+		{"log_bytes_total", data.Bytes},
 	} {
 		pair := pair
 		v := expvar.Get(pair.k).(*expvar.Map)
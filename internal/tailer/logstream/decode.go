@@ -7,17 +7,85 @@ import (
 	"bytes"
 	"context"
 	"expvar"
+	"fmt"
+	"net/url"
+	"sync/atomic"
 	"unicode/utf8"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
 )
 
-// logLines counts the number of lines read per log file.
-var logLines = expvar.NewMap("log_lines_total")
+var (
+	// logLines counts the number of lines read per log file.
+	logLines = expvar.NewMap("log_lines_total")
+	// logBytes counts the number of bytes read per log file.
+	logBytes = expvar.NewMap("log_bytes_total")
+)
+
+// RemoveCounters removes the per log counts of lines and bytes read of the log
+// at pathname, as given to New, so that a log that is no longer read stops
+// being reported.  The counts of sockets are kept by their address.
+func RemoveCounters(pathname string) {
+	key := pathname
+	if u, err := url.Parse(pathname); err == nil && pathname != StdinPathname {
+		switch u.Scheme {
+		case "unix", "unixgram":
+			key = socketPath(u)
+		case "tcp", "udp", "syslog":
+			key = u.Host
+		case "", "file":
+			key = u.Path
+		}
+	}
+	logLines.Delete(key)
+	logBytes.Delete(key)
+}
+
+// logCharset is a character encoding that logs are transcoded from.
+type logCharset struct {
+	encoding.Encoding
+}
+
+// logEncoding is the character encoding of the logs, or nil if they are UTF-8.
+var logEncoding atomic.Pointer[logCharset]
+
+// SetLogEncoding sets the character encoding that the lines of every log are
+// transcoded to UTF-8 from, by its IANA name such as ISO-8859-1 or
+// Shift_JIS.  It only accepts encodings that write a newline as the single
+// byte '\n', so that lines can be split before they are transcoded.  An empty
+// name reads the logs as UTF-8.
+func SetLogEncoding(name string) error {
+	if name == "" {
+		logEncoding.Store(nil)
+		return nil
+	}
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil {
+		return err
+	}
+	if e == nil {
+		return fmt.Errorf("unsupported encoding %q", name)
+	}
+	if nl, err := e.NewEncoder().Bytes([]byte("\n")); err != nil || !bytes.Equal(nl, []byte("\n")) {
+		return fmt.Errorf("encoding %q is not ASCII compatible", name)
+	}
+	if e == unicode.UTF8 {
+		logEncoding.Store(nil)
+		return nil
+	}
+	logEncoding.Store(&logCharset{e})
+	return nil
+}
 
 // decodeAndSend transforms the byte array `b` into unicode in `partial`, sending to the llp as each newline is decoded.
 func decodeAndSend(ctx context.Context, lines chan<- *logline.LogLine, pathname string, n int, b []byte, partial *bytes.Buffer) int {
+	if logEncoding.Load() != nil {
+		return splitAndSend(ctx, lines, pathname, n, b, partial)
+	}
 	var (
 		r     rune
 		width int
@@ -60,9 +128,39 @@ func decodeAndSend(ctx context.Context, lines chan<- *logline.LogLine, pathname
 	return count
 }
 
+// splitAndSend splits the byte array `b` into lines in `partial` without
+// decoding it, sending each line to the llp as each newline is found.  The
+// lines are transcoded from logEncoding as they are sent.
+func splitAndSend(ctx context.Context, lines chan<- *logline.LogLine, pathname string, n int, b []byte, partial *bytes.Buffer) int {
+	if n > len(b) {
+		n = len(b)
+	}
+	for _, c := range b[:n] {
+		switch c {
+		case '\r':
+			// nom, as in decodeAndSend.
+		case '\n':
+			sendLine(ctx, pathname, partial, lines)
+		default:
+			partial.WriteByte(c)
+		}
+	}
+	return n
+}
+
 func sendLine(ctx context.Context, pathname string, partial *bytes.Buffer, lines chan<- *logline.LogLine) {
 	glog.V(2).Infof("sendline")
 	logLines.Add(pathname, 1)
-	lines <- logline.New(ctx, pathname, partial.String())
+	line := partial.String()
+	if e := logEncoding.Load(); e != nil {
+		// Decoders replace invalid byte sequences with U+FFFD, so this only
+		// fails on a bug in the decoder; keep the line as it was read then.
+		if b, err := e.NewDecoder().Bytes(partial.Bytes()); err == nil {
+			line = string(b)
+		} else {
+			glog.V(1).Infof("%s: transcoding line failed: %s", pathname, err)
+		}
+	}
+	lines <- logline.New(ctx, pathname, line)
 	partial.Reset()
 }
@@ -0,0 +1,18 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+//go:build !unix
+// +build !unix
+
+package logstream
+
+import (
+	"os"
+)
+
+// fileID returns zero, as there is no file serial number in an os.FileInfo
+// on this platform.  A replaced file is only noticed if it is shorter than
+// the offset it is resumed from.
+func fileID(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -19,8 +19,13 @@ import (
 	"github.com/google/mtail/internal/waker"
 )
 
-// fileTruncates counts the truncations of a file stream.
-var fileTruncates = expvar.NewMap("file_truncates_total")
+var (
+	// fileTruncates counts the truncations of a file stream, by log pathname.
+	fileTruncates = expvar.NewMap("log_truncations_total")
+	// fileRemovals counts the file streams completed because their file was
+	// removed, by log pathname.
+	fileRemovals = expvar.NewMap("log_removals_total")
+)
 
 // fileStream streams log lines from a regular file on the file system.  These
 // log files are appended to by another process, and are either rotated or
@@ -42,15 +47,23 @@ type fileStream struct {
 	mu           sync.RWMutex // protects following fields.
 	lastReadTime time.Time    // Last time a log line was read from this file
 	completed    bool         // The filestream is completed and can no longer be used.
+	fi           os.FileInfo  // The file currently being read.
+	prev         os.FileInfo  // The file read before fi, if it was rotated away.
+	offset       int64        // Offset in fi after the last complete line sent.
+	head         fingerprint  // Fingerprint of the start of fi.
 
 	stopOnce sync.Once     // Ensure stopChan only closed once.
 	stopChan chan struct{} // Close to start graceful shutdown.
 }
 
-// newFileStream creates a new log stream from a regular file.
-func newFileStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, fi os.FileInfo, lines chan<- *logline.LogLine, streamFromStart bool) (LogStream, error) {
+// seekToEnd is the offset given to a fileStream to start reading at the end of the file.
+const seekToEnd = -1
+
+// newFileStream creates a new log stream from a regular file, starting to
+// read at offset, or at the end of the file if offset is seekToEnd.
+func newFileStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, fi os.FileInfo, lines chan<- *logline.LogLine, offset int64) (LogStream, error) {
 	fs := &fileStream{ctx: ctx, pathname: pathname, lastReadTime: time.Now(), lines: lines, stopChan: make(chan struct{})}
-	if err := fs.stream(ctx, wg, waker, fi, streamFromStart); err != nil {
+	if err := fs.stream(ctx, wg, waker, fi, offset); err != nil {
 		return nil, err
 	}
 	return fs, nil
@@ -62,7 +75,21 @@ func (fs *fileStream) LastReadTime() time.Time {
 	return fs.lastReadTime
 }
 
-func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, fi os.FileInfo, streamFromStart bool) error {
+// Follows implements the Follower interface.
+func (fs *fileStream) Follows(fi os.FileInfo) bool {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return (fs.fi != nil && os.SameFile(fs.fi, fi)) || (fs.prev != nil && os.SameFile(fs.prev, fi))
+}
+
+// Position implements the Positioner interface.
+func (fs *fileStream) Position() Position {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	return Position{Inode: fileID(fs.fi), Offset: fs.offset, HeadSize: fs.head.size, HeadSum: fs.head.sum}
+}
+
+func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, fi os.FileInfo, offset int64) error {
 	fd, err := os.OpenFile(fs.pathname, os.O_RDONLY, 0o600)
 	if err != nil {
 		logErrors.Add(fs.pathname, 1)
@@ -70,8 +97,15 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 	}
 	logOpens.Add(fs.pathname, 1)
 	glog.V(2).Infof("%v: opened new file", fd)
-	if !streamFromStart {
-		if _, err := fd.Seek(0, io.SeekEnd); err != nil {
+	// readPos is the offset in the file of the next byte to be read.
+	var readPos int64
+	if offset != 0 {
+		if offset == seekToEnd {
+			readPos, err = fd.Seek(0, io.SeekEnd)
+		} else {
+			readPos, err = fd.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
 			logErrors.Add(fs.pathname, 1)
 			if err := fd.Close(); err != nil {
 				logErrors.Add(fs.pathname, 1)
@@ -79,9 +113,22 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 			}
 			return err
 		}
-		glog.V(2).Infof("%v: seeked to end", fd)
+		glog.V(2).Infof("%v: seeked to %d", fd, readPos)
+	}
+	head, err := readFingerprint(fd)
+	if err != nil {
+		logErrors.Add(fs.pathname, 1)
+		glog.Info(err)
+	}
+	fs.mu.Lock()
+	if fs.fi != nil && !os.SameFile(fs.fi, fi) {
+		fs.prev = fs.fi
 	}
-	b := make([]byte, defaultReadBufferSize)
+	fs.fi = fi
+	fs.offset = readPos
+	fs.head = head
+	fs.mu.Unlock()
+	b := newReadBuffer()
 	var lastBytes []byte
 	partial := bytes.NewBufferString("")
 	started := make(chan struct{})
@@ -99,25 +146,117 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 			logCloses.Add(fs.pathname, 1)
 		}()
 		close(started)
+		// send decodes the count bytes just read into b and sends any
+		// complete lines.
+		send := func(count int) {
+			total += count
+			logBytes.Add(fs.pathname, int64(count))
+			readPos += int64(count)
+			glog.V(2).Infof("%v: decode and send", fd)
+			needSend := lastBytes
+			needSend = append(needSend, b[:count]...)
+			sendCount := decodeAndSend(ctx, fs.lines, fs.pathname, len(needSend), needSend, partial)
+			// The offset of the start of needSend in the file, used to find
+			// where the last line sent ended.
+			start := readPos - int64(len(needSend))
+			if sendCount < len(needSend) {
+				lastBytes = append([]byte{}, needSend[sendCount:]...)
+			} else {
+				lastBytes = []byte{}
+			}
+			fs.mu.Lock()
+			fs.lastReadTime = time.Now()
+			if i := bytes.LastIndexByte(needSend[:sendCount], '\n'); i >= 0 {
+				fs.offset = start + int64(i) + 1
+			}
+			fs.mu.Unlock()
+		}
+		// flush sends the partial line accumulated so far, which consumes
+		// everything read from the file that could be decoded.
+		flush := func() {
+			if partial.Len() > 0 {
+				sendLine(ctx, fs.pathname, partial, fs.lines)
+			}
+			fs.mu.Lock()
+			fs.offset = readPos - int64(len(lastBytes))
+			fs.mu.Unlock()
+		}
+		// drain reads the old file to EOF after it has been rotated away or
+		// removed, as the writer may have appended to it after the last read
+		// but before the rotation.  The file descriptor still refers to the
+		// old inode, so nothing written to it is lost.
+		drain := func() {
+			for {
+				count, err := fd.Read(b)
+				glog.V(2).Infof("%v: drained %d bytes, err is %v", fd, count, err)
+				if count > 0 {
+					send(count)
+				}
+				if err != nil {
+					if err != io.EOF {
+						logErrors.Add(fs.pathname, 1)
+						glog.Info(err)
+					}
+					break
+				}
+			}
+			flush()
+		}
+		// headChanged reports whether the start of the file is no longer
+		// what was read, refreshing the fingerprint if it is still shorter
+		// than fingerprintSize.
+		headChanged := func() bool {
+			ok, err := head.matches(fd)
+			if err != nil {
+				logErrors.Add(fs.pathname, 1)
+				glog.Info(err)
+				return false
+			}
+			if !ok {
+				glog.V(2).Infof("%v: start of file has changed", fd)
+				return true
+			}
+			if head.size < fingerprintSize {
+				head = fs.updateHead(fd, head)
+			}
+			return false
+		}
+		// restart goes back to the start of a truncated or rewritten file.
+		restart := func() {
+			// About to lose all remaining data because of the truncate so flush the accumulator.
+			if partial.Len() > 0 {
+				sendLine(ctx, fs.pathname, partial, fs.lines)
+			}
+			p, serr := fd.Seek(0, io.SeekStart)
+			if serr != nil {
+				logErrors.Add(fs.pathname, 1)
+				glog.Info(serr)
+			}
+			glog.V(2).Infof("%v: Seeked to %d", fd, p)
+			readPos = p
+			fs.mu.Lock()
+			fs.offset = p
+			fs.mu.Unlock()
+			head = fs.updateHead(fd, fingerprint{})
+			lastBytes = []byte{}
+			fileTruncates.Add(fs.pathname, 1)
+		}
+		woken := false
 		for {
+			// A file rewritten while we slept may already be longer than the
+			// current offset, so check its start before reading on from there.
+			if woken {
+				woken = false
+				if headChanged() {
+					restart()
+				}
+			}
 			// Blocking read but regular files will return EOF straight away.
 			count, err := fd.Read(b)
 			glog.V(2).Infof("%v: read %d bytes, err is %v", fd, count, err)
 
 			if count > 0 {
-				total += count
-				glog.V(2).Infof("%v: decode and send", fd)
-				needSend := lastBytes
-				needSend = append(needSend, b[:count]...)
-				sendCount := decodeAndSend(ctx, fs.lines, fs.pathname, len(needSend), needSend, partial)
-				if sendCount < len(needSend) {
-					lastBytes = append([]byte{}, needSend[sendCount:]...)
-				} else {
-					lastBytes = []byte{}
-				}
-				fs.mu.Lock()
-				fs.lastReadTime = time.Now()
-				fs.mu.Unlock()
+				send(count)
 			}
 
 			if err != nil && err != io.EOF {
@@ -127,8 +266,9 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 				// retryable.
 				if errors.Is(err, syscall.ESTALE) {
 					glog.Infof("%v: reopening stream due to %s", fd, err)
-					if nerr := fs.stream(ctx, wg, waker, fi, true); nerr != nil {
+					if nerr := fs.stream(ctx, wg, waker, fi, 0); nerr != nil {
 						glog.Info(nerr)
+						fs.reopenFailed()
 					}
 					// Close this stream.
 					return
@@ -154,9 +294,8 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 					// detection of IsCompleted.
 					if os.IsNotExist(serr) {
 						glog.V(2).Infof("%v: source no longer exists, exiting", fd)
-						if partial.Len() > 0 {
-							sendLine(ctx, fs.pathname, partial, fs.lines)
-						}
+						drain()
+						fileRemovals.Add(fs.pathname, 1)
 						fs.mu.Lock()
 						fs.completed = true
 						fs.mu.Unlock()
@@ -165,12 +304,16 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 					logErrors.Add(fs.pathname, 1)
 					goto Sleep
 				}
+				// A different inode at the same path means the file has
+				// been rotated; the same inode with a smaller size means it
+				// has been truncated.
 				if !os.SameFile(fi, newfi) {
-					glog.V(2).Infof("%v: adding a new file routine", fd)
-					if err := fs.stream(ctx, wg, waker, newfi, true); err != nil {
+					glog.V(2).Infof("%v: rotated, draining before adding a new file routine", fd)
+					drain()
+					if err := fs.stream(ctx, wg, waker, newfi, 0); err != nil {
 						glog.Info(err)
+						fs.reopenFailed()
 					}
-					// We're at EOF so there's nothing left to read here.
 					return
 				}
 				currentOffset, serr := fd.Seek(0, io.SeekCurrent)
@@ -181,25 +324,17 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 				}
 				glog.V(2).Infof("%v: current seek is %d", fd, currentOffset)
 				glog.V(2).Infof("%v: new size is %d", fd, newfi.Size())
-				// We know that newfi is from the current file.  Truncation can
-				// only be detected if the new file is currently shorter than
-				// the current seek offset.  In test this can be a race, but in
-				// production it's unlikely that a new file writes more bytes
-				// than the previous after rotation in the time it takes for
-				// mtail to notice.
-				if newfi.Size() < currentOffset {
+				// The inode of a removed file can be reused by the next one
+				// created, and a file can be truncated and then written
+				// past the current offset before mtail notices.  Either way
+				// the start of the file is no longer what was read.
+				rewritten := headChanged()
+				// We know that newfi is from the current file.  Truncation is
+				// detected if the new file is currently shorter than the
+				// current seek offset, or its start has changed.
+				if newfi.Size() < currentOffset || rewritten {
 					glog.V(2).Infof("%v: truncate? currentoffset is %d and size is %d", fd, currentOffset, newfi.Size())
-					// About to lose all remaining data because of the truncate so flush the accumulator.
-					if partial.Len() > 0 {
-						sendLine(ctx, fs.pathname, partial, fs.lines)
-					}
-					p, serr := fd.Seek(0, io.SeekStart)
-					if serr != nil {
-						logErrors.Add(fs.pathname, 1)
-						glog.Info(serr)
-					}
-					glog.V(2).Infof("%v: Seeked to %d", fd, p)
-					fileTruncates.Add(fs.pathname, 1)
+					restart()
 					continue
 				}
 			}
@@ -217,18 +352,14 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 				select {
 				case <-fs.stopChan:
 					glog.V(2).Infof("%v: stream has been stopped, exiting", fd)
-					if partial.Len() > 0 {
-						sendLine(ctx, fs.pathname, partial, fs.lines)
-					}
+					flush()
 					fs.mu.Lock()
 					fs.completed = true
 					fs.mu.Unlock()
 					return
 				case <-ctx.Done():
 					glog.V(2).Infof("%v: stream has been cancelled, exiting", fd)
-					if partial.Len() > 0 {
-						sendLine(ctx, fs.pathname, partial, fs.lines)
-					}
+					flush()
 					fs.mu.Lock()
 					fs.completed = true
 					fs.mu.Unlock()
@@ -258,6 +389,7 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 			case <-waker.Wake():
 				// sleep until next Wake()
 				glog.V(2).Infof("%v: Wake received", fd)
+				woken = true
 			}
 		}
 	}()
@@ -266,6 +398,30 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 	return nil
 }
 
+// updateHead returns the fingerprint of the start of fd now, recording it as
+// the stream's, or head if it can't be read.
+func (fs *fileStream) updateHead(fd *os.File, head fingerprint) fingerprint {
+	newHead, err := readFingerprint(fd)
+	if err != nil {
+		logErrors.Add(fs.pathname, 1)
+		glog.Info(err)
+		return head
+	}
+	fs.mu.Lock()
+	fs.head = newHead
+	fs.mu.Unlock()
+	return newHead
+}
+
+// reopenFailed completes the stream when the file couldn't be opened again
+// after a rotation, so that the tailer opens a new stream on the file,
+// retrying until it succeeds.
+func (fs *fileStream) reopenFailed() {
+	fs.mu.Lock()
+	fs.completed = true
+	fs.mu.Unlock()
+}
+
 func (fs *fileStream) IsComplete() bool {
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
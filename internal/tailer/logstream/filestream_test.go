@@ -5,7 +5,10 @@ package logstream_test
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
@@ -50,6 +53,39 @@ func TestFileStreamRead(t *testing.T) {
 	wg.Wait()
 }
 
+func TestFileStreamReadFromStart(t *testing.T) {
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+	testutil.WriteString(t, f, "before\n")
+
+	lines := make(chan *logline.LogLine, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.NewFromStart(ctx, &wg, waker, name, lines)
+	testutil.FatalIfErr(t, err)
+	awaken(1)
+
+	testutil.WriteString(t, f, "after\n")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.TODO(), name, "before"},
+		{context.TODO(), name, "after"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+	cancel()
+	wg.Wait()
+}
+
 func TestFileStreamReadNonSingleByteEnd(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -157,6 +193,8 @@ func TestFileStreamTruncation(t *testing.T) {
 	testutil.FatalIfErr(t, err)
 	awaken(1) // Synchronise past first read after seekToEnd
 
+	truncationsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "log_truncations_total", name, 1)
+
 	testutil.WriteString(t, f, "1\n2\n")
 	awaken(1)
 	testutil.FatalIfErr(t, f.Close())
@@ -166,6 +204,7 @@ func TestFileStreamTruncation(t *testing.T) {
 
 	testutil.WriteString(t, f, "3\n")
 	awaken(1)
+	truncationsCheck()
 
 	fs.Stop()
 	wg.Wait()
@@ -184,6 +223,114 @@ func TestFileStreamTruncation(t *testing.T) {
 	wg.Wait()
 }
 
+// TestFileStreamInodeReuse simulates a log that is removed and recreated with
+// the inode of the old one, and written past the old offset before the stream
+// notices, by rewriting it in place.
+func TestFileStreamInodeReuse(t *testing.T) {
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.OpenLogFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	defer fs.Stop()
+	awaken(1)
+
+	testutil.WriteString(t, f, "1\n2\n")
+	awaken(1)
+	testutil.FatalIfErr(t, f.Close())
+	f = testutil.OpenLogFile(t, name)
+	defer f.Close()
+	testutil.WriteString(t, f, "new 1\nnew 2\n")
+	awaken(1)
+
+	// Appending to the new file carries on from where it was read to.
+	testutil.WriteString(t, f, "new 3\n")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.TODO(), name, "1"},
+		{context.TODO(), name, "2"},
+		{context.TODO(), name, "new 1"},
+		{context.TODO(), name, "new 2"},
+		{context.TODO(), name, "new 3"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	cancel()
+	wg.Wait()
+}
+
+func TestFileStreamResumeInodeReuse(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rewrite  bool
+		expected []string
+	}{
+		{"same file", false, []string{"3"}},
+		{"reused inode", true, []string{"new 1", "new 2", "3"}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			name := filepath.Join(testutil.TestTempDir(t), "log")
+			f := testutil.OpenLogFile(t, name)
+			defer f.Close()
+			testutil.WriteString(t, f, "1\n2\n")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			lines := make(chan *logline.LogLine, 4)
+			w, awaken := waker.NewTest(ctx, 1)
+			fs, err := logstream.New(ctx, &wg, w, name, lines, true)
+			testutil.FatalIfErr(t, err)
+			awaken(1)
+			fs.Stop()
+			wg.Wait()
+			pos := fs.(logstream.Positioner).Position()
+			if pos.HeadSize != 4 {
+				t.Errorf("position fingerprints %d bytes, want 4", pos.HeadSize)
+			}
+
+			if tc.rewrite {
+				// Rewriting the file in place keeps its inode.
+				testutil.FatalIfErr(t, f.Close())
+				f = testutil.OpenLogFile(t, name)
+				defer f.Close()
+				testutil.WriteString(t, f, "new 1\nnew 2\n")
+			}
+			testutil.WriteString(t, f, "3\n")
+
+			lines = make(chan *logline.LogLine, 4)
+			fs, err = logstream.NewFromPosition(ctx, &wg, w, name, lines, pos)
+			testutil.FatalIfErr(t, err)
+			awaken(1)
+			fs.Stop()
+			wg.Wait()
+			close(lines)
+
+			received := testutil.LinesReceived(lines)
+			got := make([]string, 0, len(received))
+			for _, l := range received {
+				got = append(got, l.Line)
+			}
+			testutil.ExpectNoDiff(t, tc.expected, got)
+		})
+	}
+}
+
 func TestFileStreamFinishedBecauseCancel(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -262,3 +409,157 @@ func TestFileStreamPartialRead(t *testing.T) {
 	cancel()
 	wg.Wait()
 }
+
+func TestFileStreamReadLongLine(t *testing.T) {
+	logstream.SetReadBufferSize(64)
+	defer logstream.SetReadBufferSize(logstream.DefaultReadBufferSize)
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	awaken(1)
+
+	long := strings.Repeat("0123456789中", 100)
+	testutil.WriteString(t, f, long+"\nyo\n")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.TODO(), name, long},
+		{context.TODO(), name, "yo"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	cancel()
+	wg.Wait()
+}
+
+func BenchmarkFileStreamRead(b *testing.B) {
+	tmpDir := testutil.TestTempDir(b)
+	name := filepath.Join(tmpDir, "log")
+	line := strings.Repeat("x", 199) + "\n"
+	content := strings.Repeat(line, 50000)
+	testutil.FatalIfErr(b, os.WriteFile(name, []byte(content), 0o600))
+
+	for _, size := range []int{4096, 16384, 65536, 262144} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			logstream.SetReadBufferSize(size)
+			defer logstream.SetReadBufferSize(logstream.DefaultReadBufferSize)
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				lines := make(chan *logline.LogLine, 1000)
+				ctx, cancel := context.WithCancel(context.Background())
+				waker, _ := waker.NewTest(ctx, 1)
+				fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+				testutil.FatalIfErr(b, err)
+				fs.Stop()
+				done := make(chan struct{})
+				go func() {
+					for range lines {
+					}
+					close(done)
+				}()
+				wg.Wait()
+				close(lines)
+				<-done
+				cancel()
+			}
+		})
+	}
+}
+
+func TestFileStreamReadLogEncoding(t *testing.T) {
+	testutil.FatalIfErr(t, logstream.SetLogEncoding("ISO-8859-1"))
+	defer func() { testutil.FatalIfErr(t, logstream.SetLogEncoding("")) }()
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	awaken(1)
+
+	testutil.WriteString(t, f, "caf\xe9 cr\xe8me\r\nna\xefve")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.TODO(), name, "café crème"},
+		{context.TODO(), name, "naïve"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	cancel()
+	wg.Wait()
+}
+
+func TestFileStreamReadLogEncodingInvalid(t *testing.T) {
+	testutil.FatalIfErr(t, logstream.SetLogEncoding("Shift_JIS"))
+	defer func() { testutil.FatalIfErr(t, logstream.SetLogEncoding("")) }()
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	awaken(1)
+
+	testutil.WriteString(t, f, "\x82\xa0\x81 ok\n")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.TODO(), name, "あ\ufffd ok"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	cancel()
+	wg.Wait()
+}
+
+func TestSetLogEncoding(t *testing.T) {
+	defer func() { testutil.FatalIfErr(t, logstream.SetLogEncoding("")) }()
+	for _, name := range []string{"ISO-8859-1", "windows-1252", "Shift_JIS", "UTF-8", ""} {
+		if err := logstream.SetLogEncoding(name); err != nil {
+			t.Errorf("SetLogEncoding(%q): unexpected error %s", name, err)
+		}
+	}
+	for _, name := range []string{"UTF-16", "no-such-encoding"} {
+		if err := logstream.SetLogEncoding(name); err == nil {
+			t.Errorf("SetLogEncoding(%q): expected error", name)
+		}
+	}
+}
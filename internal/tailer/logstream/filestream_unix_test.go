@@ -77,6 +77,59 @@ func TestFileStreamRotation(t *testing.T) {
 	wg.Wait()
 }
 
+func TestFileStreamRotationReadsOldFileToEnd(t *testing.T) {
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	defer fs.Stop()
+	awaken(1)
+
+	testutil.WriteString(t, f, "1\n")
+	awaken(1)
+
+	// The writer keeps appending to the old file around the rotation, and
+	// a new file is created and written to before the stream is woken.
+	testutil.WriteString(t, f, "2\n")
+	testutil.FatalIfErr(t, os.Rename(name, name+".1"))
+	testutil.WriteString(t, f, "3\n")
+	newF := testutil.TestOpenFile(t, name)
+	defer newF.Close()
+	testutil.WriteString(t, newF, "4\n")
+	testutil.WriteString(t, f, "5\n")
+	awaken(1)
+	// Wait for the stream on the new file to be idle.
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.TODO(), name, "1"},
+		{context.TODO(), name, "2"},
+		{context.TODO(), name, "3"},
+		{context.TODO(), name, "5"},
+		{context.TODO(), name, "4"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	cancel()
+	wg.Wait()
+}
+
 func TestFileStreamURL(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -138,3 +191,41 @@ func TestFileStreamOpenFailure(t *testing.T) {
 	}
 	cancel()
 }
+
+// TestFileStreamRotationUnreadable checks that a stream whose file is rotated
+// to one it can't open completes, so the tailer can retry opening it.
+func TestFileStreamRotationUnreadable(t *testing.T) {
+	// Can't force a permission denied error if run as root.
+	testutil.SkipIfRoot(t)
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	waker, awaken := waker.NewTest(ctx, 1)
+
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	defer fs.Stop()
+	awaken(1)
+
+	testutil.FatalIfErr(t, os.Rename(name, name+".1"))
+	g, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0)
+	testutil.FatalIfErr(t, err)
+	defer g.Close()
+
+	// The stream is woken at most once more, as it stops waiting once it
+	// finds it can't open the new file.
+	go awaken(1)
+	wg.Wait()
+	if !fs.IsComplete() {
+		t.Error("stream not complete after failing to open the rotated file")
+	}
+}
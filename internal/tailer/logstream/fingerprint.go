@@ -0,0 +1,63 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// fingerprintSize is the most bytes from the start of a file that are hashed
+// into its fingerprint.
+const fingerprintSize = 256
+
+// fingerprint identifies the contents of a file by a hash of its first bytes,
+// so that a new file given the inode of a removed one, or a file rewritten in
+// place, can be told apart from the file that was read.
+type fingerprint struct {
+	size int    // Number of bytes hashed, up to fingerprintSize.
+	sum  uint64 // FNV-1a hash of those bytes.
+}
+
+// readFingerprint returns the fingerprint of the first bytes of f, which is
+// shorter than fingerprintSize if f is.
+func readFingerprint(f io.ReaderAt) (fingerprint, error) {
+	b := make([]byte, fingerprintSize)
+	n, err := f.ReadAt(b, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fingerprint{}, err
+	}
+	h := fnv.New64a()
+	h.Write(b[:n])
+	return fingerprint{n, h.Sum64()}, nil
+}
+
+// matches returns true if the file f begins with the bytes fp was taken from.
+// A file shorter than those bytes doesn't match.
+func (fp fingerprint) matches(f io.ReaderAt) (bool, error) {
+	b := make([]byte, fp.size)
+	n, err := f.ReadAt(b, 0)
+	if n < fp.size {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		return false, nil
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64() == fp.sum, nil
+}
+
+// fileFingerprintMatches returns true if the file at pathname begins with the
+// bytes fp was taken from.
+func fileFingerprintMatches(pathname string, fp fingerprint) (bool, error) {
+	f, err := os.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return fp.matches(f)
+}
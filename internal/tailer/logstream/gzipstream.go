@@ -0,0 +1,128 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/logline"
+)
+
+// GzipSuffix is the filename suffix that marks a log as gzip-compressed.
+const GzipSuffix = ".gz"
+
+// gzipStream reads a gzip-compressed log file once from its start.
+// Compressed logs are usually the result of rotation, and are never appended
+// to, so they are not followed after EOF.
+type gzipStream struct {
+	ctx   context.Context
+	lines chan<- *logline.LogLine
+
+	pathname string // Given name for the underlying file on the filesystem
+
+	mu           sync.RWMutex // protects following fields
+	completed    bool         // This gzipStream is completed and can no longer be used.
+	lastReadTime time.Time    // Last time a log line was read from this file
+}
+
+func newGzipStream(ctx context.Context, wg *sync.WaitGroup, pathname string, lines chan<- *logline.LogLine) (LogStream, error) {
+	gs := &gzipStream{ctx: ctx, pathname: pathname, lastReadTime: time.Now(), lines: lines}
+	if err := gs.stream(ctx, wg); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+func (gs *gzipStream) LastReadTime() time.Time {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.lastReadTime
+}
+
+func (gs *gzipStream) stream(ctx context.Context, wg *sync.WaitGroup) error {
+	fd, err := os.OpenFile(gs.pathname, os.O_RDONLY, 0o600)
+	if err != nil {
+		logErrors.Add(gs.pathname, 1)
+		return err
+	}
+	logOpens.Add(gs.pathname, 1)
+	glog.V(2).Infof("%v: opened new compressed file", fd)
+	b := newReadBuffer()
+	partial := bytes.NewBufferString("")
+	var total int
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			glog.V(2).Infof("%v: read total %d bytes from %s", fd, total, gs.pathname)
+			if err := fd.Close(); err != nil {
+				logErrors.Add(gs.pathname, 1)
+				glog.Info(err)
+			}
+			logCloses.Add(gs.pathname, 1)
+			gs.mu.Lock()
+			gs.completed = true
+			gs.mu.Unlock()
+		}()
+		// A corrupt or truncated file is logged and abandoned, keeping any
+		// lines that were decompressed before the error.
+		zr, err := gzip.NewReader(fd)
+		if err != nil {
+			logErrors.Add(gs.pathname, 1)
+			glog.Infof("%s: %s", gs.pathname, err)
+			return
+		}
+		for {
+			n, err := zr.Read(b)
+			glog.V(2).Infof("%v: read %d bytes, err is %v", fd, n, err)
+
+			if n > 0 {
+				total += n
+				logBytes.Add(gs.pathname, int64(n))
+				//nolint:contextcheck
+				decodeAndSend(gs.ctx, gs.lines, gs.pathname, n, b[:n], partial)
+				gs.mu.Lock()
+				gs.lastReadTime = time.Now()
+				gs.mu.Unlock()
+			}
+
+			if err != nil {
+				if partial.Len() > 0 {
+					sendLine(ctx, gs.pathname, partial, gs.lines)
+				}
+				if !errors.Is(err, io.EOF) {
+					logErrors.Add(gs.pathname, 1)
+					glog.Infof("%s: %s", gs.pathname, err)
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+func (gs *gzipStream) IsComplete() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.completed
+}
+
+// Stop implements the LogStream interface.
+// Calling Stop on a gzipStream is a no-op; it always reads until EOF.
+func (gs *gzipStream) Stop() {
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestGzipStreamRead(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		trim int // Bytes removed from the end of the compressed file.
+	}{
+		{"complete", 0},
+		// Cutting the CRC and size trailer leaves the data readable.
+		{"truncated trailer", 8},
+	} {
+		tc := tc
+		t.Run(tc.name, testutil.TimeoutTest(1*time.Second, func(t *testing.T) { //nolint:thelper
+			var wg sync.WaitGroup
+
+			tmpDir := testutil.TestTempDir(t)
+			name := filepath.Join(tmpDir, "log.1.gz")
+			b := gzipBytes(t, "1\n2\n")
+			testutil.FatalIfErr(t, os.WriteFile(name, b[:len(b)-tc.trim], 0o600))
+
+			lines := make(chan *logline.LogLine, 2)
+			ctx, cancel := context.WithCancel(context.Background())
+			waker := waker.NewTestAlways()
+
+			gs, err := logstream.New(ctx, &wg, waker, name, lines, false)
+			testutil.FatalIfErr(t, err)
+
+			wg.Wait()
+			close(lines)
+
+			received := testutil.LinesReceived(lines)
+			expected := []*logline.LogLine{
+				{context.TODO(), name, "1"},
+				{context.TODO(), name, "2"},
+			}
+			testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+			cancel()
+
+			if !gs.IsComplete() {
+				t.Errorf("expecting gzipstream to be complete after EOF")
+			}
+		}))
+	}
+}
+
+func TestGzipStreamCorruptHeader(t *testing.T) {
+	testutil.TimeoutTest(1*time.Second, func(t *testing.T) { //nolint:thelper
+		var wg sync.WaitGroup
+
+		tmpDir := testutil.TestTempDir(t)
+		name := filepath.Join(tmpDir, "log.1.gz")
+		testutil.FatalIfErr(t, os.WriteFile(name, []byte("not gzip\n"), 0o600))
+
+		lines := make(chan *logline.LogLine, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		waker := waker.NewTestAlways()
+
+		gs, err := logstream.New(ctx, &wg, waker, name, lines, false)
+		testutil.FatalIfErr(t, err)
+
+		wg.Wait()
+		close(lines)
+
+		if received := testutil.LinesReceived(lines); len(received) != 0 {
+			t.Errorf("expecting no lines from corrupt file, received %v", received)
+		}
+		if !gs.IsComplete() {
+			t.Errorf("expecting gzipstream to be complete after error")
+		}
+	})(t)
+}
@@ -15,7 +15,9 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
@@ -39,8 +41,58 @@ type LogStream interface {
 	IsComplete() bool        // True if the logstream has completed work and cannot recover.  The caller should clean up this logstream, creating a new logstream on a pathname if necessary.
 }
 
-// defaultReadBufferSize the size of the buffer for reading bytes into.
-const defaultReadBufferSize = 4096
+// Position is the point in a file that a stream has read up to, which a new
+// stream can resume reading from.
+type Position struct {
+	Inode  uint64 // File serial number of the file read, or zero if the platform has none.
+	Offset int64  // Offset in the file after the last complete line read.
+	// HeadSize and HeadSum fingerprint the start of the file read, so that a
+	// different file that reuses its inode isn't resumed from Offset.
+	HeadSize int    `json:",omitempty"`
+	HeadSum  uint64 `json:",omitempty"`
+}
+
+// Positioner is implemented by log streams that can report their Position.
+type Positioner interface {
+	Position() Position
+}
+
+// Follower is implemented by log streams that can report whether they are
+// reading a file, or have just been rotated away from it.
+type Follower interface {
+	Follows(fi os.FileInfo) bool
+}
+
+// DefaultReadBufferSize is the size of the buffer for reading bytes into,
+// unless SetReadBufferSize is called.
+const DefaultReadBufferSize = 4096
+
+// minReadBufferSize is the smallest buffer used, whatever size is set, so
+// that the decoder always has room for a few complete runes.
+const minReadBufferSize = 64
+
+// readBufferSize is the size of the buffer each new stream reads into, or
+// zero for DefaultReadBufferSize.
+var readBufferSize atomic.Int64
+
+// SetReadBufferSize sets the size in bytes of the buffer that each stream
+// created afterwards reads into.  Sizes smaller than 64 bytes are rounded up.
+func SetReadBufferSize(size int) {
+	readBufferSize.Store(int64(size))
+}
+
+// newReadBuffer returns a buffer of the size set by SetReadBufferSize for a
+// stream to read bytes into.
+func newReadBuffer() []byte {
+	size := int(readBufferSize.Load())
+	if size == 0 {
+		size = DefaultReadBufferSize
+	}
+	if size < minReadBufferSize {
+		size = minReadBufferSize
+	}
+	return make([]byte, size)
+}
 
 var (
 	ErrUnsupportedURLScheme = errors.New("unsupported URL scheme")
@@ -52,8 +104,33 @@ var (
 // `pathname`.  The LogStream will watch `ctx` for a cancellation signal, and
 // notify the `wg` when it is Done.  Log lines will be sent to the `lines`
 // channel.  `seekToStart` is only used for testing and only works for regular
-// files that can be seeked.
+// files that can be seeked.  The pathname StdinPathname reads the standard
+// input of the process until EOF.
 func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, lines chan<- *logline.LogLine, oneShot bool) (LogStream, error) {
+	return newLogStream(ctx, wg, waker, pathname, lines, oneShot, false, nil)
+}
+
+// NewFromPosition creates a LogStream like New, except that a regular file is
+// read from the Position `pos` that an earlier stream on the same pathname
+// reached.  If the file has been replaced or truncated since then, it is
+// read from the start.
+func NewFromPosition(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, lines chan<- *logline.LogLine, pos Position) (LogStream, error) {
+	return newLogStream(ctx, wg, waker, pathname, lines, false, false, &pos)
+}
+
+// NewFromStart creates a LogStream like New, except that a regular file is
+// read from its start rather than its end.
+func NewFromStart(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, lines chan<- *logline.LogLine) (LogStream, error) {
+	return newLogStream(ctx, wg, waker, pathname, lines, false, true, nil)
+}
+
+// newLogStream creates the LogStream for pathname.  A regular file is read
+// from its start if oneShot or fromStart is set, else from pos if it's not
+// nil, else from its end.
+func newLogStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, lines chan<- *logline.LogLine, oneShot, fromStart bool, pos *Position) (LogStream, error) {
+	if pathname == StdinPathname {
+		return newStdinStream(ctx, wg, lines)
+	}
 	u, err := url.Parse(pathname)
 	if err != nil {
 		return nil, err
@@ -65,13 +142,15 @@ func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname st
 	default:
 		glog.V(2).Infof("%v: %q in path pattern %q, treating as path", ErrUnsupportedURLScheme, u.Scheme, pathname)
 	case "unixgram":
-		return newDgramStream(ctx, wg, waker, u.Scheme, u.Path, lines)
+		return newDgramStream(ctx, wg, waker, u.Scheme, socketPath(u), lines)
 	case "unix":
-		return newSocketStream(ctx, wg, waker, u.Scheme, u.Path, lines, oneShot)
+		return newSocketStream(ctx, wg, waker, u.Scheme, socketPath(u), lines, oneShot)
 	case "tcp":
 		return newSocketStream(ctx, wg, waker, u.Scheme, u.Host, lines, oneShot)
 	case "udp":
 		return newDgramStream(ctx, wg, waker, u.Scheme, u.Host, lines)
+	case "syslog":
+		return newSyslogStream(ctx, wg, u.Host, lines)
 	case "", "file":
 		path = u.Path
 	}
@@ -81,8 +160,18 @@ func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname st
 		return nil, err
 	}
 	switch m := fi.Mode(); {
+	case m.IsRegular() && strings.HasSuffix(path, GzipSuffix):
+		return newGzipStream(ctx, wg, path, lines)
 	case m.IsRegular():
-		return newFileStream(ctx, wg, waker, path, fi, lines, oneShot)
+		var offset int64 = seekToEnd
+		switch {
+		case oneShot, fromStart:
+			offset = 0
+		case pos != nil:
+			offset = resumeOffset(path, fi, *pos)
+			glog.V(2).Infof("%s: resuming at offset %d", path, offset)
+		}
+		return newFileStream(ctx, wg, waker, path, fi, lines, offset)
 	case m&os.ModeType == os.ModeNamedPipe:
 		return newPipeStream(ctx, wg, waker, path, fi, lines)
 	// TODO(jaq): in order to listen on an existing socket filepath, we must unlink and recreate it
@@ -92,3 +181,34 @@ func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname st
 		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFileType, pathname)
 	}
 }
+
+// resumeOffset returns the offset to resume reading the file fi at path from,
+// from the Position a previous stream reached.  A file that doesn't start
+// with the same bytes as the file the stream read is read from the start,
+// even though it has the same inode.
+func resumeOffset(path string, fi os.FileInfo, pos Position) int64 {
+	if fileID(fi) != pos.Inode || fi.Size() < pos.Offset {
+		return 0
+	}
+	if pos.HeadSize > 0 {
+		ok, err := fileFingerprintMatches(path, fingerprint{pos.HeadSize, pos.HeadSum})
+		if err != nil {
+			glog.Info(err)
+		}
+		if !ok {
+			glog.V(2).Infof("%s: inode reused by a new file, reading from the start", path)
+			return 0
+		}
+	}
+	return pos.Offset
+}
+
+// socketPath returns the filesystem path of a unix domain socket URL.  Both
+// `unix:///run/log.sock` and `unix:/run/log.sock` name an absolute path, and
+// `unix:log.sock` names a path relative to the working directory.
+func socketPath(u *url.URL) string {
+	if u.Path == "" {
+		return u.Opaque
+	}
+	return u.Path
+}
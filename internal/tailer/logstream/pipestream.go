@@ -49,7 +49,7 @@ func (ps *pipeStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 		return err
 	}
 	glog.V(2).Infof("opened new pipe %v", fd)
-	b := make([]byte, defaultReadBufferSize)
+	b := newReadBuffer()
 	partial := bytes.NewBufferString("")
 	var total int
 	wg.Add(1)
@@ -78,6 +78,7 @@ func (ps *pipeStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 
 			if n > 0 {
 				total += n
+				logBytes.Add(ps.pathname, int64(n))
 				//nolint:contextcheck
 				decodeAndSend(ps.ctx, ps.lines, ps.pathname, n, b[:n], partial)
 				// Update the last read time if we were able to read anything.
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -50,6 +51,12 @@ func (ss *socketStream) LastReadTime() time.Time {
 
 // stream starts goroutines to read data from the stream socket, until Stop is called or the context is cancelled.
 func (ss *socketStream) stream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker) error {
+	if ss.scheme == "unix" {
+		if err := removeStaleSocket(ss.address); err != nil {
+			logErrors.Add(ss.address, 1)
+			return err
+		}
+	}
 	l, err := net.Listen(ss.scheme, ss.address)
 	if err != nil {
 		logErrors.Add(ss.address, 1)
@@ -118,9 +125,28 @@ func (ss *socketStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wa
 	return nil
 }
 
+// removeStaleSocket removes the unix domain socket at pathname if nothing is
+// listening on it, such as one left behind by a previous mtail that did not
+// exit cleanly, so that the listener can be recreated.  A socket that accepts
+// connections is left in place.
+func removeStaleSocket(pathname string) error {
+	fi, err := os.Lstat(pathname)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		// Let Listen report a missing directory or a file in the way.
+		return nil
+	}
+	c, err := net.Dial("unix", pathname)
+	if err == nil {
+		_ = c.Close()
+		return nil
+	}
+	glog.Infof("Removing stale socket %s", pathname)
+	return os.Remove(pathname)
+}
+
 func (ss *socketStream) handleConn(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, c net.Conn) {
 	defer wg.Done()
-	b := make([]byte, defaultReadBufferSize)
+	b := newReadBuffer()
 	partial := bytes.NewBufferString("")
 	var total int
 	defer func() {
@@ -143,6 +169,7 @@ func (ss *socketStream) handleConn(ctx context.Context, wg *sync.WaitGroup, wake
 
 		if n > 0 {
 			total += n
+			logBytes.Add(ss.address, int64(n))
 			//nolint:contextcheck
 			decodeAndSend(ss.ctx, ss.lines, ss.address, n, b[:n], partial)
 			ss.mu.Lock()
@@ -125,3 +125,88 @@ func TestSocketStreamReadCompletedBecauseCancel(t *testing.T) {
 		}))
 	}
 }
+
+func TestSocketStreamMultipleConnections(t *testing.T) {
+	testutil.TimeoutTest(time.Second, func(t *testing.T) { //nolint:thelper
+		var wg sync.WaitGroup
+		addr := filepath.Join(testutil.TestTempDir(t), "sock")
+		lines := make(chan *logline.LogLine, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		waker, _ := waker.NewTest(ctx, 1)
+
+		ss, err := logstream.New(ctx, &wg, waker, "unix:"+addr, lines, false)
+		testutil.FatalIfErr(t, err)
+
+		var received []*logline.LogLine
+		send := func(s net.Conn, line string) {
+			t.Helper()
+			_, err := s.Write([]byte(line + "\n"))
+			testutil.FatalIfErr(t, err)
+			received = append(received, <-lines)
+		}
+		s1, err := net.Dial("unix", addr)
+		testutil.FatalIfErr(t, err)
+		s2, err := net.Dial("unix", addr)
+		testutil.FatalIfErr(t, err)
+		send(s1, "1")
+		send(s2, "2")
+
+		// A client that disconnects can connect again.
+		testutil.FatalIfErr(t, s1.Close())
+		s3, err := net.Dial("unix", addr)
+		testutil.FatalIfErr(t, err)
+		send(s3, "3")
+		testutil.FatalIfErr(t, s2.Close())
+		testutil.FatalIfErr(t, s3.Close())
+
+		cancel()
+		wg.Wait()
+
+		expected := []*logline.LogLine{
+			{context.TODO(), addr, "1"},
+			{context.TODO(), addr, "2"},
+			{context.TODO(), addr, "3"},
+		}
+		testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+		if !ss.IsComplete() {
+			t.Errorf("expecting socketstream to be complete because cancel")
+		}
+	})(t)
+}
+
+func TestSocketStreamReplacesStaleSocket(t *testing.T) {
+	var wg sync.WaitGroup
+	addr := filepath.Join(testutil.TestTempDir(t), "sock")
+
+	// Leave a socket file behind with nothing listening on it.
+	l, err := net.Listen("unix", addr)
+	testutil.FatalIfErr(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	testutil.FatalIfErr(t, l.Close())
+
+	lines := make(chan *logline.LogLine, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, _ := waker.NewTest(ctx, 1)
+	_, err = logstream.New(ctx, &wg, waker, "unix://"+addr, lines, false)
+	testutil.FatalIfErr(t, err)
+	cancel()
+	wg.Wait()
+}
+
+func TestSocketStreamKeepsLiveSocket(t *testing.T) {
+	var wg sync.WaitGroup
+	addr := filepath.Join(testutil.TestTempDir(t), "sock")
+
+	l, err := net.Listen("unix", addr)
+	testutil.FatalIfErr(t, err)
+	defer l.Close()
+
+	lines := make(chan *logline.LogLine, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	waker, _ := waker.NewTest(ctx, 1)
+	if _, err := logstream.New(ctx, &wg, waker, "unix://"+addr, lines, false); err == nil {
+		t.Error("expected error listening on a socket that is in use")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/logline"
+)
+
+// StdinPathname is the log pathname that names the standard input of the
+// process.
+const StdinPathname = "-"
+
+type stdinStream struct {
+	ctx   context.Context
+	lines chan<- *logline.LogLine
+
+	mu           sync.RWMutex // protects following fields
+	completed    bool         // This stdinStream is completed and can no longer be used.
+	lastReadTime time.Time    // Last time a log line was read from stdin
+}
+
+func newStdinStream(ctx context.Context, wg *sync.WaitGroup, lines chan<- *logline.LogLine) (LogStream, error) {
+	ss := &stdinStream{ctx: ctx, lastReadTime: time.Now(), lines: lines}
+	ss.stream(ctx, wg, os.Stdin)
+	return ss, nil
+}
+
+func (ss *stdinStream) LastReadTime() time.Time {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.lastReadTime
+}
+
+// stream reads from fd until EOF.  There is nothing to watch for, so reads
+// block until data arrives rather than waiting for a wakeup.
+func (ss *stdinStream) stream(ctx context.Context, wg *sync.WaitGroup, fd *os.File) {
+	logOpens.Add(StdinPathname, 1)
+	b := newReadBuffer()
+	partial := bytes.NewBufferString("")
+	var total int
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			glog.V(2).Infof("read total %d bytes from stdin", total)
+			logCloses.Add(StdinPathname, 1)
+			ss.mu.Lock()
+			ss.completed = true
+			ss.mu.Unlock()
+		}()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		// Deadlines are only supported when stdin is a pipe, so a cancelled
+		// read on a terminal or regular file completes at the next read.
+		SetReadDeadlineOnDone(ctx, fd)
+
+		for {
+			n, err := fd.Read(b)
+			glog.V(2).Infof("stdin: read %d bytes, err is %v", n, err)
+
+			if n > 0 {
+				total += n
+				logBytes.Add(StdinPathname, int64(n))
+				//nolint:contextcheck
+				decodeAndSend(ss.ctx, ss.lines, StdinPathname, n, b[:n], partial)
+				ss.mu.Lock()
+				ss.lastReadTime = time.Now()
+				ss.mu.Unlock()
+			}
+
+			if err != nil {
+				if partial.Len() > 0 {
+					sendLine(ctx, StdinPathname, partial, ss.lines)
+				}
+				if !IsEndOrCancel(err) {
+					logErrors.Add(StdinPathname, 1)
+					glog.Info(err)
+				}
+				glog.V(2).Infof("stdin: exiting, stream has error %s", err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+}
+
+func (ss *stdinStream) IsComplete() bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.completed
+}
+
+// Stop implements the LogStream interface.
+// Calling Stop on a stdinStream is a no-op; it always reads until EOF.
+func (ss *stdinStream) Stop() {
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+)
+
+func TestStdinStreamReadCompletedBecauseClosed(t *testing.T) {
+	testutil.TimeoutTest(1*time.Second, func(t *testing.T) { //nolint:thelper
+		var wg sync.WaitGroup
+
+		r, w, err := os.Pipe()
+		testutil.FatalIfErr(t, err)
+		stdin := os.Stdin
+		os.Stdin = r
+		defer func() { os.Stdin = stdin }()
+
+		lines := make(chan *logline.LogLine, 2)
+		ctx, cancel := context.WithCancel(context.Background())
+		waker := waker.NewTestAlways()
+
+		ss, err := logstream.New(ctx, &wg, waker, logstream.StdinPathname, lines, false)
+		testutil.FatalIfErr(t, err)
+
+		testutil.WriteString(t, w, "1\n2")
+		testutil.FatalIfErr(t, w.Close())
+
+		ss.Stop() // no-op for stdin
+		wg.Wait()
+		close(lines)
+
+		received := testutil.LinesReceived(lines)
+		expected := []*logline.LogLine{
+			{context.TODO(), logstream.StdinPathname, "1"},
+			{context.TODO(), logstream.StdinPathname, "2"},
+		}
+		testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+		cancel()
+
+		if !ss.IsComplete() {
+			t.Errorf("expecting stdinstream to be complete because stdin closed")
+		}
+	})(t)
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var errMalformedSyslog = errors.New("malformed syslog message")
+
+// syslogMessage is the part of a syslog message that is passed on to programs.
+type syslogMessage struct {
+	facility int
+	severity int
+	hostname string
+	msg      string
+}
+
+// fields returns the structured fields of the message, named as the capture
+// group references that programs use to read them.
+func (m syslogMessage) fields() map[string]string {
+	return map[string]string{
+		"syslog_facility": strconv.Itoa(m.facility),
+		"syslog_severity": strconv.Itoa(m.severity),
+		"syslog_hostname": m.hostname,
+	}
+}
+
+// parseSyslog parses a single syslog message in either the RFC 5424 format,
+// or the older BSD format described by RFC 3164.  The message of an RFC 3164
+// frame includes its tag, so it reads like a line written to a log file by
+// syslogd.
+func parseSyslog(b []byte) (syslogMessage, error) {
+	var m syslogMessage
+	b = bytes.TrimRight(b, "\r\n\x00")
+	pri, rest, err := parsePri(b)
+	if err != nil {
+		return m, err
+	}
+	m.facility, m.severity = pri/8, pri%8
+	if len(rest) >= 2 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+		return parseRFC5424(m, rest[2:])
+	}
+	return parseRFC3164(m, rest), nil
+}
+
+// parsePri parses the <PRI> at the start of a message.
+func parsePri(b []byte) (int, []byte, error) {
+	if len(b) < 3 || b[0] != '<' {
+		return 0, nil, errMalformedSyslog
+	}
+	// The PRI value is at most three digits.
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return 0, nil, errMalformedSyslog
+	}
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, nil, errMalformedSyslog
+	}
+	return pri, b[end+1:], nil
+}
+
+// parseRFC5424 parses the header after the version of an RFC 5424 message:
+//
+//	TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func parseRFC5424(m syslogMessage, b []byte) (syslogMessage, error) {
+	var header [5][]byte
+	for i := range header {
+		sp := bytes.IndexByte(b, ' ')
+		if sp < 1 {
+			return m, errMalformedSyslog
+		}
+		header[i], b = b[:sp], b[sp+1:]
+	}
+	if hostname := string(header[1]); hostname != "-" {
+		m.hostname = hostname
+	}
+	rest, err := skipStructuredData(b)
+	if err != nil {
+		return m, err
+	}
+	if len(rest) > 0 {
+		if rest[0] != ' ' {
+			return m, errMalformedSyslog
+		}
+		rest = bytes.TrimPrefix(rest[1:], []byte("\xef\xbb\xbf"))
+	}
+	m.msg = string(rest)
+	return m, nil
+}
+
+// skipStructuredData returns the remainder of b after the STRUCTURED-DATA
+// field, which is either a nil value or a sequence of bracketed elements.
+func skipStructuredData(b []byte) ([]byte, error) {
+	if len(b) > 0 && b[0] == '-' {
+		return b[1:], nil
+	}
+	if len(b) == 0 || b[0] != '[' {
+		return nil, errMalformedSyslog
+	}
+	inValue := false
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case inValue && c == '\\':
+			i++
+		case c == '"':
+			inValue = !inValue
+		case !inValue && c == ']':
+			if i+1 == len(b) || b[i+1] != '[' {
+				return b[i+1:], nil
+			}
+		}
+	}
+	return nil, errMalformedSyslog
+}
+
+// parseRFC3164 parses the timestamp and hostname of a BSD syslog message.  As
+// a relay would, a message without a recognisable timestamp is taken to be
+// all message.
+func parseRFC3164(m syslogMessage, b []byte) syslogMessage {
+	const stampLen = len(time.Stamp)
+	if len(b) > stampLen && b[stampLen] == ' ' {
+		if _, err := time.Parse(time.Stamp, string(b[:stampLen])); err == nil {
+			b = b[stampLen+1:]
+			if sp := bytes.IndexByte(b, ' '); sp > 0 {
+				m.hostname, b = string(b[:sp]), b[sp+1:]
+			}
+		}
+	}
+	m.msg = string(b)
+	return m
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+var parseSyslogTests = []struct {
+	name  string
+	frame string
+	want  syslogMessage
+}{
+	{
+		"rfc3164",
+		"<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8",
+		syslogMessage{4, 2, "mymachine", "su[123]: 'su root' failed for lonvick on /dev/pts/8"},
+	},
+	{
+		"rfc3164 without timestamp",
+		"<13>something happened\n",
+		syslogMessage{1, 5, "", "something happened"},
+	},
+	{
+		"rfc5424",
+		"<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\"] \xef\xbb\xbfAn application event log entry...",
+		syslogMessage{20, 5, "mymachine.example.com", "An application event log entry..."},
+	},
+	{
+		"rfc5424 escaped structured data",
+		`<14>1 - host app - - [a@1 x="\"]"][b@1] msg`,
+		syslogMessage{1, 6, "host", "msg"},
+	},
+	{
+		"rfc5424 no structured data or message",
+		"<0>1 2003-10-11T22:14:15Z - - - - -",
+		syslogMessage{0, 0, "", ""},
+	},
+}
+
+func TestParseSyslog(t *testing.T) {
+	for _, tc := range parseSyslogTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSyslog([]byte(tc.frame))
+			testutil.FatalIfErr(t, err)
+			testutil.ExpectNoDiff(t, tc.want, got, testutil.AllowUnexported(syslogMessage{}))
+		})
+	}
+}
+
+func TestParseSyslogMalformed(t *testing.T) {
+	for _, frame := range []string{
+		"no priority",
+		"<>empty priority",
+		"<192>priority out of range",
+		"<1a>bad priority",
+		"<1234>long priority",
+		"<14>1 2003-10-11T22:14:15Z host",
+		"<14>1 2003-10-11T22:14:15Z host app - - [unterminated",
+		"<14>1 2003-10-11T22:14:15Z host app - - -msg",
+	} {
+		if m, err := parseSyslog([]byte(frame)); err == nil {
+			t.Errorf("parseSyslog(%q) = %+v, expected error", frame, m)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("5 <1>a <2>b\n<3>c"))
+	for _, want := range []string{"<1>a ", "<2>b\n", "<3>c"} {
+		frame, err := readSyslogFrame(r)
+		if err != nil && !errors.Is(err, io.EOF) {
+			t.Fatal(err)
+		}
+		testutil.ExpectNoDiff(t, want, string(frame))
+	}
+}
+
+func TestReadSyslogFrameTooLong(t *testing.T) {
+	for name, stream := range map[string]string{
+		"count":        fmt.Sprintf("%d <1>a", maxSyslogFrameSize+1),
+		"count digits": strings.Repeat("9", maxSyslogFrameSize),
+		"zero count":   "0 <1>a",
+		"line":         "<1>" + strings.Repeat("a", maxSyslogFrameSize) + "\n",
+	} {
+		if frame, err := readSyslogFrame(bufio.NewReader(strings.NewReader(stream))); !errors.Is(err, errMalformedSyslog) {
+			t.Errorf("%s: readSyslogFrame() = %d bytes, %v, expected malformed", name, len(frame), err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/logline"
+)
+
+// syslogMalformed counts the syslog frames dropped because they could not be parsed, per listening address.
+var syslogMalformed = expvar.NewMap("syslog_malformed_messages_total")
+
+// maxSyslogFrameSize bounds the length of a frame received over TCP, whether
+// octet-counted or newline-terminated.
+const maxSyslogFrameSize = datagramReadBufferSize
+
+// syslogRetryDelay is how long to wait before reading the UDP socket again
+// after a temporary error.
+const syslogRetryDelay = 100 * time.Millisecond
+
+// syslogStream receives syslog messages on a UDP socket and a TCP listener
+// bound to the same address, and sends the message body of each as a line.
+type syslogStream struct {
+	ctx   context.Context
+	lines chan<- *logline.LogLine
+
+	address string // Host and port to listen on.
+
+	mu           sync.RWMutex // protects following fields
+	completed    bool         // This syslogStream is completed and can no longer be used.
+	lastReadTime time.Time    // Last time a message was read from this stream
+
+	stopOnce sync.Once     // Ensure stopChan only closed once.
+	stopChan chan struct{} // Close to start graceful shutdown.
+}
+
+func newSyslogStream(ctx context.Context, wg *sync.WaitGroup, address string, lines chan<- *logline.LogLine) (LogStream, error) {
+	if address == "" {
+		return nil, ErrEmptySocketAddress
+	}
+	ss := &syslogStream{ctx: ctx, address: address, lastReadTime: time.Now(), lines: lines, stopChan: make(chan struct{})}
+	if err := ss.stream(ctx, wg); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+func (ss *syslogStream) LastReadTime() time.Time {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.lastReadTime
+}
+
+// stream starts goroutines to receive messages until Stop is called or the context is cancelled.
+func (ss *syslogStream) stream(ctx context.Context, wg *sync.WaitGroup) error {
+	pc, err := net.ListenPacket("udp", ss.address)
+	if err != nil {
+		logErrors.Add(ss.address, 1)
+		return err
+	}
+	// Listen for TCP on the port UDP was given, in case the address let the
+	// system choose one.
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		logErrors.Add(ss.address, 1)
+		_ = pc.Close()
+		return err
+	}
+	glog.V(2).Infof("opened new syslog listeners %v and %v", pc.LocalAddr(), l.Addr())
+	logOpens.Add(ss.address, 1)
+
+	// Set up for shutdown.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+		case <-ss.stopChan:
+		}
+		glog.V(2).Infof("%s: closing syslog listeners", ss.address)
+		if err := pc.Close(); err != nil {
+			glog.Info(err)
+		}
+		if err := l.Close(); err != nil {
+			glog.Info(err)
+		}
+		logCloses.Add(ss.address, 1)
+		ss.mu.Lock()
+		ss.completed = true
+		ss.mu.Unlock()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b := make([]byte, datagramReadBufferSize)
+		for {
+			n, _, err := pc.ReadFrom(b)
+			if n > 0 {
+				ss.send(b[:n])
+			}
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+					return
+				}
+				logErrors.Add(ss.address, 1)
+				glog.Info(err)
+				// Only a temporary error, such as running out of
+				// buffers, leaves the socket worth reading again, after
+				// a pause so that a persistent one doesn't spin.
+				var nerr net.Error
+				if !errors.As(err, &nerr) || !nerr.Temporary() { //nolint:staticcheck // No replacement for Temporary on a read error.
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(syslogRetryDelay):
+				}
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				if !IsEndOrCancel(err) {
+					logErrors.Add(ss.address, 1)
+					glog.Info(err)
+				}
+				return
+			}
+			glog.V(2).Infof("%s: got new conn %v", ss.address, c)
+			wg.Add(1)
+			go ss.handleConn(ctx, wg, c)
+		}
+	}()
+	return nil
+}
+
+// handleConn reads the frames sent on a TCP connection until the peer closes
+// it.  Frames are either octet-counted, or terminated by a newline, as
+// described in RFC 6587.
+func (ss *syslogStream) handleConn(ctx context.Context, wg *sync.WaitGroup, c net.Conn) {
+	defer wg.Done()
+	defer func() {
+		glog.V(2).Infof("%v: closing connection", c)
+		if err := c.Close(); err != nil {
+			logErrors.Add(ss.address, 1)
+			glog.Info(err)
+		}
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	SetReadDeadlineOnDone(ctx, c)
+
+	r := bufio.NewReader(c)
+	for {
+		frame, err := readSyslogFrame(r)
+		if len(frame) > 0 {
+			ss.send(frame)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, errMalformedSyslog):
+				// The stream can't be resynchronised after a bad frame
+				// length, or a line longer than maxSyslogFrameSize.
+				syslogMalformed.Add(ss.address, 1)
+				glog.V(1).Infof("%s: dropping connection %v after malformed frame", ss.address, c)
+			case !IsEndOrCancel(err):
+				logErrors.Add(ss.address, 1)
+				glog.Info(err)
+			}
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads the next frame from a TCP syslog stream.  An
+// octet-counted frame starts with its length in bytes and a space; any other
+// frame runs to the end of the line.  Frames longer than maxSyslogFrameSize
+// are malformed, and so is a count that can't be the length of one.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '0' || first[0] > '9' {
+		return readSyslogLine(r)
+	}
+	var n int
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == ' ' {
+			break
+		}
+		if c < '0' || c > '9' {
+			return nil, errMalformedSyslog
+		}
+		n = n*10 + int(c-'0')
+		if n > maxSyslogFrameSize {
+			return nil, errMalformedSyslog
+		}
+	}
+	if n < 1 {
+		return nil, errMalformedSyslog
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readSyslogLine reads a newline-terminated frame, of up to
+// maxSyslogFrameSize bytes.
+func readSyslogLine(r *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		b, err := r.ReadSlice('\n')
+		if len(line)+len(b) > maxSyslogFrameSize {
+			return nil, errMalformedSyslog
+		}
+		line = append(line, b...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
+}
+
+// send parses a frame and sends its message as a line, or drops it if it is malformed.
+func (ss *syslogStream) send(frame []byte) {
+	logBytes.Add(ss.address, int64(len(frame)))
+	frame = bytes.TrimRight(frame, "\r\n\x00")
+	if len(frame) == 0 {
+		return
+	}
+	m, err := parseSyslog(frame)
+	if err != nil {
+		syslogMalformed.Add(ss.address, 1)
+		glog.V(1).Infof("%s: dropping %q: %s", ss.address, frame, err)
+		return
+	}
+	ss.mu.Lock()
+	ss.lastReadTime = time.Now()
+	ss.mu.Unlock()
+	logLines.Add(ss.address, 1)
+	select {
+	case ss.lines <- logline.New(logline.WithFields(ss.ctx, m.fields()), ss.address, m.msg):
+	case <-ss.ctx.Done():
+	}
+}
+
+func (ss *syslogStream) IsComplete() bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.completed
+}
+
+// Stop implements the LogStream interface.
+// Stop closes the listeners so no new messages are received; open TCP connections are read until closed by their peers.
+func (ss *syslogStream) Stop() {
+	ss.stopOnce.Do(func() {
+		close(ss.stopChan)
+	})
+}
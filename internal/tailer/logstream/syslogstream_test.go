@@ -0,0 +1,73 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+)
+
+func TestSyslogStream(t *testing.T) {
+	testutil.TimeoutTest(time.Second, func(t *testing.T) { //nolint:thelper
+		var wg sync.WaitGroup
+		addr := fmt.Sprintf("127.0.0.1:%d", testutil.FreePort(t))
+		lines := make(chan *logline.LogLine, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		waker, _ := waker.NewTest(ctx, 1)
+
+		ss, err := logstream.New(ctx, &wg, waker, "syslog://"+addr, lines, false)
+		testutil.FatalIfErr(t, err)
+
+		malformedCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "syslog_malformed_messages_total", addr, 1)
+
+		var received []*logline.LogLine
+		u, err := net.Dial("udp", addr)
+		testutil.FatalIfErr(t, err)
+		_, err = u.Write([]byte("not syslog"))
+		testutil.FatalIfErr(t, err)
+		_, err = u.Write([]byte("<34>Oct 11 22:14:15 host1 su: udp"))
+		testutil.FatalIfErr(t, err)
+		received = append(received, <-lines)
+		testutil.FatalIfErr(t, u.Close())
+		malformedCheck()
+
+		c, err := net.Dial("tcp", addr)
+		testutil.FatalIfErr(t, err)
+		// An octet-counted frame, then a newline-terminated frame.
+		msg := "<11>1 - host2 app - - - octet counted"
+		_, err = c.Write([]byte(fmt.Sprintf("%d %s<13>Oct 11 22:14:15 host3 newline\n", len(msg), msg)))
+		testutil.FatalIfErr(t, err)
+		received = append(received, <-lines, <-lines)
+		testutil.FatalIfErr(t, c.Close())
+
+		cancel()
+		wg.Wait()
+
+		expected := []*logline.LogLine{
+			{context.TODO(), addr, "su: udp"},
+			{context.TODO(), addr, "octet counted"},
+			{context.TODO(), addr, "newline"},
+		}
+		testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+		var fields [][]string
+		for _, l := range received {
+			fields = append(fields, []string{l.Field("syslog_facility"), l.Field("syslog_severity"), l.Field("syslog_hostname")})
+		}
+		testutil.ExpectNoDiff(t, [][]string{{"4", "2", "host1"}, {"1", "3", "host2"}, {"1", "5", "host3"}}, fields)
+
+		if !ss.IsComplete() {
+			t.Errorf("expecting syslogstream to be complete because cancel")
+		}
+	})(t)
+}
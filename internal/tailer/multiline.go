@@ -0,0 +1,232 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package tailer
+
+import (
+	"errors"
+	"expvar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+)
+
+// multilineOverflows counts the records per log that reached the maximum
+// record size, and were sent before their next start line was read.
+var multilineOverflows = expvar.NewMap("log_multiline_overflows_total")
+
+// multiline configures the joining of continuation lines into records for the
+// logs whose pathname matches glob.
+type multiline struct {
+	glob    string
+	start   *regexp.Regexp
+	maxSize int
+	timeout time.Duration
+}
+
+// Multiline joins the lines read from logs whose pathname matches the glob
+// pattern into records, where each record begins with a line matching the
+// regular expression start, and every following line that does not match
+// is appended to the record, separated by a newline.  Each record is sent
+// to the programs as a single line.  A record is sent early once it is
+// maxSize bytes long, and a partial record is sent if no further lines are
+// read from the log for the timeout, or the log is closed.
+func Multiline(glob, start string, maxSize int, timeout time.Duration) Option {
+	return &multilineOption{glob, start, maxSize, timeout}
+}
+
+type multilineOption struct {
+	glob    string
+	start   string
+	maxSize int
+	timeout time.Duration
+}
+
+var (
+	ErrMultilineMaxSize = errors.New("multiline record size must be positive")
+	ErrMultilineTimeout = errors.New("multiline timeout must be positive")
+)
+
+func (opt *multilineOption) apply(t *Tailer) error {
+	re, err := regexp.Compile(opt.start)
+	if err != nil {
+		return err
+	}
+	if opt.maxSize <= 0 {
+		return ErrMultilineMaxSize
+	}
+	if opt.timeout <= 0 {
+		return ErrMultilineTimeout
+	}
+	glob := opt.glob
+	if u, err := url.Parse(glob); err == nil && (u.Scheme == "" || u.Scheme == "file") && glob != logstream.StdinPathname {
+		if glob, err = filepath.Abs(u.Path); err != nil {
+			return err
+		}
+	}
+	if _, err := filepath.Match(glob, ""); err != nil {
+		return err
+	}
+	glog.V(2).Infof("Joining lines of %q into records starting with %q", glob, opt.start)
+	t.multilines = append(t.multilines, multiline{glob, re, opt.maxSize, opt.timeout})
+	return nil
+}
+
+// multilineFor returns the multiline configuration for the log at pathname,
+// or nil if its lines are not joined.  The first matching glob wins.
+func (t *Tailer) multilineFor(pathname string) *multiline {
+	for i := range t.multilines {
+		if ok, _ := filepath.Match(t.multilines[i].glob, pathname); ok {
+			return &t.multilines[i]
+		}
+	}
+	return nil
+}
+
+// multilineTicks is the number of times per timeout that a joiner checks
+// whether its partial record has timed out, so that a record is sent no more
+// than a fraction of the timeout late.
+const multilineTicks = 10
+
+// joiner reads lines from a logstream and sends them on as records.
+type joiner struct {
+	multiline
+	in  chan *logline.LogLine
+	out chan<- *logline.LogLine
+
+	stream logstream.Positioner // The logstream read, if it has a position, or nil.
+
+	record *logline.LogLine // The record being joined, or nil.
+	b      strings.Builder  // The text of record.
+
+	mu        sync.Mutex
+	recordPos *logstream.Position // The position of stream at the start of record, or nil; protected by mu.
+}
+
+func newJoiner(m *multiline, out chan<- *logline.LogLine) *joiner {
+	return &joiner{multiline: *m, in: make(chan *logline.LogLine), out: out}
+}
+
+// run joins the lines read from the stream l until it is complete.  As every
+// line sent by l is received before l can complete, the last record can be
+// sent once l is found to be complete.
+func (j *joiner) run(wg *sync.WaitGroup, l logstream.LogStream) {
+	defer wg.Done()
+	tick := j.timeout / multilineTicks
+	if tick <= 0 {
+		tick = j.timeout
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	lastRead := time.Now()
+	for {
+		select {
+		case line := <-j.in:
+			j.add(line)
+			lastRead = time.Now()
+		case <-ticker.C:
+			if l.IsComplete() {
+				j.flush()
+				return
+			}
+			if time.Since(lastRead) >= j.timeout {
+				j.flush()
+			}
+		}
+	}
+}
+
+// add appends line to the current record, or starts a new record with it if
+// it matches the start pattern.
+func (j *joiner) add(line *logline.LogLine) {
+	if j.record != nil && !j.start.MatchString(line.Line) {
+		if j.b.Len()+1+len(line.Line) <= j.maxSize {
+			j.b.WriteString("\n")
+			j.b.WriteString(line.Line)
+			return
+		}
+		multilineOverflows.Add(line.Filename, 1)
+	}
+	j.flush()
+	j.record = line
+	j.b.WriteString(line.Line)
+	if j.stream != nil {
+		// A logstream only moves its position on once every line of a read
+		// has been sent, so this is at or before the start of the record.
+		pos := j.stream.Position()
+		j.mu.Lock()
+		j.recordPos = &pos
+		j.mu.Unlock()
+	}
+}
+
+// flush sends the current record, if any.
+func (j *joiner) flush() {
+	if j.record == nil {
+		return
+	}
+	j.out <- logline.New(j.record.Context, j.record.Filename, j.b.String())
+	j.record = nil
+	j.b.Reset()
+	j.mu.Lock()
+	j.recordPos = nil
+	j.mu.Unlock()
+}
+
+// joinedStream is a logstream whose lines are joined into records.  Its
+// position is that of the start of the partial record, so that a resumed
+// stream reads the lines of the record again rather than losing them.
+type joinedStream struct {
+	logstream.LogStream
+	j *joiner
+}
+
+// Position implements the Positioner interface.
+func (s *joinedStream) Position() logstream.Position {
+	s.j.mu.Lock()
+	defer s.j.mu.Unlock()
+	if s.j.recordPos != nil {
+		return *s.j.recordPos
+	}
+	return s.j.stream.Position()
+}
+
+// Follows implements the Follower interface.
+func (s *joinedStream) Follows(fi os.FileInfo) bool {
+	f, ok := s.LogStream.(logstream.Follower)
+	return ok && f.Follows(fi)
+}
+
+// joinLines starts a joiner that sends the records of the lines read by the
+// logstream created by newStream to the tailer's lines channel, if the log at
+// pathname has multiline records, otherwise the logstream sends its lines
+// directly.
+func (t *Tailer) joinLines(pathname string, newStream func(chan<- *logline.LogLine) (logstream.LogStream, error)) (logstream.LogStream, error) {
+	m := t.multilineFor(pathname)
+	if m == nil {
+		return newStream(t.lines)
+	}
+	j := newJoiner(m, t.lines)
+	l, err := newStream(j.in)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := l.(logstream.Positioner)
+	if ok {
+		j.stream = p
+	}
+	t.wg.Add(1)
+	go j.run(&t.wg, l)
+	if ok {
+		return &joinedStream{l, j}, nil
+	}
+	return l, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package tailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+)
+
+func TestMultilineOneShot(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	logfile := filepath.Join(dir, "log")
+	testutil.FatalIfErr(t, os.WriteFile(logfile, []byte("2021 ERROR NullPointerException\n\tat a\n\tat b\n2021 INFO ok\n"), 0o600))
+	other := filepath.Join(dir, "other")
+	testutil.FatalIfErr(t, os.WriteFile(other, []byte("x\n\ty\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lines := make(chan *logline.LogLine, 10)
+	var wg sync.WaitGroup
+	_, err := New(ctx, &wg, lines, OneShot, LogstreamPollWaker(waker.NewTimed(ctx, 10*time.Millisecond)), LogPatterns([]string{logfile, other}), Multiline(filepath.Join(dir, "l*"), `^\d{4} `, 1024, 10*time.Millisecond))
+	testutil.FatalIfErr(t, err)
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "2021 ERROR NullPointerException\n\tat a\n\tat b"},
+		{context.Background(), logfile, "2021 INFO ok"},
+		{context.Background(), other, "x"},
+		{context.Background(), other, "\ty"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"), testutil.SortSlices(func(a, b *logline.LogLine) bool {
+		return a.Filename < b.Filename || (a.Filename == b.Filename && a.Line < b.Line)
+	}))
+}
+
+func TestMultilineMaxSize(t *testing.T) {
+	out := make(chan *logline.LogLine, 10)
+	j := newJoiner(&multiline{"", regexp.MustCompile(`^start`), 12, time.Hour}, out)
+	overflowCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "log_multiline_overflows_total", "log", 1)
+	for _, l := range []string{"start", " a", " b", " c", "start"} {
+		j.add(logline.New(context.Background(), "log", l))
+	}
+	j.flush()
+	close(out)
+	overflowCheck()
+
+	received := testutil.LinesReceived(out)
+	expected := []*logline.LogLine{
+		{context.Background(), "log", "start\n a\n b"},
+		{context.Background(), "log", " c"},
+		{context.Background(), "log", "start"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}
+
+// stubStream is a LogStream whose completion is controlled by the test.
+type stubStream struct {
+	complete int32
+}
+
+func (s *stubStream) LastReadTime() time.Time { return time.Now() }
+func (s *stubStream) Stop()                   { atomic.StoreInt32(&s.complete, 1) }
+func (s *stubStream) IsComplete() bool        { return atomic.LoadInt32(&s.complete) == 1 }
+
+// positionStream is a stubStream with a position set by the test.
+type positionStream struct {
+	stubStream
+	mu  sync.Mutex
+	pos logstream.Position
+}
+
+func (s *positionStream) Position() logstream.Position {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pos
+}
+
+func (s *positionStream) setOffset(offset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pos.Offset = offset
+}
+
+func TestMultilinePositionOfPartialRecord(t *testing.T) {
+	out := make(chan *logline.LogLine, 10)
+	j := newJoiner(&multiline{"", regexp.MustCompile(`^start`), 1024, time.Hour}, out)
+	s := &positionStream{}
+	j.stream = s
+	js := &joinedStream{s, j}
+
+	s.setOffset(10)
+	j.add(logline.New(context.Background(), "log", "start"))
+	s.setOffset(20)
+	j.add(logline.New(context.Background(), "log", " a"))
+	// The lines of the partial record are read again by a resumed stream.
+	testutil.ExpectNoDiff(t, int64(10), js.Position().Offset)
+
+	j.flush()
+	testutil.ExpectNoDiff(t, int64(20), js.Position().Offset)
+}
+
+func TestMultilineIdleTimeout(t *testing.T) {
+	out := make(chan *logline.LogLine, 10)
+	j := newJoiner(&multiline{"", regexp.MustCompile(`^start`), 1024, 100 * time.Millisecond}, out)
+	s := &stubStream{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go j.run(&wg, s)
+
+	j.in <- logline.New(context.Background(), "log", "start")
+	j.in <- logline.New(context.Background(), "log", " a")
+	select {
+	case l := <-out:
+		testutil.ExpectNoDiff(t, "start\n a", l.Line)
+	case <-time.After(10 * time.Second):
+		t.Fatal("partial record not sent after idle timeout")
+	}
+
+	// The last record is sent when the stream completes.
+	j.in <- logline.New(context.Background(), "log", "start again")
+	s.Stop()
+	wg.Wait()
+	close(out)
+	received := testutil.LinesReceived(out)
+	if len(received) != 1 || received[0].Line != "start again" {
+		t.Errorf("last record not sent on completion: %v", received)
+	}
+}
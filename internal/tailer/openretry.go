@@ -0,0 +1,106 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package tailer
+
+import (
+	"errors"
+	"expvar"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// logOpenErrors counts the opens of logs that failed because permission was
+// denied, by log pathname.
+var logOpenErrors = expvar.NewMap("log_open_errors_total")
+
+const (
+	minOpenRetryDelay = time.Second
+	maxOpenRetryDelay = 30 * time.Second
+)
+
+// openRetry records when to next try opening a log that permission was denied
+// to.
+type openRetry struct {
+	next  time.Time
+	delay time.Duration
+	fi    os.FileInfo // The log when the open failed, or nil if it couldn't be stat'ed.
+}
+
+// openFailed records the failure to open the log at pathname with err.  If
+// permission was denied, the log is retried on later polls, backing off
+// between attempts, until it can be read.  The caller must hold logstreamsMu.
+func (t *Tailer) openFailed(pathname string, err error) {
+	if !errors.Is(err, fs.ErrPermission) {
+		delete(t.openRetries, pathname)
+		return
+	}
+	logOpenErrors.Add(pathname, 1)
+	r, ok := t.openRetries[pathname]
+	if !ok {
+		r = &openRetry{delay: minOpenRetryDelay}
+		t.openRetries[pathname] = r
+	} else {
+		r.delay *= 2
+		if r.delay > maxOpenRetryDelay {
+			r.delay = maxOpenRetryDelay
+		}
+	}
+	r.next = time.Now().Add(r.delay)
+	r.fi, _ = os.Stat(pathname)
+	glog.Infof("Permission denied opening %q, retrying in %s: %s", pathname, r.delay, err)
+}
+
+// pruneOpenRetries forgets the logs waiting to be reopened that no longer
+// exist, or are no longer matched by a log pattern.  The caller must hold
+// globPatternsMu.
+func (t *Tailer) pruneOpenRetries() {
+	t.logstreamsMu.Lock()
+	defer t.logstreamsMu.Unlock()
+	for pathname := range t.openRetries {
+		if _, err := os.Stat(pathname); errors.Is(err, fs.ErrNotExist) || !t.matched(pathname) {
+			glog.V(2).Infof("no longer retrying to open %q", pathname)
+			delete(t.openRetries, pathname)
+		}
+	}
+}
+
+// matched returns true if pathname is matched by a log pattern, or is in a
+// tailed directory, and isn't ignored.  The caller must hold globPatternsMu.
+func (t *Tailer) matched(pathname string) bool {
+	if t.Ignore(pathname) {
+		return false
+	}
+	if _, ok := t.dirs[filepath.Dir(pathname)]; ok {
+		return true
+	}
+	for pattern := range t.globPatterns {
+		if ok, err := filepath.Match(pattern, pathname); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// waitToReopen returns true if the log at pathname failed to open, and should
+// not be tried again yet.  A log that has been replaced or had its mode
+// changed since the failure is tried again straight away.  The caller must
+// hold logstreamsMu.
+func (t *Tailer) waitToReopen(pathname string) bool {
+	r, ok := t.openRetries[pathname]
+	if !ok || !time.Now().Before(r.next) {
+		return false
+	}
+	fi, err := os.Stat(pathname)
+	if (err != nil) != (r.fi == nil) {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return os.SameFile(r.fi, fi) && r.fi.Mode() == fi.Mode()
+}
@@ -0,0 +1,135 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package tailer
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/waker"
+)
+
+// stateCheckpoints counts the writes of the state file.
+var stateCheckpoints = expvar.NewInt("tailer_state_checkpoints_total")
+
+// StateFile makes the tailer keep the position read in each log file in the
+// file at `pathname`.  The state is written each time `w` wakes, and when the
+// tailer finishes.  Logs found at startup are read from the position
+// recorded in an existing state file.
+func StateFile(pathname string, w waker.Waker) Option {
+	return &stateFile{pathname, w}
+}
+
+type stateFile struct {
+	pathname string
+	waker.Waker
+}
+
+func (opt stateFile) apply(t *Tailer) error {
+	positions, err := readState(opt.pathname)
+	if err != nil {
+		return err
+	}
+	t.stateFile = opt.pathname
+	t.positions = positions
+	t.stateWaker = opt.Waker
+	return nil
+}
+
+// readState reads the positions recorded in a state file.  A missing state
+// file has no positions.
+func readState(pathname string) (map[string]logstream.Position, error) {
+	positions := make(map[string]logstream.Position)
+	b, err := os.ReadFile(pathname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			glog.Infof("No state file at %q, reading logs from their end", pathname)
+			return positions, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &positions); err != nil {
+		return nil, err
+	}
+	glog.Infof("Read positions of %d logs from state file %q", len(positions), pathname)
+	return positions, nil
+}
+
+// WriteState records the position of each log stream that has one in the
+// state file.  The new state is written to a temporary file in the same
+// directory, which then replaces the old one, so that the state file is
+// never seen partly written.
+func (t *Tailer) WriteState() error {
+	if t.stateFile == "" {
+		return nil
+	}
+	positions := make(map[string]logstream.Position)
+	t.logstreamsMu.RLock()
+	for pathname, l := range t.logstreams {
+		if p, ok := l.(logstream.Positioner); ok {
+			positions[pathname] = p.Position()
+		}
+	}
+	t.logstreamsMu.RUnlock()
+	b, err := json.Marshal(positions)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(t.stateFile), filepath.Base(t.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), t.stateFile); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	stateCheckpoints.Add(1)
+	return nil
+}
+
+// StartStateCheckpointLoop runs a permanent goroutine to write the state file.
+func (t *Tailer) StartStateCheckpointLoop(waker waker.Waker) {
+	if waker == nil {
+		glog.Info("State checkpoints disabled")
+		return
+	}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		<-t.initDone
+		if t.oneShot {
+			glog.Info("No state checkpoint loop in oneshot mode.")
+			return
+		}
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-waker.Wake():
+				if err := t.WriteState(); err != nil {
+					glog.Info(err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package tailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+)
+
+// tailWithState runs a Tailer on logfile that keeps its state in stateFile,
+// calling f before stopping it, and returns the lines read.
+func tailWithState(t *testing.T, logfile, stateFile string, f func(awaken waker.WakeFunc), options ...Option) []*logline.LogLine {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan *logline.LogLine, 5)
+	var wg sync.WaitGroup
+	w, awaken := waker.NewTest(ctx, 1)
+	options = append(options, LogPatterns([]string{logfile}), LogstreamPollWaker(w), StateFile(stateFile, nil))
+	_, err := New(ctx, &wg, lines, options...)
+	testutil.FatalIfErr(t, err)
+	f(awaken)
+	cancel()
+	wg.Wait()
+	return testutil.LinesReceived(lines)
+}
+
+func TestTailerResumesFromStateFile(t *testing.T) {
+	tmpDir := testutil.TestTempDir(t)
+	logfile := filepath.Join(tmpDir, "log")
+	stateFile := filepath.Join(tmpDir, "state")
+
+	f := testutil.TestOpenFile(t, logfile)
+	defer f.Close()
+	testutil.WriteString(t, f, "a\n")
+
+	// Without a state file, the log is read from its end.
+	received := tailWithState(t, logfile, stateFile, func(awaken waker.WakeFunc) {
+		awaken(1)
+		testutil.WriteString(t, f, "b\n")
+		awaken(1)
+	})
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "b"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	// Lines written while the tailer was stopped are read when it restarts.
+	testutil.WriteString(t, f, "c\nd\n")
+	received = tailWithState(t, logfile, stateFile, func(awaken waker.WakeFunc) {
+		awaken(1)
+	})
+	expected = []*logline.LogLine{
+		{context.Background(), logfile, "c"},
+		{context.Background(), logfile, "d"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	positions, err := readState(stateFile)
+	testutil.FatalIfErr(t, err)
+	if positions[logfile].Offset != 8 {
+		t.Errorf("state offset of %q: got %d, want 8", logfile, positions[logfile].Offset)
+	}
+}
+
+func TestTailerStateFileRotatedLog(t *testing.T) {
+	tmpDir := testutil.TestTempDir(t)
+	logfile := filepath.Join(tmpDir, "log")
+	stateFile := filepath.Join(tmpDir, "state")
+
+	f := testutil.TestOpenFile(t, logfile)
+	testutil.WriteString(t, f, "a\nb\n")
+	testutil.FatalIfErr(t, f.Close())
+
+	_ = tailWithState(t, logfile, stateFile, func(awaken waker.WakeFunc) {
+		awaken(1)
+	})
+
+	// The log was rotated while the tailer was stopped, so the new file is read from its start.
+	testutil.FatalIfErr(t, os.Rename(logfile, logfile+".1"))
+	f = testutil.TestOpenFile(t, logfile)
+	defer f.Close()
+	testutil.WriteString(t, f, "c\n")
+
+	received := tailWithState(t, logfile, stateFile, func(awaken waker.WakeFunc) {
+		awaken(1)
+	})
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "c"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}
+
+func TestTailerReadFromStartResumesFromStateFile(t *testing.T) {
+	tmpDir := testutil.TestTempDir(t)
+	logfile := filepath.Join(tmpDir, "log")
+	stateFile := filepath.Join(tmpDir, "state")
+
+	f := testutil.TestOpenFile(t, logfile)
+	defer f.Close()
+	testutil.WriteString(t, f, "a\n")
+
+	// Without a recorded position, the log is read from its start.
+	received := tailWithState(t, logfile, stateFile, func(awaken waker.WakeFunc) {
+		awaken(1)
+	}, ReadFromStart)
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "a"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+
+	// Lines already read are not read again on restart.
+	testutil.WriteString(t, f, "b\n")
+	received = tailWithState(t, logfile, stateFile, func(awaken waker.WakeFunc) {
+		awaken(1)
+	}, ReadFromStart)
+	expected = []*logline.LogLine{
+		{context.Background(), logfile, "b"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}
+
+func TestTailerWithNothingToTailKeepsStateFile(t *testing.T) {
+	tmpDir := testutil.TestTempDir(t)
+	stateFile := filepath.Join(tmpDir, "state")
+	const state = `{"/var/log/x":{"Inode":1,"Offset":2}}`
+	testutil.FatalIfErr(t, os.WriteFile(stateFile, []byte(state), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	_, err := New(ctx, &wg, lines, StateFile(stateFile, waker.NewTestAlways()))
+	testutil.FatalIfErr(t, err)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	b, err := os.ReadFile(stateFile)
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, state, string(b))
+}
+
+func TestReadStateMissingFile(t *testing.T) {
+	tmpDir := testutil.TestTempDir(t)
+	positions, err := readState(filepath.Join(tmpDir, "state"))
+	testutil.FatalIfErr(t, err)
+	testutil.ExpectNoDiff(t, map[string]logstream.Position{}, positions)
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,6 +27,15 @@ import (
 // logCount records the number of logs that are being tailed.
 var logCount = expvar.NewInt("log_count")
 
+// logDiscoveries counts the logs found by the log patterns after startup.
+// The new file at the path of a log that was rotated is counted too.
+var logDiscoveries = expvar.NewInt("log_discoveries_total")
+
+// logRetirements counts the logs no longer tailed because they were read to
+// the end after being removed or rotated away.  A rotation retires the old
+// file and discovers the new one, so it counts in both.
+var logRetirements = expvar.NewInt("log_retirements_total")
+
 // Tailer polls the filesystem for log sources that match given
 // `LogPathPatterns` and creates `LogStream`s to tail them.
 type Tailer struct {
@@ -33,13 +43,28 @@ type Tailer struct {
 	wg    sync.WaitGroup // Wait for our subroutines to finish
 	lines chan<- *logline.LogLine
 
-	globPatternsMu     sync.RWMutex        // protects `globPatterns'
+	globPatternsMu     sync.RWMutex        // protects `globPatterns' and `dirs'
 	globPatterns       map[string]struct{} // glob patterns to match newly created logs in dir paths against
+	dirs               map[string]struct{} // directories whose files are all tailed
 	ignoreRegexPattern *regexp.Regexp
 
 	socketPaths []string
+	stdin       bool // read log lines from standard input
+
+	oneShot       bool
+	readFromStart bool // read logs found at startup from their start
+
+	compressed map[*compressedLog]struct{} // compressed logs already read; protected by logstreamsMu
+
+	startupDone bool // set once the logs found at startup are tailed; protected by logstreamsMu
 
-	oneShot bool
+	multilines []multiline // joining of continuation lines into records, by log glob
+
+	openRetries map[string]*openRetry // logs to reopen after permission was denied; protected by logstreamsMu
+
+	stateFile  string                        // pathname to record log positions in
+	positions  map[string]logstream.Position // positions read from stateFile at startup
+	stateWaker waker.Waker                   // wakes to checkpoint stateFile, if not nil
 
 	pollMu sync.Mutex // protects Poll()
 
@@ -48,6 +73,7 @@ type Tailer struct {
 	logstreams         map[string]logstream.LogStream // Map absolte pathname to logstream reading that pathname.
 
 	initDone chan struct{}
+	done     chan struct{} // closed when the tailer has finished, and closed its lines channel
 }
 
 // Option configures a new Tailer.
@@ -66,7 +92,13 @@ func (n *niladicOption) apply(t *Tailer) error {
 // OneShot puts the tailer in one-shot mode, where sources are read once from the start and then closed.
 var OneShot = &niladicOption{func(t *Tailer) error { t.oneShot = true; return nil }}
 
-// LogPatterns sets the glob patterns to use to match pathnames.
+// ReadFromStart reads the log files found at startup from their start, rather
+// than their end.  Logs with a position recorded in the state file are still
+// read from that position.
+var ReadFromStart = &niladicOption{func(t *Tailer) error { t.readFromStart = true; return nil }}
+
+// LogPatterns sets the glob patterns to use to match pathnames.  A pattern
+// naming a directory tails every file in it, and the files created in it later.
 type LogPatterns []string
 
 func (opt LogPatterns) apply(t *Tailer) error {
@@ -85,6 +117,22 @@ func (opt IgnoreRegex) apply(t *Tailer) error {
 	return t.SetIgnorePattern(string(opt))
 }
 
+// ReadBufferSize sets the size in bytes of the buffer each log is read into.
+type ReadBufferSize int
+
+func (opt ReadBufferSize) apply(t *Tailer) error {
+	logstream.SetReadBufferSize(int(opt))
+	return nil
+}
+
+// LogEncoding sets the character encoding of the logs, by IANA name, that
+// their lines are transcoded to UTF-8 from.  If empty the logs are read as UTF-8.
+type LogEncoding string
+
+func (opt LogEncoding) apply(t *Tailer) error {
+	return logstream.SetLogEncoding(string(opt))
+}
+
 // StaleLogGcWaker triggers garbage collection runs for stale logs in the tailer.
 func StaleLogGcWaker(w waker.Waker) Option {
 	return &staleLogGcWaker{w}
@@ -138,18 +186,33 @@ func New(ctx context.Context, wg *sync.WaitGroup, lines chan<- *logline.LogLine,
 		ctx:          ctx,
 		lines:        lines,
 		initDone:     make(chan struct{}),
+		done:         make(chan struct{}),
 		globPatterns: make(map[string]struct{}),
+		dirs:         make(map[string]struct{}),
 		logstreams:   make(map[string]logstream.LogStream),
+		openRetries:  make(map[string]*openRetry),
+		compressed:   make(map[*compressedLog]struct{}),
 	}
 	defer close(t.initDone)
 	if err := t.SetOption(options...); err != nil {
 		return nil, err
 	}
-	if len(t.globPatterns) == 0 && len(t.socketPaths) == 0 {
+	if len(t.globPatterns) == 0 && len(t.dirs) == 0 && len(t.socketPaths) == 0 && !t.stdin {
 		glog.Info("No patterns or sockets to tail, tailer done.")
 		close(t.lines)
+		close(t.done)
 		return t, nil
 	}
+	if t.stdin {
+		if err := t.TailPath(logstream.StdinPathname); err != nil {
+			return nil, err
+		}
+		// With nothing else to tail, the tailer is done once stdin reaches
+		// EOF, just as in oneshot mode.
+		if len(t.globPatterns) == 0 && len(t.dirs) == 0 && len(t.socketPaths) == 0 {
+			t.oneShot = true
+		}
+	}
 	// Set up listeners on every socket.
 	for _, pattern := range t.socketPaths {
 		if err := t.TailPath(pattern); err != nil {
@@ -161,6 +224,20 @@ func New(ctx context.Context, wg *sync.WaitGroup, lines chan<- *logline.LogLine,
 	if err := t.PollLogPatterns(); err != nil {
 		return nil, err
 	}
+	t.logstreamsMu.Lock()
+	t.startupDone = true
+	t.logstreamsMu.Unlock()
+	if !t.oneShot {
+		for dir := range t.dirs {
+			t.watchDir(dir)
+		}
+	}
+	// The checkpoint loop is started here rather than by the StateFile
+	// option, as a tailer with nothing to tail returns above without waiting
+	// for it.
+	if t.stateFile != "" {
+		t.StartStateCheckpointLoop(t.stateWaker)
+	}
 	// Setup for shutdown, once all routines are finished.
 	wg.Add(1)
 	go func() {
@@ -177,11 +254,26 @@ func New(ctx context.Context, wg *sync.WaitGroup, lines chan<- *logline.LogLine,
 			<-t.ctx.Done()
 		}
 		t.wg.Wait()
+		if err := t.WriteState(); err != nil {
+			glog.Info(err)
+		}
 		close(t.lines)
+		close(t.done)
 	}()
 	return t, nil
 }
 
+// Alive returns false once the tailer has finished reading logs, after it's
+// shut down or, in oneshot mode, read every log to its end.
+func (t *Tailer) Alive() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
 var ErrNilOption = errors.New("nil option supplied")
 
 // SetOption takes one or more option functions and applies them in order to Tailer.
@@ -201,6 +293,11 @@ var ErrUnsupportedURLScheme = errors.New("unsupported URL scheme")
 
 // AddPattern adds a pattern to the list of patterns to filter filenames against.
 func (t *Tailer) AddPattern(pattern string) error {
+	if pattern == logstream.StdinPathname {
+		glog.V(2).Infof("AddPattern: stdin")
+		t.stdin = true
+		return nil
+	}
 	u, err := url.Parse(pattern)
 	if err != nil {
 		return err
@@ -210,7 +307,7 @@ func (t *Tailer) AddPattern(pattern string) error {
 	switch u.Scheme {
 	default:
 		glog.V(2).Infof("%v: %q in path pattern %q, treating as path", ErrUnsupportedURLScheme, u.Scheme, pattern)
-	case "unix", "unixgram", "tcp", "udp":
+	case "unix", "unixgram", "tcp", "udp", "syslog":
 		// Keep the scheme.
 		glog.V(2).Infof("AddPattern: socket %q", pattern)
 		t.socketPaths = append(t.socketPaths, pattern)
@@ -223,10 +320,15 @@ func (t *Tailer) AddPattern(pattern string) error {
 		glog.V(2).Infof("Couldn't canonicalize path %q: %s", u.Path, err)
 		return err
 	}
-	glog.V(2).Infof("AddPattern: file %q", absPath)
 	t.globPatternsMu.Lock()
+	defer t.globPatternsMu.Unlock()
+	if isDirPattern(path) {
+		glog.V(2).Infof("AddPattern: directory %q", absPath)
+		t.dirs[absPath] = struct{}{}
+		return nil
+	}
+	glog.V(2).Infof("AddPattern: file %q", absPath)
 	t.globPatterns[absPath] = struct{}{}
-	t.globPatternsMu.Unlock()
 	return nil
 }
 
@@ -263,33 +365,109 @@ func (t *Tailer) SetIgnorePattern(pattern string) error {
 	return nil
 }
 
-// TailPath registers a filesystem pathname to be tailed.
+// TailPath registers a filesystem pathname to be tailed.  Logs that exist when
+// the Tailer starts are tailed from their end, or from the position recorded
+// in the state file, and logs that appear later are read from their start so
+// that no lines written before they were found are lost.
 func (t *Tailer) TailPath(pathname string) error {
 	t.logstreamsMu.Lock()
 	defer t.logstreamsMu.Unlock()
+	if t.waitToReopen(pathname) {
+		glog.V(2).Infof("waiting to retry opening %q", pathname)
+		return nil
+	}
+	var compressed os.FileInfo
+	if strings.HasSuffix(pathname, logstream.GzipSuffix) {
+		// Compressed logs are read once, even if renamed by a later rotation.
+		fi, err := os.Stat(pathname)
+		if err != nil {
+			t.openFailed(pathname, err)
+			return err
+		}
+		for c := range t.compressed {
+			if os.SameFile(c.fi, fi) {
+				glog.V(2).Infof("already read compressed log %q", pathname)
+				c.pathname = pathname
+				return nil
+			}
+		}
+		compressed = fi
+	}
 	if l, ok := t.logstreams[pathname]; ok {
 		if !l.IsComplete() {
 			glog.V(2).Infof("already got a logstream on %q", pathname)
 			return nil
 		}
 		logCount.Add(-1) // Removing the current entry before re-adding.
+		logstream.RemoveCounters(pathname)
+		logRetirements.Add(1)
 		glog.V(2).Infof("Existing logstream is finished, creating a new one.")
 	}
-	l, err := logstream.New(t.ctx, &t.wg, t.logstreamPollWaker, pathname, t.lines, t.oneShot)
+	followed := t.startupDone && compressed == nil && t.followed(pathname)
+	l, err := t.joinLines(pathname, func(lines chan<- *logline.LogLine) (logstream.LogStream, error) {
+		if t.oneShot {
+			return logstream.New(t.ctx, &t.wg, t.logstreamPollWaker, pathname, lines, true)
+		}
+		if followed {
+			// A log renamed by rotation has already been read up to here by
+			// the logstream that followed it under its old name.
+			return logstream.New(t.ctx, &t.wg, t.logstreamPollWaker, pathname, lines, false)
+		}
+		if t.startupDone {
+			// A log found after startup is read from its start, so that the
+			// lines written to it before it was found aren't missed.
+			return logstream.NewFromStart(t.ctx, &t.wg, t.logstreamPollWaker, pathname, lines)
+		}
+		if pos, ok := t.positions[pathname]; ok {
+			return logstream.NewFromPosition(t.ctx, &t.wg, t.logstreamPollWaker, pathname, lines, pos)
+		}
+		if t.readFromStart {
+			return logstream.NewFromStart(t.ctx, &t.wg, t.logstreamPollWaker, pathname, lines)
+		}
+		return logstream.New(t.ctx, &t.wg, t.logstreamPollWaker, pathname, lines, false)
+	})
 	if err != nil {
+		t.openFailed(pathname, err)
 		return err
 	}
+	delete(t.openRetries, pathname)
 	if t.oneShot {
 		glog.V(2).Infof("Starting oneshot read at startup of %q", pathname)
 		l.Stop()
 	}
+	if compressed != nil {
+		t.compressed[&compressedLog{pathname, compressed}] = struct{}{}
+	}
 	t.logstreams[pathname] = l
 	glog.Infof("Tailing %s", pathname)
 	logCount.Add(1)
+	if t.startupDone {
+		logDiscoveries.Add(1)
+	}
 	return nil
 }
 
-// ExpireStaleLogstreams removes logstreams that have had no reads for 1h or more.
+// followed reports whether the file at pathname is, or was until it was
+// rotated, being read by the logstream of another pathname.
+// t.logstreamsMu must be held.
+func (t *Tailer) followed(pathname string) bool {
+	fi, err := os.Stat(pathname)
+	if err != nil {
+		return false
+	}
+	for name, l := range t.logstreams {
+		if name == pathname {
+			continue
+		}
+		if f, ok := l.(logstream.Follower); ok && f.Follows(fi) {
+			glog.V(2).Infof("%q is already followed as %q", pathname, name)
+			return true
+		}
+	}
+	return false
+}
+
+// ExpireStaleLogstreams removes logstreams that have had no reads for 24h or more.
 func (t *Tailer) ExpireStaleLogstreams() error {
 	t.logstreamsMu.Lock()
 	defer t.logstreamsMu.Unlock()
@@ -381,9 +559,38 @@ func (t *Tailer) PollLogPatterns() error {
 			}
 		}
 	}
+	for dir := range t.dirs {
+		if err := t.pollDir(dir); err != nil {
+			glog.Info(err)
+		}
+	}
+	t.pruneCompressed()
+	t.pruneOpenRetries()
 	return nil
 }
 
+// compressedLog is a compressed log that has been read, and the pathname it
+// was last found at.
+type compressedLog struct {
+	pathname string
+	fi       os.FileInfo
+}
+
+// pruneCompressed forgets the compressed logs that have been read and are no
+// longer at the pathname they were last found at.  It's called after the log
+// patterns are polled, which finds a log renamed by rotation at its new
+// pathname, so only logs that have been removed are forgotten.
+func (t *Tailer) pruneCompressed() {
+	t.logstreamsMu.Lock()
+	defer t.logstreamsMu.Unlock()
+	for c := range t.compressed {
+		if fi, err := os.Stat(c.pathname); err != nil || !os.SameFile(c.fi, fi) {
+			glog.V(2).Infof("forgetting removed compressed log %q", c.pathname)
+			delete(t.compressed, c)
+		}
+	}
+}
+
 // PollLogStreamsForCompletion looks at the existing paths and checks if they're already
 // complete, removing it from the map if so.
 func (t *Tailer) PollLogStreamsForCompletion() error {
@@ -393,7 +600,9 @@ func (t *Tailer) PollLogStreamsForCompletion() error {
 		if l.IsComplete() {
 			glog.Infof("%s is complete", name)
 			delete(t.logstreams, name)
+			logstream.RemoveCounters(name)
 			logCount.Add(-1)
+			logRetirements.Add(1)
 			continue
 		}
 	}
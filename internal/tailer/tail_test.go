@@ -4,11 +4,14 @@
 package tailer
 
 import (
+	"compress/gzip"
 	"context"
+	"expvar"
 	"os"
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
@@ -269,3 +272,184 @@ func TestTailExpireStaleHandles(t *testing.T) {
 	ta.logstreamsMu.RUnlock()
 	glog.Info("good")
 }
+
+func TestTailStdinExitsAtEOF(t *testing.T) {
+	testutil.TimeoutTest(1*time.Second, func(t *testing.T) { //nolint:thelper
+		r, w, err := os.Pipe()
+		testutil.FatalIfErr(t, err)
+		stdin := os.Stdin
+		os.Stdin = r
+		defer func() { os.Stdin = stdin }()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		lines := make(chan *logline.LogLine, 5)
+		var wg sync.WaitGroup
+		_, err = New(ctx, &wg, lines, LogPatterns([]string{"-"}))
+		testutil.FatalIfErr(t, err)
+
+		testutil.WriteString(t, w, "a\nb\nc")
+		testutil.FatalIfErr(t, w.Close())
+
+		// The tailer finishes without being cancelled once stdin is closed.
+		wg.Wait()
+
+		received := testutil.LinesReceived(lines)
+		expected := []*logline.LogLine{
+			{context.Background(), "-", "a"},
+			{context.Background(), "-", "b"},
+			{context.Background(), "-", "c"},
+		}
+		testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+	})(t)
+}
+
+func TestTailCompressedLogReadOnce(t *testing.T) {
+	ta, lines, _, dir, stop := makeTestTail(t)
+
+	logfile := filepath.Join(dir, "log.1.gz")
+	f := testutil.TestOpenFile(t, logfile)
+	zw := gzip.NewWriter(f)
+	_, err := zw.Write([]byte("a\nb\n"))
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, zw.Close())
+	testutil.FatalIfErr(t, f.Close())
+
+	testutil.FatalIfErr(t, ta.TailPath(logfile))
+	for !ta.logstreams[logfile].IsComplete() {
+		time.Sleep(time.Millisecond)
+	}
+	testutil.FatalIfErr(t, ta.Poll())
+	// A completed compressed log is not read again.
+	testutil.FatalIfErr(t, ta.TailPath(logfile))
+
+	stop()
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "a"},
+		{context.Background(), logfile, "b"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}
+
+func TestTailForgetsRemovedCompressedLogs(t *testing.T) {
+	ta, _, _, dir, stop := makeTestTail(t)
+	defer stop()
+
+	logfile := filepath.Join(dir, "log.1.gz")
+	f := testutil.TestOpenFile(t, logfile)
+	zw := gzip.NewWriter(f)
+	testutil.FatalIfErr(t, zw.Close())
+	testutil.FatalIfErr(t, f.Close())
+
+	testutil.FatalIfErr(t, ta.TailPath(logfile))
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	if len(ta.compressed) != 1 {
+		t.Fatalf("expected the compressed log to be remembered, got %v", ta.compressed)
+	}
+
+	testutil.FatalIfErr(t, os.Remove(logfile))
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	if len(ta.compressed) != 0 {
+		t.Errorf("expected the removed compressed log to be forgotten, got %v", ta.compressed)
+	}
+}
+
+func TestTailForgetsOpenRetriesOfUnmatchedLogs(t *testing.T) {
+	ta, _, _, dir, stop := makeTestTail(t)
+	defer stop()
+
+	kept := filepath.Join(dir, "kept")
+	removed := filepath.Join(dir, "removed")
+	outside := testutil.TestTempDir(t)
+	unmatched := filepath.Join(outside, "unmatched")
+	for _, name := range []string{kept, unmatched} {
+		testutil.FatalIfErr(t, os.WriteFile(name, nil, 0o600))
+	}
+	ta.logstreamsMu.Lock()
+	for _, name := range []string{kept, removed, unmatched} {
+		// Retry far in the future, so that the poll doesn't tail the logs.
+		fi, _ := os.Stat(name)
+		ta.openRetries[name] = &openRetry{next: time.Now().Add(time.Hour), delay: time.Hour, fi: fi}
+	}
+	ta.logstreamsMu.Unlock()
+
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	ta.logstreamsMu.RLock()
+	defer ta.logstreamsMu.RUnlock()
+	var retrying []string
+	for name := range ta.openRetries {
+		retrying = append(retrying, name)
+	}
+	testutil.ExpectNoDiff(t, []string{kept}, retrying)
+}
+
+func TestTailRemovesCountersOfCompletedLogs(t *testing.T) {
+	ta, _, _, dir, stop := makeTestTail(t)
+	defer stop()
+
+	logLines := expvar.Get("log_lines_total").(*expvar.Map)
+	logBytes := expvar.Get("log_bytes_total").(*expvar.Map)
+	gone := filepath.Join(dir, "gone")
+	tailed := filepath.Join(dir, "tailed")
+	for name, complete := range map[string]int32{gone: 1, tailed: 0} {
+		logLines.Add(name, 1)
+		logBytes.Add(name, 2)
+		ta.logstreamsMu.Lock()
+		ta.logstreams[name] = &stubStream{complete: complete}
+		ta.logstreamsMu.Unlock()
+	}
+	testutil.FatalIfErr(t, ta.PollLogStreamsForCompletion())
+	if logLines.Get(gone) != nil || logBytes.Get(gone) != nil {
+		t.Errorf("counters of %q not removed", gone)
+	}
+	if logLines.Get(tailed) == nil || logBytes.Get(tailed) == nil {
+		t.Errorf("counters of %q removed", tailed)
+	}
+}
+
+func TestTailDirectory(t *testing.T) {
+	ta, lines, awaken, dir, stop := makeTestTail(t)
+
+	logfile := filepath.Join(dir, "a.log")
+	f := testutil.TestOpenFile(t, logfile)
+	testutil.FatalIfErr(t, os.Mkdir(filepath.Join(dir, "sub"), 0o700))
+
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	testutil.WriteString(t, f, "a\n")
+	awaken(1)
+
+	ta.logstreamsMu.RLock()
+	testutil.ExpectNoDiff(t, 1, len(ta.logstreams))
+	if _, ok := ta.logstreams[logfile]; !ok {
+		t.Errorf("log in directory not tailed: %+#v", ta.logstreams)
+	}
+	ta.logstreamsMu.RUnlock()
+
+	// The tailer of a log removed from the directory is stopped.
+	testutil.FatalIfErr(t, f.Close())
+	testutil.FatalIfErr(t, os.Remove(logfile))
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		ta.logstreamsMu.RLock()
+		defer ta.logstreamsMu.RUnlock()
+		return ta.logstreams[logfile].IsComplete(), nil
+	}, 10*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("tailer of removed log not stopped")
+	}
+	testutil.FatalIfErr(t, ta.PollLogStreamsForCompletion())
+	ta.logstreamsMu.RLock()
+	testutil.ExpectNoDiff(t, 0, len(ta.logstreams))
+	ta.logstreamsMu.RUnlock()
+
+	stop()
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "a"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
@@ -69,3 +70,80 @@ func TestTailerOpenRetries(t *testing.T) {
 	}
 	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
 }
+
+func TestTailerOpenRetryBackoff(t *testing.T) {
+	// Can't force a permission denied error if run as root.
+	testutil.SkipIfRoot(t)
+
+	ta, _, _, dir, stop := makeTestTail(t)
+	defer stop()
+
+	logfile := filepath.Join(dir, "log")
+	f, err := os.OpenFile(logfile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0)
+	testutil.FatalIfErr(t, err)
+	defer f.Close()
+
+	openErrorsCheck := testutil.ExpectMapExpvarDeltaWithDeadline(t, "log_open_errors_total", logfile, 1)
+	if err := ta.TailPath(logfile); !os.IsPermission(err) {
+		t.Fatalf("Expected a permission denied error here: %s", err)
+	}
+	// Retries wait for the backoff while the log is unchanged.
+	testutil.FatalIfErr(t, ta.TailPath(logfile))
+	openErrorsCheck()
+
+	// A change of mode is retried straight away.
+	testutil.FatalIfErr(t, os.Chmod(logfile, 0o666))
+	testutil.FatalIfErr(t, ta.TailPath(logfile))
+	ta.logstreamsMu.RLock()
+	_, tailed := ta.logstreams[logfile]
+	_, retrying := ta.openRetries[logfile]
+	ta.logstreamsMu.RUnlock()
+	if !tailed || retrying {
+		t.Errorf("log not tailed once readable: tailed %v, retrying %v", tailed, retrying)
+	}
+}
+
+// TestTailDirectoryRenamedLogNotReread is a unix-specific test because on
+// Windows, a file can't be renamed while it is open.
+func TestTailDirectoryRenamedLogNotReread(t *testing.T) {
+	ta, lines, awaken, dir, stop := makeTestTail(t)
+
+	logfile := filepath.Join(dir, "a.log")
+	f := testutil.TestOpenFile(t, logfile)
+	defer f.Close()
+
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	testutil.WriteString(t, f, "a\nb\n")
+	awaken(1)
+
+	// Rotating the log renames it within the directory, where it's found
+	// again under its new name.
+	rotated := filepath.Join(dir, "a.log.1")
+	testutil.FatalIfErr(t, os.Rename(logfile, rotated))
+	testutil.FatalIfErr(t, ta.PollLogPatterns())
+	ta.logstreamsMu.RLock()
+	if _, ok := ta.logstreams[rotated]; !ok {
+		t.Errorf("renamed log not tailed: %+#v", ta.logstreams)
+	}
+	ta.logstreamsMu.RUnlock()
+	awaken(1)
+
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		ta.logstreamsMu.RLock()
+		defer ta.logstreamsMu.RUnlock()
+		return ta.logstreams[logfile].IsComplete(), nil
+	}, 10*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("tailer of renamed log not stopped")
+	}
+
+	stop()
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{context.Background(), logfile, "a"},
+		{context.Background(), logfile, "b"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}